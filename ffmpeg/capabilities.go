@@ -0,0 +1,156 @@
+package ffmpeg
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os/exec"
+	"slices"
+	"strings"
+)
+
+// Path and ProbePath are the ffmpeg and ffprobe executables. Unless they hold a path, they are looked up in PATH.
+var (
+	Path      = "ffmpeg"
+	ProbePath = "ffprobe"
+)
+
+// Capabilities describes the ffmpeg installation.
+type Capabilities struct {
+	FFMPEG   Binary   `json:"ffmpeg"`
+	FFProbe  Binary   `json:"ffprobe"`
+	Encoders []string `json:"encoders"`
+	HWAccels []string `json:"hwaccels"`
+	Filters  []string `json:"filters"`
+}
+
+// Binary is an ffmpeg executable.
+type Binary struct {
+	Path    string `json:"path"`
+	Version string `json:"version"`
+}
+
+// GetCapabilities locates the ffmpeg and ffprobe executables and lists the encoders, hardware acceleration methods
+// and filters that ffmpeg supports.
+func GetCapabilities(ctx context.Context) (Capabilities, error) {
+	var c Capabilities
+	var err error
+	if c.FFMPEG, err = lookup(ctx, Path); err != nil {
+		return c, err
+	}
+	if c.FFProbe, err = lookup(ctx, ProbePath); err != nil {
+		return c, err
+	}
+	for _, list := range []struct {
+		flag  string
+		parse func([]byte) []string
+		value *[]string
+	}{
+		{flag: "-encoders", parse: parseEncoders, value: &c.Encoders},
+		{flag: "-hwaccels", parse: parseHWAccels, value: &c.HWAccels},
+		{flag: "-filters", parse: parseFilters, value: &c.Filters},
+	} {
+		output, err := run(ctx, c.FFMPEG.Path, "-hide_banner", list.flag)
+		if err != nil {
+			return c, fmt.Errorf("%s %s: %w", c.FFMPEG.Path, list.flag, err)
+		}
+		*list.value = list.parse(output)
+	}
+	return c, nil
+}
+
+// HasEncoder returns true if ffmpeg supports the encoder.
+func (c Capabilities) HasEncoder(name string) bool {
+	return slices.Contains(c.Encoders, name)
+}
+
+// HasHWAccel returns true if ffmpeg supports the hardware acceleration method.
+func (c Capabilities) HasHWAccel(name string) bool {
+	return slices.Contains(c.HWAccels, name)
+}
+
+// HasFilter returns true if ffmpeg supports the filter.
+func (c Capabilities) HasFilter(name string) bool {
+	return slices.Contains(c.Filters, name)
+}
+
+func lookup(ctx context.Context, name string) (Binary, error) {
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return Binary{}, err
+	}
+	output, err := run(ctx, path, "-version")
+	if err != nil {
+		return Binary{}, fmt.Errorf("%s -version: %w", path, err)
+	}
+	return Binary{Path: path, Version: parseVersion(output)}, nil
+}
+
+func run(ctx context.Context, name string, args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%w (%s)", err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// parseVersion returns the version from the first line of "ffmpeg -version": "ffmpeg version 7.1.1 Copyright ...".
+func parseVersion(output []byte) string {
+	line, _, _ := bytes.Cut(output, []byte("\n"))
+	fields := strings.Fields(string(line))
+	if len(fields) < 3 || fields[1] != "version" {
+		return "unknown"
+	}
+	return fields[2]
+}
+
+// parseEncoders returns the names of the encoders listed by "ffmpeg -encoders". The list follows a legend,
+// which ends with a " ------" line.
+func parseEncoders(output []byte) []string {
+	var encoders []string
+	var inList bool
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		switch {
+		case len(fields) == 0:
+		case !inList:
+			inList = strings.HasPrefix(fields[0], "---")
+		case len(fields) >= 2:
+			encoders = append(encoders, fields[1])
+		}
+	}
+	return encoders
+}
+
+// parseHWAccels returns the hardware acceleration methods listed by "ffmpeg -hwaccels".
+func parseHWAccels(output []byte) []string {
+	var hwaccels []string
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasSuffix(line, ":") {
+			continue
+		}
+		hwaccels = append(hwaccels, line)
+	}
+	return hwaccels
+}
+
+// parseFilters returns the names of the filters listed by "ffmpeg -filters". Filters are recognized by their
+// input/output types ("V->V"), so the legend is skipped.
+func parseFilters(output []byte) []string {
+	var filters []string
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 3 && strings.Contains(fields[2], "->") {
+			filters = append(filters, fields[1])
+		}
+	}
+	return filters
+}
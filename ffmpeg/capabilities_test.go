@@ -0,0 +1,64 @@
+package ffmpeg
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseVersion(t *testing.T) {
+	assert.Equal(t, "7.1.1", parseVersion([]byte("ffmpeg version 7.1.1 Copyright (c) 2000-2025 the FFmpeg developers\nbuilt with gcc 14\n")))
+	assert.Equal(t, "n7.0-2-g1234", parseVersion([]byte("ffprobe version n7.0-2-g1234 Copyright (c) 2007-2024 the FFmpeg developers\n")))
+	assert.Equal(t, "unknown", parseVersion([]byte("")))
+}
+
+func TestParseEncoders(t *testing.T) {
+	const output = `Encoders:
+ V..... = Video
+ A..... = Audio
+ S..... = Subtitle
+ .F.... = Frame-level multithreading
+ ..S... = Slice-level multithreading
+ ...X.. = Codec is experimental
+ ....B. = Supports draw_horiz_band
+ .....D = Supports direct rendering method 1
+ ------
+ V....D libx264              libx264 H.264 / AVC / MPEG-4 AVC / MPEG-4 part 10 (codec h264)
+ V....D libx265              libx265 H.265 / HEVC (codec hevc)
+ V..... hevc_qsv             HEVC (Intel Quick Sync Video acceleration) (codec hevc)
+ A....D aac                  AAC (Advanced Audio Coding)
+`
+	c := Capabilities{Encoders: parseEncoders([]byte(output))}
+	assert.Equal(t, []string{"libx264", "libx265", "hevc_qsv", "aac"}, c.Encoders)
+	assert.True(t, c.HasEncoder("hevc_qsv"))
+	assert.False(t, c.HasEncoder("hevc_videotoolbox"))
+}
+
+func TestParseHWAccels(t *testing.T) {
+	const output = `Hardware acceleration methods:
+vdpau
+vaapi
+qsv
+
+`
+	c := Capabilities{HWAccels: parseHWAccels([]byte(output))}
+	assert.Equal(t, []string{"vdpau", "vaapi", "qsv"}, c.HWAccels)
+	assert.True(t, c.HasHWAccel("qsv"))
+}
+
+func TestParseFilters(t *testing.T) {
+	const output = `Filters:
+  T.. = Timeline support
+  .S. = Slice threading
+  A = Audio input/output
+  V = Video input/output
+  N = Dynamic number and/or type of input/output
+  | = Source or sink filter
+ ... abench            A->A       Benchmark part of a filtergraph.
+ TSC cropdetect        V->V       Auto-detect crop size.
+ ... testsrc2          |->V       Generate another test pattern.
+`
+	c := Capabilities{Filters: parseFilters([]byte(output))}
+	assert.Equal(t, []string{"abench", "cropdetect", "testsrc2"}, c.Filters)
+	assert.True(t, c.HasFilter("cropdetect"))
+}
@@ -0,0 +1,86 @@
+package ffmpeg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// Crop is a rectangle in a video frame: the size of the rectangle and the position of its top left corner.
+type Crop struct {
+	Width  int `json:"w"`
+	Height int `json:"h"`
+	X      int `json:"x"`
+	Y      int `json:"y"`
+}
+
+// IsZero returns true if the Crop is empty.
+func (c Crop) IsZero() bool {
+	return c.Width <= 0 || c.Height <= 0
+}
+
+// String returns the Crop as the parameters of ffmpeg's crop filter.
+func (c Crop) String() string {
+	return fmt.Sprintf("%d:%d:%d:%d", c.Width, c.Height, c.X, c.Y)
+}
+
+// Union returns the smallest Crop that holds both crops. If one of the crops is empty, it returns the other one.
+func (c Crop) Union(other Crop) Crop {
+	if c.IsZero() {
+		return other
+	}
+	if other.IsZero() {
+		return c
+	}
+	x, y := min(c.X, other.X), min(c.Y, other.Y)
+	return Crop{
+		Width:  max(c.X+c.Width, other.X+other.Width) - x,
+		Height: max(c.Y+c.Height, other.Y+other.Height) - y,
+		X:      x,
+		Y:      y,
+	}
+}
+
+// DetectCrop runs ffmpeg's cropdetect filter on a sample of the video, starting at start, and returns the area
+// of the frame that isn't black. The crop is empty if the sample is completely black.
+func (p Priority) DetectCrop(ctx context.Context, path string, start, duration time.Duration) (Crop, error) {
+	stderr, err := Decode(path,
+		"-ss", strconv.FormatFloat(start.Seconds(), 'f', 3, 64),
+		"-t", strconv.FormatFloat(duration.Seconds(), 'f', 3, 64),
+	).
+		Encode("-map", "0:v:0", "-vf", "cropdetect=round=2", "-an", "-sn", "-dn").
+		Muxer("null").
+		LogLevel("info").
+		NoStats().
+		Priority(p).
+		run(ctx)
+	if err != nil {
+		return Crop{}, err
+	}
+	return parseCrop(stderr)
+}
+
+var cropResult = regexp.MustCompile(`crop=(-?\d+):(-?\d+):(-?\d+):(-?\d+)`)
+
+// parseCrop parses the crop that cropdetect logs for the last frame. cropdetect's crop grows with each frame,
+// so the last frame holds the crop for the whole sample.
+func parseCrop(stderr string) (Crop, error) {
+	matches := cropResult.FindAllStringSubmatch(stderr, -1)
+	if len(matches) == 0 {
+		return Crop{}, errors.New("no cropdetect result found")
+	}
+	m := matches[len(matches)-1]
+	var values [4]int
+	for i := range values {
+		values[i], _ = strconv.Atoi(m[i+1])
+	}
+	c := Crop{Width: values[0], Height: values[1], X: values[2], Y: values[3]}
+	if c.IsZero() {
+		// cropdetect reports a negative size for black frames
+		return Crop{}, nil
+	}
+	return c, nil
+}
@@ -0,0 +1,76 @@
+package ffmpeg
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCrop_Union(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b Crop
+		want Crop
+	}{
+		{name: "empty", want: Crop{}},
+		{name: "one empty", a: Crop{Width: 1920, Height: 800, Y: 140}, want: Crop{Width: 1920, Height: 800, Y: 140}},
+		{name: "other empty", b: Crop{Width: 1920, Height: 800, Y: 140}, want: Crop{Width: 1920, Height: 800, Y: 140}},
+		{name: "nested", a: Crop{Width: 1920, Height: 800, Y: 140}, b: Crop{Width: 1000, Height: 600, X: 100, Y: 200}, want: Crop{Width: 1920, Height: 800, Y: 140}},
+		{name: "overlapping", a: Crop{Width: 1920, Height: 800, Y: 140}, b: Crop{Width: 1800, Height: 1000, X: 60, Y: 40}, want: Crop{Width: 1920, Height: 1000, Y: 40}},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, tt.a.Union(tt.b), tt.name)
+	}
+}
+
+func TestCrop_String(t *testing.T) {
+	assert.Equal(t, "1920:800:0:140", Crop{Width: 1920, Height: 800, Y: 140}.String())
+}
+
+func TestPriority_DetectCrop(t *testing.T) {
+	fake := filepath.Join(t.TempDir(), "ffmpeg")
+	require.NoError(t, os.WriteFile(fake, []byte(`#!/bin/sh
+case "$*" in
+*"-ss 600.000 -t 10.000 -i movie.mkv -map 0:v:0 -vf cropdetect=round=2"*)
+	echo "[Parsed_cropdetect_0 @ 0x7f8] x1:0 x2:1919 y1:150 y2:929 w:1920 h:780 x:0 y:150 pts:1 t:0.041 limit:0.094118 crop=1920:780:0:150" >&2
+	echo "[Parsed_cropdetect_0 @ 0x7f8] x1:0 x2:1919 y1:140 y2:939 w:1920 h:800 x:0 y:140 pts:2 t:0.083 limit:0.094118 crop=1920:800:0:140" >&2
+	;;
+*"-i black.mkv"*)
+	echo "[Parsed_cropdetect_0 @ 0x7f8] x1:1919 x2:0 y1:1079 y2:0 w:-1918 h:-1078 x:1920 y:1080 pts:1 t:0.041 limit:0.094118 crop=-1918:-1078:1920:1080" >&2
+	;;
+*) echo "unexpected arguments: $*" >&2; exit 1;;
+esac
+`), 0o755))
+	path := Path
+	Path = fake
+	t.Cleanup(func() { Path = path })
+
+	got, err := Priority{}.DetectCrop(t.Context(), "movie.mkv", 10*time.Minute, 10*time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, Crop{Width: 1920, Height: 800, Y: 140}, got)
+
+	got, err = Priority{}.DetectCrop(t.Context(), "black.mkv", 10*time.Minute, 10*time.Second)
+	require.NoError(t, err)
+	assert.True(t, got.IsZero())
+
+	_, err = Priority{}.DetectCrop(t.Context(), "bad.mkv", 10*time.Minute, 10*time.Second)
+	assert.Error(t, err)
+
+	_, err = parseCrop("Output #0, null, to 'pipe:':")
+	assert.Error(t, err)
+}
+
+func TestVideoStats_Crop_JSON(t *testing.T) {
+	body, err := json.Marshal(VideoStats{VideoCodec: "hevc"})
+	require.NoError(t, err)
+	assert.NotContains(t, string(body), "crop")
+
+	body, err = json.Marshal(VideoStats{VideoCodec: "hevc", Crop: Crop{Width: 1920, Height: 800, Y: 140}})
+	require.NoError(t, err)
+	assert.Contains(t, string(body), `"crop":{"w":1920,"h":800,"x":0,"y":140}`)
+}
@@ -0,0 +1,140 @@
+package ffmpeg
+
+import (
+	"errors"
+	"os/exec"
+	"strings"
+	"syscall"
+)
+
+// ErrorKind classifies why ffmpeg or ffprobe failed.
+type ErrorKind string
+
+const (
+	ErrorUnknown          ErrorKind = "unknown"
+	ErrorCorruptInput     ErrorKind = "corrupt_input"
+	ErrorUnsupportedCodec ErrorKind = "unsupported_codec"
+	ErrorHardwareInit     ErrorKind = "hardware_init"
+	ErrorNoSpace          ErrorKind = "no_space"
+	ErrorPermissionDenied ErrorKind = "permission_denied"
+	ErrorTargetExists     ErrorKind = "target_exists"
+	ErrorKilled           ErrorKind = "killed"
+)
+
+var errorReasons = map[ErrorKind]string{
+	ErrorCorruptInput:     "corrupt input",
+	ErrorUnsupportedCodec: "unsupported codec",
+	ErrorHardwareInit:     "hardware initialization failed",
+	ErrorNoSpace:          "no space left on device",
+	ErrorPermissionDenied: "permission denied",
+	ErrorTargetExists:     "target already exists",
+	ErrorKilled:           "killed",
+}
+
+// Retryable returns true if running the same command again (possibly with a different backend) may succeed.
+// Corrupt input, for instance, will fail every time.
+func (k ErrorKind) Retryable() bool {
+	switch k {
+	case ErrorCorruptInput, ErrorNoSpace, ErrorPermissionDenied, ErrorTargetExists:
+		return false
+	default:
+		return true
+	}
+}
+
+// errorPatterns maps the messages that ffmpeg and ffprobe write to stderr to the kind of error.
+// The first match wins, so more specific patterns go first.
+var errorPatterns = []struct {
+	kind     ErrorKind
+	patterns []string
+}{
+	{kind: ErrorNoSpace, patterns: []string{"no space left on device"}},
+	{kind: ErrorPermissionDenied, patterns: []string{"permission denied", "operation not permitted"}},
+	{kind: ErrorTargetExists, patterns: []string{"already exists. exiting", "not overwriting - exiting"}},
+	{kind: ErrorHardwareInit, patterns: []string{
+		"device creation failed",
+		"failed to initialise vaapi",
+		"error initializing an internal mfx session",
+		"error creating a mfx session",
+		"no device available for decoder",
+		"cannot load libcuda",
+		"cannot load nvcuda",
+		"hwaccel initialisation returned error",
+		"failed setup for format",
+		"no usable encoding profile found",
+		"openencodesessionex failed",
+	}},
+	{kind: ErrorUnsupportedCodec, patterns: []string{
+		"unknown encoder",
+		"unknown decoder",
+		"encoder not found",
+		"decoder not found",
+		"codec not currently supported",
+		"could not find tag for codec",
+		"unsupported codec",
+	}},
+	{kind: ErrorCorruptInput, patterns: []string{
+		"invalid data found when processing input",
+		"moov atom not found",
+		"ebml header parsing failed",
+		"error while decoding stream",
+		"invalid nal unit size",
+		"header missing",
+	}},
+}
+
+// An Error is returned when ffmpeg or ffprobe fails. Error() returns the full output that the command
+// wrote to stderr. Reason() returns a short description, suitable for display.
+type Error struct {
+	// Err is the error returned when running the command.
+	Err error
+	// Kind classifies the error.
+	Kind ErrorKind
+	// Command is the command that failed: "ffmpeg" or "ffprobe".
+	Command string
+	// Stderr is the output that the command wrote to stderr.
+	Stderr string
+}
+
+func newError(command string, err error, stderr string) *Error {
+	stderr = strings.TrimSuffix(stderr, "\n")
+	return &Error{Command: command, Err: err, Stderr: stderr, Kind: classify(err, stderr)}
+}
+
+func (e *Error) Error() string {
+	return e.Command + ": " + e.Err.Error() + " (" + e.Stderr + ")"
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Reason returns a short description of the error. For unknown errors, this is the last line that the command
+// wrote to stderr, as this typically holds the cause.
+func (e *Error) Reason() string {
+	if reason, ok := errorReasons[e.Kind]; ok {
+		return reason
+	}
+	if lines := strings.Split(strings.TrimSpace(e.Stderr), "\n"); lines[len(lines)-1] != "" {
+		return lines[len(lines)-1]
+	}
+	return e.Command + ": " + e.Err.Error()
+}
+
+// classify determines the kind of error from the output that the command wrote to stderr.
+func classify(err error, stderr string) ErrorKind {
+	if exitErr, ok := errors.AsType[*exec.ExitError](err); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() && status.Signal() == syscall.SIGKILL {
+			return ErrorKilled
+		}
+	}
+	stderr = strings.ToLower(stderr)
+	for _, p := range errorPatterns {
+		for _, pattern := range p.patterns {
+			if strings.Contains(stderr, pattern) {
+				return p.kind
+			}
+		}
+	}
+	return ErrorUnknown
+}
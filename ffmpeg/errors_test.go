@@ -0,0 +1,94 @@
+package ffmpeg
+
+import (
+	"errors"
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestError(t *testing.T) {
+	exitErr := errors.New("exit status 1")
+	tests := []struct {
+		name       string
+		stderr     string
+		wantKind   ErrorKind
+		wantReason string
+		retryable  bool
+	}{
+		{
+			name:       "corrupt input",
+			stderr:     "[matroska,webm @ 0x1234] EBML header parsing failed\nfoo.mkv: Invalid data found when processing input\n",
+			wantKind:   ErrorCorruptInput,
+			wantReason: "corrupt input",
+		},
+		{
+			name:       "unsupported codec",
+			stderr:     "Unknown encoder 'libx265'\n",
+			wantKind:   ErrorUnsupportedCodec,
+			wantReason: "unsupported codec",
+			retryable:  true,
+		},
+		{
+			name:       "hardware init",
+			stderr:     "[hevc_qsv @ 0x1234] Error initializing an internal MFX session: unsupported (-3)\n",
+			wantKind:   ErrorHardwareInit,
+			wantReason: "hardware initialization failed",
+			retryable:  true,
+		},
+		{
+			name:       "no space",
+			stderr:     "av_interleaved_write_frame(): No space left on device\n",
+			wantKind:   ErrorNoSpace,
+			wantReason: "no space left on device",
+		},
+		{
+			name:       "permission denied",
+			stderr:     "/media/foo.hevc.mkv: Permission denied\n",
+			wantKind:   ErrorPermissionDenied,
+			wantReason: "permission denied",
+		},
+		{
+			name:       "target exists",
+			stderr:     "File '/media/foo.hevc.mkv' already exists. Exiting.\n",
+			wantKind:   ErrorTargetExists,
+			wantReason: "target already exists",
+		},
+		{
+			name:       "unknown",
+			stderr:     "something odd happened\nConversion failed!\n",
+			wantKind:   ErrorUnknown,
+			wantReason: "Conversion failed!",
+			retryable:  true,
+		},
+		{
+			name:       "no output",
+			wantKind:   ErrorUnknown,
+			wantReason: "ffmpeg: exit status 1",
+			retryable:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := newError("ffmpeg", exitErr, tt.stderr)
+			assert.Equal(t, tt.wantKind, err.Kind)
+			assert.Equal(t, tt.wantReason, err.Reason())
+			assert.Equal(t, tt.retryable, err.Kind.Retryable())
+			assert.ErrorIs(t, err, exitErr)
+		})
+	}
+}
+
+func TestError_Error(t *testing.T) {
+	err := newError("ffmpeg", errors.New("exit status 8"), "Unknown encoder 'libx265'\n")
+	assert.EqualError(t, err, "ffmpeg: exit status 8 (Unknown encoder 'libx265')")
+}
+
+func TestError_Killed(t *testing.T) {
+	err := exec.Command("sh", "-c", "echo partial output >&2; kill -9 $$").Run()
+	require.Error(t, err)
+	assert.Equal(t, ErrorKilled, newError("ffmpeg", err, "partial output\n").Kind)
+}
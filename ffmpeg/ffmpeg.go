@@ -10,7 +10,6 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
-	"strings"
 )
 
 type FFMPEG struct {
@@ -18,6 +17,7 @@ type FFMPEG struct {
 	output             string
 	progressSocketPath string
 	args               []string
+	priority           Priority
 }
 
 func Decode(path string, args ...string) *FFMPEG {
@@ -70,13 +70,19 @@ func (ff *FFMPEG) Progress(cb func(Progress), progressSocketPath string) *FFMPEG
 	return ff
 }
 
+func (ff *FFMPEG) Priority(p Priority) *FFMPEG {
+	ff.priority = p
+	return ff
+}
+
 func (ff *FFMPEG) Build(ctx context.Context) *exec.Cmd {
 	args := ff.args
 	if ff.progress != nil {
 		args = append(args, "-progress", "unix://"+ff.progressSocketPath)
 	}
 	args = append(args, cmp.Or(ff.output, "-"))
-	return exec.CommandContext(ctx, "ffmpeg", args...)
+	name, args := ff.priority.command(Path, args...)
+	return exec.CommandContext(ctx, name, args...)
 }
 
 func (ff *FFMPEG) Run(ctx context.Context, logger *slog.Logger) error {
@@ -85,15 +91,20 @@ func (ff *FFMPEG) Run(ctx context.Context, logger *slog.Logger) error {
 			return err
 		}
 	}
+	_, err := ff.run(ctx)
+	return err
+}
+
+// run runs ffmpeg and returns its stderr output.
+func (ff *FFMPEG) run(ctx context.Context) (string, error) {
 	cmd := ff.Build(ctx)
 	var stdout, stderr bytes.Buffer
 	cmd.Stdout = &stdout
 	cmd.Stderr = &stderr
-	err := cmd.Run()
-	if err != nil {
-		return fmt.Errorf("ffmpeg: %v (%s)", err, strings.TrimSuffix(stderr.String(), "\n"))
+	if err := cmd.Run(); err != nil {
+		return "", newError("ffmpeg", err, stderr.String())
 	}
-	return err
+	return stderr.String(), nil
 }
 
 func (ff *FFMPEG) runProgressSocket(logger *slog.Logger) error {
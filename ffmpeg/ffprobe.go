@@ -2,23 +2,32 @@ package ffmpeg
 
 import (
 	"bytes"
-	"fmt"
 	"os/exec"
 )
 
 func Probe(path string) (VideoStats, error) {
-	cmd := exec.Command("ffprobe",
-		"-show_format",
-		"-show_streams",
-		"-loglevel", "error",
-		"-output_format", "json",
-		path,
-	)
+	return Priority{}.Probe(path)
+}
+
+// Probe runs ffprobe with the priority.
+func (p Priority) Probe(path string) (VideoStats, error) {
+	output, err := p.probe(path, "-show_format", "-show_streams")
+	if err != nil {
+		return VideoStats{}, err
+	}
+	return parseVideoStats(output)
+}
+
+// probe runs ffprobe with the priority and returns its JSON output.
+func (p Priority) probe(path string, args ...string) (*bytes.Buffer, error) {
+	args = append(args, "-loglevel", "error", "-output_format", "json", path)
+	name, args := p.command(ProbePath, args...)
+	cmd := exec.Command(name, args...)
 	var stdOut, stdErr bytes.Buffer
 	cmd.Stdout = &stdOut
 	cmd.Stderr = &stdErr
 	if err := cmd.Run(); err != nil {
-		return VideoStats{}, fmt.Errorf("[%s] %w", stdErr.String(), err)
+		return nil, newError("ffprobe", err, stdErr.String())
 	}
-	return parseVideoStats(&stdOut)
+	return &stdOut, nil
 }
@@ -0,0 +1,104 @@
+package ffmpeg
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// Field orders of a video.
+const (
+	FieldOrderProgressive = "progressive"
+	// FieldOrderTFF is an interlaced video with the top field first.
+	FieldOrderTFF = "tff"
+	// FieldOrderBFF is an interlaced video with the bottom field first.
+	FieldOrderBFF = "bff"
+	// FieldOrderTelecine is a progressive video that was converted to an interlaced frame rate with 3:2 pulldown.
+	FieldOrderTelecine = "telecine"
+)
+
+// Interlacing holds the number of frames that ffmpeg's idet filter classified as interlaced (top or bottom field
+// first), progressive or undetermined, and the number of frames with a repeated top or bottom field.
+type Interlacing struct {
+	TFF            int
+	BFF            int
+	Progressive    int
+	Undetermined   int
+	RepeatedTop    int
+	RepeatedBottom int
+	// RepeatedNeither is the number of frames without a repeated field.
+	RepeatedNeither int
+}
+
+// telecineThreshold is the minimum part of the frames with a repeated field for a video to be telecined.
+// 3:2 pulldown repeats a field in two out of every five frames.
+const telecineThreshold = 0.3
+
+// FieldOrder returns the field order of the video: telecined if enough frames have a repeated field, interlaced
+// if most classified frames are interlaced, progressive otherwise. It returns a blank field order if no frames
+// could be classified.
+func (i Interlacing) FieldOrder() string {
+	repeated := i.RepeatedTop + i.RepeatedBottom
+	switch {
+	case repeated > 0 && float64(repeated) >= telecineThreshold*float64(repeated+i.RepeatedNeither):
+		return FieldOrderTelecine
+	case i.TFF+i.BFF+i.Progressive == 0:
+		return ""
+	case i.TFF+i.BFF <= i.Progressive:
+		return FieldOrderProgressive
+	case i.TFF >= i.BFF:
+		return FieldOrderTFF
+	default:
+		return FieldOrderBFF
+	}
+}
+
+// DetectInterlacing runs ffmpeg's idet filter on a sample of the video, starting at start, and returns how
+// the frames in the sample were classified.
+func (p Priority) DetectInterlacing(ctx context.Context, path string, start, duration time.Duration) (Interlacing, error) {
+	stderr, err := Decode(path,
+		"-ss", strconv.FormatFloat(start.Seconds(), 'f', 3, 64),
+		"-t", strconv.FormatFloat(duration.Seconds(), 'f', 3, 64),
+	).
+		Encode("-map", "0:v:0", "-vf", "idet", "-an", "-sn", "-dn").
+		Muxer("null").
+		LogLevel("info").
+		NoStats().
+		Priority(p).
+		run(ctx)
+	if err != nil {
+		return Interlacing{}, err
+	}
+	return parseInterlacing(stderr)
+}
+
+var (
+	idetResult   = regexp.MustCompile(`Multi frame detection:\s*TFF:\s*(\d+)\s*BFF:\s*(\d+)\s*Progressive:\s*(\d+)\s*Undetermined:\s*(\d+)`)
+	idetRepeated = regexp.MustCompile(`Repeated Fields:\s*Neither:\s*(\d+)\s*Top:\s*(\d+)\s*Bottom:\s*(\d+)`)
+)
+
+// parseInterlacing parses the summary that idet logs when it's done. For interlacing, this uses the multi frame
+// detection, which is more reliable than the single frame detection that idet also logs.
+func parseInterlacing(stderr string) (Interlacing, error) {
+	m := idetResult.FindStringSubmatch(stderr)
+	if m == nil {
+		return Interlacing{}, errors.New("no idet summary found")
+	}
+	counts := atoi(m[1:])
+	i := Interlacing{TFF: counts[0], BFF: counts[1], Progressive: counts[2], Undetermined: counts[3]}
+	if m = idetRepeated.FindStringSubmatch(stderr); m != nil {
+		counts = atoi(m[1:])
+		i.RepeatedNeither, i.RepeatedTop, i.RepeatedBottom = counts[0], counts[1], counts[2]
+	}
+	return i, nil
+}
+
+func atoi(values []string) []int {
+	ints := make([]int, len(values))
+	for i, value := range values {
+		ints[i], _ = strconv.Atoi(value)
+	}
+	return ints
+}
@@ -0,0 +1,68 @@
+package ffmpeg
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInterlacing_FieldOrder(t *testing.T) {
+	tests := []struct {
+		name string
+		in   Interlacing
+		want string
+	}{
+		{name: "empty", in: Interlacing{Undetermined: 10}, want: ""},
+		{name: "progressive", in: Interlacing{TFF: 10, Progressive: 1000, Undetermined: 10}, want: FieldOrderProgressive},
+		{name: "tff", in: Interlacing{TFF: 900, BFF: 10, Progressive: 100}, want: FieldOrderTFF},
+		{name: "bff", in: Interlacing{TFF: 10, BFF: 900, Progressive: 100}, want: FieldOrderBFF},
+		{name: "telecine", in: Interlacing{TFF: 300, Progressive: 700, RepeatedTop: 200, RepeatedBottom: 200, RepeatedNeither: 600}, want: FieldOrderTelecine},
+		{name: "few repeated fields", in: Interlacing{TFF: 10, Progressive: 990, RepeatedTop: 10, RepeatedNeither: 990}, want: FieldOrderProgressive},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, tt.in.FieldOrder(), tt.name)
+	}
+}
+
+func TestPriority_DetectInterlacing(t *testing.T) {
+	fake := filepath.Join(t.TempDir(), "ffmpeg")
+	require.NoError(t, os.WriteFile(fake, []byte(`#!/bin/sh
+case "$*" in
+*"-ss 60.000 -t 30.000 -i foo.mkv -map 0:v:0 -vf idet"*) ;;
+*) echo "unexpected arguments: $*" >&2; exit 1;;
+esac
+cat >&2 <<EOF
+[Parsed_idet_0 @ 0x600003a8c000] Repeated Fields: Neither:   749 Top:     0 Bottom:     0
+[Parsed_idet_0 @ 0x600003a8c000] Single frame detection: TFF:   520 BFF:     0 Progressive:   101 Undetermined:   128
+[Parsed_idet_0 @ 0x600003a8c000] Multi frame detection: TFF:   701 BFF:     0 Progressive:    45 Undetermined:     3
+EOF
+`), 0o755))
+	path := Path
+	Path = fake
+	t.Cleanup(func() { Path = path })
+
+	got, err := Priority{}.DetectInterlacing(t.Context(), "foo.mkv", time.Minute, 30*time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, Interlacing{TFF: 701, Progressive: 45, Undetermined: 3, RepeatedNeither: 749}, got)
+	assert.Equal(t, FieldOrderTFF, got.FieldOrder())
+
+	_, err = Priority{}.DetectInterlacing(t.Context(), "bar.mkv", time.Minute, 30*time.Second)
+	assert.Error(t, err)
+}
+
+func Test_parseInterlacing(t *testing.T) {
+	_, err := parseInterlacing("Output #0, null, to 'pipe:':")
+	assert.Error(t, err)
+}
+
+func TestVideoStats_Interlaced(t *testing.T) {
+	assert.False(t, VideoStats{}.Interlaced())
+	assert.False(t, VideoStats{FieldOrder: FieldOrderProgressive}.Interlaced())
+	assert.True(t, VideoStats{FieldOrder: FieldOrderTFF}.Interlaced())
+	assert.True(t, VideoStats{FieldOrder: FieldOrderBFF}.Interlaced())
+	assert.True(t, VideoStats{FieldOrder: FieldOrderTelecine}.Interlaced())
+}
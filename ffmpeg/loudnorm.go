@@ -0,0 +1,123 @@
+package ffmpeg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// loudnormTargets are the EBU R128 targets for both loudnorm passes: an integrated loudness of -23 LUFS,
+// a maximum true peak of -1 dBTP and a loudness range of 7 LU.
+const loudnormTargets = "I=-23:TP=-1:LRA=7"
+
+// Loudness is the loudness of an audio stream, as measured by the first pass of ffmpeg's loudnorm filter.
+// The second pass uses it to normalize the audio stream.
+type Loudness struct {
+	// Integrated is the integrated loudness, in LUFS.
+	Integrated float64 `json:"i"`
+	// TruePeak is the maximum true peak, in dBTP.
+	TruePeak float64 `json:"tp"`
+	// Range is the loudness range, in LU.
+	Range float64 `json:"lra"`
+	// Threshold is the gating threshold, in LUFS.
+	Threshold float64 `json:"thresh"`
+	// Offset is the gain, in LU, that the second pass applies to reach the target loudness.
+	Offset float64 `json:"offset"`
+}
+
+// IsZero returns true if the Loudness wasn't measured. This is also the case for a silent audio stream.
+func (l Loudness) IsZero() bool {
+	return l == Loudness{}
+}
+
+// Filter returns the second pass of ffmpeg's loudnorm filter, which normalizes the audio stream to EBU R128 in
+// linear mode. loudnorm resamples to 192 kHz, so the filter resamples the result back to 48 kHz.
+func (l Loudness) Filter() string {
+	return "loudnorm=" + loudnormTargets +
+		":measured_I=" + formatLoudness(l.Integrated) +
+		":measured_TP=" + formatLoudness(l.TruePeak) +
+		":measured_LRA=" + formatLoudness(l.Range) +
+		":measured_thresh=" + formatLoudness(l.Threshold) +
+		":offset=" + formatLoudness(l.Offset) +
+		":linear=true,aresample=48000"
+}
+
+func formatLoudness(value float64) string {
+	return strconv.FormatFloat(value, 'f', 2, 64)
+}
+
+// MeasureLoudness runs the first pass of ffmpeg's loudnorm filter on the audio streams of the media file and returns
+// the loudness of each stream. streams is the number of audio streams. This reads all audio of the media file.
+func (p Priority) MeasureLoudness(ctx context.Context, path string, streams int) ([]Loudness, error) {
+	if streams == 0 {
+		return nil, nil
+	}
+	filters := make([]string, streams)
+	maps := make([]string, 0, 2*streams)
+	for i := range streams {
+		filters[i] = fmt.Sprintf("[0:a:%d]loudnorm=%s:print_format=json[a%d]", i, loudnormTargets, i)
+		maps = append(maps, "-map", fmt.Sprintf("[a%d]", i))
+	}
+	stderr, err := Decode(path).
+		Encode("-filter_complex", strings.Join(filters, ";")).
+		Encode(maps...).
+		Muxer("null").
+		LogLevel("info").
+		NoStats().
+		Priority(p).
+		run(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return parseLoudness(stderr, streams)
+}
+
+var loudnormResult = regexp.MustCompile(`\[Parsed_loudnorm_(\d+) @ [^\]]*\]\s*(\{[^}]*\})`)
+
+// parseLoudness parses the measurements that each loudnorm filter logs when it's done. The filters are numbered
+// in the order of the filter graph, which is the order of the audio streams.
+func parseLoudness(stderr string, streams int) ([]Loudness, error) {
+	loudness := make([]Loudness, streams)
+	found := make([]bool, streams)
+	for _, m := range loudnormResult.FindAllStringSubmatch(stderr, -1) {
+		i, _ := strconv.Atoi(m[1])
+		if i >= streams {
+			continue
+		}
+		//nolint:tagliatelle
+		var result struct {
+			InputI      string `json:"input_i"`
+			InputTP     string `json:"input_tp"`
+			InputLRA    string `json:"input_lra"`
+			InputThresh string `json:"input_thresh"`
+			Offset      string `json:"target_offset"`
+		}
+		if err := json.Unmarshal([]byte(m[2]), &result); err != nil {
+			return nil, fmt.Errorf("audio stream %d: json: %w", i, err)
+		}
+		values := make([]float64, 0, 5)
+		for _, s := range []string{result.InputI, result.InputTP, result.InputLRA, result.InputThresh, result.Offset} {
+			value, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				return nil, fmt.Errorf("audio stream %d: invalid loudness %q", i, s)
+			}
+			values = append(values, value)
+		}
+		found[i] = true
+		// a silent stream has no loudness (-inf), so there is nothing to normalize
+		if !slices.ContainsFunc(values, func(value float64) bool { return math.IsInf(value, 0) || math.IsNaN(value) }) {
+			loudness[i] = Loudness{Integrated: values[0], TruePeak: values[1], Range: values[2], Threshold: values[3], Offset: values[4]}
+		}
+	}
+	for i, ok := range found {
+		if !ok {
+			return nil, fmt.Errorf("audio stream %d: no loudnorm result found", i)
+		}
+	}
+	return loudness, nil
+}
@@ -0,0 +1,77 @@
+package ffmpeg
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPriority_MeasureLoudness(t *testing.T) {
+	fake := filepath.Join(t.TempDir(), "ffmpeg")
+	require.NoError(t, os.WriteFile(fake, []byte(`#!/bin/sh
+case "$*" in
+*"-i movie.mkv -filter_complex [0:a:0]loudnorm=I=-23:TP=-1:LRA=7:print_format=json[a0];[0:a:1]loudnorm=I=-23:TP=-1:LRA=7:print_format=json[a1] -map [a0] -map [a1] -f null"*) ;;
+*) echo "unexpected arguments: $*" >&2; exit 1;;
+esac
+cat >&2 <<EOF
+[Parsed_loudnorm_1 @ 0x600001f0c000]
+{
+	"input_i" : "-inf",
+	"input_tp" : "-inf",
+	"input_lra" : "0.00",
+	"input_thresh" : "-inf",
+	"output_i" : "-inf",
+	"output_tp" : "-inf",
+	"output_lra" : "0.00",
+	"output_thresh" : "-inf",
+	"normalization_type" : "dynamic",
+	"target_offset" : "inf"
+}
+[Parsed_loudnorm_0 @ 0x600001f0c0a0]
+{
+	"input_i" : "-27.61",
+	"input_tp" : "-4.47",
+	"input_lra" : "18.06",
+	"input_thresh" : "-39.20",
+	"output_i" : "-22.58",
+	"output_tp" : "-1.50",
+	"output_lra" : "14.78",
+	"output_thresh" : "-33.71",
+	"normalization_type" : "dynamic",
+	"target_offset" : "-0.42"
+}
+EOF
+`), 0o755))
+	path := Path
+	Path = fake
+	t.Cleanup(func() { Path = path })
+
+	got, err := Priority{}.MeasureLoudness(t.Context(), "movie.mkv", 2)
+	require.NoError(t, err)
+	want := []Loudness{{Integrated: -27.61, TruePeak: -4.47, Range: 18.06, Threshold: -39.2, Offset: -0.42}, {}}
+	assert.Equal(t, want, got)
+	assert.True(t, got[1].IsZero())
+
+	got, err = Priority{}.MeasureLoudness(t.Context(), "movie.mkv", 0)
+	require.NoError(t, err)
+	assert.Empty(t, got)
+
+	_, err = Priority{}.MeasureLoudness(t.Context(), "bad.mkv", 1)
+	assert.Error(t, err)
+}
+
+func Test_parseLoudness(t *testing.T) {
+	_, err := parseLoudness("Output #0, null, to 'pipe:':", 1)
+	assert.Error(t, err)
+
+	_, err = parseLoudness(`[Parsed_loudnorm_0 @ 0x600001f0c0a0] { "input_i" : "foo" }`, 1)
+	assert.Error(t, err)
+}
+
+func TestLoudness_Filter(t *testing.T) {
+	l := Loudness{Integrated: -27.61, TruePeak: -4.47, Range: 18.06, Threshold: -39.2, Offset: -0.42}
+	assert.Equal(t, "loudnorm=I=-23:TP=-1:LRA=7:measured_I=-27.61:measured_TP=-4.47:measured_LRA=18.06:measured_thresh=-39.20:offset=-0.42:linear=true,aresample=48000", l.Filter())
+}
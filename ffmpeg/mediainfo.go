@@ -0,0 +1,101 @@
+package ffmpeg
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// MediaInfo describes the layout of a media file: its streams and chapters.
+type MediaInfo struct {
+	Streams  []Stream
+	Duration time.Duration
+	Chapters int
+}
+
+// Stream describes one stream of a media file.
+type Stream struct {
+	// Type is the type of stream: video, audio, subtitle, etc.
+	Type     string
+	Codec    string
+	Language string
+	Channels int
+	// Packets is the number of packets in the stream. For video streams, this is the number of frames.
+	Packets int64
+	// StartTime is the presentation time of the stream's first packet.
+	StartTime time.Duration
+}
+
+// StreamsOfType returns the streams of the given type.
+func (m MediaInfo) StreamsOfType(streamType string) []Stream {
+	var streams []Stream
+	for _, s := range m.Streams {
+		if s.Type == streamType {
+			streams = append(streams, s)
+		}
+	}
+	return streams
+}
+
+// ProbeMediaInfo runs ffprobe with the priority and returns the layout of the media file.
+// This reads the whole file to count the packets in each stream.
+func (p Priority) ProbeMediaInfo(path string) (MediaInfo, error) {
+	output, err := p.probe(path, "-show_format", "-show_streams", "-show_chapters", "-count_packets")
+	if err != nil {
+		return MediaInfo{}, err
+	}
+	return parseMediaInfo(output)
+}
+
+func parseMediaInfo(r io.Reader) (MediaInfo, error) {
+	//nolint:tagliatelle
+	var info struct {
+		Format struct {
+			Duration string `json:"duration"`
+		} `json:"format"`
+		Streams []struct {
+			CodecName     string `json:"codec_name"`
+			CodecType     string `json:"codec_type"`
+			StartTime     string `json:"start_time"`
+			NbReadPackets string `json:"nb_read_packets"`
+			Tags          struct {
+				Language string `json:"language"`
+			} `json:"tags"`
+			Channels int `json:"channels"`
+		} `json:"streams"`
+		Chapters []json.RawMessage `json:"chapters"`
+	}
+	if err := json.NewDecoder(r).Decode(&info); err != nil {
+		return MediaInfo{}, fmt.Errorf("json: %w", err)
+	}
+
+	var m MediaInfo
+	var err error
+	if m.Duration, err = parseSeconds(info.Format.Duration); err != nil {
+		return MediaInfo{}, fmt.Errorf("invalid duration: %w", err)
+	}
+	m.Chapters = len(info.Chapters)
+	m.Streams = make([]Stream, len(info.Streams))
+	for i, s := range info.Streams {
+		m.Streams[i] = Stream{Type: s.CodecType, Codec: s.CodecName, Language: s.Tags.Language, Channels: s.Channels}
+		if s.StartTime != "" {
+			if m.Streams[i].StartTime, err = parseSeconds(s.StartTime); err != nil {
+				return MediaInfo{}, fmt.Errorf("stream %d: invalid start_time: %w", i, err)
+			}
+		}
+		if s.NbReadPackets != "" {
+			if m.Streams[i].Packets, err = strconv.ParseInt(s.NbReadPackets, 10, 64); err != nil {
+				return MediaInfo{}, fmt.Errorf("stream %d: invalid nb_read_packets: %w", i, err)
+			}
+		}
+	}
+	return m, nil
+}
+
+// parseSeconds parses a time in seconds, as reported by ffprobe ("1800.000000").
+func parseSeconds(s string) (time.Duration, error) {
+	seconds, err := strconv.ParseFloat(s, 64)
+	return time.Duration(seconds * float64(time.Second)), err
+}
@@ -0,0 +1,37 @@
+package ffmpeg
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseMediaInfo(t *testing.T) {
+	const input = `{
+    "streams": [
+        { "codec_name": "hevc", "codec_type": "video", "start_time": "0.000000", "nb_read_packets": "43200" },
+        { "codec_name": "eac3", "codec_type": "audio", "channels": 6, "start_time": "0.012000", "nb_read_packets": "56250", "tags": { "language": "eng" } },
+        { "codec_name": "subrip", "codec_type": "subtitle", "start_time": "0.000000", "nb_read_packets": "512", "tags": { "language": "dut" } }
+    ],
+    "chapters": [ { "id": 0 }, { "id": 1 } ],
+    "format": { "duration": "1800.000000" }
+}`
+	m, err := parseMediaInfo(strings.NewReader(input))
+	require.NoError(t, err)
+	assert.Equal(t, MediaInfo{
+		Duration: 30 * time.Minute,
+		Chapters: 2,
+		Streams: []Stream{
+			{Type: "video", Codec: "hevc", Packets: 43200},
+			{Type: "audio", Codec: "eac3", Language: "eng", Channels: 6, StartTime: 12 * time.Millisecond, Packets: 56250},
+			{Type: "subtitle", Codec: "subrip", Language: "dut", Packets: 512},
+		},
+	}, m)
+	assert.Len(t, m.StreamsOfType("audio"), 1)
+
+	_, err = parseMediaInfo(strings.NewReader(`{"format": {"duration": "N/A"}}`))
+	assert.Error(t, err)
+}
@@ -0,0 +1,51 @@
+package ffmpeg
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Priority sets the CPU and I/O scheduling priority of ffmpeg and ffprobe processes, by running them
+// through nice and ionice. The zero value leaves the priority unchanged.
+type Priority struct {
+	// IOClass is the ionice scheduling class: "idle", "best-effort" or "realtime". Blank leaves the class unchanged.
+	// ionice is only available on Linux.
+	IOClass string
+	// Nice is the niceness of the process, from -20 (highest priority) to 19 (lowest priority).
+	Nice int
+	// IOLevel is the priority within the best-effort and realtime classes, from 0 (highest) to 7 (lowest).
+	IOLevel int
+}
+
+var ioClasses = map[string]string{
+	"realtime":    "1",
+	"best-effort": "2",
+	"idle":        "3",
+}
+
+// Validate returns an error if the priority is invalid.
+func (p Priority) Validate() error {
+	if p.Nice < -20 || p.Nice > 19 {
+		return fmt.Errorf("invalid nice level %d", p.Nice)
+	}
+	if _, ok := ioClasses[p.IOClass]; !ok && p.IOClass != "" {
+		return fmt.Errorf("invalid io class %q", p.IOClass)
+	}
+	if p.IOLevel < 0 || p.IOLevel > 7 {
+		return fmt.Errorf("invalid io level %d", p.IOLevel)
+	}
+	return nil
+}
+
+// command returns the command line that runs name with the priority.
+func (p Priority) command(name string, args ...string) (string, []string) {
+	var prefix []string
+	if p.Nice != 0 {
+		prefix = append(prefix, "nice", "-n", strconv.Itoa(p.Nice))
+	}
+	prefix = append(prefix, ioniceArgs(p)...)
+	if len(prefix) == 0 {
+		return name, args
+	}
+	return prefix[0], append(append(prefix[1:], name), args...)
+}
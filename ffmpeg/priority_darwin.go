@@ -0,0 +1,6 @@
+package ffmpeg
+
+// ioniceArgs returns nil: macOS doesn't have ionice.
+func ioniceArgs(_ Priority) []string {
+	return nil
+}
@@ -0,0 +1,16 @@
+package ffmpeg
+
+import "strconv"
+
+// ioniceArgs returns the ionice command that sets the I/O priority.
+func ioniceArgs(p Priority) []string {
+	class, ok := ioClasses[p.IOClass]
+	if !ok {
+		return nil
+	}
+	args := []string{"ionice", "-c", class}
+	if p.IOClass != "idle" {
+		args = append(args, "-n", strconv.Itoa(p.IOLevel))
+	}
+	return args
+}
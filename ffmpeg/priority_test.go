@@ -0,0 +1,43 @@
+package ffmpeg
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPriority(t *testing.T) {
+	tests := []struct {
+		name     string
+		priority Priority
+		want     string
+		linux    bool
+		wantErr  string
+	}{
+		{name: "default", priority: Priority{}, want: "ffmpeg -i foo.mkv -"},
+		{name: "nice", priority: Priority{Nice: 10}, want: "nice -n 10 ffmpeg -i foo.mkv -"},
+		{name: "idle", priority: Priority{Nice: 10, IOClass: "idle"}, want: "nice -n 10 ionice -c 3 ffmpeg -i foo.mkv -", linux: true},
+		{name: "best-effort", priority: Priority{IOClass: "best-effort", IOLevel: 7}, want: "ionice -c 2 -n 7 ffmpeg -i foo.mkv -", linux: true},
+		{name: "invalid nice", priority: Priority{Nice: 20}, wantErr: "invalid nice level 20"},
+		{name: "invalid class", priority: Priority{IOClass: "low"}, wantErr: `invalid io class "low"`},
+		{name: "invalid level", priority: Priority{IOClass: "best-effort", IOLevel: 8}, wantErr: "invalid io level 8"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.priority.Validate()
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			if tt.linux && runtime.GOOS != "linux" {
+				t.Skip("ionice is only supported on Linux")
+			}
+			cmd := Decode("foo.mkv").Priority(tt.priority).Build(t.Context())
+			assert.Equal(t, tt.want, strings.Join(cmd.Args, " "))
+		})
+	}
+}
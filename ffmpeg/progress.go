@@ -35,9 +35,21 @@ type Progress struct {
 	DuplicateFrames uint64
 	DroppedFrames   uint64
 	Speed           float64
-	// TBD: stream_0_0_q=-0.0
-	// TBD: bitrate=N/A
-	// TBD: total_size=N/A
+	// Bitrate is the output bitrate, in bits per second.
+	Bitrate float64
+	// TotalSize is the number of bytes written so far.
+	TotalSize int64
+	// Quality is the quantizer of the first output stream.
+	Quality float64
+}
+
+// ProjectedSize extrapolates the final output size from the bytes written so far, given the duration of the input.
+// Returns zero if nothing has been converted yet.
+func (p Progress) ProjectedSize(duration time.Duration) int64 {
+	if p.Converted <= 0 || p.TotalSize <= 0 {
+		return 0
+	}
+	return int64(float64(p.TotalSize) * duration.Seconds() / p.Converted.Seconds())
 }
 
 func progress(r io.Reader, logger *slog.Logger) iter.Seq[Progress] {
@@ -71,6 +83,12 @@ func progress(r io.Reader, logger *slog.Logger) iter.Seq[Progress] {
 				prog.DuplicateFrames, err = strconv.ParseUint(string(val), 10, 64)
 			case "drop_frames":
 				prog.DroppedFrames, err = strconv.ParseUint(string(val), 10, 64)
+			case "bitrate":
+				prog.Bitrate, err = parseBitrate(val)
+			case "total_size":
+				prog.TotalSize, err = strconv.ParseInt(string(val), 10, 64)
+			case "stream_0_0_q":
+				prog.Quality, err = strconv.ParseFloat(string(val), 64)
 			case "speed":
 				v := bytes.TrimSuffix(val, []byte("x"))
 				prog.Speed, err = strconv.ParseFloat(string(v), 64)
@@ -88,3 +106,23 @@ func progress(r io.Reader, logger *slog.Logger) iter.Seq[Progress] {
 		}
 	}
 }
+
+// parseBitrate parses the bitrate reported by ffmpeg ("2048.5kbits/s") and returns it in bits per second.
+func parseBitrate(val []byte) (float64, error) {
+	multiplier := 1.0
+	for _, unit := range []struct {
+		suffix     string
+		multiplier float64
+	}{
+		{suffix: "kbits/s", multiplier: 1000},
+		{suffix: "mbits/s", multiplier: 1000 * 1000},
+		{suffix: "bits/s", multiplier: 1},
+	} {
+		if v, ok := bytes.CutSuffix(val, []byte(unit.suffix)); ok {
+			val, multiplier = v, unit.multiplier
+			break
+		}
+	}
+	bitrate, err := strconv.ParseFloat(string(val), 64)
+	return bitrate * multiplier, err
+}
@@ -55,6 +55,14 @@ func Test_progress(t *testing.T) {
 			input: "frame=10\nfps=25.0\nout_time_us=1000\ndup_frames=1\ndrop_frames=2\nspeed=10x\nprogress=end\n",
 			want:  []Progress{{Frame: 10, FPS: 25.0, Converted: time.Millisecond, DuplicateFrames: 1, DroppedFrames: 2, Speed: 10}},
 		},
+		{
+			name:  "output",
+			input: "stream_0_0_q=28.0\nbitrate=2048.5kbits/s\ntotal_size=1048576\nprogress=continue\nbitrate=N/A\ntotal_size=N/A\nprogress=end\n",
+			want: []Progress{
+				{Quality: 28, Bitrate: 2_048_500, TotalSize: 1_048_576},
+				{Quality: 28, Bitrate: 2_048_500, TotalSize: 1_048_576},
+			},
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -69,6 +77,11 @@ func Test_progress(t *testing.T) {
 	}
 }
 
+func TestProgress_ProjectedSize(t *testing.T) {
+	assert.Zero(t, Progress{}.ProjectedSize(time.Hour))
+	assert.Equal(t, int64(400), Progress{Converted: 15 * time.Minute, TotalSize: 100}.ProjectedSize(time.Hour))
+}
+
 func Benchmark_progress(b *testing.B) {
 	// Benchmark_progress-10    	    6948	    154579 ns/op	    4256 B/op	       3 allocs/op
 	var input strings.Builder
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
+	"math"
 	"strconv"
 	"strings"
 	"time"
@@ -18,6 +19,43 @@ type VideoStats struct {
 	BitsPerSample int           `json:"bits_per_sample"`
 	Height        int           `json:"height"`
 	Width         int           `json:"width"`
+	// FrameRate is the average frame rate of the video.
+	FrameRate float64 `json:"frame_rate,omitempty"`
+	// RealFrameRate is the lowest frame rate that can represent all timestamps of the video. For video with a
+	// constant frame rate, this is the same as FrameRate.
+	RealFrameRate float64 `json:"real_frame_rate,omitempty"`
+	// FieldOrder is the field order of the video, as detected by DetectInterlacing. It's blank if it wasn't detected.
+	FieldOrder string `json:"field_order,omitempty"`
+	// Deinterlace is the filter used to deinterlace the video when encoding it. It's only set for target videos.
+	Deinterlace string `json:"deinterlace,omitempty"`
+	// FPS is the frame rate that the video is converted to when encoding it, with ffmpeg's fps filter.
+	// It's only set for target videos.
+	FPS float64 `json:"fps,omitempty"`
+	// AudioStreams is the number of audio streams in the media file.
+	AudioStreams int `json:"audio_streams,omitempty"`
+	// Loudness holds the loudness of each audio stream, as measured by MeasureLoudness. It's empty if the loudness
+	// wasn't measured.
+	Loudness []Loudness `json:"loudness,omitempty"`
+	// NormalizeLoudness normalizes the loudness of the audio streams when encoding the video, using the measured
+	// Loudness. It's only set for target videos.
+	NormalizeLoudness bool `json:"normalize_loudness,omitempty"`
+	// Crop is the area of the frame without black bars, as detected by DetectCrop, for source videos. For target
+	// videos, it's the area of the source video that is encoded. It's empty if the video isn't cropped.
+	Crop Crop `json:"crop,omitzero"`
+}
+
+// Interlaced returns true if the video was detected as interlaced or telecined.
+func (s VideoStats) Interlaced() bool {
+	return s.FieldOrder == FieldOrderTFF || s.FieldOrder == FieldOrderBFF || s.FieldOrder == FieldOrderTelecine
+}
+
+// VariableFrameRate returns true if the video has a variable frame rate, i.e. its average frame rate differs
+// from its real frame rate by more than 1%.
+func (s VideoStats) VariableFrameRate() bool {
+	if s.FrameRate <= 0 || s.RealFrameRate <= 0 {
+		return false
+	}
+	return math.Abs(s.FrameRate-s.RealFrameRate) > s.RealFrameRate/100
 }
 
 func parseVideoStats(r io.Reader) (VideoStats, error) {
@@ -32,6 +70,8 @@ func parseVideoStats(r io.Reader) (VideoStats, error) {
 			CodecName        string `json:"codec_name,omitempty"`
 			CodecType        string `json:"codec_type"`
 			BitsPerRawSample string `json:"bits_per_raw_sample,omitempty"`
+			AvgFrameRate     string `json:"avg_frame_rate,omitempty"`
+			RFrameRate       string `json:"r_frame_rate,omitempty"`
 			Height           int    `json:"height,omitempty"`
 			Width            int    `json:"width,omitempty"`
 		} `json:"streams"`
@@ -54,10 +94,15 @@ func parseVideoStats(r io.Reader) (VideoStats, error) {
 	videoStats.BitRate = bitrate
 
 	for _, stream := range stats.Streams {
+		if stream.CodecType == "audio" {
+			videoStats.AudioStreams++
+		}
 		if stream.CodecType == "video" {
 			videoStats.VideoCodec = stream.CodecName
 			videoStats.Height = stream.Height
 			videoStats.Width = stream.Width
+			videoStats.FrameRate = parseFrameRate(stream.AvgFrameRate)
+			videoStats.RealFrameRate = parseFrameRate(stream.RFrameRate)
 			switch stream.BitsPerRawSample {
 			case "", "8":
 				videoStats.BitsPerSample = 8
@@ -76,6 +121,20 @@ func parseVideoStats(r io.Reader) (VideoStats, error) {
 	return videoStats, nil
 }
 
+// parseFrameRate parses a frame rate as reported by ffprobe, e.g. "24000/1001". Unknown frame rates ("0/0") return zero.
+func parseFrameRate(s string) float64 {
+	num, den, ok := strings.Cut(s, "/")
+	if !ok {
+		den = "1"
+	}
+	n, err1 := strconv.ParseFloat(num, 64)
+	d, err2 := strconv.ParseFloat(den, 64)
+	if err1 != nil || err2 != nil || d == 0 {
+		return 0
+	}
+	return n / d
+}
+
 func (s VideoStats) String() string {
 	if s.VideoCodec == "" {
 		return ""
@@ -94,7 +153,7 @@ func (s VideoStats) String() string {
 var _ slog.LogValuer = VideoStats{}
 
 func (s VideoStats) LogValue() slog.Value {
-	values := make([]slog.Attr, 0, 5)
+	values := make([]slog.Attr, 0, 7)
 	if s.VideoCodec != "" {
 		values = append(values, slog.String("codec", s.VideoCodec))
 	}
@@ -107,6 +166,12 @@ func (s VideoStats) LogValue() slog.Value {
 	if s.BitsPerSample > 0 {
 		values = append(values, slog.Int("bits", s.BitsPerSample))
 	}
+	if s.FrameRate > 0 {
+		values = append(values, slog.String("fps", strconv.FormatFloat(s.FrameRate, 'f', 3, 64)))
+	}
+	if s.FieldOrder != "" {
+		values = append(values, slog.String("field_order", s.FieldOrder))
+	}
 	return slog.GroupValue(values...)
 }
 
@@ -126,6 +191,45 @@ func (b Bits) Format(decimals int) string {
 	return strconv.FormatFloat(floatBits, 'f', decimals, 64) + " " + unit + "ps"
 }
 
+// ParseBits parses a bitrate, with an optional unit: "8mbps", "800 kbps" or "8000000".
+func ParseBits(s string) (Bits, error) {
+	value := strings.ToLower(strings.TrimSpace(s))
+	multiplier := 1.0
+	for _, unit := range []struct {
+		suffix     string
+		multiplier float64
+	}{
+		{suffix: "mbps", multiplier: 1000 * 1000},
+		{suffix: "kbps", multiplier: 1000},
+		{suffix: "bps", multiplier: 1},
+	} {
+		if v, ok := strings.CutSuffix(value, unit.suffix); ok {
+			value, multiplier = strings.TrimSpace(v), unit.multiplier
+			break
+		}
+	}
+	bits, err := strconv.ParseFloat(value, 64)
+	if err != nil || bits < 0 {
+		return 0, fmt.Errorf("invalid bitrate %q", s)
+	}
+	return Bits(bits * multiplier), nil
+}
+
+type Bytes int64
+
+func (b Bytes) Format(decimals int) string {
+	floatBytes := float64(b)
+	unit := "B"
+	for _, next := range []string{"KB", "MB", "GB", "TB"} {
+		if math.Abs(floatBytes) < 1000 {
+			break
+		}
+		floatBytes /= 1000
+		unit = next
+	}
+	return strconv.FormatFloat(floatBytes, 'f', decimals, 64) + " " + unit
+}
+
 type InvalidMediaError struct {
 	Reason string
 }
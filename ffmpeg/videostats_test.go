@@ -26,7 +26,13 @@ func Test_parseVideoStats(t *testing.T) {
     "format": { "filename": "foo.hevc.mkv", "duration": "1800.000", "bit_rate": "5000000" }
 }
 `,
-			want:    VideoStats{Duration: 30 * time.Minute, VideoCodec: "hevc", BitRate: 5_000_000, BitsPerSample: 10, Height: 1080, Width: 1920},
+			want:    VideoStats{Duration: 30 * time.Minute, VideoCodec: "hevc", BitRate: 5_000_000, BitsPerSample: 10, Height: 1080, Width: 1920, AudioStreams: 1},
+			wantErr: assert.NoError,
+		},
+		{
+			name:    "frame rate",
+			input:   `{"format": { "duration": "1800.000", "bit_rate": "5000000" }, "streams": [ { "codec_name": "h264", "codec_type": "video", "height": 1080, "width": 1920, "avg_frame_rate": "24000/1001", "r_frame_rate": "24000/1001" } ]}`,
+			want:    VideoStats{Duration: 30 * time.Minute, VideoCodec: "h264", BitRate: 5_000_000, BitsPerSample: 8, Height: 1080, Width: 1920, FrameRate: 24000.0 / 1001, RealFrameRate: 24000.0 / 1001},
 			wantErr: assert.NoError,
 		},
 		{
@@ -85,6 +91,21 @@ func Test_parseVideoStats(t *testing.T) {
 	}
 }
 
+func Test_parseFrameRate(t *testing.T) {
+	assert.Equal(t, 25.0, parseFrameRate("25/1"))
+	assert.Equal(t, 30000.0/1001, parseFrameRate("30000/1001"))
+	assert.Equal(t, 50.0, parseFrameRate("50"))
+	assert.Zero(t, parseFrameRate("0/0"))
+	assert.Zero(t, parseFrameRate(""))
+}
+
+func TestVideoStats_VariableFrameRate(t *testing.T) {
+	assert.False(t, VideoStats{}.VariableFrameRate())
+	assert.False(t, VideoStats{FrameRate: 25, RealFrameRate: 25}.VariableFrameRate())
+	assert.False(t, VideoStats{FrameRate: 29.9, RealFrameRate: 30}.VariableFrameRate())
+	assert.True(t, VideoStats{FrameRate: 27.3, RealFrameRate: 30}.VariableFrameRate())
+}
+
 func TestVideoStats_String(t *testing.T) {
 	stats := VideoStats{
 		Duration:      30 * time.Minute,
@@ -145,6 +166,43 @@ func TestVideoStats_LogValue(t *testing.T) {
 	}
 }
 
+func TestBytes_Format(t *testing.T) {
+	tests := []struct {
+		bytes Bytes
+		want  string
+	}{
+		{bytes: 0, want: "0.0 B"},
+		{bytes: 999, want: "999.0 B"},
+		{bytes: 1_500, want: "1.5 KB"},
+		{bytes: 2_500_000_000, want: "2.5 GB"},
+		{bytes: -2_500_000_000, want: "-2.5 GB"},
+		{bytes: 3_000_000_000_000_000, want: "3000.0 TB"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, tt.bytes.Format(1))
+	}
+}
+
+func TestParseBits(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    Bits
+		wantErr assert.ErrorAssertionFunc
+	}{
+		{input: "8mbps", want: 8_000_000, wantErr: assert.NoError},
+		{input: "1.5 Mbps", want: 1_500_000, wantErr: assert.NoError},
+		{input: "800kbps", want: 800_000, wantErr: assert.NoError},
+		{input: "4000000", want: 4_000_000, wantErr: assert.NoError},
+		{input: "fast", wantErr: assert.Error},
+		{input: "-1mbps", wantErr: assert.Error},
+	}
+	for _, tt := range tests {
+		got, err := ParseBits(tt.input)
+		tt.wantErr(t, err, tt.input)
+		assert.Equal(t, tt.want, got, tt.input)
+	}
+}
+
 func BenchmarkParse(b *testing.B) {
 	// Current:
 	// BenchmarkParse-10    	  623712	      1919 ns/op	    1664 B/op	      24 allocs/op
@@ -0,0 +1,174 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/clambin/xcoder/ffmpeg"
+	"github.com/clambin/xcoder/internal/transcoder"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	doctorCmd = &cobra.Command{
+		Use:          "doctor",
+		Short:        "Check which backends and profiles can run on this system",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			priority, err := getPriority(viper.GetViper())
+			if err != nil {
+				return fmt.Errorf("invalid priority: %w", err)
+			}
+			r, err := diagnose(cmd.Context(), priority)
+			if err != nil {
+				return err
+			}
+			format, _ := cmd.Flags().GetString("format")
+			switch format {
+			case "json":
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				err = enc.Encode(r)
+			case "text":
+				err = r.write(os.Stdout)
+			default:
+				err = fmt.Errorf("invalid format %q", format)
+			}
+			if err == nil && len(r.runnableProfiles()) == 0 {
+				err = errors.New("no profile can run on this system")
+			}
+			return err
+		},
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(doctorCmd)
+	addFormatFlag(doctorCmd, "text", "json")
+}
+
+// doctorReport describes the ffmpeg installation and which backends and profiles work on this system.
+type doctorReport struct {
+	Capabilities ffmpeg.Capabilities `json:"capabilities"`
+	Backends     []backendCheck      `json:"backends"`
+	Profiles     []profileCheck      `json:"profiles"`
+}
+
+type backendCheck struct {
+	Name  string `json:"name"`
+	Error string `json:"error,omitempty"`
+}
+
+type profileCheck struct {
+	Name string `json:"name"`
+	// Backends lists the profile's backends that work on this system.
+	Backends []string `json:"backends"`
+}
+
+func diagnose(ctx context.Context, priority ffmpeg.Priority) (doctorReport, error) {
+	var r doctorReport
+	var err error
+	if r.Capabilities, err = ffmpeg.GetCapabilities(ctx); err != nil {
+		return r, err
+	}
+	r.Backends = checkBackends(ctx, transcoder.SupportedBackends(), priority)
+	for _, name := range transcoder.SupportedProfiles() {
+		profile, _ := transcoder.GetProfile(name)
+		check := profileCheck{Name: name, Backends: make([]string, 0)}
+		for _, backend := range r.Backends {
+			if backend.Error == "" && slices.Contains(profile.Backends(), backend.Name) {
+				check.Backends = append(check.Backends, backend.Name)
+			}
+		}
+		r.Profiles = append(r.Profiles, check)
+	}
+	return r, nil
+}
+
+func checkBackends(ctx context.Context, backends []string, priority ffmpeg.Priority) []backendCheck {
+	checks := make([]backendCheck, len(backends))
+	for i, name := range backends {
+		checks[i].Name = name
+		if err := transcoder.CheckBackend(ctx, name, priority); err != nil {
+			checks[i].Error = err.Error()
+		}
+	}
+	return checks
+}
+
+func (r doctorReport) runnableProfiles() []string {
+	var profiles []string
+	for _, p := range r.Profiles {
+		if len(p.Backends) > 0 {
+			profiles = append(profiles, p.Name)
+		}
+	}
+	return profiles
+}
+
+func (r doctorReport) write(w io.Writer) error {
+	c := r.Capabilities
+	var hevcEncoders []string
+	for _, encoder := range c.Encoders {
+		if strings.Contains(encoder, "hevc") || strings.Contains(encoder, "265") {
+			hevcEncoders = append(hevcEncoders, encoder)
+		}
+	}
+	lines := []string{
+		fmt.Sprintf("ffmpeg:   %s (version %s)", c.FFMPEG.Path, c.FFMPEG.Version),
+		fmt.Sprintf("ffprobe:  %s (version %s)", c.FFProbe.Path, c.FFProbe.Version),
+		"hwaccels: " + strings.Join(c.HWAccels, ", "),
+		fmt.Sprintf("encoders: %d available (hevc: %s)", len(c.Encoders), strings.Join(hevcEncoders, ", ")),
+		fmt.Sprintf("filters:  %d available", len(c.Filters)),
+		"",
+		"backends:",
+	}
+	for _, b := range r.Backends {
+		status := "OK"
+		if b.Error != "" {
+			status = "FAIL: " + b.Error
+		}
+		lines = append(lines, fmt.Sprintf("  %-9s %s", b.Name+":", status))
+	}
+	lines = append(lines, "", "profiles:")
+	for _, p := range r.Profiles {
+		status := "FAIL: no working backend"
+		if len(p.Backends) > 0 {
+			status = "OK (" + strings.Join(p.Backends, ", ") + ")"
+		}
+		lines = append(lines, fmt.Sprintf("  %-12s %s", p.Name+":", status))
+	}
+	_, err := fmt.Fprintln(w, strings.Join(lines, "\n"))
+	return err
+}
+
+// startupCheck checks that ffmpeg is installed and that at least one of the backends works (if any are given).
+// Backends that don't work are logged, as the transcoder fails over to the next backend when retrying.
+func startupCheck(ctx context.Context, backends []string, priority ffmpeg.Priority, logger *slog.Logger) error {
+	c, err := ffmpeg.GetCapabilities(ctx)
+	if err != nil {
+		return err
+	}
+	logger.Info("ffmpeg found", "path", c.FFMPEG.Path, "version", c.FFMPEG.Version)
+	var working int
+	for _, check := range checkBackends(ctx, backends, priority) {
+		if check.Error != "" {
+			logger.Warn("backend doesn't work on this system", "backend", check.Name, "err", check.Error)
+			continue
+		}
+		working++
+	}
+	if len(backends) > 0 && working == 0 {
+		return fmt.Errorf("none of the backends (%s) work on this system. run 'xcoder doctor' for details", strings.Join(backends, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,46 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/clambin/xcoder/internal/history"
+	"github.com/clambin/xcoder/internal/plan"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	planCmd = &cobra.Command{
+		Use:          "plan [file|dir]...",
+		Short:        "Show what a profile would do with media files, without converting them",
+		Args:         cobra.MinimumNArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			priority, err := getPriority(viper.GetViper())
+			if err != nil {
+				return fmt.Errorf("invalid priority: %w", err)
+			}
+			profile, err := getCommandProfile(cmd)
+			if err != nil {
+				return err
+			}
+			h, err := history.Load(historyFilename())
+			if err != nil {
+				return fmt.Errorf("invalid history: %w", err)
+			}
+			p, err := plan.New(cmd.Context(), args, profile, priority.Probe, history.NewSpeeds(h.Records()))
+			if err != nil {
+				return err
+			}
+			format, _ := cmd.Flags().GetString("format")
+			return p.Write(os.Stdout, format)
+		},
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(planCmd)
+	addFormatFlag(planCmd, "text", "csv", "json")
+	addProfileFlag(planCmd)
+}
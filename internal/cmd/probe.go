@@ -1,57 +1,70 @@
 package cmd
 
 import (
-	"encoding/json"
 	"fmt"
-	"math"
 	"os"
-	"time"
+	"strconv"
 
 	"codeberg.org/clambin/go-common/charmer"
 	"github.com/clambin/xcoder/ffmpeg"
+	"github.com/clambin/xcoder/internal/inventory"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
 var (
 	probeCmd = &cobra.Command{
-		Use:          "probe",
-		Short:        "Determine video properties of a media file",
+		Use:          "probe [file|dir]...",
+		Short:        "Determine video properties of media files and summarize them by codec & resolution",
+		Args:         cobra.MinimumNArgs(1),
 		SilenceUsage: true,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			for _, arg := range args {
-				stats, err := ffmpeg.Probe(arg)
-				if err != nil {
-					return err
-				}
-
-				if viper.GetBool("json") {
-					enc := json.NewEncoder(os.Stdout)
-					enc.SetIndent("", "  ")
-					_ = enc.Encode(stats)
-				} else {
-					fmt.Printf("%s: codec:%s bitrate:%s height:%d width:%d duration: %6s\n",
-						arg,
-						stats.VideoCodec,
-						ffmpeg.Bits(stats.BitRate).Format(1),
-						stats.Height,
-						stats.Width,
-						(time.Duration(math.Round(stats.Duration.Seconds())) * time.Second).String(),
-					)
-				}
+			priority, err := getPriority(viper.GetViper())
+			if err != nil {
+				return fmt.Errorf("invalid priority: %w", err)
+			}
+			filter, err := getInventoryFilter(viper.GetViper())
+			if err != nil {
+				return err
 			}
-			return nil
+			inv, err := inventory.Scan(cmd.Context(), args, filter, priority.Probe)
+			if err != nil {
+				return err
+			}
+			format, _ := cmd.Flags().GetString("format")
+			return inv.Write(os.Stdout, format)
 		},
 	}
 
 	probeArgs = charmer.Arguments{
-		"json": {Default: false, Help: "output properties as JSON"},
+		"codec":       {Default: "", Help: "only include files with this video codec, e.g. h264"},
+		"min-height":  {Default: "", Help: "only include files with at least this height, e.g. 1080"},
+		"min-bitrate": {Default: "", Help: "only include files with at least this bitrate, e.g. 8mbps"},
 	}
 )
 
 func init() {
 	rootCmd.AddCommand(probeCmd)
+	addFormatFlag(probeCmd, "table", "csv", "json")
 	if err := charmer.SetPersistentFlags(probeCmd, viper.GetViper(), probeArgs); err != nil {
 		panic(err)
 	}
 }
+
+func getInventoryFilter(v *viper.Viper) (inventory.Filter, error) {
+	f := inventory.Filter{Codec: v.GetString("codec")}
+	if s := v.GetString("min-height"); s != "" {
+		var err error
+		if f.MinHeight, err = strconv.Atoi(s); err != nil {
+			return f, fmt.Errorf("min-height: %w", err)
+		}
+	}
+	if s := v.GetString("min-bitrate"); s != "" {
+		bitrate, err := ffmpeg.ParseBits(s)
+		if err != nil {
+			return f, fmt.Errorf("min-bitrate: %w", err)
+		}
+		f.MinBitrate = int(bitrate)
+	}
+	return f, nil
+}
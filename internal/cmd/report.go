@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+
+	"codeberg.org/clambin/go-common/charmer"
+	"github.com/clambin/xcoder/internal/history"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	reportCmd = &cobra.Command{
+		Use:          "report",
+		Short:        "Summarize the history of transcoding sessions",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			h, err := history.Load(historyFilename())
+			if err != nil {
+				return err
+			}
+			r, err := history.Summarize(h.Records(), history.Period(viper.GetString("period")))
+			if err != nil {
+				return err
+			}
+			format, _ := cmd.Flags().GetString("format")
+			return r.Write(os.Stdout, format)
+		},
+	}
+
+	reportArgs = charmer.Arguments{
+		"period": {Default: "day", Help: "report throughput by day, week or month"},
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(reportCmd)
+	addFormatFlag(reportCmd, "table", "csv", "json")
+	if err := charmer.SetPersistentFlags(reportCmd, viper.GetViper(), reportArgs); err != nil {
+		panic(err)
+	}
+}
+
+func historyFilename() string {
+	return filepath.Join(mustConfigDir(), "history.jsonl")
+}
@@ -1,21 +1,33 @@
 package cmd
 
 import (
+	"cmp"
 	"context"
 	"fmt"
 	"io"
 	"log/slog"
+	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"runtime/debug"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	tea "charm.land/bubbletea/v2"
 	"codeberg.org/clambin/go-common/charmer"
+	"github.com/clambin/xcoder/ffmpeg"
+	"github.com/clambin/xcoder/internal/history"
+	"github.com/clambin/xcoder/internal/hooks"
 	"github.com/clambin/xcoder/internal/mediafiles"
+	"github.com/clambin/xcoder/internal/mediaserver"
+	"github.com/clambin/xcoder/internal/metrics"
+	"github.com/clambin/xcoder/internal/remote"
 	"github.com/clambin/xcoder/internal/transcoder"
 	"github.com/clambin/xcoder/internal/ui"
+	"github.com/clambin/xcoder/internal/web"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
@@ -32,13 +44,53 @@ var (
 
 	configFilename string
 
-	uiArgs = charmer.Arguments{
-		"active":     {Default: false, Help: "start processor in active mode"},
+	// logArgs are shared by all commands
+	logArgs = charmer.Arguments{
 		"log.format": {Default: "text", Help: "log format"},
 		"log.level":  {Default: "info", Help: "log level"},
-		"overwrite":  {Default: false, Help: "overwrite existing files"},
-		"remove":     {Default: false, Help: "remove source files after successful transcoding"},
-		"profile":    {Default: "hevc-high", Help: "transcoding profile"},
+	}
+
+	// ffmpegArgs determine how all commands run ffmpeg and ffprobe
+	ffmpegArgs = charmer.Arguments{
+		"ffmpeg.path":       {Default: "ffmpeg", Help: "path of the ffmpeg executable"},
+		"ffprobe.path":      {Default: "ffprobe", Help: "path of the ffprobe executable"},
+		"startup-check":     {Default: true, Help: "check that ffmpeg works before transcoding"},
+		"priority.nice":     {Default: "", Help: "nice level of ffmpeg and ffprobe processes, from -20 to 19 (unchanged if blank)"},
+		"priority.io-class": {Default: "", Help: "ionice class of ffmpeg and ffprobe processes: idle, best-effort or realtime (Linux only, unchanged if blank)"},
+		"priority.io-level": {Default: "4", Help: "ionice level within the best-effort and realtime classes, from 0 to 7"},
+	}
+
+	uiArgs = charmer.Arguments{
+		"active":                  {Default: false, Help: "start processor in active mode"},
+		"coordinator.addr":        {Default: "", Help: "address of the listener for remote workers (disabled if blank)"},
+		"coordinator.remote-only": {Default: false, Help: "leave all transcoding to remote workers"},
+		"crop.apply":              {Default: false, Help: "remove the black bars that were detected in source videos (see crop.detect)"},
+		"crop.detect":             {Default: false, Help: "detect black bars in source videos while scanning, by sampling them with ffmpeg's cropdetect filter"},
+		"deinterlace.detect":      {Default: false, Help: "detect interlaced source videos while scanning, by sampling them with ffmpeg's idet filter"},
+		"deinterlace.ivtc":        {Default: false, Help: "reverse the 3:2 pulldown of source videos that were detected as telecined (see deinterlace.detect)"},
+		"deinterlace.method":      {Default: "", Help: "deinterlacing of interlaced source videos: off, bwdif, yadif or hardware (default: off)"},
+		"framerate.constant":      {Default: false, Help: "convert source videos with a variable frame rate to a constant frame rate"},
+		"framerate.max":           {Default: "", Help: "maximum frame rate of the target video (default: the profile's maximum)"},
+		"headless":                {Default: false, Help: "run without the user interface, logging to stderr"},
+		"load.max":                {Default: "", Help: "maximum 1-minute load average per CPU to start a new session (disabled if blank)"},
+		"load.min-idle":           {Default: "", Help: "minimum CPU idle percentage to start a new session (Linux only, disabled if blank)"},
+		"load.probe":              {Default: "", Help: "command that must succeed to start a new session (disabled if blank)"},
+		"loudness.normalize":      {Default: false, Help: "measure the loudness of the audio streams before transcoding and normalize it to EBU R128"},
+		"metrics.addr":            {Default: "", Help: "address of the Prometheus metrics listener (disabled if blank)"},
+		"overwrite":               {Default: false, Help: "overwrite existing files"},
+		"remove":                  {Default: false, Help: "remove source files after successful transcoding"},
+		"profile":                 {Default: "hevc-high", Help: "transcoding profile"},
+		"retry.attempts":          {Default: "", Help: "maximum number of times a media file is transcoded, e.g. 3 (default: the profile's)"},
+		"retry.backends":          {Default: "", Help: "backends used for each attempt, separated by ',', e.g. 'hardware,software' (default: the profile's)"},
+		"retry.backoff":           {Default: "", Help: "delay before the first retry, doubled for each subsequent retry, e.g. 5m (default: the profile's)"},
+		"rules":                   {Default: "", Help: "additional profile rules, separated by ';', e.g. 'reject if codec == \"mpeg4\" && height < 576; skip if bitrate < 2mbps'"},
+		"schedule.action":         {Default: "finish", Help: "handling of active transcoding sessions when a processing window closes (finish, pause, cancel)"},
+		"schedule.windows":        {Default: "", Help: "processing windows for batch processing, separated by ';', e.g. 'mon-fri 22:00-06:00; sat,sun 00:00-24:00' (disabled if blank)"},
+		"segments.dir":            {Default: "", Help: "work directory for segmented encoding (default: user cache directory)"},
+		"segments.duration":       {Default: "", Help: "encode large files in segments of this duration, e.g. 10m (disabled if blank)"},
+		"segments.parallel":       {Default: false, Help: "encode segments in parallel, using free transcoding slots"},
+		"shutdown":                {Default: "drain", Help: "handling of active transcoding sessions on SIGINT/SIGTERM (drain, abort, detach)"},
+		"web.addr":                {Default: "", Help: "address of the web dashboard & API listener (disabled if blank)"},
 	}
 )
 
@@ -55,6 +107,12 @@ func init() {
 
 	cobra.OnInitialize(initConfig)
 	rootCmd.Flags().StringVar(&configFilename, "config", "", "Configuration file")
+	if err := charmer.SetPersistentFlags(&rootCmd, viper.GetViper(), logArgs); err != nil {
+		panic(err)
+	}
+	if err := charmer.SetPersistentFlags(&rootCmd, viper.GetViper(), ffmpegArgs); err != nil {
+		panic(err)
+	}
 	if err := charmer.SetFlags(&rootCmd, viper.GetViper(), uiArgs); err != nil {
 		panic(err)
 	}
@@ -70,6 +128,8 @@ func initConfig() {
 	if err := viper.ReadInConfig(); err != nil {
 		_, _ = fmt.Fprintln(os.Stderr, "failed to read config file: "+err.Error())
 	}
+	ffmpeg.Path = viper.GetString("ffmpeg.path")
+	ffmpeg.ProbePath = viper.GetString("ffprobe.path")
 }
 
 func mustConfigDir() string {
@@ -84,6 +144,167 @@ func mustConfigDir() string {
 	return cfgDir
 }
 
+func getSegmentConfiguration(v *viper.Viper) (transcoder.SegmentConfiguration, error) {
+	var cfg transcoder.SegmentConfiguration
+	if d := v.GetString("segments.duration"); d != "" {
+		duration, err := time.ParseDuration(d)
+		if err != nil {
+			return cfg, err
+		}
+		cfg.Duration = duration
+	}
+	cfg.Parallel = v.GetBool("segments.parallel")
+	cfg.WorkDir = v.GetString("segments.dir")
+	if cfg.WorkDir == "" && cfg.Duration > 0 {
+		cacheDir, err := os.UserCacheDir()
+		if err != nil {
+			return cfg, err
+		}
+		cfg.WorkDir = filepath.Join(cacheDir, "com.github.clambin.xcoder", "segments")
+	}
+	return cfg, nil
+}
+
+func getLoadConfiguration(v *viper.Viper) (transcoder.LoadConfiguration, error) {
+	var cfg transcoder.LoadConfiguration
+	var err error
+	if s := v.GetString("load.max"); s != "" {
+		if cfg.MaxLoad, err = strconv.ParseFloat(s, 64); err != nil {
+			return cfg, fmt.Errorf("load.max: %w", err)
+		}
+	}
+	if s := v.GetString("load.min-idle"); s != "" {
+		if cfg.MinIdle, err = strconv.ParseFloat(s, 64); err != nil {
+			return cfg, fmt.Errorf("load.min-idle: %w", err)
+		}
+	}
+	cfg.Probe = strings.Fields(v.GetString("load.probe"))
+	return cfg, nil
+}
+
+func getPriority(v *viper.Viper) (ffmpeg.Priority, error) {
+	p := ffmpeg.Priority{IOClass: v.GetString("priority.io-class")}
+	var err error
+	if s := v.GetString("priority.nice"); s != "" {
+		if p.Nice, err = strconv.Atoi(s); err != nil {
+			return p, fmt.Errorf("priority.nice: %w", err)
+		}
+	}
+	if s := v.GetString("priority.io-level"); s != "" {
+		if p.IOLevel, err = strconv.Atoi(s); err != nil {
+			return p, fmt.Errorf("priority.io-level: %w", err)
+		}
+	}
+	return p, p.Validate()
+}
+
+// addFormatFlag adds the --format flag, which selects the output format of the command, with its default and the
+// other supported formats. Each command has its own formats, but viper binds a key to one flag only, so the flag
+// isn't bound to viper: the command reads it with cmd.Flags().GetString("format").
+func addFormatFlag(cmd *cobra.Command, defaultFormat string, formats ...string) {
+	cmd.Flags().String("format", defaultFormat, "output format ("+strings.Join(append([]string{defaultFormat}, formats...), ", ")+")")
+}
+
+// addProfileFlag adds the --profile flag to a command that doesn't transcode, but needs a profile (e.g. plan).
+// Like --format, the flag isn't bound to viper, as "profile" is bound to the root command's flag.
+func addProfileFlag(cmd *cobra.Command) {
+	cmd.Flags().String("profile", "", "transcoding profile (default: the configured profile)")
+}
+
+// getCommandProfile returns the profile selected with the command's --profile flag or, if not set, the configured profile.
+func getCommandProfile(cmd *cobra.Command) (transcoder.Profile, error) {
+	name, _ := cmd.Flags().GetString("profile")
+	return getProfile(viper.GetViper(), cmp.Or(name, viper.GetString("profile")))
+}
+
+// getProfile returns the named profile. The rules configured in "rules" are evaluated before the profile's own rules.
+// If "deinterlace.method" is set, it replaces the profile's deinterlacing method. "crop.apply" and "deinterlace.ivtc"
+// turn on cropping and inverse telecine, "framerate.constant" and "framerate.max" override the profile's frame rate
+// handling, "loudness.normalize" turns on loudness normalization and "retry.*" override the profile's retry policy.
+func getProfile(v *viper.Viper, name string) (transcoder.Profile, error) {
+	profile, err := transcoder.GetProfile(name)
+	if err != nil {
+		return profile, err
+	}
+	var rules []string
+	for rule := range strings.SplitSeq(v.GetString("rules"), ";") {
+		if rule = strings.TrimSpace(rule); rule != "" {
+			rules = append(rules, rule)
+		}
+	}
+	parsed, err := transcoder.ParseRules(rules)
+	if err != nil {
+		return profile, err
+	}
+	profile.Rules = append(parsed, profile.Rules...)
+	if method := v.GetString("deinterlace.method"); method != "" {
+		if profile.Deinterlace, err = transcoder.ParseDeinterlace(method); err != nil {
+			return profile, err
+		}
+	}
+	if v.GetBool("crop.apply") {
+		profile.Crop = true
+	}
+	if v.GetBool("deinterlace.ivtc") {
+		profile.InverseTelecine = true
+	}
+	if v.GetBool("framerate.constant") {
+		profile.ConstantFrameRate = true
+	}
+	if v.GetBool("loudness.normalize") {
+		profile.NormalizeLoudness = true
+	}
+	if s := v.GetString("framerate.max"); s != "" {
+		if profile.MaxFrameRate, err = strconv.ParseFloat(s, 64); err != nil {
+			return profile, fmt.Errorf("framerate.max: %w", err)
+		}
+	}
+	profile.Retry, err = getRetryPolicy(v, profile.Retry)
+	return profile, err
+}
+
+// getRetryPolicy applies the "retry.*" settings to the profile's retry policy.
+func getRetryPolicy(v *viper.Viper, policy transcoder.RetryPolicy) (transcoder.RetryPolicy, error) {
+	var err error
+	if s := v.GetString("retry.attempts"); s != "" {
+		if policy.MaxAttempts, err = strconv.Atoi(s); err != nil {
+			return policy, fmt.Errorf("retry.attempts: %w", err)
+		}
+	}
+	if s := v.GetString("retry.backoff"); s != "" {
+		if policy.Backoff, err = time.ParseDuration(s); err != nil {
+			return policy, fmt.Errorf("retry.backoff: %w", err)
+		}
+	}
+	if s := v.GetString("retry.backends"); s != "" {
+		var backends []string
+		for backend := range strings.SplitSeq(s, ",") {
+			backend = strings.TrimSpace(backend)
+			if _, err = transcoder.GetBackend(backend); err != nil {
+				return policy, fmt.Errorf("retry.backends: %w", err)
+			}
+			backends = append(backends, backend)
+		}
+		policy.Backends = backends
+	}
+	return policy, nil
+}
+
+func getSchedule(v *viper.Viper) (transcoder.Schedule, transcoder.ScheduleAction, error) {
+	action, err := transcoder.ParseScheduleAction(v.GetString("schedule.action"))
+	if err != nil {
+		return nil, "", err
+	}
+	var windows []string
+	for window := range strings.SplitSeq(v.GetString("schedule.windows"), ";") {
+		if window = strings.TrimSpace(window); window != "" {
+			windows = append(windows, window)
+		}
+	}
+	schedule, err := transcoder.ParseSchedule(windows)
+	return schedule, action, err
+}
+
 func runUI(ctx context.Context, v *viper.Viper, args []string) error {
 	if len(args) == 0 {
 		args = []string{"."}
@@ -92,9 +313,36 @@ func runUI(ctx context.Context, v *viper.Viper, args []string) error {
 	var q transcoder.WorkItems
 
 	profileName := v.GetString("profile")
-	profile, err := transcoder.GetProfile(profileName)
+	profile, err := getProfile(v, profileName)
+	if err != nil {
+		return fmt.Errorf("invalid profile %q: %w", profileName, err)
+	}
+
+	shutdownMode := transcoder.ShutdownMode(v.GetString("shutdown"))
+	switch shutdownMode {
+	case transcoder.ShutdownDrain, transcoder.ShutdownAbort, transcoder.ShutdownDetach:
+	default:
+		return fmt.Errorf("invalid shutdown mode %q", string(shutdownMode))
+	}
+
+	segments, err := getSegmentConfiguration(v)
+	if err != nil {
+		return fmt.Errorf("invalid segment parameters: %w", err)
+	}
+
+	schedule, scheduleAction, err := getSchedule(v)
+	if err != nil {
+		return fmt.Errorf("invalid schedule: %w", err)
+	}
+
+	load, err := getLoadConfiguration(v)
+	if err != nil {
+		return fmt.Errorf("invalid load parameters: %w", err)
+	}
+
+	priority, err := getPriority(v)
 	if err != nil {
-		return fmt.Errorf("invalid profile name %q: %w", profileName, err)
+		return fmt.Errorf("invalid priority: %w", err)
 	}
 
 	r, logger, err := getLogger(v)
@@ -102,19 +350,105 @@ func runUI(ctx context.Context, v *viper.Viper, args []string) error {
 		return fmt.Errorf("invalid logger parameters: %w", err)
 	}
 
+	if v.GetBool("startup-check") {
+		// remote workers do the transcoding, so we only need ffprobe
+		backends := profile.Backends()
+		if v.GetBool("coordinator.remote-only") {
+			backends = nil
+		}
+		// nothing reads the logger's pipe until the UI (or, when headless, stderr) takes over,
+		// so the check logs to stderr directly
+		checkLogger, err := newLogger(v, os.Stderr)
+		if err != nil {
+			return fmt.Errorf("invalid logger parameters: %w", err)
+		}
+		if err = startupCheck(ctx, backends, priority, checkLogger); err != nil {
+			return fmt.Errorf("startup check failed: %w", err)
+		}
+	}
+
+	overrides, err := transcoder.LoadOverrides(filepath.Join(mustConfigDir(), "overrides.json"))
+	if err != nil {
+		return fmt.Errorf("invalid overrides: %w", err)
+	}
+
+	h, err := history.Load(historyFilename())
+	if err != nil {
+		return fmt.Errorf("invalid history: %w", err)
+	}
+
 	cfg := transcoder.Configuration{
-		BaseDir:         args[0],
-		Profile:         profile,
-		OverwriteTarget: v.GetBool("overwrite"),
-		RemoveSource:    v.GetBool("remove"),
+		Overrides:         overrides,
+		History:           h,
+		BaseDir:           args[0],
+		Profile:           profile,
+		GetProfile:        func(name string) (transcoder.Profile, error) { return getProfile(v, name) },
+		Segments:          segments,
+		Schedule:          schedule,
+		ScheduleAction:    scheduleAction,
+		Load:              load,
+		Priority:          priority,
+		OverwriteTarget:   v.GetBool("overwrite"),
+		RemoveSource:      v.GetBool("remove"),
+		RemoteOnly:        v.GetBool("coordinator.remote-only"),
+		DetectInterlacing: v.GetBool("deinterlace.detect"),
+		DetectCrop:        v.GetBool("crop.detect"),
 	}
 
 	tr := transcoder.New(&q, cfg, logger)
 	tr.SetActive(v.GetBool("active"))
 
-	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	var hookCfg []hooks.Hook
+	if err = v.UnmarshalKey("hooks", &hookCfg); err != nil {
+		return fmt.Errorf("invalid hooks: %w", err)
+	}
+	hookRunner, err := hooks.New(hookCfg, &q, tr, logger.With(slog.String("component", "hooks")))
+	if err != nil {
+		return fmt.Errorf("invalid hooks: %w", err)
+	}
+
+	var mediaServerCfg []mediaserver.Server
+	if err = v.UnmarshalKey("mediaservers", &mediaServerCfg); err != nil {
+		return fmt.Errorf("invalid media servers: %w", err)
+	}
+	refresher, err := mediaserver.New(mediaServerCfg, tr, logger.With(slog.String("component", "mediaserver")))
+	if err != nil {
+		return fmt.Errorf("invalid media servers: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 	go func() { _ = tr.Run(ctx) }()
+	go func() { _ = hookRunner.Run(ctx) }()
+	go func() { _ = refresher.Run(ctx) }()
+
+	if addr := v.GetString("metrics.addr"); addr != "" {
+		m := metrics.New(&q, tr)
+		go func() { _ = m.Run(ctx) }()
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", m)
+		go func() {
+			if err := runHTTPServer(ctx, addr, mux); err != nil {
+				logger.Error("failed to start metrics listener", "err", err)
+			}
+		}()
+	}
+
+	if addr := v.GetString("web.addr"); addr != "" {
+		go func() {
+			if err := runHTTPServer(ctx, addr, web.New(&q, tr, logger.With(slog.String("component", "web")))); err != nil {
+				logger.Error("failed to start web listener", "err", err)
+			}
+		}()
+	}
+
+	if addr := v.GetString("coordinator.addr"); addr != "" {
+		go func() {
+			if err := runHTTPServer(ctx, addr, remote.NewCoordinator(tr, logger.With(slog.String("component", "coordinator")))); err != nil {
+				logger.Error("failed to start coordinator listener", "err", err)
+			}
+		}()
+	}
 
 	go func() {
 		err := mediafiles.FindMediaFiles(cfg.BaseDir, func(path string) {
@@ -125,26 +459,63 @@ func runUI(ctx context.Context, v *viper.Viper, args []string) error {
 		}
 	}()
 
+	// SIGINT & SIGTERM shut down the transcoder as per the shutdown mode
+	sigCtx, sigCancel := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer sigCancel()
+
+	if v.GetBool("headless") {
+		go func() { _, _ = io.Copy(os.Stderr, r) }()
+		<-sigCtx.Done()
+		return tr.Shutdown(context.Background(), shutdownMode)
+	}
+
 	u := ui.New(&q, tr, profileName, r, ui.DefaultKeyMap(), ui.DefaultStyles())
 	a := tea.NewProgram(u, tea.WithoutCatchPanics())
+	go func() {
+		<-sigCtx.Done()
+		a.Send(ui.ShutdownMsg{Mode: shutdownMode})
+	}()
 	_, err = a.Run()
 	return err
 }
 
+// runHTTPServer serves the handler on addr until the context is canceled.
+func runHTTPServer(ctx context.Context, addr string, handler http.Handler) error {
+	s := http.Server{Addr: addr, Handler: handler, ReadHeaderTimeout: 5 * time.Second}
+	errCh := make(chan error, 1)
+	go func() { errCh <- s.ListenAndServe() }()
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+		return s.Shutdown(context.Background())
+	}
+}
+
+// getLogger returns a logger that writes to a pipe, and the reader of that pipe. The logger blocks until the pipe is read.
 func getLogger(v *viper.Viper) (io.Reader, *slog.Logger, error) {
+	r, w := io.Pipe()
+	logger, err := newLogger(v, w)
+	if err != nil {
+		return nil, nil, err
+	}
+	return r, logger, nil
+}
+
+// newLogger returns a logger that writes to w, as per the "log.level" and "log.format" configuration.
+func newLogger(v *viper.Viper, w io.Writer) (*slog.Logger, error) {
 	var lvl slog.Level
 	if err := lvl.UnmarshalText([]byte(v.GetString("log.level"))); err != nil {
-		return nil, nil, fmt.Errorf("invalid log level %q: %w", v.GetString("log.level"), err)
+		return nil, fmt.Errorf("invalid log level %q: %w", v.GetString("log.level"), err)
 	}
 	opts := slog.HandlerOptions{Level: lvl}
 
-	r, w := io.Pipe()
 	switch strings.ToLower(v.GetString("log.format")) {
 	case "text":
-		return r, slog.New(slog.NewTextHandler(w, &opts)), nil
+		return slog.New(slog.NewTextHandler(w, &opts)), nil
 	case "json":
-		return r, slog.New(slog.NewJSONHandler(w, &opts)), nil
+		return slog.New(slog.NewJSONHandler(w, &opts)), nil
 	default:
-		return nil, nil, fmt.Errorf("invalid log format %q", v.GetString("log.format"))
+		return nil, fmt.Errorf("invalid log format %q", v.GetString("log.format"))
 	}
 }
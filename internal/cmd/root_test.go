@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"codeberg.org/clambin/go-common/charmer"
+	"github.com/clambin/xcoder/ffmpeg"
+	"github.com/clambin/xcoder/internal/transcoder"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunUI_Defaults(t *testing.T) {
+	// fake ffmpeg & ffprobe that pass the startup check
+	dir := t.TempDir()
+	for _, name := range []string{"ffmpeg", "ffprobe"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte(`#!/bin/sh
+[ "$1" = "-version" ] && echo "`+name+` version 7.1.1 Copyright (c) 2000-2025 the FFmpeg developers"
+exit 0
+`), 0o755))
+	}
+	path, probePath := ffmpeg.Path, ffmpeg.ProbePath
+	ffmpeg.Path, ffmpeg.ProbePath = filepath.Join(dir, "ffmpeg"), filepath.Join(dir, "ffprobe")
+	t.Cleanup(func() { ffmpeg.Path, ffmpeg.ProbePath = path, probePath })
+	// keep overrides & history out of the user's config directory
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+
+	v := viper.New()
+	var cmd cobra.Command
+	for _, args := range []charmer.Arguments{logArgs, ffmpegArgs, uiArgs} {
+		require.NoError(t, charmer.SetFlags(&cmd, v, args))
+	}
+	require.True(t, v.GetBool("startup-check"))
+	// the UI needs a terminal, so run headless. all other settings are the defaults
+	v.Set("headless", true)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	errCh := make(chan error, 1)
+	go func() { errCh <- runUI(ctx, v, []string{t.TempDir()}) }()
+
+	// runUI runs until canceled. if the startup check blocks on the logger, it never returns.
+	time.Sleep(time.Second)
+	cancel()
+	select {
+	case err := <-errCh:
+		assert.NoError(t, err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("runUI did not return")
+	}
+}
+
+func TestSubcommandFlags(t *testing.T) {
+	// all commands use the same flag names
+	for _, cmd := range []*cobra.Command{reportCmd, verifyCmd, probeCmd, planCmd, doctorCmd} {
+		assert.NotNil(t, cmd.Flags().Lookup("format"), cmd.Name())
+	}
+	for _, cmd := range []*cobra.Command{verifyCmd, planCmd} {
+		assert.NotNil(t, cmd.Flags().Lookup("profile"), cmd.Name())
+	}
+}
+
+func TestGetCommandProfile(t *testing.T) {
+	var cmd cobra.Command
+	addProfileFlag(&cmd)
+
+	// without --profile, the configured profile is used
+	profile, err := getCommandProfile(&cmd)
+	require.NoError(t, err)
+	assert.Equal(t, "hevc-high", profile.Name)
+
+	require.NoError(t, cmd.Flags().Set("profile", "hevc-low"))
+	profile, err = getCommandProfile(&cmd)
+	require.NoError(t, err)
+	assert.Equal(t, "hevc-low", profile.Name)
+
+	require.NoError(t, cmd.Flags().Set("profile", "invalid"))
+	_, err = getCommandProfile(&cmd)
+	assert.Error(t, err)
+}
+
+func TestGetProfile_Retry(t *testing.T) {
+	v := viper.New()
+	profile, err := getProfile(v, "hevc-high")
+	require.NoError(t, err)
+	assert.Equal(t, 2, profile.Retry.MaxAttempts)
+
+	v.Set("retry.attempts", "3")
+	v.Set("retry.backoff", "5m")
+	v.Set("retry.backends", "hardware, software")
+	profile, err = getProfile(v, "hevc-high")
+	require.NoError(t, err)
+	assert.Equal(t, transcoder.RetryPolicy{MaxAttempts: 3, Backoff: 5 * time.Minute, Backends: []string{"hardware", "software"}}, profile.Retry)
+
+	for key, value := range map[string]string{"retry.attempts": "many", "retry.backoff": "soon", "retry.backends": "hardware,gpu"} {
+		v := viper.New()
+		v.Set(key, value)
+		_, err = getProfile(v, "hevc-high")
+		assert.Error(t, err, key)
+	}
+}
@@ -1,60 +1,102 @@
 package cmd
 
 import (
-	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
-	"path/filepath"
+	"strconv"
 
+	"codeberg.org/clambin/go-common/charmer"
 	"github.com/clambin/xcoder/ffmpeg"
-	"github.com/clambin/xcoder/internal/transcoder"
+	"github.com/clambin/xcoder/internal/verify"
 	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
 )
 
 var (
 	verifyCmd = &cobra.Command{
-		Use:   "verify",
-		Short: "Verify media files",
+		Use:          "verify [file|dir]...",
+		Short:        "Verify media files, optionally comparing them with their source",
+		Args:         cobra.MinimumNArgs(1),
+		SilenceUsage: true,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			for _, arg := range args {
-				verify(cmd.Context(), arg)
+			priority, err := getPriority(viper.GetViper())
+			if err != nil {
+				return fmt.Errorf("invalid priority: %w", err)
+			}
+			var workers int
+			if s := viper.GetString("verify.workers"); s != "" {
+				if workers, err = strconv.Atoi(s); err != nil || workers < 0 {
+					return fmt.Errorf("invalid verify.workers %q", s)
+				}
+			}
+			format, _ := cmd.Flags().GetString("format")
+			if format != "text" && format != "json" && format != "junit" {
+				return fmt.Errorf("invalid format %q", format)
+			}
+			files, err := verify.Files(args)
+			if err != nil {
+				return err
+			}
+			items, err := verifyItems(cmd, files, priority)
+			if err != nil {
+				return err
+			}
+
+			v := verify.Verifier{Priority: priority, Workers: workers, Logger: slog.Default()}
+			var onResult func(verify.Result)
+			if format == "text" {
+				// show results as they come in
+				onResult = func(r verify.Result) { fmt.Println(r) }
+			}
+			r := v.Verify(cmd.Context(), items, onResult)
+			if format == "text" {
+				fmt.Println(r.Summary())
+			} else if err = r.Write(os.Stdout, format); err != nil {
+				return err
+			}
+			if failed := r.Failed(); failed > 0 {
+				return fmt.Errorf("%d of %d files failed verification", failed, len(r.Results))
 			}
 			return nil
 		},
 	}
+
+	verifyArgs = charmer.Arguments{
+		"against":        {Default: "", Help: "source file to compare the file with"},
+		"pair":           {Default: false, Help: "compare each file with its target, if it exists"},
+		"verify.workers": {Default: "", Help: "number of files to verify in parallel (default: number of CPUs)"},
+	}
 )
 
 func init() {
 	rootCmd.AddCommand(verifyCmd)
+	addFormatFlag(verifyCmd, "text", "json", "junit")
+	addProfileFlag(verifyCmd)
+	if err := charmer.SetPersistentFlags(verifyCmd, viper.GetViper(), verifyArgs); err != nil {
+		panic(err)
+	}
 }
 
-func verify(ctx context.Context, path string) {
-	stats, err := ffmpeg.Probe(path)
-	if err != nil {
-		fmt.Printf("\r%s FAIL: %v\n", path, err)
-		return
+// verifyItems returns the files to verify, paired with their source if requested.
+func verifyItems(cmd *cobra.Command, files []string, priority ffmpeg.Priority) ([]verify.Item, error) {
+	source, pair := viper.GetString("against"), viper.GetBool("pair")
+	switch {
+	case source != "" && pair:
+		return nil, errors.New("--against and --pair are mutually exclusive")
+	case source != "" && len(files) != 1:
+		return nil, errors.New("--against requires exactly one file to verify")
+	case source == "" && !pair:
+		return verify.Items(files), nil
 	}
-
-	tmpDir, err := os.MkdirTemp(os.TempDir(), "xcoder")
+	// the profile determines how the files were converted from their source
+	profile, err := getCommandProfile(cmd)
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
-	tempSocketPath := filepath.Join(tmpDir, "ffmpeg-verify.sock")
-
-	f := ffmpeg.
-		Decode(path, transcoder.DecoderArguments(ffmpeg.VideoStats{VideoCodec: "hevc"})...).
-		Muxer("null").LogLevel("error").
-		NoStats().
-		Progress(func(p ffmpeg.Progress) {
-			progress := p.Converted.Seconds() / stats.Duration.Seconds()
-			fmt.Printf("\r%s ... %.1f%%", path, 100*progress)
-		}, tempSocketPath)
-
-	err = f.Run(ctx, slog.Default())
-	if err == nil {
-		fmt.Println(" PASS")
-	} else {
-		fmt.Println(" FAIL: ", err.Error())
+	if source != "" {
+		return []verify.Item{verify.Against(files[0], source, profile, priority)}, nil
 	}
+	return verify.Pair(files, profile, priority), nil
 }
@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"codeberg.org/clambin/go-common/charmer"
+	"github.com/clambin/xcoder/internal/remote"
+	"github.com/clambin/xcoder/internal/transcoder"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var (
+	workerCmd = &cobra.Command{
+		Use:          "worker",
+		Short:        "Transcode media files leased from a coordinator",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runWorker(cmd.Context(), viper.GetViper())
+		},
+	}
+
+	workerArgs = charmer.Arguments{
+		"coordinator.url": {Default: "http://localhost:9091", Help: "URL of the coordinator"},
+		"worker.name":     {Default: "", Help: "name of the worker (default: hostname)"},
+	}
+)
+
+func init() {
+	rootCmd.AddCommand(workerCmd)
+	if err := charmer.SetFlags(workerCmd, viper.GetViper(), workerArgs); err != nil {
+		panic(err)
+	}
+}
+
+func runWorker(ctx context.Context, v *viper.Viper) error {
+	priority, err := getPriority(v)
+	if err != nil {
+		return fmt.Errorf("invalid priority: %w", err)
+	}
+
+	r, logger, err := getLogger(v)
+	if err != nil {
+		return fmt.Errorf("invalid logger parameters: %w", err)
+	}
+	go func() { _, _ = io.Copy(os.Stderr, r) }()
+
+	if v.GetBool("startup-check") {
+		if err = startupCheck(ctx, transcoder.SupportedBackends(), priority, logger); err != nil {
+			return fmt.Errorf("startup check failed: %w", err)
+		}
+	}
+
+	name := v.GetString("worker.name")
+	if name == "" {
+		if name, err = os.Hostname(); err != nil {
+			return fmt.Errorf("worker name: %w", err)
+		}
+	}
+
+	// the worker only uses the transcoder to run sessions: the coordinator owns the work items and the scheduling
+	tr := transcoder.New(&transcoder.WorkItems{}, transcoder.Configuration{Priority: priority}, logger)
+
+	ctx, cancel := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	url := v.GetString("coordinator.url")
+	logger.Info("worker started", "coordinator", url, "name", name)
+	return remote.NewWorker(url, name, tr, logger.With(slog.String("worker", name))).Run(ctx)
+}
@@ -0,0 +1,117 @@
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/clambin/xcoder/ffmpeg"
+)
+
+// A Record describes one transcoding session.
+//
+//nolint:tagliatelle
+type Record struct {
+	Start   time.Time `json:"start"`
+	End     time.Time `json:"end"`
+	Source  File      `json:"source"`
+	Target  File      `json:"target"`
+	Profile string    `json:"profile,omitempty"`
+	Backend string    `json:"backend,omitempty"`
+	Error   string    `json:"error,omitempty"`
+	// Speed is the average transcoding speed of the session, relative to real time.
+	Speed float64 `json:"speed"`
+}
+
+// Succeeded returns true if the session converted the source file.
+func (r Record) Succeeded() bool {
+	return r.Error == ""
+}
+
+// Saved returns the difference in size between the source and target file.
+func (r Record) Saved() int64 {
+	if !r.Succeeded() {
+		return 0
+	}
+	return r.Source.Size - r.Target.Size
+}
+
+// A File describes the source or target of a transcoding session.
+type File struct {
+	Path  string            `json:"path"`
+	Stats ffmpeg.VideoStats `json:"stats"`
+	Size  int64             `json:"size"`
+}
+
+// History records transcoding sessions. If a filename is set, each Record is appended to the file,
+// one JSON object per line, so the history survives a restart.
+type History struct {
+	filename string
+	records  []Record
+	mu       sync.Mutex
+}
+
+// Load loads the history from filename. If the file does not exist, it returns an empty history.
+func Load(filename string) (*History, error) {
+	h := History{filename: filename}
+	f, err := os.Open(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return &h, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read history: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		var r Record
+		if err = json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			return nil, fmt.Errorf("parse history line %d: %w", line, err)
+		}
+		h.records = append(h.records, r)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read history: %w", err)
+	}
+	return &h, nil
+}
+
+// Add adds a Record to the history and, if a filename is set, appends it to the history file.
+func (h *History) Add(r Record) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.records = append(h.records, r)
+	if h.filename == "" {
+		return nil
+	}
+	body, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("encode history: %w", err)
+	}
+	f, err := os.OpenFile(h.filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("write history: %w", err)
+	}
+	_, err = f.Write(append(body, '\n'))
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		return fmt.Errorf("write history: %w", err)
+	}
+	return nil
+}
+
+// Records returns all recorded sessions.
+func (h *History) Records() []Record {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return slices.Clone(h.records)
+}
@@ -0,0 +1,144 @@
+package history
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/clambin/xcoder/ffmpeg"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistory(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "history.jsonl")
+
+	h, err := Load(filename)
+	require.NoError(t, err)
+	assert.Empty(t, h.Records())
+
+	start := time.Date(2024, time.March, 1, 12, 0, 0, 0, time.UTC)
+	records := []Record{
+		{Start: start, End: start.Add(time.Hour), Source: File{Path: "foo.mkv", Size: 1000}, Target: File{Path: "foo.hevc.mkv", Size: 400}, Speed: 2},
+		{Start: start, End: start.Add(time.Minute), Source: File{Path: "bar.mkv", Size: 1000}, Error: "failed"},
+	}
+	for _, r := range records {
+		require.NoError(t, h.Add(r))
+	}
+	assert.Equal(t, records, h.Records())
+
+	// history is persisted
+	h, err = Load(filename)
+	require.NoError(t, err)
+	assert.Equal(t, records, h.Records())
+	assert.Equal(t, int64(600), h.Records()[0].Saved())
+	assert.Zero(t, h.Records()[1].Saved())
+
+	// invalid content
+	require.NoError(t, os.WriteFile(filename, []byte("invalid"), 0o644))
+	_, err = Load(filename)
+	assert.Error(t, err)
+}
+
+func TestSummarize(t *testing.T) {
+	day := time.Date(2024, time.March, 6, 12, 0, 0, 0, time.UTC) // a Wednesday
+	h264 := ffmpeg.VideoStats{VideoCodec: "h264", Height: 1080, Duration: time.Hour}
+	records := []Record{
+		{End: day, Source: File{Stats: h264, Size: 1000}, Target: File{Size: 400}, Speed: 2},
+		{End: day, Source: File{Stats: h264, Size: 1000}, Target: File{Size: 600}, Speed: 4},
+		{End: day, Source: File{Stats: h264}, Error: "failed"},
+		{End: day.AddDate(0, 0, 1), Source: File{Stats: ffmpeg.VideoStats{VideoCodec: "mpeg4", Height: 720, Duration: 30 * time.Minute}, Size: 500}, Target: File{Size: 100}, Speed: 1},
+	}
+
+	r, err := Summarize(records, Daily)
+	require.NoError(t, err)
+	assert.Equal(t, 4, r.Sessions)
+	assert.Equal(t, 3, r.Converted)
+	assert.Equal(t, 1, r.Failed)
+	assert.Equal(t, int64(1400), r.SpaceSaved)
+	assert.Equal(t, 2.5, r.MediaHours)
+	assert.Equal(t, []FailureRate{
+		{Codec: "h264", Resolution: "1080p", Sessions: 3, Failed: 1, Rate: 1.0 / 3},
+		{Codec: "mpeg4", Resolution: "720p", Sessions: 1, Failed: 0, Rate: 0},
+	}, r.Failures)
+	assert.Equal(t, []Throughput{
+		{Start: time.Date(2024, time.March, 6, 0, 0, 0, 0, time.UTC), Converted: 2, MediaHours: 2, SpaceSaved: 1000, Speed: 3},
+		{Start: time.Date(2024, time.March, 7, 0, 0, 0, 0, time.UTC), Converted: 1, MediaHours: 0.5, SpaceSaved: 400, Speed: 1},
+	}, r.Throughput)
+
+	for period, want := range map[Period]time.Time{
+		Weekly:  time.Date(2024, time.March, 4, 0, 0, 0, 0, time.UTC),
+		Monthly: time.Date(2024, time.March, 1, 0, 0, 0, 0, time.UTC),
+	} {
+		r, err = Summarize(records, period)
+		require.NoError(t, err)
+		require.Len(t, r.Throughput, 1)
+		assert.Equal(t, want, r.Throughput[0].Start)
+		assert.Equal(t, 3, r.Throughput[0].Converted)
+	}
+
+	_, err = Summarize(records, "year")
+	assert.Error(t, err)
+}
+
+func TestReport_Write(t *testing.T) {
+	r := Report{
+		Sessions:   2,
+		Converted:  1,
+		Failed:     1,
+		SpaceSaved: 1_500_000_000,
+		MediaHours: 1.5,
+		Failures:   []FailureRate{{Codec: "h264", Resolution: "1080p", Sessions: 2, Failed: 1, Rate: 0.5}},
+		Throughput: []Throughput{{Start: time.Date(2024, time.March, 6, 0, 0, 0, 0, time.UTC), Converted: 1, MediaHours: 1.5, SpaceSaved: 1_500_000_000, Speed: 2.5}},
+	}
+
+	tests := []struct {
+		format  string
+		want    string
+		wantErr assert.ErrorAssertionFunc
+	}{
+		{
+			format: "table",
+			want: `Sessions:         2
+Converted:        1
+Failed:           1
+Media converted:  1.5 hours
+Space saved:      1.5 GB
+
+CODEC  RESOLUTION  SESSIONS  FAILED  RATE
+h264   1080p       2         1       50.0%
+
+PERIOD      CONVERTED  HOURS  SAVED   SPEED
+2024-03-06  1          1.5    1.5 GB  2.50x
+`,
+			wantErr: assert.NoError,
+		},
+		{
+			format: "csv",
+			want: `sessions,converted,failed,media_hours,space_saved
+2,1,1,1.5,1500000000
+
+codec,resolution,sessions,failed,rate
+h264,1080p,2,1,0.5
+
+period,converted,media_hours,space_saved,speed
+2024-03-06,1,1.5,1500000000,2.5
+`,
+			wantErr: assert.NoError,
+		},
+		{
+			format:  "xml",
+			wantErr: assert.Error,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var output strings.Builder
+			tt.wantErr(t, r.Write(&output, tt.format))
+			assert.Equal(t, tt.want, output.String())
+		})
+	}
+}
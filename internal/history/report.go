@@ -0,0 +1,203 @@
+package history
+
+import (
+	"cmp"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/clambin/xcoder/ffmpeg"
+)
+
+// Period determines how a Report groups sessions over time.
+type Period string
+
+const (
+	Daily   Period = "day"
+	Weekly  Period = "week"
+	Monthly Period = "month"
+)
+
+// start returns the start of the period that holds t.
+func (p Period) start(t time.Time) (time.Time, error) {
+	year, month, day := t.Date()
+	switch p {
+	case Daily:
+		return time.Date(year, month, day, 0, 0, 0, 0, t.Location()), nil
+	case Weekly:
+		// weeks start on Monday
+		offset := (int(t.Weekday()) + 6) % 7
+		return time.Date(year, month, day-offset, 0, 0, 0, 0, t.Location()), nil
+	case Monthly:
+		return time.Date(year, month, 1, 0, 0, 0, 0, t.Location()), nil
+	default:
+		return time.Time{}, fmt.Errorf("invalid period %q", string(p))
+	}
+}
+
+// A Report summarizes the history of transcoding sessions.
+//
+//nolint:tagliatelle
+type Report struct {
+	Failures   []FailureRate `json:"failures"`
+	Throughput []Throughput  `json:"throughput"`
+	Sessions   int           `json:"sessions"`
+	Converted  int           `json:"converted"`
+	Failed     int           `json:"failed"`
+	SpaceSaved int64         `json:"space_saved"`
+	MediaHours float64       `json:"media_hours"`
+}
+
+// FailureRate reports the failed sessions for one source codec & resolution.
+type FailureRate struct {
+	Codec      string  `json:"codec"`
+	Resolution string  `json:"resolution"`
+	Sessions   int     `json:"sessions"`
+	Failed     int     `json:"failed"`
+	Rate       float64 `json:"rate"`
+}
+
+// Throughput reports the converted media for one period.
+//
+//nolint:tagliatelle
+type Throughput struct {
+	Start      time.Time `json:"start"`
+	Converted  int       `json:"converted"`
+	MediaHours float64   `json:"media_hours"`
+	SpaceSaved int64     `json:"space_saved"`
+	Speed      float64   `json:"speed"`
+}
+
+// Summarize creates a Report for the records, grouping throughput by period.
+func Summarize(records []Record, period Period) (Report, error) {
+	var r Report
+	failures := make(map[[2]string]*FailureRate)
+	throughput := make(map[time.Time]*Throughput)
+
+	for _, record := range records {
+		start, err := period.start(record.End)
+		if err != nil {
+			return Report{}, err
+		}
+
+		key := [2]string{record.Source.Stats.VideoCodec, resolution(record.Source.Stats.Height)}
+		f, ok := failures[key]
+		if !ok {
+			f = &FailureRate{Codec: key[0], Resolution: key[1]}
+			failures[key] = f
+		}
+		f.Sessions++
+		r.Sessions++
+
+		if !record.Succeeded() {
+			f.Failed++
+			r.Failed++
+			continue
+		}
+
+		t, ok := throughput[start]
+		if !ok {
+			t = &Throughput{Start: start}
+			throughput[start] = t
+		}
+		hours := record.Source.Stats.Duration.Hours()
+		t.Converted++
+		t.MediaHours += hours
+		t.SpaceSaved += record.Saved()
+		t.Speed += record.Speed
+		r.Converted++
+		r.MediaHours += hours
+		r.SpaceSaved += record.Saved()
+	}
+
+	for _, f := range failures {
+		f.Rate = float64(f.Failed) / float64(f.Sessions)
+		r.Failures = append(r.Failures, *f)
+	}
+	slices.SortFunc(r.Failures, func(a, b FailureRate) int {
+		return cmp.Or(cmp.Compare(a.Codec, b.Codec), cmp.Compare(b.Resolution, a.Resolution))
+	})
+	for _, t := range throughput {
+		// average speed
+		t.Speed /= float64(t.Converted)
+		r.Throughput = append(r.Throughput, *t)
+	}
+	slices.SortFunc(r.Throughput, func(a, b Throughput) int { return a.Start.Compare(b.Start) })
+	return r, nil
+}
+
+func resolution(height int) string {
+	if height <= 0 {
+		return "unknown"
+	}
+	return strconv.Itoa(height) + "p"
+}
+
+// Write writes the Report in the requested format: "table", "csv" or "json".
+func (r Report) Write(w io.Writer, format string) error {
+	switch format {
+	case "table":
+		return r.writeTable(w)
+	case "csv":
+		return r.writeCSV(w)
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(r)
+	default:
+		return fmt.Errorf("invalid format %q", format)
+	}
+}
+
+func (r Report) writeTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintf(tw, "Sessions:\t%d\n", r.Sessions)
+	_, _ = fmt.Fprintf(tw, "Converted:\t%d\n", r.Converted)
+	_, _ = fmt.Fprintf(tw, "Failed:\t%d\n", r.Failed)
+	_, _ = fmt.Fprintf(tw, "Media converted:\t%.1f hours\n", r.MediaHours)
+	_, _ = fmt.Fprintf(tw, "Space saved:\t%s\n", ffmpeg.Bytes(r.SpaceSaved).Format(1))
+
+	_, _ = fmt.Fprintln(tw, "\nCODEC\tRESOLUTION\tSESSIONS\tFAILED\tRATE")
+	for _, f := range r.Failures {
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%.1f%%\n", f.Codec, f.Resolution, f.Sessions, f.Failed, 100*f.Rate)
+	}
+
+	_, _ = fmt.Fprintln(tw, "\nPERIOD\tCONVERTED\tHOURS\tSAVED\tSPEED")
+	for _, t := range r.Throughput {
+		_, _ = fmt.Fprintf(tw, "%s\t%d\t%.1f\t%s\t%.2fx\n", t.Start.Format(time.DateOnly), t.Converted, t.MediaHours, ffmpeg.Bytes(t.SpaceSaved).Format(1), t.Speed)
+	}
+	return tw.Flush()
+}
+
+// writeCSV writes the Report as three CSV tables, separated by an empty line.
+func (r Report) writeCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	_ = cw.WriteAll([][]string{
+		{"sessions", "converted", "failed", "media_hours", "space_saved"},
+		{strconv.Itoa(r.Sessions), strconv.Itoa(r.Converted), strconv.Itoa(r.Failed), formatFloat(r.MediaHours), strconv.FormatInt(r.SpaceSaved, 10)},
+	})
+
+	_, _ = io.WriteString(w, "\n")
+	_ = cw.Write([]string{"codec", "resolution", "sessions", "failed", "rate"})
+	for _, f := range r.Failures {
+		_ = cw.Write([]string{f.Codec, f.Resolution, strconv.Itoa(f.Sessions), strconv.Itoa(f.Failed), formatFloat(f.Rate)})
+	}
+	cw.Flush()
+
+	_, _ = io.WriteString(w, "\n")
+	_ = cw.Write([]string{"period", "converted", "media_hours", "space_saved", "speed"})
+	for _, t := range r.Throughput {
+		_ = cw.Write([]string{t.Start.Format(time.DateOnly), strconv.Itoa(t.Converted), formatFloat(t.MediaHours), strconv.FormatInt(t.SpaceSaved, 10), formatFloat(t.Speed)})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
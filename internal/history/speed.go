@@ -0,0 +1,49 @@
+package history
+
+import "github.com/clambin/xcoder/ffmpeg"
+
+// Speeds holds the average transcoding speed of successful sessions, by source codec & resolution.
+type Speeds struct {
+	groups map[[2]string]speedSum
+	all    speedSum
+}
+
+type speedSum struct {
+	total    float64
+	sessions int
+}
+
+func (s speedSum) average() (float64, bool) {
+	if s.sessions == 0 {
+		return 0, false
+	}
+	return s.total / float64(s.sessions), true
+}
+
+// NewSpeeds determines the average transcoding speeds from the records.
+func NewSpeeds(records []Record) Speeds {
+	s := Speeds{groups: make(map[[2]string]speedSum)}
+	for _, r := range records {
+		if !r.Succeeded() || r.Speed <= 0 {
+			continue
+		}
+		key := [2]string{r.Source.Stats.VideoCodec, resolution(r.Source.Stats.Height)}
+		group := s.groups[key]
+		group.total += r.Speed
+		group.sessions++
+		s.groups[key] = group
+		s.all.total += r.Speed
+		s.all.sessions++
+	}
+	return s
+}
+
+// Estimate returns the expected transcoding speed of a source file. This is the average speed of sessions
+// that converted files with the same codec & resolution or, if there are none, the average speed of all sessions.
+// It returns false if no session converted a file.
+func (s Speeds) Estimate(source ffmpeg.VideoStats) (float64, bool) {
+	if speed, ok := s.groups[[2]string{source.VideoCodec, resolution(source.Height)}].average(); ok {
+		return speed, true
+	}
+	return s.all.average()
+}
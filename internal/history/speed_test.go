@@ -0,0 +1,30 @@
+package history
+
+import (
+	"testing"
+
+	"github.com/clambin/xcoder/ffmpeg"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSpeeds_Estimate(t *testing.T) {
+	h264 := ffmpeg.VideoStats{VideoCodec: "h264", Height: 1080}
+	speeds := NewSpeeds([]Record{
+		{Source: File{Stats: h264}, Speed: 2},
+		{Source: File{Stats: h264}, Speed: 4},
+		{Source: File{Stats: h264}, Speed: 10, Error: "failed"},
+		{Source: File{Stats: ffmpeg.VideoStats{VideoCodec: "h264", Height: 720}}, Speed: 6},
+	})
+
+	speed, ok := speeds.Estimate(h264)
+	assert.True(t, ok)
+	assert.Equal(t, 3.0, speed)
+
+	// no sessions for this codec & resolution: average of all sessions
+	speed, ok = speeds.Estimate(ffmpeg.VideoStats{VideoCodec: "mpeg4", Height: 480})
+	assert.True(t, ok)
+	assert.Equal(t, 4.0, speed)
+
+	_, ok = NewSpeeds(nil).Estimate(h264)
+	assert.False(t, ok)
+}
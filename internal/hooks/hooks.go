@@ -0,0 +1,358 @@
+// Package hooks runs commands and webhooks when the transcoder processes a work item.
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/exec"
+	"slices"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/clambin/xcoder/internal/transcoder"
+)
+
+// defaultTimeout is the timeout of a hook that doesn't configure one.
+const defaultTimeout = 30 * time.Second
+
+// Event is a lifecycle event that triggers a hook.
+type Event string
+
+const (
+	Scanned       Event = "scanned"
+	Queued        Event = "queued"
+	Started       Event = "started"
+	Converted     Event = "converted"
+	Failed        Event = "failed"
+	SourceRemoved Event = "source_removed"
+	// Drained is triggered when the transcoder has no more work items queued or transcoding.
+	Drained Event = "drained"
+)
+
+var events = []Event{Scanned, Queued, Started, Converted, Failed, SourceRemoved, Drained}
+
+// statusEvents maps the status of a work item to its lifecycle event.
+var statusEvents = map[transcoder.Status]Event{
+	transcoder.StatusScanned:     Scanned,
+	transcoder.StatusQueued:      Queued,
+	transcoder.StatusTranscoding: Started,
+	transcoder.StatusConverted:   Converted,
+	transcoder.StatusFailed:      Failed,
+}
+
+// statusTransitions holds the status that a work item must come from to trigger the event of its new status.
+// This way, Scanned and Queued are only triggered once per scan, not when a work item is dequeued, re-analyzed,
+// retried or interrupted.
+var statusTransitions = map[transcoder.Status]transcoder.Status{
+	transcoder.StatusScanned: transcoder.StatusScanning,
+	transcoder.StatusQueued:  transcoder.StatusScanned,
+}
+
+// A Hook runs a command or sends an HTTP POST request when one of its events occurs.
+// Command, Env, URL and Body are text/template templates, executed with the event's Data.
+type Hook struct {
+	// Events lists the events that trigger the hook.
+	Events []Event
+	// Command holds the command and its arguments. The command is not run in a shell.
+	Command []string
+	// Env holds additional environment variables for the command, in the form "KEY=value".
+	Env []string
+	// URL is the URL of the webhook.
+	URL string
+	// Body is the JSON body of the webhook. The "json" function encodes a value as a JSON string.
+	Body string
+	// Timeout is the maximum time the hook may run. Defaults to 30s.
+	Timeout time.Duration
+}
+
+// Data is passed to the hook's templates.
+type Data struct {
+	Event      Event
+	Source     string
+	Target     string
+	Status     string
+	Error      string
+	Profile    string
+	SourceSize int64
+	TargetSize int64
+	Saved      int64
+}
+
+func newData(event Event, workItem *transcoder.WorkItem) Data {
+	d := Data{Event: event}
+	if workItem == nil {
+		return d
+	}
+	status, err := workItem.Status()
+	d.Source = workItem.Source.Path
+	d.Target = workItem.Target.Path
+	d.Status = status.String()
+	if err != nil {
+		d.Error = err.Error()
+	}
+	d.Profile = workItem.Profile()
+	d.SourceSize = workItem.Source.Size
+	d.TargetSize = workItem.Target.Size
+	d.Saved = workItem.Saved()
+	return d
+}
+
+// hook is a Hook with parsed templates.
+type hook struct {
+	events  []Event
+	command []*template.Template
+	env     []*template.Template
+	url     *template.Template
+	body    *template.Template
+	timeout time.Duration
+}
+
+var funcs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+func parse(h Hook) (hook, error) {
+	if len(h.Events) == 0 {
+		return hook{}, errors.New("no events")
+	}
+	for _, event := range h.Events {
+		if !slices.Contains(events, event) {
+			return hook{}, fmt.Errorf("invalid event %q", string(event))
+		}
+	}
+	if (len(h.Command) == 0) == (h.URL == "") {
+		return hook{}, errors.New("hook needs either a command or a URL")
+	}
+
+	p := hook{events: h.Events, timeout: h.Timeout}
+	if p.timeout <= 0 {
+		p.timeout = defaultTimeout
+	}
+	var err error
+	for _, arg := range h.Command {
+		var t *template.Template
+		if t, err = template.New("command").Funcs(funcs).Parse(arg); err != nil {
+			return hook{}, fmt.Errorf("command: %w", err)
+		}
+		p.command = append(p.command, t)
+	}
+	for _, env := range h.Env {
+		var t *template.Template
+		if t, err = template.New("env").Funcs(funcs).Parse(env); err != nil {
+			return hook{}, fmt.Errorf("env: %w", err)
+		}
+		p.env = append(p.env, t)
+	}
+	if h.URL != "" {
+		if p.url, err = template.New("url").Funcs(funcs).Parse(h.URL); err != nil {
+			return hook{}, fmt.Errorf("url: %w", err)
+		}
+		if p.body, err = template.New("body").Funcs(funcs).Parse(h.Body); err != nil {
+			return hook{}, fmt.Errorf("body: %w", err)
+		}
+	}
+	return p, nil
+}
+
+// run runs the hook for the event.
+func (h hook) run(ctx context.Context, data Data) error {
+	ctx, cancel := context.WithTimeout(ctx, h.timeout)
+	defer cancel()
+	if h.url != nil {
+		return h.post(ctx, data)
+	}
+	return h.exec(ctx, data)
+}
+
+func (h hook) exec(ctx context.Context, data Data) error {
+	args := make([]string, len(h.command))
+	for i, t := range h.command {
+		arg, err := execute(t, data)
+		if err != nil {
+			return err
+		}
+		args[i] = arg
+	}
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Env = os.Environ()
+	for _, t := range h.env {
+		env, err := execute(t, data)
+		if err != nil {
+			return err
+		}
+		cmd.Env = append(cmd.Env, env)
+	}
+	output, err := cmd.CombinedOutput()
+	if err == nil {
+		return nil
+	}
+	if output := strings.TrimSpace(string(output)); output != "" {
+		return fmt.Errorf("%s: %w (%s)", args[0], err, output)
+	}
+	return fmt.Errorf("%s: %w", args[0], err)
+}
+
+func (h hook) post(ctx context.Context, data Data) error {
+	url, err := execute(h.url, data)
+	if err != nil {
+		return err
+	}
+	body, err := execute(h.body, data)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, strings.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	return nil
+}
+
+func execute(t *template.Template, data Data) (string, error) {
+	var b bytes.Buffer
+	if err := t.Execute(&b, data); err != nil {
+		return "", fmt.Errorf("template %s: %w", t.Name(), err)
+	}
+	return b.String(), nil
+}
+
+////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+type WorkItems interface {
+	Items() []*transcoder.WorkItem
+}
+
+var _ WorkItems = (*transcoder.WorkItems)(nil)
+
+type Transcoder interface {
+	Active() bool
+	Subscribe() <-chan transcoder.SessionEvent
+	Unsubscribe(<-chan transcoder.SessionEvent)
+	SubscribeStatus() <-chan transcoder.StatusEvent
+	UnsubscribeStatus(<-chan transcoder.StatusEvent)
+}
+
+var _ Transcoder = (*transcoder.Transcoder)(nil)
+
+// A Runner runs the hooks for the Transcoder's events.
+type Runner struct {
+	workItems  WorkItems
+	transcoder Transcoder
+	logger     *slog.Logger
+	statuses   map[*transcoder.WorkItem]transcoder.Status
+	hooks      []hook
+	wg         sync.WaitGroup
+	busy       bool
+}
+
+// New returns a new Runner. It returns an error if any of the hooks is invalid.
+func New(hooks []Hook, workItems WorkItems, tr Transcoder, logger *slog.Logger) (*Runner, error) {
+	r := Runner{workItems: workItems, transcoder: tr, logger: logger, statuses: make(map[*transcoder.WorkItem]transcoder.Status)}
+	for i, h := range hooks {
+		p, err := parse(h)
+		if err != nil {
+			return nil, fmt.Errorf("hook %d: %w", i+1, err)
+		}
+		r.hooks = append(r.hooks, p)
+	}
+	return &r, nil
+}
+
+// Run runs the hooks until the context is canceled. It then waits for any running hooks to complete.
+func (r *Runner) Run(ctx context.Context) error {
+	sessionEvents := r.transcoder.Subscribe()
+	statusEvents := r.transcoder.SubscribeStatus()
+
+	for {
+		select {
+		case <-ctx.Done():
+			// unsubscribe before waiting for the hooks, so the transcoder doesn't block publishing events
+			r.transcoder.Unsubscribe(sessionEvents)
+			r.transcoder.UnsubscribeStatus(statusEvents)
+			r.wg.Wait()
+			return nil
+		case ev := <-sessionEvents:
+			if ev.Type == transcoder.SourceRemovedEvent {
+				r.trigger(ctx, newData(SourceRemoved, ev.Session.WorkItem))
+			}
+		case ev := <-statusEvents:
+			r.handleStatusEvent(ctx, ev)
+		}
+	}
+}
+
+func (r *Runner) handleStatusEvent(ctx context.Context, ev transcoder.StatusEvent) {
+	previous := r.statuses[ev.WorkItem]
+	r.statuses[ev.WorkItem] = ev.Status
+	event, ok := statusEvents[ev.Status]
+	if !ok {
+		return
+	}
+	if from, ok := statusTransitions[ev.Status]; !ok || previous == from {
+		r.trigger(ctx, newData(event, ev.WorkItem))
+	}
+
+	switch event {
+	case Queued, Started:
+		r.busy = true
+	case Converted, Failed:
+		// trigger Drained once the last work item of a batch is done
+		if r.busy && r.drained() {
+			r.busy = false
+			r.trigger(ctx, newData(Drained, nil))
+		}
+	default:
+	}
+}
+
+// drained returns true if no work items are queued or transcoding. If the transcoder is active,
+// scanned work items will be queued automatically, so they count as well.
+func (r *Runner) drained() bool {
+	pending := []transcoder.Status{transcoder.StatusQueued, transcoder.StatusTranscoding, transcoder.StatusRetrying}
+	if r.transcoder.Active() {
+		pending = append(pending, transcoder.StatusScanned)
+	}
+	for _, item := range r.workItems.Items() {
+		if status, _ := item.Status(); slices.Contains(pending, status) {
+			return false
+		}
+	}
+	return true
+}
+
+// trigger runs all hooks for the event in the background. Hooks run to completion (or until they time out),
+// even if the Runner is shutting down.
+func (r *Runner) trigger(ctx context.Context, data Data) {
+	for _, h := range r.hooks {
+		if !slices.Contains(h.events, data.Event) {
+			continue
+		}
+		r.wg.Go(func() {
+			if err := h.run(context.WithoutCancel(ctx), data); err != nil {
+				r.logger.Warn("hook failed", "event", data.Event, "source", data.Source, "err", err)
+				return
+			}
+			r.logger.Debug("hook completed", "event", data.Event, "source", data.Source)
+		})
+	}
+}
@@ -0,0 +1,239 @@
+package hooks
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"codeberg.org/clambin/go-common/pubsub"
+	"github.com/clambin/xcoder/internal/transcoder"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunner(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "output.txt")
+	var webhooks webhookRecorder
+	ts := httptest.NewServer(&webhooks)
+	t.Cleanup(ts.Close)
+
+	var q transcoder.WorkItems
+	workItem := transcoder.WorkItem{Source: transcoder.File{Path: "foo.mkv", Size: 1000}, Target: transcoder.File{Path: "foo.hevc.mkv"}}
+	q.Add(&workItem)
+
+	var tr fakeTranscoder
+	r, err := New([]Hook{
+		{
+			Events:  []Event{Scanned, Queued, Converted, SourceRemoved},
+			Command: []string{"sh", "-c", `echo "$0 $EVENT" >> ` + output, "{{.Source}}"},
+			Env:     []string{"EVENT={{.Event}}"},
+		},
+		{
+			Events: []Event{Failed, Drained},
+			URL:    ts.URL + "/{{.Event}}",
+			Body:   `{"source":{{json .Source}},"error":{{json .Error}}}`,
+		},
+	}, &q, &tr, slog.New(slog.DiscardHandler))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	errCh := make(chan error)
+	go func() { errCh <- r.Run(ctx) }()
+	require.Eventually(t, func() bool {
+		return tr.statusEvents.Subscribers() == 1 && tr.sessionEvents.Subscribers() == 1
+	}, time.Second, 10*time.Millisecond)
+
+	// scanned & queued are only triggered once: not when the work item is dequeued or retried
+	for _, status := range []transcoder.Status{
+		transcoder.StatusScanning, transcoder.StatusScanned, transcoder.StatusQueued, transcoder.StatusScanned,
+		transcoder.StatusTranscoding, transcoder.StatusRetrying, transcoder.StatusQueued, transcoder.StatusTranscoding,
+		transcoder.StatusConverted,
+	} {
+		workItem.SetStatus(status, nil)
+		tr.statusEvents.Publish(transcoder.StatusEvent{WorkItem: &workItem, Status: status})
+	}
+	tr.sessionEvents.Publish(transcoder.SessionEvent{Session: &transcoder.Session{WorkItem: &workItem}, Type: transcoder.SourceRemovedEvent})
+	cancel()
+	require.NoError(t, <-errCh)
+
+	// commands run in the background, so they may complete in any order
+	body, err := os.ReadFile(output)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"foo.mkv scanned", "foo.mkv queued", "foo.mkv converted", "foo.mkv source_removed"}, strings.Split(strings.TrimSpace(string(body)), "\n"))
+	assert.Equal(t, []string{`/drained {"source":"","error":""}`}, webhooks.requests())
+}
+
+func TestRunner_Drained(t *testing.T) {
+	var webhooks webhookRecorder
+	ts := httptest.NewServer(&webhooks)
+	t.Cleanup(ts.Close)
+
+	var q transcoder.WorkItems
+	items := make([]*transcoder.WorkItem, 2)
+	for i := range items {
+		items[i] = &transcoder.WorkItem{Source: transcoder.File{Path: "foo" + string(rune('0'+i)) + ".mkv"}}
+		items[i].SetStatus(transcoder.StatusQueued, nil)
+		q.Add(items[i])
+	}
+
+	var tr fakeTranscoder
+	r, err := New([]Hook{{Events: []Event{Drained}, URL: ts.URL}}, &q, &tr, slog.New(slog.DiscardHandler))
+	require.NoError(t, err)
+
+	// the first item completes: the second one is still queued
+	r.handleStatusEvent(t.Context(), transcoder.StatusEvent{WorkItem: items[0], Status: transcoder.StatusQueued})
+	items[0].SetStatus(transcoder.StatusFailed, assert.AnError)
+	r.handleStatusEvent(t.Context(), transcoder.StatusEvent{WorkItem: items[0], Status: transcoder.StatusFailed, Err: assert.AnError})
+	r.wg.Wait()
+	assert.Empty(t, webhooks.requests())
+
+	// the second item completes: the queue is drained
+	items[1].SetStatus(transcoder.StatusConverted, nil)
+	r.handleStatusEvent(t.Context(), transcoder.StatusEvent{WorkItem: items[1], Status: transcoder.StatusConverted})
+	r.wg.Wait()
+	assert.Len(t, webhooks.requests(), 1)
+
+	// if the transcoder is active, scanned items will be queued, so the batch isn't done yet
+	tr.active = true
+	items[0].SetStatus(transcoder.StatusScanned, nil)
+	r.handleStatusEvent(t.Context(), transcoder.StatusEvent{WorkItem: items[1], Status: transcoder.StatusTranscoding})
+	r.handleStatusEvent(t.Context(), transcoder.StatusEvent{WorkItem: items[1], Status: transcoder.StatusConverted})
+	r.wg.Wait()
+	assert.Len(t, webhooks.requests(), 1)
+}
+
+func TestRunner_Shutdown(t *testing.T) {
+	var q transcoder.WorkItems
+	workItem := transcoder.WorkItem{Source: transcoder.File{Path: "foo.mkv"}}
+	q.Add(&workItem)
+
+	var tr fakeTranscoder
+	r, err := New([]Hook{{Events: []Event{Started}, Command: []string{"sleep", "1"}}}, &q, &tr, slog.New(slog.DiscardHandler))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	errCh := make(chan error)
+	go func() { errCh <- r.Run(ctx) }()
+	require.Eventually(t, func() bool {
+		return tr.statusEvents.Subscribers() == 1 && tr.sessionEvents.Subscribers() == 1
+	}, time.Second, 10*time.Millisecond)
+	tr.statusEvents.Publish(transcoder.StatusEvent{WorkItem: &workItem, Status: transcoder.StatusTranscoding})
+
+	// while waiting for the hook to complete, the Runner no longer receives events
+	cancel()
+	assert.Eventually(t, func() bool {
+		return tr.statusEvents.Subscribers() == 0 && tr.sessionEvents.Subscribers() == 0
+	}, 500*time.Millisecond, 10*time.Millisecond)
+	require.NoError(t, <-errCh)
+}
+
+func TestHook_Failures(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	t.Cleanup(ts.Close)
+
+	tests := []struct {
+		name    string
+		hook    Hook
+		wantErr string
+	}{
+		{name: "timeout", hook: Hook{Events: []Event{Failed}, Command: []string{"sleep", "5"}, Timeout: 50 * time.Millisecond}, wantErr: "sleep: signal: killed"},
+		{name: "command failed", hook: Hook{Events: []Event{Failed}, Command: []string{"sh", "-c", "echo oops; exit 1"}}, wantErr: "sh: exit status 1 (oops)"},
+		{name: "webhook failed", hook: Hook{Events: []Event{Failed}, URL: ts.URL}, wantErr: ts.URL + ": 500 Internal Server Error"},
+		{name: "template failed", hook: Hook{Events: []Event{Failed}, Command: []string{"{{.Unknown}}"}}, wantErr: `template command: template: command:1:2: executing "command" at <.Unknown>: can't evaluate field Unknown in type hooks.Data`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, err := parse(tt.hook)
+			require.NoError(t, err)
+			assert.EqualError(t, h.run(t.Context(), Data{Event: Failed}), tt.wantErr)
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name    string
+		hook    Hook
+		wantErr string
+	}{
+		{name: "valid", hook: Hook{Events: []Event{Converted}, Command: []string{"true"}}},
+		{name: "no events", hook: Hook{Command: []string{"true"}}, wantErr: "hook 1: no events"},
+		{name: "invalid event", hook: Hook{Events: []Event{"foo"}, Command: []string{"true"}}, wantErr: `hook 1: invalid event "foo"`},
+		{name: "no action", hook: Hook{Events: []Event{Converted}}, wantErr: "hook 1: hook needs either a command or a URL"},
+		{name: "both actions", hook: Hook{Events: []Event{Converted}, Command: []string{"true"}, URL: "http://localhost"}, wantErr: "hook 1: hook needs either a command or a URL"},
+		{name: "invalid template", hook: Hook{Events: []Event{Converted}, URL: "http://localhost", Body: "{{"}, wantErr: "hook 1: body: template: body:1: unclosed action"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New([]Hook{tt.hook}, &transcoder.WorkItems{}, &fakeTranscoder{}, slog.New(slog.DiscardHandler))
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.EqualError(t, err, tt.wantErr)
+		})
+	}
+}
+
+// webhookRecorder records the path and body of each webhook request.
+type webhookRecorder struct {
+	received []string
+	mu       sync.Mutex
+}
+
+func (w *webhookRecorder) ServeHTTP(_ http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	if len(body) > 0 && !json.Valid(body) {
+		body = []byte("invalid JSON: " + string(body))
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.received = append(w.received, r.URL.Path+" "+string(body))
+}
+
+func (w *webhookRecorder) requests() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.received
+}
+
+var _ Transcoder = (*fakeTranscoder)(nil)
+
+type fakeTranscoder struct {
+	sessionEvents pubsub.Publisher[transcoder.SessionEvent]
+	statusEvents  pubsub.Publisher[transcoder.StatusEvent]
+	active        bool
+}
+
+func (f *fakeTranscoder) Active() bool {
+	return f.active
+}
+
+func (f *fakeTranscoder) Subscribe() <-chan transcoder.SessionEvent {
+	return f.sessionEvents.Subscribe()
+}
+
+func (f *fakeTranscoder) Unsubscribe(ch <-chan transcoder.SessionEvent) {
+	f.sessionEvents.Unsubscribe(ch)
+}
+
+func (f *fakeTranscoder) SubscribeStatus() <-chan transcoder.StatusEvent {
+	return f.statusEvents.Subscribe()
+}
+
+func (f *fakeTranscoder) UnsubscribeStatus(ch <-chan transcoder.StatusEvent) {
+	f.statusEvents.Unsubscribe(ch)
+}
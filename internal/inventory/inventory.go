@@ -0,0 +1,141 @@
+package inventory
+
+import (
+	"cmp"
+	"context"
+	"os"
+	"slices"
+	"strconv"
+
+	"github.com/clambin/xcoder/ffmpeg"
+	"github.com/clambin/xcoder/internal/mediafiles"
+)
+
+// Filter selects the media files to include in the inventory. Zero values match all files.
+type Filter struct {
+	Codec      string
+	MinHeight  int
+	MinBitrate int
+}
+
+// Match returns true if the media file's video stats pass the filter.
+func (f Filter) Match(stats ffmpeg.VideoStats) bool {
+	return (f.Codec == "" || stats.VideoCodec == f.Codec) &&
+		stats.Height >= f.MinHeight &&
+		stats.BitRate >= f.MinBitrate
+}
+
+// A File is a media file in the inventory. Files that couldn't be probed hold the reason in Error.
+//
+//nolint:tagliatelle
+type File struct {
+	Path  string            `json:"path"`
+	Error string            `json:"error,omitempty"`
+	Stats ffmpeg.VideoStats `json:"stats"`
+	Size  int64             `json:"size"`
+}
+
+// A Group summarizes the media files for one codec & resolution.
+//
+//nolint:tagliatelle
+type Group struct {
+	Codec      string  `json:"codec"`
+	Resolution string  `json:"resolution"`
+	Files      int     `json:"files"`
+	MediaHours float64 `json:"media_hours"`
+	Size       int64   `json:"size"`
+}
+
+// An Inventory lists the media files and summarizes them by codec & resolution.
+//
+//nolint:tagliatelle
+type Inventory struct {
+	Files      []File  `json:"files"`
+	Groups     []Group `json:"groups"`
+	Failed     int     `json:"failed"`
+	MediaHours float64 `json:"media_hours"`
+	Size       int64   `json:"size"`
+}
+
+// Scan probes all media files below the paths and returns the inventory of the ones that pass the filter.
+// Paths that aren't directories are probed as-is. Files that can't be probed are always included.
+func Scan(ctx context.Context, paths []string, filter Filter, probe func(string) (ffmpeg.VideoStats, error)) (Inventory, error) {
+	var files []File
+	add := func(path string) {
+		if ctx.Err() != nil {
+			return
+		}
+		f := File{Path: path}
+		if fi, err := os.Stat(path); err == nil {
+			f.Size = fi.Size()
+		}
+		var err error
+		if f.Stats, err = probe(path); err != nil {
+			f.Error = err.Error()
+		} else if !filter.Match(f.Stats) {
+			return
+		}
+		files = append(files, f)
+	}
+	for _, path := range paths {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return Inventory{}, err
+		}
+		if !fi.IsDir() {
+			add(path)
+			continue
+		}
+		if err = mediafiles.FindMediaFiles(path, add); err != nil {
+			return Inventory{}, err
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return Inventory{}, err
+	}
+	return Summarize(files), nil
+}
+
+// Summarize creates an Inventory for the files.
+func Summarize(files []File) Inventory {
+	inv := Inventory{Files: files}
+	groups := make(map[[2]string]*Group)
+	for _, f := range files {
+		if f.Error != "" {
+			inv.Failed++
+			continue
+		}
+		key := [2]string{f.Stats.VideoCodec, resolution(f.Stats.Height)}
+		g, ok := groups[key]
+		if !ok {
+			g = &Group{Codec: key[0], Resolution: key[1]}
+			groups[key] = g
+		}
+		hours := f.Stats.Duration.Hours()
+		g.Files++
+		g.MediaHours += hours
+		g.Size += f.Size
+		inv.MediaHours += hours
+		inv.Size += f.Size
+	}
+	for _, g := range groups {
+		inv.Groups = append(inv.Groups, *g)
+	}
+	slices.SortFunc(inv.Groups, func(a, b Group) int {
+		return cmp.Or(cmp.Compare(a.Codec, b.Codec), cmp.Compare(height(b.Resolution), height(a.Resolution)))
+	})
+	return inv
+}
+
+func resolution(height int) string {
+	if height <= 0 {
+		return "unknown"
+	}
+	return strconv.Itoa(height) + "p"
+}
+
+// height returns the height of a resolution, so resolutions sort by height rather than alphabetically.
+func height(resolution string) int {
+	h, _ := strconv.Atoi(resolution[:len(resolution)-1])
+	return h
+}
@@ -0,0 +1,130 @@
+package inventory
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/clambin/xcoder/ffmpeg"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFilter_Match(t *testing.T) {
+	stats := ffmpeg.VideoStats{VideoCodec: "h264", Height: 1080, BitRate: 10_000_000}
+	tests := []struct {
+		name   string
+		filter Filter
+		want   bool
+	}{
+		{name: "empty", want: true},
+		{name: "codec", filter: Filter{Codec: "h264"}, want: true},
+		{name: "other codec", filter: Filter{Codec: "hevc"}},
+		{name: "height", filter: Filter{MinHeight: 1080}, want: true},
+		{name: "height too low", filter: Filter{MinHeight: 2160}},
+		{name: "bitrate", filter: Filter{MinBitrate: 8_000_000}, want: true},
+		{name: "bitrate too low", filter: Filter{MinBitrate: 12_000_000}},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, tt.filter.Match(stats), tt.name)
+	}
+}
+
+func TestScan(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]ffmpeg.VideoStats{
+		"a.mkv":     {VideoCodec: "h264", Height: 1080, BitRate: 10_000_000, Duration: time.Hour},
+		"sub/b.mkv": {VideoCodec: "h264", Height: 720, BitRate: 4_000_000, Duration: time.Hour},
+		"sub/c.mp4": {VideoCodec: "hevc", Height: 1080, BitRate: 4_000_000, Duration: 30 * time.Minute},
+		"notes.txt": {},
+		"bad.mkv":   {},
+	}
+	for name := range files {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), make([]byte, 100), 0o644))
+	}
+	probe := func(path string) (ffmpeg.VideoStats, error) {
+		rel, _ := filepath.Rel(dir, path)
+		if rel == "bad.mkv" {
+			return ffmpeg.VideoStats{}, errors.New("corrupt input")
+		}
+		return files[rel], nil
+	}
+
+	inv, err := Scan(t.Context(), []string{dir}, Filter{MinBitrate: 8_000_000}, probe)
+	require.NoError(t, err)
+	require.Len(t, inv.Files, 2)
+	assert.Equal(t, filepath.Join(dir, "a.mkv"), inv.Files[0].Path)
+	assert.Equal(t, filepath.Join(dir, "bad.mkv"), inv.Files[1].Path)
+	assert.Equal(t, "corrupt input", inv.Files[1].Error)
+	assert.Equal(t, 1, inv.Failed)
+
+	inv, err = Scan(t.Context(), []string{dir}, Filter{}, probe)
+	require.NoError(t, err)
+	assert.Equal(t, []Group{
+		{Codec: "h264", Resolution: "1080p", Files: 1, MediaHours: 1, Size: 100},
+		{Codec: "h264", Resolution: "720p", Files: 1, MediaHours: 1, Size: 100},
+		{Codec: "hevc", Resolution: "1080p", Files: 1, MediaHours: 0.5, Size: 100},
+	}, inv.Groups)
+	assert.Equal(t, 2.5, inv.MediaHours)
+	assert.Equal(t, int64(300), inv.Size)
+
+	_, err = Scan(t.Context(), []string{filepath.Join(dir, "missing")}, Filter{}, probe)
+	assert.Error(t, err)
+}
+
+func TestInventory_Write(t *testing.T) {
+	inv := Summarize([]File{
+		{Path: "a.mkv", Stats: ffmpeg.VideoStats{VideoCodec: "h264", Height: 1080, BitRate: 10_000_000, Duration: time.Hour}, Size: 4_500_000_000},
+		{Path: "b.mkv", Stats: ffmpeg.VideoStats{VideoCodec: "h264", Height: 720, BitRate: 4_000_000, Duration: 30 * time.Minute}, Size: 900_000_000},
+		{Path: "c.mkv", Error: "corrupt input", Size: 100},
+	})
+
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{
+			format: "table",
+			want: `PATH   CODEC                 RESOLUTION  BITRATE    DURATION  SIZE
+a.mkv  h264                  1080p       10.0 mbps  1h0m0s    4.5 GB
+b.mkv  h264                  720p        4.0 mbps   30m0s     900.0 MB
+c.mkv  error: corrupt input                                   100.0 B
+
+CODEC  RESOLUTION  FILES  HOURS  SIZE
+h264   1080p       1      1.0    4.5 GB
+h264   720p        1      0.5    900.0 MB
+total              2      1.5    5.4 GB
+
+Failed to probe 1 files
+`,
+		},
+		{
+			format: "csv",
+			want: `path,codec,height,bitrate,duration,size,error
+a.mkv,h264,1080,10000000,3600,4500000000,
+b.mkv,h264,720,4000000,1800,900000000,
+c.mkv,,0,0,0,100,corrupt input
+
+codec,resolution,files,media_hours,size
+h264,1080p,1,1,4500000000
+h264,720p,1,0.5,900000000
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var buf bytes.Buffer
+			require.NoError(t, inv.Write(&buf, tt.format))
+			assert.Equal(t, tt.want, buf.String())
+		})
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, inv.Write(&buf, "json"))
+	assert.Contains(t, buf.String(), `"media_hours": 1.5`)
+	assert.Error(t, inv.Write(&buf, "invalid"))
+}
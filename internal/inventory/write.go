@@ -0,0 +1,86 @@
+package inventory
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/clambin/xcoder/ffmpeg"
+)
+
+// Write writes the Inventory in the requested format: table, csv or json.
+func (i Inventory) Write(w io.Writer, format string) error {
+	switch format {
+	case "table":
+		return i.writeTable(w)
+	case "csv":
+		return i.writeCSV(w)
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(i)
+	default:
+		return fmt.Errorf("invalid format %q", format)
+	}
+}
+
+func (i Inventory) writeTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "PATH\tCODEC\tRESOLUTION\tBITRATE\tDURATION\tSIZE")
+	for _, f := range i.Files {
+		if f.Error != "" {
+			_, _ = fmt.Fprintf(tw, "%s\terror: %s\t\t\t\t%s\n", f.Path, f.Error, ffmpeg.Bytes(f.Size).Format(1))
+			continue
+		}
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			f.Path,
+			f.Stats.VideoCodec,
+			resolution(f.Stats.Height),
+			ffmpeg.Bits(f.Stats.BitRate).Format(1),
+			f.Stats.Duration.Round(time.Second),
+			ffmpeg.Bytes(f.Size).Format(1),
+		)
+	}
+
+	_, _ = fmt.Fprintln(tw, "\nCODEC\tRESOLUTION\tFILES\tHOURS\tSIZE")
+	var files int
+	for _, g := range i.Groups {
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%d\t%.1f\t%s\n", g.Codec, g.Resolution, g.Files, g.MediaHours, ffmpeg.Bytes(g.Size).Format(1))
+		files += g.Files
+	}
+	_, _ = fmt.Fprintf(tw, "total\t\t%d\t%.1f\t%s\n", files, i.MediaHours, ffmpeg.Bytes(i.Size).Format(1))
+	if i.Failed > 0 {
+		_, _ = fmt.Fprintf(tw, "\nFailed to probe %d files\n", i.Failed)
+	}
+	return tw.Flush()
+}
+
+// writeCSV writes the Inventory as two CSV tables, separated by an empty line.
+func (i Inventory) writeCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"path", "codec", "height", "bitrate", "duration", "size", "error"})
+	for _, f := range i.Files {
+		_ = cw.Write([]string{
+			f.Path,
+			f.Stats.VideoCodec,
+			strconv.Itoa(f.Stats.Height),
+			strconv.Itoa(f.Stats.BitRate),
+			strconv.FormatFloat(f.Stats.Duration.Seconds(), 'f', -1, 64),
+			strconv.FormatInt(f.Size, 10),
+			f.Error,
+		})
+	}
+	cw.Flush()
+
+	_, _ = io.WriteString(w, "\n")
+	_ = cw.Write([]string{"codec", "resolution", "files", "media_hours", "size"})
+	for _, g := range i.Groups {
+		_ = cw.Write([]string{g.Codec, g.Resolution, strconv.Itoa(g.Files), strconv.FormatFloat(g.MediaHours, 'f', -1, 64), strconv.FormatInt(g.Size, 10)})
+	}
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,131 @@
+package mediaserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// jellyfin refreshes folders through Jellyfin's "media updated" API. Emby offers the same API under /emby.
+type jellyfin struct {
+	httpClient *http.Client
+	url        string
+	token      string
+}
+
+//nolint:tagliatelle
+type jellyfinUpdate struct {
+	Path       string `json:"Path"`
+	UpdateType string `json:"UpdateType"`
+}
+
+//nolint:tagliatelle
+type jellyfinRequest struct {
+	Updates []jellyfinUpdate `json:"Updates"`
+}
+
+// refresh reports all folders as modified in one request.
+func (j *jellyfin) refresh(ctx context.Context, dirs []string) error {
+	var request jellyfinRequest
+	for _, dir := range dirs {
+		request.Updates = append(request.Updates, jellyfinUpdate{Path: dir, UpdateType: "Modified"})
+	}
+	body, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, j.url+"/Library/Media/Updated", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Emby-Token", j.token)
+	return do(j.httpClient, req, nil)
+}
+
+// plex refreshes folders through Plex's partial library scan. Plex refreshes per library section,
+// so we look up the section that holds each folder.
+type plex struct {
+	httpClient *http.Client
+	url        string
+	token      string
+}
+
+//nolint:tagliatelle
+type plexSections struct {
+	MediaContainer struct {
+		Directory []struct {
+			Key      string `json:"key"`
+			Location []struct {
+				Path string `json:"path"`
+			} `json:"Location"`
+		} `json:"Directory"`
+	} `json:"MediaContainer"`
+}
+
+// refresh scans each folder in the library section that holds it.
+func (p *plex) refresh(ctx context.Context, dirs []string) error {
+	var sections plexSections
+	if err := p.get(ctx, "/library/sections", nil, &sections); err != nil {
+		return fmt.Errorf("sections: %w", err)
+	}
+	var errs []error
+	for _, dir := range dirs {
+		key, ok := sectionFor(sections, dir)
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: no library section found", dir))
+			continue
+		}
+		if err := p.get(ctx, "/library/sections/"+key+"/refresh", url.Values{"path": {dir}}, nil); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", dir, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// sectionFor returns the key of the library section whose location holds dir.
+func sectionFor(sections plexSections, dir string) (string, bool) {
+	for _, section := range sections.MediaContainer.Directory {
+		for _, location := range section.Location {
+			if rel, err := filepath.Rel(location.Path, dir); err == nil && !strings.HasPrefix(rel, "..") {
+				return section.Key, true
+			}
+		}
+	}
+	return "", false
+}
+
+func (p *plex) get(ctx context.Context, path string, query url.Values, response any) error {
+	if query == nil {
+		query = make(url.Values)
+	}
+	query.Set("X-Plex-Token", p.token)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.url+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	return do(p.httpClient, req, response)
+}
+
+// do sends the request and decodes the JSON response, if any.
+func do(httpClient *http.Client, req *http.Request, response any) error {
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return errors.New(resp.Status)
+	}
+	if response == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(response)
+}
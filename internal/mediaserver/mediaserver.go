@@ -0,0 +1,153 @@
+// Package mediaserver refreshes the libraries of media servers (Jellyfin, Emby, Plex) once the transcoder converts
+// a media file, so the media server picks up the converted file (and forgets the removed source file) without
+// waiting for its next scheduled library scan.
+package mediaserver
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"github.com/clambin/xcoder/internal/transcoder"
+)
+
+// batchDelay is the time the Refresher waits after a conversion before refreshing the media servers.
+// Any other conversions during that time are refreshed in the same batch. This also gives the transcoder
+// time to remove the source file.
+const batchDelay = 30 * time.Second
+
+// refreshTimeout is the maximum time to refresh one media server.
+const refreshTimeout = time.Minute
+
+// Server configures a media server.
+type Server struct {
+	// Type is the type of media server: "jellyfin", "emby" or "plex".
+	Type string
+	// URL is the base URL of the media server, e.g. http://localhost:8096.
+	URL string
+	// Token is the API key (Jellyfin, Emby) or X-Plex-Token (Plex).
+	Token string
+}
+
+// A client refreshes the library folders of one media server.
+type client interface {
+	refresh(ctx context.Context, dirs []string) error
+}
+
+func newClient(s Server, httpClient *http.Client) (client, error) {
+	switch s.Type {
+	case "jellyfin":
+		return &jellyfin{url: s.URL, token: s.Token, httpClient: httpClient}, nil
+	case "emby":
+		return &jellyfin{url: s.URL + "/emby", token: s.Token, httpClient: httpClient}, nil
+	case "plex":
+		return &plex{url: s.URL, token: s.Token, httpClient: httpClient}, nil
+	default:
+		return nil, fmt.Errorf("invalid media server type %q", s.Type)
+	}
+}
+
+type Transcoder interface {
+	SubscribeStatus() <-chan transcoder.StatusEvent
+	UnsubscribeStatus(<-chan transcoder.StatusEvent)
+}
+
+var _ Transcoder = (*transcoder.Transcoder)(nil)
+
+// A Refresher refreshes the media servers' library folders that hold converted media files.
+// Folders are refreshed in batches, so converting several files in the same folder only refreshes the folder once.
+type Refresher struct {
+	transcoder Transcoder
+	logger     *slog.Logger
+	servers    []Server
+	clients    []client
+	pending    map[string]struct{}
+	delay      time.Duration
+}
+
+// New returns a new Refresher. It returns an error if any of the servers is invalid.
+func New(servers []Server, tr Transcoder, logger *slog.Logger) (*Refresher, error) {
+	r := Refresher{
+		transcoder: tr,
+		logger:     logger,
+		servers:    servers,
+		pending:    make(map[string]struct{}),
+		delay:      batchDelay,
+	}
+	httpClient := &http.Client{Timeout: refreshTimeout}
+	for i, s := range servers {
+		c, err := newClient(s, httpClient)
+		if err != nil {
+			return nil, fmt.Errorf("media server %d: %w", i+1, err)
+		}
+		r.clients = append(r.clients, c)
+	}
+	return &r, nil
+}
+
+// Run refreshes the media servers until the context is canceled. Any pending folders are refreshed before Run returns.
+func (r *Refresher) Run(ctx context.Context) error {
+	ch := r.transcoder.SubscribeStatus()
+
+	// media servers may be slow to respond (or down). refresh them in the background, so we keep receiving
+	// status events: the transcoder blocks until all subscribers receive them.
+	batches := make(chan []string, 1)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for dirs := range batches {
+			r.refresh(context.WithoutCancel(ctx), dirs)
+		}
+	}()
+
+	var batch <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			r.transcoder.UnsubscribeStatus(ch)
+			if len(r.pending) > 0 {
+				batches <- slices.Sorted(maps.Keys(r.pending))
+			}
+			close(batches)
+			<-done
+			return nil
+		case ev := <-ch:
+			if ev.Status != transcoder.StatusConverted {
+				continue
+			}
+			r.pending[filepath.Dir(ev.WorkItem.Source.Path)] = struct{}{}
+			r.pending[filepath.Dir(ev.WorkItem.Target.Path)] = struct{}{}
+			if batch == nil {
+				batch = time.After(r.delay)
+			}
+		case <-batch:
+			batch = nil
+			select {
+			case batches <- slices.Sorted(maps.Keys(r.pending)):
+				clear(r.pending)
+			default:
+				// the previous batches are still being refreshed. try again later, adding any new folders to the batch.
+				batch = time.After(r.delay)
+			}
+		}
+	}
+}
+
+// refresh refreshes the folders on all media servers.
+func (r *Refresher) refresh(ctx context.Context, dirs []string) {
+	for i, c := range r.clients {
+		ctx, cancel := context.WithTimeout(ctx, refreshTimeout)
+		err := c.refresh(ctx, dirs)
+		cancel()
+		if err != nil {
+			r.logger.Warn("failed to refresh media server", "server", r.servers[i].URL, "type", r.servers[i].Type, "err", err)
+			continue
+		}
+		r.logger.Info("refreshed media server", "server", r.servers[i].URL, "type", r.servers[i].Type, "folders", len(dirs))
+	}
+}
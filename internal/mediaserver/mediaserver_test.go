@@ -0,0 +1,251 @@
+package mediaserver
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"codeberg.org/clambin/go-common/pubsub"
+	"github.com/clambin/xcoder/internal/transcoder"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRefresher(t *testing.T) {
+	var jellyfin, plex mediaServer
+	jellyfinServer := httptest.NewServer(&jellyfin)
+	t.Cleanup(jellyfinServer.Close)
+	plexServer := httptest.NewServer(&plex)
+	t.Cleanup(plexServer.Close)
+
+	var tr fakeTranscoder
+	r, err := New([]Server{
+		{Type: "jellyfin", URL: jellyfinServer.URL, Token: "jellyfin-token"},
+		{Type: "plex", URL: plexServer.URL, Token: "plex-token"},
+	}, &tr, slog.New(slog.DiscardHandler))
+	require.NoError(t, err)
+	r.delay = 100 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(t.Context())
+	errCh := make(chan error)
+	go func() { errCh <- r.Run(ctx) }()
+	require.Eventually(t, func() bool { return tr.statusEvents.Subscribers() == 1 }, time.Second, 10*time.Millisecond)
+
+	// two conversions in the same folder are refreshed once. other statuses are ignored.
+	for _, path := range []string{"/media/movies/foo/foo.mkv", "/media/movies/foo/foo.extras.mkv", "/media/tv/bar/bar.mkv"} {
+		workItem := transcoder.WorkItem{Source: transcoder.File{Path: path}, Target: transcoder.File{Path: path[:len(path)-4] + ".hevc.mkv"}}
+		tr.statusEvents.Publish(transcoder.StatusEvent{WorkItem: &workItem, Status: transcoder.StatusTranscoding})
+		if path != "/media/tv/bar/bar.mkv" {
+			tr.statusEvents.Publish(transcoder.StatusEvent{WorkItem: &workItem, Status: transcoder.StatusConverted})
+		}
+	}
+	require.Eventually(t, func() bool { return len(jellyfin.requests()) == 1 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{
+		`POST /Library/Media/Updated token=jellyfin-token {"Updates":[{"Path":"/media/movies/foo","UpdateType":"Modified"}]}`,
+	}, jellyfin.requests())
+	require.Eventually(t, func() bool { return len(plex.requests()) == 2 }, time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{
+		"GET /library/sections X-Plex-Token=plex-token",
+		"GET /library/sections/1/refresh X-Plex-Token=plex-token&path=%2Fmedia%2Fmovies%2Ffoo",
+	}, plex.requests())
+
+	// pending folders are refreshed at shutdown
+	workItem := transcoder.WorkItem{Source: transcoder.File{Path: "/media/tv/bar/bar.mkv"}, Target: transcoder.File{Path: "/media/tv/bar/bar.hevc.mkv"}}
+	tr.statusEvents.Publish(transcoder.StatusEvent{WorkItem: &workItem, Status: transcoder.StatusConverted})
+	cancel()
+	require.NoError(t, <-errCh)
+	assert.Len(t, jellyfin.requests(), 2)
+	assert.Equal(t, "GET /library/sections/2/refresh X-Plex-Token=plex-token&path=%2Fmedia%2Ftv%2Fbar", plex.requests()[3])
+}
+
+func TestRefresher_SlowServer(t *testing.T) {
+	// a media server that doesn't respond until it's unblocked
+	release := make(chan struct{})
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	t.Cleanup(server.Close)
+	unblock := sync.OnceFunc(func() { close(release) })
+	t.Cleanup(unblock)
+
+	var tr fakeTranscoder
+	r, err := New([]Server{{Type: "jellyfin", URL: server.URL, Token: "token"}}, &tr, slog.New(slog.DiscardHandler))
+	require.NoError(t, err)
+	r.delay = 10 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(t.Context())
+	errCh := make(chan error)
+	go func() { errCh <- r.Run(ctx) }()
+	require.Eventually(t, func() bool { return tr.statusEvents.Subscribers() == 1 }, time.Second, 10*time.Millisecond)
+
+	workItem := transcoder.WorkItem{Source: transcoder.File{Path: "/media/movies/foo/foo.mkv"}, Target: transcoder.File{Path: "/media/movies/foo/foo.hevc.mkv"}}
+	tr.statusEvents.Publish(transcoder.StatusEvent{WorkItem: &workItem, Status: transcoder.StatusConverted})
+	require.Eventually(t, func() bool { return requests.Load() == 1 }, time.Second, 10*time.Millisecond)
+
+	// while the media server is refreshing, the refresher keeps receiving status events
+	published := make(chan struct{})
+	go func() {
+		for range 100 {
+			tr.statusEvents.Publish(transcoder.StatusEvent{WorkItem: &workItem, Status: transcoder.StatusConverted})
+			time.Sleep(time.Millisecond)
+		}
+		close(published)
+	}()
+	select {
+	case <-published:
+	case <-time.After(5 * time.Second):
+		t.Fatal("publishing blocked on a slow media server")
+	}
+
+	// at shutdown, the refresher waits for the pending refreshes to finish
+	cancel()
+	select {
+	case <-errCh:
+		t.Fatal("refresher stopped during a refresh")
+	case <-time.After(100 * time.Millisecond):
+	}
+	unblock()
+	require.NoError(t, <-errCh)
+	assert.Equal(t, 0, tr.statusEvents.Subscribers())
+}
+
+func TestClients(t *testing.T) {
+	var server mediaServer
+	ts := httptest.NewServer(&server)
+	t.Cleanup(ts.Close)
+
+	tests := []struct {
+		name    string
+		server  Server
+		dirs    []string
+		wantErr string
+		want    []string
+	}{
+		{
+			name:   "jellyfin",
+			server: Server{Type: "jellyfin", URL: ts.URL, Token: "token"},
+			dirs:   []string{"/media/movies/foo", "/media/tv/bar"},
+			want:   []string{`POST /Library/Media/Updated token=token {"Updates":[{"Path":"/media/movies/foo","UpdateType":"Modified"},{"Path":"/media/tv/bar","UpdateType":"Modified"}]}`},
+		},
+		{
+			name:   "emby",
+			server: Server{Type: "emby", URL: ts.URL, Token: "token"},
+			dirs:   []string{"/media/movies/foo"},
+			want:   []string{`POST /emby/Library/Media/Updated token=token {"Updates":[{"Path":"/media/movies/foo","UpdateType":"Modified"}]}`},
+		},
+		{
+			name:    "jellyfin: invalid token",
+			server:  Server{Type: "jellyfin", URL: ts.URL, Token: "bad"},
+			dirs:    []string{"/media/movies/foo"},
+			wantErr: "401 Unauthorized",
+			want:    []string{`POST /Library/Media/Updated token=bad {"Updates":[{"Path":"/media/movies/foo","UpdateType":"Modified"}]}`},
+		},
+		{
+			name:    "plex: unknown folder",
+			server:  Server{Type: "plex", URL: ts.URL, Token: "token"},
+			dirs:    []string{"/media/movies/foo", "/media/music/bar", "/media/movies-4k/snafu"},
+			wantErr: "/media/music/bar: no library section found\n/media/movies-4k/snafu: no library section found",
+			want: []string{
+				"GET /library/sections X-Plex-Token=token",
+				"GET /library/sections/1/refresh X-Plex-Token=token&path=%2Fmedia%2Fmovies%2Ffoo",
+			},
+		},
+		{
+			name:    "plex: invalid token",
+			server:  Server{Type: "plex", URL: ts.URL, Token: "bad"},
+			dirs:    []string{"/media/movies/foo"},
+			wantErr: "sections: 401 Unauthorized",
+			want:    []string{"GET /library/sections X-Plex-Token=bad"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server.reset()
+			c, err := newClient(tt.server, http.DefaultClient)
+			require.NoError(t, err)
+			err = c.refresh(t.Context(), tt.dirs)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tt.wantErr)
+			}
+			assert.Equal(t, tt.want, server.requests())
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	_, err := New([]Server{{Type: "jellyfin"}, {Type: "kodi"}}, &fakeTranscoder{}, slog.New(slog.DiscardHandler))
+	assert.EqualError(t, err, `media server 2: invalid media server type "kodi"`)
+}
+
+// mediaServer is a stand-in for Jellyfin, Emby and Plex. It records each request and only accepts the token "token".
+type mediaServer struct {
+	received []string
+	mu       sync.Mutex
+}
+
+func (m *mediaServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	request := r.Method + " " + r.URL.Path
+	token := r.URL.Query().Get("X-Plex-Token")
+	if token != "" {
+		request += " " + r.URL.RawQuery
+	} else {
+		token = r.Header.Get("X-Emby-Token")
+		request += " token=" + token + " " + string(body)
+	}
+	m.mu.Lock()
+	m.received = append(m.received, request)
+	m.mu.Unlock()
+
+	if token != "token" && token != "jellyfin-token" && token != "plex-token" {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if r.URL.Path == "/library/sections" {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"MediaContainer":{"Directory":[` +
+			`{"key":"1","Location":[{"path":"/media/movies"}]},` +
+			`{"key":"2","Location":[{"path":"/media/tv"},{"path":"/media/tv2"}]}` +
+			`]}}`))
+	}
+}
+
+func (m *mediaServer) requests() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.received
+}
+
+func (m *mediaServer) reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.received = nil
+}
+
+var _ Transcoder = (*fakeTranscoder)(nil)
+
+type fakeTranscoder struct {
+	statusEvents pubsub.Publisher[transcoder.StatusEvent]
+}
+
+func (f *fakeTranscoder) SubscribeStatus() <-chan transcoder.StatusEvent {
+	return f.statusEvents.Subscribe()
+}
+
+func (f *fakeTranscoder) UnsubscribeStatus(ch <-chan transcoder.StatusEvent) {
+	f.statusEvents.Unsubscribe(ch)
+}
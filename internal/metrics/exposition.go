@@ -0,0 +1,153 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"maps"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// This file implements the subset of the Prometheus text exposition format needed by Metrics.
+// See https://prometheus.io/docs/instrumenting/exposition_formats/
+
+// desc describes a metric
+type desc struct {
+	name string
+	help string
+	kind string
+}
+
+func (d desc) writeHeader(w io.Writer) {
+	_, _ = fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.name, d.help, d.name, d.kind)
+}
+
+// label is a label name/value pair of a sample
+type label struct {
+	name  string
+	value string
+}
+
+func writeSample(w io.Writer, name string, labels []label, value float64) {
+	var b strings.Builder
+	b.WriteString(name)
+	if len(labels) > 0 {
+		b.WriteByte('{')
+		for i, l := range labels {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(l.name + `="` + escapeLabelValue(l.value) + `"`)
+		}
+		b.WriteByte('}')
+	}
+	b.WriteByte(' ')
+	b.WriteString(formatFloat(value))
+	b.WriteByte('\n')
+	_, _ = io.WriteString(w, b.String())
+}
+
+var labelValueEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(value string) string {
+	return labelValueEscaper.Replace(value)
+}
+
+func formatFloat(value float64) string {
+	if math.IsInf(value, +1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// counter is a metric that only goes up
+type counter struct {
+	desc
+	value float64
+}
+
+func newCounter(name, help string) counter {
+	return counter{desc: desc{name: name, help: help, kind: "counter"}}
+}
+
+func (c *counter) add(value float64) {
+	c.value += value
+}
+
+func (c *counter) write(w io.Writer) {
+	c.writeHeader(w)
+	writeSample(w, c.name, nil, c.value)
+}
+
+// counterVec is a counter, partitioned by a set of labels
+type counterVec struct {
+	desc
+	values     map[string]float64
+	labelNames []string
+}
+
+func newCounterVec(name, help string, labelNames ...string) counterVec {
+	return counterVec{
+		desc:       desc{name: name, help: help, kind: "counter"},
+		values:     make(map[string]float64),
+		labelNames: labelNames,
+	}
+}
+
+// labelSeparator separates label values in the counterVec's key
+const labelSeparator = "\xff"
+
+func (c *counterVec) add(value float64, labelValues ...string) {
+	c.values[strings.Join(labelValues, labelSeparator)] += value
+}
+
+func (c *counterVec) write(w io.Writer) {
+	c.writeHeader(w)
+	for _, key := range slices.Sorted(maps.Keys(c.values)) {
+		labelValues := strings.Split(key, labelSeparator)
+		labels := make([]label, len(c.labelNames))
+		for i, name := range c.labelNames {
+			labels[i] = label{name: name, value: labelValues[i]}
+		}
+		writeSample(w, c.name, labels, c.values[key])
+	}
+}
+
+// histogram samples observations and counts them in buckets
+type histogram struct {
+	desc
+	buckets []float64
+	counts  []uint64
+	count   uint64
+	sum     float64
+}
+
+func newHistogram(name, help string, buckets ...float64) histogram {
+	return histogram{
+		desc:    desc{name: name, help: help, kind: "histogram"},
+		buckets: buckets,
+		counts:  make([]uint64, len(buckets)),
+	}
+}
+
+func (h *histogram) observe(value float64) {
+	for i, upperBound := range h.buckets {
+		if value <= upperBound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += value
+}
+
+func (h *histogram) write(w io.Writer) {
+	h.writeHeader(w)
+	for i, upperBound := range h.buckets {
+		writeSample(w, h.name+"_bucket", []label{{name: "le", value: formatFloat(upperBound)}}, float64(h.counts[i]))
+	}
+	writeSample(w, h.name+"_bucket", []label{{name: "le", value: "+Inf"}}, float64(h.count))
+	writeSample(w, h.name+"_sum", nil, h.sum)
+	writeSample(w, h.name+"_count", nil, float64(h.count))
+}
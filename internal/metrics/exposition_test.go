@@ -0,0 +1,43 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCounterVec(t *testing.T) {
+	c := newCounterVec("foo_total", "foo counter", "stage", "class")
+	c.add(1, "scan", "unknown")
+	c.add(2, "transcode", `a "quoted" value`)
+	c.add(1, "scan", "unknown")
+
+	var b strings.Builder
+	c.write(&b)
+	const want = `# HELP foo_total foo counter
+# TYPE foo_total counter
+foo_total{stage="scan",class="unknown"} 2
+foo_total{stage="transcode",class="a \"quoted\" value"} 2
+`
+	assert.Equal(t, want, b.String())
+}
+
+func TestHistogram(t *testing.T) {
+	h := newHistogram("foo_seconds", "foo histogram", 1, 10)
+	h.observe(.5)
+	h.observe(5)
+	h.observe(50)
+
+	var b strings.Builder
+	h.write(&b)
+	const want = `# HELP foo_seconds foo histogram
+# TYPE foo_seconds histogram
+foo_seconds_bucket{le="1"} 1
+foo_seconds_bucket{le="10"} 2
+foo_seconds_bucket{le="+Inf"} 3
+foo_seconds_sum 55.5
+foo_seconds_count 3
+`
+	assert.Equal(t, want, b.String())
+}
@@ -0,0 +1,195 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/clambin/xcoder/ffmpeg"
+	"github.com/clambin/xcoder/internal/transcoder"
+)
+
+type WorkItems interface {
+	Items() []*transcoder.WorkItem
+}
+
+var _ WorkItems = (*transcoder.WorkItems)(nil)
+
+type Transcoder interface {
+	SessionCount() int
+	Subscribe() <-chan transcoder.SessionEvent
+	Unsubscribe(<-chan transcoder.SessionEvent)
+	SubscribeStatus() <-chan transcoder.StatusEvent
+	UnsubscribeStatus(<-chan transcoder.StatusEvent)
+}
+
+var _ Transcoder = (*transcoder.Transcoder)(nil)
+
+var _ http.Handler = (*Metrics)(nil)
+
+// Metrics collects the Transcoder's metrics from its session and status events.
+// It serves them over HTTP in the Prometheus text exposition format.
+type Metrics struct {
+	workItems         WorkItems
+	transcoder        Transcoder
+	sessions          map[*transcoder.Session]struct{}
+	started           map[*transcoder.WorkItem]time.Time
+	failures          counterVec
+	probeDuration     histogram
+	transcodeDuration histogram
+	bytesRead         counter
+	bytesWritten      counter
+	bytesSaved        counter
+	mu                sync.Mutex
+}
+
+// New returns a new Metrics collector. Call Run to start collecting metrics.
+func New(workItems WorkItems, tr Transcoder) *Metrics {
+	return &Metrics{
+		workItems:         workItems,
+		transcoder:        tr,
+		sessions:          make(map[*transcoder.Session]struct{}),
+		started:           make(map[*transcoder.WorkItem]time.Time),
+		failures:          newCounterVec("xcoder_failures_total", "Number of failed scans and transcoding sessions.", "stage", "class"),
+		probeDuration:     newHistogram("xcoder_probe_duration_seconds", "Time taken to scan a media file.", .1, .25, .5, 1, 2.5, 5, 10, 30),
+		transcodeDuration: newHistogram("xcoder_transcode_duration_seconds", "Time taken to transcode a media file.", 60, 300, 600, 1800, 3600, 7200, 14400, 28800),
+		bytesRead:         newCounter("xcoder_read_bytes_total", "Size of all transcoded source files."),
+		bytesWritten:      newCounter("xcoder_written_bytes_total", "Size of all converted target files."),
+		bytesSaved:        newCounter("xcoder_saved_bytes_total", "Difference in size between all converted source and target files."),
+	}
+}
+
+// Run collects metrics from the Transcoder's events until the context is canceled.
+func (m *Metrics) Run(ctx context.Context) error {
+	sessionEvents := m.transcoder.Subscribe()
+	defer m.transcoder.Unsubscribe(sessionEvents)
+	statusEvents := m.transcoder.SubscribeStatus()
+	defer m.transcoder.UnsubscribeStatus(statusEvents)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case ev := <-sessionEvents:
+			m.handleSessionEvent(ev)
+		case ev := <-statusEvents:
+			m.handleStatusEvent(ev)
+		}
+	}
+}
+
+func (m *Metrics) handleSessionEvent(ev transcoder.SessionEvent) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch ev.Type {
+	case transcoder.SessionStartedEvent:
+		m.sessions[ev.Session] = struct{}{}
+	case transcoder.SessionStoppedEvent:
+		delete(m.sessions, ev.Session)
+	}
+}
+
+func (m *Metrics) handleStatusEvent(ev transcoder.StatusEvent) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	switch ev.Status {
+	case transcoder.StatusScanning:
+		m.started[ev.WorkItem] = time.Now()
+	case transcoder.StatusScanned, transcoder.StatusSkipped, transcoder.StatusRejected, transcoder.StatusScanFailed:
+		m.observeDuration(ev.WorkItem, &m.probeDuration)
+		if ev.Status == transcoder.StatusScanFailed {
+			m.failures.add(1, "scan", errorClass(ev.Err))
+		}
+	case transcoder.StatusTranscoding:
+		m.started[ev.WorkItem] = time.Now()
+	case transcoder.StatusConverted:
+		m.observeDuration(ev.WorkItem, &m.transcodeDuration)
+		m.bytesRead.add(float64(ev.WorkItem.Source.Size))
+		m.bytesWritten.add(float64(ev.WorkItem.Target.Size))
+		m.bytesSaved.add(float64(ev.WorkItem.Saved()))
+	case transcoder.StatusFailed, transcoder.StatusRetrying:
+		m.observeDuration(ev.WorkItem, &m.transcodeDuration)
+		m.failures.add(1, "transcode", errorClass(ev.Err))
+	default:
+	}
+}
+
+func (m *Metrics) observeDuration(workItem *transcoder.WorkItem, h *histogram) {
+	if start, ok := m.started[workItem]; ok {
+		h.observe(time.Since(start).Seconds())
+		delete(m.started, workItem)
+	}
+}
+
+// errorClass returns a short label describing the type of error
+func errorClass(err error) string {
+	if _, ok := errors.AsType[*ffmpeg.InvalidMediaError](err); ok {
+		return "invalid_media"
+	}
+	if ffmpegErr, ok := errors.AsType[*ffmpeg.Error](err); ok {
+		return string(ffmpegErr.Kind)
+	}
+	return "unknown"
+}
+
+// ServeHTTP implements the http.Handler interface. It writes all metrics in the Prometheus text exposition format.
+// The metrics are rendered in memory first, so a slow client doesn't hold up the collection of metrics.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	var buf bytes.Buffer
+
+	// work items by status
+	counts := make(map[transcoder.Status]int)
+	for _, item := range m.workItems.Items() {
+		status, _ := item.Status()
+		counts[status]++
+	}
+	workItems := desc{name: "xcoder_workitems", help: "Number of work items by status.", kind: "gauge"}
+	workItems.writeHeader(&buf)
+	// statuses are sequential, so we can report all of them, including the ones that have no work items
+	for status := transcoder.StatusFound; status.String() != "unknown"; status++ {
+		writeSample(&buf, workItems.name, []label{{name: "status", value: status.String()}}, float64(counts[status]))
+	}
+
+	// sessions
+	sessions := desc{name: "xcoder_active_sessions", help: "Number of active transcoding sessions.", kind: "gauge"}
+	sessions.writeHeader(&buf)
+	writeSample(&buf, sessions.name, nil, float64(m.transcoder.SessionCount()))
+
+	m.writeCollected(&buf)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = w.Write(buf.Bytes())
+}
+
+// writeCollected writes the metrics collected from the Transcoder's events.
+func (m *Metrics) writeCollected(w io.Writer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	speed := desc{name: "xcoder_session_speed", help: "Transcoding speed of each active session, relative to real time.", kind: "gauge"}
+	fps := desc{name: "xcoder_session_fps", help: "Frames per second of each active session.", kind: "gauge"}
+	speed.writeHeader(w)
+	for session := range m.sessions {
+		writeSample(w, speed.name, sessionLabels(session), session.Progress().Speed)
+	}
+	fps.writeHeader(w)
+	for session := range m.sessions {
+		writeSample(w, fps.name, sessionLabels(session), session.Progress().FPS)
+	}
+
+	m.bytesRead.write(w)
+	m.bytesWritten.write(w)
+	m.bytesSaved.write(w)
+	m.probeDuration.write(w)
+	m.transcodeDuration.write(w)
+	m.failures.write(w)
+}
+
+func sessionLabels(session *transcoder.Session) []label {
+	return []label{{name: "source", value: filepath.Base(session.WorkItem.Source.Path)}}
+}
@@ -0,0 +1,146 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"codeberg.org/clambin/go-common/pubsub"
+	"github.com/clambin/xcoder/ffmpeg"
+	"github.com/clambin/xcoder/internal/transcoder"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics(t *testing.T) {
+	var q transcoder.WorkItems
+	converted := &transcoder.WorkItem{
+		Source: transcoder.File{Path: "source.mkv", Size: 1000},
+		Target: transcoder.File{Path: "source.hevc.mkv", Size: 400},
+	}
+	failed := &transcoder.WorkItem{Source: transcoder.File{Path: "failed.mkv"}}
+	q.Add(converted, failed)
+
+	var tr fakeTranscoder
+	m := New(&q, &tr)
+	go func() { _ = m.Run(t.Context()) }()
+	require.Eventually(t, func() bool { return tr.statusEvents.Subscribers() == 1 }, time.Second, 10*time.Millisecond)
+
+	// scan
+	for _, status := range []transcoder.Status{transcoder.StatusScanning, transcoder.StatusScanned} {
+		converted.SetStatus(status, nil)
+		tr.statusEvents.Publish(transcoder.StatusEvent{WorkItem: converted, Status: status})
+	}
+	failed.SetStatus(transcoder.StatusScanFailed, &ffmpeg.InvalidMediaError{Reason: "no video stream found"})
+	tr.statusEvents.Publish(transcoder.StatusEvent{WorkItem: failed, Status: transcoder.StatusScanFailed, Err: &ffmpeg.InvalidMediaError{}})
+
+	// transcode
+	session := transcoder.Session{WorkItem: converted}
+	tr.sessionEvents.Publish(transcoder.SessionEvent{Session: &session, Type: transcoder.SessionStartedEvent})
+	converted.SetStatus(transcoder.StatusTranscoding, nil)
+	tr.statusEvents.Publish(transcoder.StatusEvent{WorkItem: converted, Status: transcoder.StatusTranscoding})
+
+	assert.Eventually(t, func() bool {
+		return strings.Contains(scrape(m), `xcoder_session_speed{source="source.mkv"} 0`)
+	}, time.Second, 10*time.Millisecond)
+
+	converted.SetStatus(transcoder.StatusConverted, nil)
+	tr.statusEvents.Publish(transcoder.StatusEvent{WorkItem: converted, Status: transcoder.StatusConverted})
+	tr.sessionEvents.Publish(transcoder.SessionEvent{Session: &session, Type: transcoder.SessionStoppedEvent})
+
+	want := []string{
+		`xcoder_workitems{status="converted"} 1`,
+		`xcoder_workitems{status="scan failed"} 1`,
+		`xcoder_workitems{status="queued"} 0`,
+		`xcoder_active_sessions 0`,
+		`xcoder_read_bytes_total 1000`,
+		`xcoder_written_bytes_total 400`,
+		`xcoder_saved_bytes_total 600`,
+		`xcoder_probe_duration_seconds_count 1`,
+		`xcoder_transcode_duration_seconds_count 1`,
+		`xcoder_failures_total{stage="scan",class="invalid_media"} 1`,
+	}
+	assert.Eventually(t, func() bool {
+		output := scrape(m)
+		for _, line := range want {
+			if !strings.Contains(output, line+"\n") {
+				return false
+			}
+		}
+		return !strings.Contains(output, "xcoder_session_speed{")
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestMetrics_SlowClient(t *testing.T) {
+	var q transcoder.WorkItems
+	item := &transcoder.WorkItem{Source: transcoder.File{Path: "source.mkv"}}
+	q.Add(item)
+
+	m := New(&q, &fakeTranscoder{})
+	w := blockingWriter{ResponseRecorder: httptest.NewRecorder(), blocked: make(chan struct{}), unblock: make(chan struct{})}
+	go m.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	t.Cleanup(func() { close(w.unblock) })
+	<-w.blocked
+
+	// a client that doesn't read its response must not block the collection of metrics
+	done := make(chan struct{})
+	go func() {
+		item.SetStatus(transcoder.StatusScanFailed, &ffmpeg.InvalidMediaError{})
+		m.handleStatusEvent(transcoder.StatusEvent{WorkItem: item, Status: transcoder.StatusScanFailed, Err: &ffmpeg.InvalidMediaError{}})
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("status event blocked by a slow client")
+	}
+}
+
+// blockingWriter blocks writing the metrics collected from the Transcoder's events until unblock is closed.
+type blockingWriter struct {
+	*httptest.ResponseRecorder
+	blocked chan struct{}
+	unblock chan struct{}
+}
+
+func (w blockingWriter) Write(b []byte) (int, error) {
+	if strings.Contains(string(b), "xcoder_read_bytes_total") {
+		close(w.blocked)
+		<-w.unblock
+	}
+	return w.ResponseRecorder.Write(b)
+}
+
+func scrape(m *Metrics) string {
+	w := httptest.NewRecorder()
+	m.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	return w.Body.String()
+}
+
+var _ Transcoder = (*fakeTranscoder)(nil)
+
+type fakeTranscoder struct {
+	sessionEvents pubsub.Publisher[transcoder.SessionEvent]
+	statusEvents  pubsub.Publisher[transcoder.StatusEvent]
+}
+
+func (f *fakeTranscoder) SessionCount() int {
+	return 0
+}
+
+func (f *fakeTranscoder) Subscribe() <-chan transcoder.SessionEvent {
+	return f.sessionEvents.Subscribe()
+}
+
+func (f *fakeTranscoder) Unsubscribe(ch <-chan transcoder.SessionEvent) {
+	f.sessionEvents.Unsubscribe(ch)
+}
+
+func (f *fakeTranscoder) SubscribeStatus() <-chan transcoder.StatusEvent {
+	return f.statusEvents.Subscribe()
+}
+
+func (f *fakeTranscoder) UnsubscribeStatus(ch <-chan transcoder.StatusEvent) {
+	f.statusEvents.Unsubscribe(ch)
+}
@@ -0,0 +1,139 @@
+package plan
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/clambin/xcoder/ffmpeg"
+	"github.com/clambin/xcoder/internal/history"
+	"github.com/clambin/xcoder/internal/mediafiles"
+	"github.com/clambin/xcoder/internal/transcoder"
+)
+
+// Decision is what a profile would do with a media file.
+type Decision string
+
+const (
+	Convert Decision = "convert"
+	Skip    Decision = "skip"
+	Reject  Decision = "reject"
+	// Fail means the media file couldn't be analyzed.
+	Fail Decision = "fail"
+)
+
+// An Entry is the decision for one media file. The target fields are only set for files that would be converted.
+// EncodeTime is zero if there is no history to estimate it from.
+//
+//nolint:tagliatelle
+type Entry struct {
+	Source        string        `json:"source"`
+	Decision      Decision      `json:"decision"`
+	Reason        string        `json:"reason,omitempty"`
+	Target        string        `json:"target,omitempty"`
+	SourceSize    int64         `json:"source_size"`
+	TargetBitrate int           `json:"target_bitrate,omitempty"`
+	EstimatedSize int64         `json:"estimated_size,omitempty"`
+	EncodeTime    time.Duration `json:"encode_time,omitempty"`
+}
+
+// A Plan lists the decision for each media file, sorted by source path.
+type Plan struct {
+	Profile string  `json:"profile"`
+	Entries []Entry `json:"entries"`
+}
+
+// New analyzes all media files below the paths with the profile, without converting them. Paths that aren't
+// directories are analyzed as-is. The encode time is estimated from the speeds of previous sessions.
+func New(ctx context.Context, paths []string, profile transcoder.Profile, probe func(string) (ffmpeg.VideoStats, error), speeds history.Speeds) (Plan, error) {
+	p := Plan{Profile: profile.Name}
+	add := func(path string) {
+		if ctx.Err() == nil {
+			p.Entries = append(p.Entries, analyze(path, profile, probe, speeds))
+		}
+	}
+	for _, path := range paths {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return Plan{}, err
+		}
+		if !fi.IsDir() {
+			add(path)
+			continue
+		}
+		if err = mediafiles.FindMediaFiles(path, add); err != nil {
+			return Plan{}, err
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return Plan{}, err
+	}
+	slices.SortFunc(p.Entries, func(a, b Entry) int { return cmp.Compare(a.Source, b.Source) })
+	return p, nil
+}
+
+func analyze(path string, profile transcoder.Profile, probe func(string) (ffmpeg.VideoStats, error), speeds history.Speeds) Entry {
+	e := Entry{Source: path}
+	if fi, err := os.Stat(path); err == nil {
+		e.SourceSize = fi.Size()
+	}
+	stats, err := probe(path)
+	if err != nil {
+		e.Decision, e.Reason = Fail, err.Error()
+		return e
+	}
+	target, err := profile.Analyze(transcoder.File{Path: path, VideoStats: stats})
+	if err != nil {
+		e.Decision, e.Reason = decision(err), err.Error()
+		return e
+	}
+	workItem := transcoder.WorkItem{
+		Source: transcoder.File{Path: path, VideoStats: stats, Size: e.SourceSize},
+		Target: transcoder.File{VideoStats: target},
+	}
+	e.Decision = Convert
+	e.Target = transcoder.TargetFilename(path, target, profile.TargetCodec)
+	e.TargetBitrate = target.BitRate
+	e.EstimatedSize = workItem.EstimatedSize()
+	if speed, ok := speeds.Estimate(stats); ok {
+		e.EncodeTime = time.Duration(float64(stats.Duration) / speed)
+	}
+	return e
+}
+
+// decision returns the decision for a file that the profile won't convert.
+func decision(err error) Decision {
+	if _, ok := errors.AsType[*transcoder.SourceSkippedError](err); ok {
+		return Skip
+	}
+	if _, ok := errors.AsType[*transcoder.SourceRejectedError](err); ok {
+		return Reject
+	}
+	return Fail
+}
+
+// Totals summarizes the plan.
+type Totals struct {
+	Decisions  map[Decision]int
+	SourceSize int64
+	TargetSize int64
+	EncodeTime time.Duration
+}
+
+// Totals returns the number of files for each decision and, for the files that would be converted,
+// the total size before and after conversion and the total encode time.
+func (p Plan) Totals() Totals {
+	t := Totals{Decisions: make(map[Decision]int)}
+	for _, e := range p.Entries {
+		t.Decisions[e.Decision]++
+		if e.Decision == Convert {
+			t.SourceSize += e.SourceSize
+			t.TargetSize += e.EstimatedSize
+			t.EncodeTime += e.EncodeTime
+		}
+	}
+	return t
+}
@@ -0,0 +1,114 @@
+package plan
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/clambin/xcoder/ffmpeg"
+	"github.com/clambin/xcoder/internal/history"
+	"github.com/clambin/xcoder/internal/transcoder"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNew(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]ffmpeg.VideoStats{
+		"a.mkv":     {VideoCodec: "h264", Height: 1080, Width: 1920, BitRate: 10_000_000, Duration: time.Hour},
+		"sub/b.mkv": {VideoCodec: "hevc", Height: 1080, Width: 1920, BitRate: 4_000_000, Duration: time.Hour},
+		"sub/c.mp4": {VideoCodec: "h264", Height: 480, Width: 640, BitRate: 4_000_000, Duration: time.Hour},
+		"bad.mkv":   {},
+		"notes.txt": {},
+	}
+	for name := range files {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0o755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), make([]byte, 100), 0o644))
+	}
+	probe := func(path string) (ffmpeg.VideoStats, error) {
+		rel, _ := filepath.Rel(dir, path)
+		if rel == "bad.mkv" {
+			return ffmpeg.VideoStats{}, errors.New("corrupt input")
+		}
+		return files[rel], nil
+	}
+	profile, err := transcoder.GetProfile("hevc-high")
+	require.NoError(t, err)
+	speeds := history.NewSpeeds([]history.Record{
+		{Source: history.File{Stats: ffmpeg.VideoStats{VideoCodec: "h264", Height: 1080}}, Speed: 2},
+	})
+
+	p, err := New(t.Context(), []string{dir}, profile, probe, speeds)
+	require.NoError(t, err)
+	require.Len(t, p.Entries, 4)
+
+	assert.Equal(t, filepath.Join(dir, "a.mkv"), p.Entries[0].Source)
+	assert.Equal(t, Convert, p.Entries[0].Decision)
+	assert.Equal(t, transcoder.TargetFilename(filepath.Join(dir, "a.mkv"), files["a.mkv"], "hevc"), p.Entries[0].Target)
+	assert.NotZero(t, p.Entries[0].TargetBitrate)
+	assert.Equal(t, int64(p.Entries[0].TargetBitrate)*3600/8, p.Entries[0].EstimatedSize)
+	assert.Equal(t, 30*time.Minute, p.Entries[0].EncodeTime)
+
+	assert.Equal(t, Fail, p.Entries[1].Decision)
+	assert.Equal(t, "corrupt input", p.Entries[1].Reason)
+	assert.Equal(t, Skip, p.Entries[2].Decision)
+	assert.Equal(t, "source video already in target codec", p.Entries[2].Reason)
+	assert.Equal(t, Reject, p.Entries[3].Decision)
+
+	totals := p.Totals()
+	assert.Equal(t, map[Decision]int{Convert: 1, Skip: 1, Reject: 1, Fail: 1}, totals.Decisions)
+	assert.Equal(t, int64(100), totals.SourceSize)
+	assert.Equal(t, 30*time.Minute, totals.EncodeTime)
+
+	_, err = New(t.Context(), []string{filepath.Join(dir, "missing")}, profile, probe, speeds)
+	assert.Error(t, err)
+}
+
+func TestPlan_Write(t *testing.T) {
+	p := Plan{
+		Profile: "hevc-high",
+		Entries: []Entry{
+			{Source: "a.mkv", Decision: Convert, Target: "a.hevc.mkv", SourceSize: 4_000_000_000, TargetBitrate: 4_000_000, EstimatedSize: 2_000_000_000, EncodeTime: 30 * time.Minute},
+			{Source: "b.mkv", Decision: Skip, Reason: "source video already in target codec", SourceSize: 1_000_000_000},
+		},
+	}
+
+	tests := []struct {
+		format  string
+		want    string
+		wantErr assert.ErrorAssertionFunc
+	}{
+		{
+			format: "text",
+			want: `a.mkv: convert → a.hevc.mkv (bitrate: 4.0 mbps, size: 4.0 GB → 2.0 GB, encode time: 30m0s)
+b.mkv: skip: source video already in target codec
+total: 1 convert, 1 skip, 0 reject, 0 fail; size: 4.0 GB → 2.0 GB; encode time: 30m0s
+`,
+			wantErr: assert.NoError,
+		},
+		{
+			format: "csv",
+			want: `source,decision,reason,target,source_size,target_bitrate,estimated_size,encode_time
+a.mkv,convert,,a.hevc.mkv,4000000000,4000000,2000000000,1800
+b.mkv,skip,source video already in target codec,,1000000000,0,0,0
+`,
+			wantErr: assert.NoError,
+		},
+		{format: "xml", wantErr: assert.Error},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var buf bytes.Buffer
+			tt.wantErr(t, p.Write(&buf, tt.format))
+			assert.Equal(t, tt.want, buf.String())
+		})
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, p.Write(&buf, "json"))
+	assert.Contains(t, buf.String(), `"decision": "convert"`)
+	assert.Contains(t, buf.String(), `"target": "a.hevc.mkv"`)
+}
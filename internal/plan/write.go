@@ -0,0 +1,89 @@
+package plan
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/clambin/xcoder/ffmpeg"
+)
+
+// Write writes the Plan in the requested format: text, csv or json.
+//
+// The text format writes one line per media file, in the same order for any profile, so the plans
+// of two profiles for the same media files can be compared with diff.
+func (p Plan) Write(w io.Writer, format string) error {
+	switch format {
+	case "text":
+		return p.writeText(w)
+	case "csv":
+		return p.writeCSV(w)
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(p)
+	default:
+		return fmt.Errorf("invalid format %q", format)
+	}
+}
+
+func (p Plan) writeText(w io.Writer) error {
+	for _, e := range p.Entries {
+		if _, err := fmt.Fprintln(w, e); err != nil {
+			return err
+		}
+	}
+	t := p.Totals()
+	_, err := fmt.Fprintf(w, "total: %d convert, %d skip, %d reject, %d fail; size: %s → %s; encode time: %s\n",
+		t.Decisions[Convert], t.Decisions[Skip], t.Decisions[Reject], t.Decisions[Fail],
+		ffmpeg.Bytes(t.SourceSize).Format(1),
+		ffmpeg.Bytes(t.TargetSize).Format(1),
+		encodeTime(t.EncodeTime),
+	)
+	return err
+}
+
+// String returns the Entry as a single line of text.
+func (e Entry) String() string {
+	if e.Decision != Convert {
+		return e.Source + ": " + string(e.Decision) + ": " + e.Reason
+	}
+	return fmt.Sprintf("%s: %s → %s (bitrate: %s, size: %s → %s, encode time: %s)",
+		e.Source,
+		e.Decision,
+		e.Target,
+		ffmpeg.Bits(e.TargetBitrate).Format(1),
+		ffmpeg.Bytes(e.SourceSize).Format(1),
+		ffmpeg.Bytes(e.EstimatedSize).Format(1),
+		encodeTime(e.EncodeTime),
+	)
+}
+
+func encodeTime(d time.Duration) string {
+	if d == 0 {
+		return "unknown"
+	}
+	return d.Round(time.Minute).String()
+}
+
+func (p Plan) writeCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	_ = cw.Write([]string{"source", "decision", "reason", "target", "source_size", "target_bitrate", "estimated_size", "encode_time"})
+	for _, e := range p.Entries {
+		_ = cw.Write([]string{
+			e.Source,
+			string(e.Decision),
+			e.Reason,
+			e.Target,
+			strconv.FormatInt(e.SourceSize, 10),
+			strconv.Itoa(e.TargetBitrate),
+			strconv.FormatInt(e.EstimatedSize, 10),
+			strconv.FormatFloat(e.EncodeTime.Seconds(), 'f', 0, 64),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
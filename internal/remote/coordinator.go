@@ -0,0 +1,199 @@
+// Package remote distributes transcoding sessions across multiple hosts. A Coordinator leases the transcoder's
+// work items to remote Workers over HTTP. Workers transcode the leased work item, renewing the lease while they
+// report progress, and report the result when they're done. If a worker stops renewing its lease, the lease expires
+// and the transcoder handles the work item as a failed session.
+//
+// Coordinator and workers must see the media files under the same path, e.g. by mounting the same network share.
+package remote
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/clambin/xcoder/ffmpeg"
+	"github.com/clambin/xcoder/internal/transcoder"
+)
+
+// Transcoder is the part of the transcoder that hands out leases to remote workers.
+type Transcoder interface {
+	Lease(worker string) (*transcoder.Session, bool)
+	RenewLease(id string, progress ffmpeg.Progress) error
+	CompleteLease(id string, err error) error
+}
+
+var _ Transcoder = (*transcoder.Transcoder)(nil)
+
+var _ http.Handler = (*Coordinator)(nil)
+
+// Coordinator exposes the transcoder's work items to remote workers.
+type Coordinator struct {
+	transcoder Transcoder
+	logger     *slog.Logger
+	mux        *http.ServeMux
+}
+
+// NewCoordinator returns a new Coordinator.
+func NewCoordinator(tr Transcoder, logger *slog.Logger) *Coordinator {
+	c := Coordinator{
+		transcoder: tr,
+		logger:     logger,
+		mux:        http.NewServeMux(),
+	}
+	c.mux.HandleFunc("POST /api/v1/leases", c.lease)
+	c.mux.HandleFunc("POST /api/v1/leases/{id}/progress", c.progress)
+	c.mux.HandleFunc("POST /api/v1/leases/{id}/result", c.result)
+	return &c
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (c *Coordinator) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mux.ServeHTTP(w, r)
+}
+
+// A Lease is a work item leased to a remote worker.
+//
+//nolint:tagliatelle
+type Lease struct {
+	ID              string `json:"id"`
+	Source          File   `json:"source"`
+	Target          File   `json:"target"`
+	Backend         string `json:"backend"`
+	OverwriteTarget bool   `json:"overwrite_target"`
+}
+
+// A File describes the source or target of a Lease.
+type File struct {
+	Path  string            `json:"path"`
+	Stats ffmpeg.VideoStats `json:"stats"`
+	Size  int64             `json:"size"`
+}
+
+type leaseRequest struct {
+	Worker string `json:"worker"`
+}
+
+//nolint:tagliatelle
+type progressRequest struct {
+	Converted time.Duration `json:"converted"`
+	Speed     float64       `json:"speed"`
+	FPS       float64       `json:"fps"`
+	Bitrate   float64       `json:"bitrate"`
+	TotalSize int64         `json:"total_size"`
+}
+
+// resultRequest holds the result of the worker's session. If the session failed with an *ffmpeg.Error,
+// Kind, Command and Stderr hold its fields, so the coordinator can rebuild it.
+type resultRequest struct {
+	Error   string           `json:"error,omitempty"`
+	Kind    ffmpeg.ErrorKind `json:"kind,omitempty"`
+	Command string           `json:"command,omitempty"`
+	Stderr  string           `json:"stderr,omitempty"`
+}
+
+// newResultRequest returns the resultRequest for the result of a session.
+func newResultRequest(err error) resultRequest {
+	var request resultRequest
+	if err == nil {
+		return request
+	}
+	if ffmpegErr, ok := errors.AsType[*ffmpeg.Error](err); ok {
+		request.Error = ffmpegErr.Err.Error()
+		request.Kind, request.Command, request.Stderr = ffmpegErr.Kind, ffmpegErr.Command, ffmpegErr.Stderr
+		return request
+	}
+	request.Error = err.Error()
+	return request
+}
+
+// err returns the error reported by the worker.
+func (r resultRequest) err() error {
+	switch {
+	case r.Kind != "":
+		return &ffmpeg.Error{Err: errors.New(r.Error), Kind: r.Kind, Command: r.Command, Stderr: r.Stderr}
+	case r.Error != "":
+		return errors.New(r.Error)
+	default:
+		return nil
+	}
+}
+
+// lease assigns the next work item to the worker. If there is no work available, it returns http.StatusNoContent.
+func (c *Coordinator) lease(w http.ResponseWriter, r *http.Request) {
+	var request leaseRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+		return
+	}
+	if request.Worker == "" {
+		writeError(w, http.StatusBadRequest, errors.New("invalid request: missing worker"))
+		return
+	}
+	session, ok := c.transcoder.Lease(request.Worker)
+	if !ok {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	c.logger.Info("work item leased", "source", session.WorkItem.Source.Path, "worker", request.Worker)
+	writeJSON(w, http.StatusOK, Lease{
+		ID:              session.LeaseID(),
+		Source:          File{Path: session.WorkItem.Source.Path, Stats: session.WorkItem.Source.VideoStats, Size: session.WorkItem.Source.Size},
+		Target:          File{Path: session.WorkItem.Target.Path, Stats: session.WorkItem.Target.VideoStats},
+		Backend:         session.Backend,
+		OverwriteTarget: session.OverwriteTarget(),
+	})
+}
+
+// progress records the worker's progress and renews its lease. If the lease is no longer valid, it returns
+// http.StatusGone: the worker should stop transcoding.
+func (c *Coordinator) progress(w http.ResponseWriter, r *http.Request) {
+	var request progressRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+		return
+	}
+	err := c.transcoder.RenewLease(r.PathValue("id"), ffmpeg.Progress{
+		Converted: request.Converted,
+		Speed:     request.Speed,
+		FPS:       request.FPS,
+		Bitrate:   request.Bitrate,
+		TotalSize: request.TotalSize,
+	})
+	c.writeLeaseResponse(w, err)
+}
+
+// result records the result of the worker's session.
+func (c *Coordinator) result(w http.ResponseWriter, r *http.Request) {
+	var request resultRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+		return
+	}
+	c.writeLeaseResponse(w, c.transcoder.CompleteLease(r.PathValue("id"), request.err()))
+}
+
+func (c *Coordinator) writeLeaseResponse(w http.ResponseWriter, err error) {
+	switch {
+	case err == nil:
+		w.WriteHeader(http.StatusNoContent)
+	case errors.Is(err, transcoder.ErrLeaseNotFound):
+		writeError(w, http.StatusGone, err)
+	default:
+		writeError(w, http.StatusInternalServerError, err)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, statusCode int, err error) {
+	writeJSON(w, statusCode, struct {
+		Error string `json:"error"`
+	}{Error: err.Error()})
+}
@@ -0,0 +1,173 @@
+package remote
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/clambin/xcoder/ffmpeg"
+	"github.com/clambin/xcoder/internal/transcoder"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWorker(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantStatus transcoder.Status
+		wantErr    string
+	}{
+		{name: "success", wantStatus: transcoder.StatusConverted},
+		{name: "failure", err: errors.New("ffmpeg: exit status 1"), wantStatus: transcoder.StatusRetrying, wantErr: "ffmpeg: exit status 1"},
+		{
+			name:       "corrupt input",
+			err:        &ffmpeg.Error{Err: errors.New("exit status 1"), Kind: ffmpeg.ErrorCorruptInput, Command: "ffmpeg", Stderr: "moov atom not found"},
+			wantStatus: transcoder.StatusFailed,
+			wantErr:    "ffmpeg: exit status 1 (moov atom not found)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpDir := t.TempDir()
+			var q transcoder.WorkItems
+			workItem := transcoder.WorkItem{
+				Source: transcoder.File{Path: filepath.Join(tmpDir, "source.mkv"), VideoStats: ffmpeg.VideoStats{VideoCodec: "h264", Duration: time.Hour}},
+				Target: transcoder.File{Path: filepath.Join(tmpDir, "source.hevc.mkv"), VideoStats: ffmpeg.VideoStats{VideoCodec: "hevc", BitRate: 3_000_000}},
+			}
+			workItem.SetStatus(transcoder.StatusQueued, nil)
+			q.Add(&workItem)
+
+			logger := slog.New(slog.DiscardHandler)
+			// corrupt input isn't retried, even if the worker reports it
+			retry := transcoder.RetryPolicy{MaxAttempts: 2, Backoff: time.Hour, Backends: []string{transcoder.SoftwareBackend}}
+			tr := transcoder.New(&q, transcoder.Configuration{RemoteOnly: true, Profile: transcoder.Profile{Retry: retry}}, logger)
+			go func() { _ = tr.Run(t.Context()) }()
+			ts := httptest.NewServer(NewCoordinator(tr, logger))
+			t.Cleanup(ts.Close)
+
+			local := fakeTranscoder{err: tt.err, release: make(chan struct{})}
+			w := NewWorker(ts.URL, "worker-1", &local, logger)
+			w.pollInterval = 10 * time.Millisecond
+			w.renewInterval = 10 * time.Millisecond
+			go func() { _ = w.Run(t.Context()) }()
+
+			// the coordinator tracks the remote session & its progress
+			require.Eventually(t, func() bool {
+				sessions := tr.Sessions()
+				return len(sessions) == 1 && sessions[0].Progress().Converted == 30*time.Minute
+			}, time.Second, 10*time.Millisecond)
+			assert.Equal(t, "worker-1", tr.Sessions()[0].Worker)
+			close(local.release)
+
+			require.Eventually(t, func() bool {
+				status, _ := workItem.Status()
+				return status == tt.wantStatus
+			}, time.Second, 10*time.Millisecond)
+			if _, err := workItem.Status(); tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				if ffmpegErr, ok := errors.AsType[*ffmpeg.Error](tt.err); ok {
+					assert.Equal(t, ffmpegErr, err)
+				}
+			}
+			assert.Eventually(t, func() bool { return tr.SessionCount() == 0 }, time.Second, 10*time.Millisecond)
+		})
+	}
+}
+
+func TestWorker_LeaseLost(t *testing.T) {
+	// the partial target file written by the session
+	target := filepath.Join(t.TempDir(), "source.hevc.mkv")
+	require.NoError(t, os.WriteFile(target, []byte("partial"), 0o644))
+
+	// a coordinator that no longer recognizes the lease
+	var results int
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /api/v1/leases", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, Lease{ID: "1", Source: File{Path: "source.mkv"}, Target: File{Path: target}, Backend: "software"})
+	})
+	mux.HandleFunc("POST /api/v1/leases/{id}/progress", func(w http.ResponseWriter, _ *http.Request) {
+		writeError(w, http.StatusGone, transcoder.ErrLeaseNotFound)
+	})
+	mux.HandleFunc("POST /api/v1/leases/{id}/result", func(w http.ResponseWriter, _ *http.Request) {
+		results++
+		w.WriteHeader(http.StatusNoContent)
+	})
+	ts := httptest.NewServer(mux)
+	t.Cleanup(ts.Close)
+
+	w := NewWorker(ts.URL, "worker-1", &fakeTranscoder{release: make(chan struct{})}, slog.New(slog.DiscardHandler))
+	w.renewInterval = 10 * time.Millisecond
+
+	// the session is stopped, its partial target is removed and no result is reported
+	done := make(chan struct{})
+	go func() {
+		lease, ok, err := w.lease(t.Context())
+		require.NoError(t, err)
+		require.True(t, ok)
+		w.process(t.Context(), lease)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("session not stopped")
+	}
+	assert.Zero(t, results)
+	assert.NoFileExists(t, target)
+}
+
+func TestCoordinator(t *testing.T) {
+	var q transcoder.WorkItems
+	tr := transcoder.New(&q, transcoder.Configuration{}, slog.New(slog.DiscardHandler))
+	c := NewCoordinator(tr, slog.New(slog.DiscardHandler))
+
+	tests := []struct {
+		name           string
+		path           string
+		body           string
+		wantStatusCode int
+	}{
+		{name: "no work", path: "/api/v1/leases", body: `{"worker":"worker-1"}`, wantStatusCode: http.StatusNoContent},
+		{name: "missing worker", path: "/api/v1/leases", body: `{}`, wantStatusCode: http.StatusBadRequest},
+		{name: "invalid request", path: "/api/v1/leases", body: `{`, wantStatusCode: http.StatusBadRequest},
+		{name: "unknown lease progress", path: "/api/v1/leases/1/progress", body: `{}`, wantStatusCode: http.StatusGone},
+		{name: "unknown lease result", path: "/api/v1/leases/1/result", body: `{}`, wantStatusCode: http.StatusGone},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c.ServeHTTP(w, httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(tt.body)))
+			assert.Equal(t, tt.wantStatusCode, w.Code)
+		})
+	}
+}
+
+var _ LocalTranscoder = (*fakeTranscoder)(nil)
+
+type fakeTranscoder struct {
+	err     error
+	release chan struct{}
+}
+
+func (f *fakeTranscoder) Transcode(ctx context.Context, workItem *transcoder.WorkItem, _ string, _ bool, progress func(ffmpeg.Progress)) error {
+	progress(ffmpeg.Progress{Converted: 30 * time.Minute, Speed: 2})
+	select {
+	case <-f.release:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if f.err != nil {
+		return f.err
+	}
+	return os.WriteFile(workItem.Target.Path, []byte("target"), 0o644)
+}
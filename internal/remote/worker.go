@@ -0,0 +1,202 @@
+package remote
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/clambin/xcoder/ffmpeg"
+	"github.com/clambin/xcoder/internal/transcoder"
+)
+
+const (
+	// pollInterval is the time a worker waits before asking for a new lease, if the coordinator has no work.
+	pollInterval = 10 * time.Second
+	// renewInterval is the time between two progress reports. This must be well within the transcoder's lease TTL.
+	renewInterval = 5 * time.Second
+	// requestTimeout is the maximum time a request to the coordinator may take.
+	requestTimeout = 10 * time.Second
+)
+
+// errLeaseLost is returned when the coordinator no longer recognizes the worker's lease.
+var errLeaseLost = errors.New("lease lost")
+
+// LocalTranscoder transcodes the work items leased by a Worker.
+type LocalTranscoder interface {
+	Transcode(ctx context.Context, workItem *transcoder.WorkItem, backend string, overwriteTarget bool, progress func(ffmpeg.Progress)) error
+}
+
+var _ LocalTranscoder = (*transcoder.Transcoder)(nil)
+
+// A Worker leases work items from a Coordinator and transcodes them.
+type Worker struct {
+	transcoder    LocalTranscoder
+	client        *http.Client
+	logger        *slog.Logger
+	url           string
+	name          string
+	pollInterval  time.Duration
+	renewInterval time.Duration
+}
+
+// NewWorker returns a Worker that leases work items from the coordinator at url.
+func NewWorker(url string, name string, tr LocalTranscoder, logger *slog.Logger) *Worker {
+	return &Worker{
+		transcoder:    tr,
+		client:        &http.Client{Timeout: requestTimeout},
+		logger:        logger,
+		url:           url,
+		name:          name,
+		pollInterval:  pollInterval,
+		renewInterval: renewInterval,
+	}
+}
+
+// Run leases and transcodes work items until the context is canceled.
+// Canceling the context stops the active session, removes its partial target file and reports it as failed to the coordinator.
+func (w *Worker) Run(ctx context.Context) error {
+	for ctx.Err() == nil {
+		lease, ok, err := w.lease(ctx)
+		if err != nil && ctx.Err() == nil {
+			w.logger.Warn("failed to lease work item", "err", err)
+		}
+		if ok {
+			w.process(ctx, lease)
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(w.pollInterval):
+		}
+	}
+	return nil
+}
+
+// lease asks the coordinator for a new work item. It returns false if the coordinator has no work available.
+func (w *Worker) lease(ctx context.Context) (Lease, bool, error) {
+	var lease Lease
+	statusCode, err := w.post(ctx, "/api/v1/leases", leaseRequest{Worker: w.name}, &lease)
+	if err != nil || statusCode == http.StatusNoContent {
+		return Lease{}, false, err
+	}
+	return lease, true, nil
+}
+
+// process transcodes the leased work item, reporting its progress while the session runs, and reports the result.
+func (w *Worker) process(ctx context.Context, lease Lease) {
+	logger := w.logger.With(slog.String("source", lease.Source.Path), slog.String("backend", lease.Backend))
+	logger.Info("started transcoding")
+	start := time.Now()
+
+	// report progress until the session completes. if the lease is lost, we stop the session.
+	sessionCtx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	var progress atomic.Pointer[ffmpeg.Progress]
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	wg.Go(func() {
+		ticker := time.NewTicker(w.renewInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := w.renew(ctx, lease, progress.Load()); err != nil {
+					logger.Warn("failed to report progress", "err", err)
+					if errors.Is(err, errLeaseLost) {
+						cancel(err)
+						return
+					}
+				}
+			}
+		}
+	})
+
+	workItem := transcoder.WorkItem{
+		Source: transcoder.File{Path: lease.Source.Path, VideoStats: lease.Source.Stats, Size: lease.Source.Size},
+		Target: transcoder.File{Path: lease.Target.Path, VideoStats: lease.Target.Stats},
+	}
+	err := w.transcoder.Transcode(sessionCtx, &workItem, lease.Backend, lease.OverwriteTarget, func(p ffmpeg.Progress) { progress.Store(&p) })
+	close(done)
+	wg.Wait()
+
+	if sessionCtx.Err() != nil {
+		// the session was stopped: remove the partial target file
+		if err2 := os.Remove(workItem.Target.Path); err2 != nil && !errors.Is(err2, os.ErrNotExist) {
+			logger.Warn("failed to remove partial target file", "err", err2)
+		}
+	}
+
+	if cause := context.Cause(sessionCtx); errors.Is(cause, errLeaseLost) {
+		// the coordinator no longer expects a result
+		logger.Warn("transcoding stopped: lease lost", "duration", time.Since(start))
+		return
+	}
+	if err != nil {
+		logger.Warn("finished transcoding with errors", "err", err, "duration", time.Since(start))
+	} else {
+		logger.Info("finished transcoding", "duration", time.Since(start))
+	}
+	// report the result, even if we're shutting down
+	if _, err = w.post(context.WithoutCancel(ctx), "/api/v1/leases/"+lease.ID+"/result", newResultRequest(err), nil); err != nil {
+		logger.Warn("failed to report result", "err", err)
+	}
+}
+
+// renew reports the session's progress to the coordinator, which renews the lease.
+func (w *Worker) renew(ctx context.Context, lease Lease, progress *ffmpeg.Progress) error {
+	var request progressRequest
+	if progress != nil {
+		request = progressRequest{
+			Converted: progress.Converted,
+			Speed:     progress.Speed,
+			FPS:       progress.FPS,
+			Bitrate:   progress.Bitrate,
+			TotalSize: progress.TotalSize,
+		}
+	}
+	_, err := w.post(ctx, "/api/v1/leases/"+lease.ID+"/progress", request, nil)
+	return err
+}
+
+// post sends the request to the coordinator and decodes the response, if any.
+func (w *Worker) post(ctx context.Context, path string, request any, response any) (int, error) {
+	body, err := json.Marshal(request)
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url+path, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		if response != nil {
+			err = json.NewDecoder(resp.Body).Decode(response)
+		}
+		return resp.StatusCode, err
+	case http.StatusNoContent:
+		return resp.StatusCode, nil
+	case http.StatusGone:
+		return resp.StatusCode, errLeaseLost
+	default:
+		return resp.StatusCode, fmt.Errorf("coordinator: %s", resp.Status)
+	}
+}
@@ -0,0 +1,168 @@
+package transcoder
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/clambin/xcoder/ffmpeg"
+)
+
+const (
+	// HardwareBackend uses the platform's hardware decoder & encoder.
+	HardwareBackend = "hardware"
+	// SoftwareBackend uses libx265 to encode the target.
+	SoftwareBackend = "software"
+)
+
+// A Backend builds the ffmpeg arguments to decode a source file and encode the target file.
+type Backend struct {
+	DecoderArguments func(source ffmpeg.VideoStats) []string
+	EncoderArguments func(target ffmpeg.VideoStats) ([]string, error)
+}
+
+var backends = map[string]Backend{
+	HardwareBackend: {
+		DecoderArguments: DecoderArguments,
+		EncoderArguments: encoderArguments,
+	},
+	SoftwareBackend: {
+		DecoderArguments: func(_ ffmpeg.VideoStats) []string { return []string{} },
+		EncoderArguments: softwareEncoderArguments,
+	},
+}
+
+// GetBackend returns the backend associated with name.
+func GetBackend(name string) (Backend, error) {
+	if backend, ok := backends[name]; ok {
+		return backend, nil
+	}
+	return Backend{}, fmt.Errorf("invalid backend: %q", name) //nolint:err113
+}
+
+// SupportedBackends returns a sorted list of supported backend names.
+func SupportedBackends() []string {
+	b := slices.Collect(maps.Keys(backends))
+	slices.Sort(b)
+	return b
+}
+
+// CheckBackend checks if the backend works on this system, by encoding a second of test video and decoding the result.
+func CheckBackend(ctx context.Context, name string, priority ffmpeg.Priority) error {
+	backend, err := GetBackend(name)
+	if err != nil {
+		return err
+	}
+	stats := ffmpeg.VideoStats{VideoCodec: "hevc", BitRate: 1_500_000, BitsPerSample: 8, Height: 720, Width: 1280}
+	args, err := backend.EncoderArguments(stats)
+	if err != nil {
+		return err
+	}
+	tmpDir, err := os.MkdirTemp("", "xcoder")
+	if err != nil {
+		return fmt.Errorf("create temp directory: %w", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	logger := slog.New(slog.DiscardHandler)
+	target := filepath.Join(tmpDir, "check.mkv")
+	if err = ffmpeg.Decode("testsrc2=size=1280x720:rate=25:duration=1", "-f", "lavfi").
+		Encode(args...).
+		Muxer("matroska").
+		NoStats().
+		LogLevel("error").
+		Priority(priority).
+		Output(target).
+		Run(ctx, logger); err != nil {
+		return fmt.Errorf("encode: %w", err)
+	}
+	if err = ffmpeg.Decode(target, backend.DecoderArguments(stats)...).
+		Muxer("null").
+		NoStats().
+		LogLevel("error").
+		Priority(priority).
+		Run(ctx, logger); err != nil {
+		return fmt.Errorf("decode: %w", err)
+	}
+	return nil
+}
+
+// filterArguments returns the filter arguments to deinterlace, convert the frame rate and crop the video, if the
+// target video requires it. hardwareDeinterlaceFilter is the backend's filter for DeinterlaceHardware.
+func filterArguments(videoStats ffmpeg.VideoStats, hardwareDeinterlaceFilter string) []string {
+	filters := make([]string, 0, 3)
+	switch Deinterlace(videoStats.Deinterlace) {
+	case DeinterlaceBwdif, DeinterlaceYadif:
+		filters = append(filters, videoStats.Deinterlace)
+	case DeinterlaceHardware:
+		filters = append(filters, hardwareDeinterlaceFilter)
+	case DeinterlaceInverseTelecine:
+		// fieldmatch restores the original frames, yadif deinterlaces the frames it couldn't match
+		// and decimate drops the duplicate frame in each group of five
+		filters = append(filters, "fieldmatch,yadif=deint=interlaced,decimate")
+	}
+	if videoStats.FPS > 0 {
+		filters = append(filters, "fps="+strconv.FormatFloat(videoStats.FPS, 'f', -1, 64))
+	}
+	// crop after deinterlacing, as deinterlacing needs both fields
+	if !videoStats.Crop.IsZero() {
+		filters = append(filters, "crop="+videoStats.Crop.String())
+	}
+	if len(filters) == 0 {
+		return []string{}
+	}
+	return []string{"-vf", strings.Join(filters, ",")}
+}
+
+// audioArguments returns the arguments to encode the audio streams. The audio streams are copied, unless the target
+// video normalizes their loudness. Silent audio streams have no loudness and are re-encoded as-is.
+func audioArguments(videoStats ffmpeg.VideoStats) []string {
+	if !videoStats.NormalizeLoudness || len(videoStats.Loudness) == 0 {
+		return []string{"-c:a", "copy"}
+	}
+	args := make([]string, 0, 4+2*len(videoStats.Loudness))
+	args = append(args, "-c:a", audioCodec, "-b:a", audioBitrate)
+	for i, loudness := range videoStats.Loudness {
+		if !loudness.IsZero() {
+			args = append(args, "-filter:a:"+strconv.Itoa(i), loudness.Filter())
+		}
+	}
+	return args
+}
+
+const (
+	// audioCodec is the codec of re-encoded audio streams.
+	audioCodec = "aac"
+	// audioBitrate is the bitrate of each re-encoded audio stream.
+	audioBitrate = "256k"
+)
+
+func softwareEncoderArguments(videoStats ffmpeg.VideoStats) ([]string, error) {
+	switch videoStats.VideoCodec {
+	case "hevc":
+		profileName, pixelFormat := "main", "yuv420p"
+		if videoStats.BitsPerSample == 10 {
+			profileName, pixelFormat = "main10", "yuv420p10le"
+		}
+		// libx265 has no hardware deinterlacer
+		return slices.Concat(
+			filterArguments(videoStats, string(DeinterlaceBwdif)),
+			[]string{
+				"-c:v", "libx265",
+				"-b:v", strconv.Itoa(videoStats.BitRate),
+				"-profile:v", profileName,
+				"-pix_fmt", pixelFormat,
+			},
+			audioArguments(videoStats),
+			[]string{"-c:s", "copy"},
+		), nil
+	default:
+		return nil, fmt.Errorf("unsupported codec: %s", videoStats.VideoCodec)
+	}
+}
@@ -0,0 +1,108 @@
+package transcoder
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/clambin/xcoder/ffmpeg"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetBackend(t *testing.T) {
+	for _, name := range []string{HardwareBackend, SoftwareBackend} {
+		backend, err := GetBackend(name)
+		require.NoError(t, err)
+		args, err := backend.EncoderArguments(ffmpeg.VideoStats{VideoCodec: "hevc", BitRate: 4_000_000})
+		require.NoError(t, err)
+		assert.Contains(t, args, "4000000")
+		_, err = backend.EncoderArguments(ffmpeg.VideoStats{VideoCodec: "h264"})
+		assert.Error(t, err)
+	}
+
+	_, err := GetBackend("invalid")
+	assert.Error(t, err)
+}
+
+func Test_softwareEncoderArguments(t *testing.T) {
+	args, err := softwareEncoderArguments(ffmpeg.VideoStats{VideoCodec: "hevc", BitRate: 4_000_000, BitsPerSample: 10})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"-c:v", "libx265", "-b:v", "4000000", "-profile:v", "main10", "-pix_fmt", "yuv420p10le", "-c:a", "copy", "-c:s", "copy"}, args)
+
+	args, err = softwareEncoderArguments(ffmpeg.VideoStats{VideoCodec: "hevc", BitRate: 4_000_000, Deinterlace: "hardware"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"-vf", "bwdif", "-c:v", "libx265", "-b:v", "4000000", "-profile:v", "main", "-pix_fmt", "yuv420p", "-c:a", "copy", "-c:s", "copy"}, args)
+}
+
+func Test_filterArguments(t *testing.T) {
+	crop := ffmpeg.Crop{Width: 1920, Height: 800, Y: 140}
+	tests := []struct {
+		name  string
+		stats ffmpeg.VideoStats
+		want  []string
+	}{
+		{name: "none", want: []string{}},
+		{name: "off", stats: ffmpeg.VideoStats{Deinterlace: "off"}, want: []string{}},
+		{name: "bwdif", stats: ffmpeg.VideoStats{Deinterlace: "bwdif"}, want: []string{"-vf", "bwdif"}},
+		{name: "yadif", stats: ffmpeg.VideoStats{Deinterlace: "yadif"}, want: []string{"-vf", "yadif"}},
+		{name: "hardware", stats: ffmpeg.VideoStats{Deinterlace: "hardware"}, want: []string{"-vf", "hw_deinterlace"}},
+		{name: "invalid", stats: ffmpeg.VideoStats{Deinterlace: "invalid"}, want: []string{}},
+		{name: "crop", stats: ffmpeg.VideoStats{Crop: crop}, want: []string{"-vf", "crop=1920:800:0:140"}},
+		{name: "deinterlace & crop", stats: ffmpeg.VideoStats{Deinterlace: "bwdif", Crop: crop}, want: []string{"-vf", "bwdif,crop=1920:800:0:140"}},
+		{name: "inverse telecine", stats: ffmpeg.VideoStats{Deinterlace: "ivtc"}, want: []string{"-vf", "fieldmatch,yadif=deint=interlaced,decimate"}},
+		{name: "fps", stats: ffmpeg.VideoStats{FPS: 23.976}, want: []string{"-vf", "fps=23.976"}},
+		{name: "all", stats: ffmpeg.VideoStats{Deinterlace: "yadif", FPS: 25, Crop: crop}, want: []string{"-vf", "yadif,fps=25,crop=1920:800:0:140"}},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, filterArguments(tt.stats, "hw_deinterlace"), tt.name)
+	}
+}
+
+func Test_audioArguments(t *testing.T) {
+	loudness := ffmpeg.Loudness{Integrated: -27.61, TruePeak: -4.47, Range: 18.06, Threshold: -39.2, Offset: -0.42}
+	tests := []struct {
+		name  string
+		stats ffmpeg.VideoStats
+		want  []string
+	}{
+		{name: "copy", stats: ffmpeg.VideoStats{}, want: []string{"-c:a", "copy"}},
+		{name: "not measured", stats: ffmpeg.VideoStats{NormalizeLoudness: true}, want: []string{"-c:a", "copy"}},
+		{name: "measured, not normalized", stats: ffmpeg.VideoStats{Loudness: []ffmpeg.Loudness{loudness}}, want: []string{"-c:a", "copy"}},
+		{
+			name:  "normalized",
+			stats: ffmpeg.VideoStats{NormalizeLoudness: true, Loudness: []ffmpeg.Loudness{{}, loudness}},
+			want:  []string{"-c:a", "aac", "-b:a", "256k", "-filter:a:1", loudness.Filter()},
+		},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, audioArguments(tt.stats), tt.name)
+	}
+}
+
+func TestCheckBackend(t *testing.T) {
+	// a fake ffmpeg that writes its output file, but doesn't support libx265
+	fake := filepath.Join(t.TempDir(), "ffmpeg")
+	require.NoError(t, os.WriteFile(fake, []byte(`#!/bin/sh
+case "$*" in
+*libx265*) echo "Unknown encoder 'libx265'" >&2; exit 8;;
+esac
+for output; do :; done
+[ "$output" = "-" ] || touch "$output"
+`), 0o755))
+	path := ffmpeg.Path
+	ffmpeg.Path = fake
+	t.Cleanup(func() { ffmpeg.Path = path })
+
+	assert.NoError(t, CheckBackend(t.Context(), HardwareBackend, ffmpeg.Priority{}))
+	assert.EqualError(t, CheckBackend(t.Context(), SoftwareBackend, ffmpeg.Priority{}), "encode: ffmpeg: exit status 8 (Unknown encoder 'libx265')")
+	assert.Error(t, CheckBackend(t.Context(), "invalid", ffmpeg.Priority{}))
+}
+
+func TestProfile_Backends(t *testing.T) {
+	profile, err := GetProfile("hevc-high")
+	require.NoError(t, err)
+	assert.Equal(t, []string{HardwareBackend, SoftwareBackend}, profile.Backends())
+	assert.Equal(t, []string{HardwareBackend}, Profile{}.Backends())
+	assert.Equal(t, []string{HardwareBackend, SoftwareBackend}, SupportedBackends())
+}
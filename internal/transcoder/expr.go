@@ -0,0 +1,412 @@
+package transcoder
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/clambin/xcoder/ffmpeg"
+)
+
+// ParseRules parses a list of rule expressions. See ParseRule for the syntax.
+func ParseRules(rules []string) ([]Rule, error) {
+	parsed := make([]Rule, 0, len(rules))
+	for _, rule := range rules {
+		r, err := ParseRule(rule)
+		if err != nil {
+			return nil, fmt.Errorf("rule %q: %w", rule, err)
+		}
+		parsed = append(parsed, r)
+	}
+	return parsed, nil
+}
+
+// ParseRule parses a rule expression of the form "<action> if <condition> [because <reason>]". The action is either
+// "skip" or "reject". If the condition is true for the source video, the rule returns a SourceSkippedError or a
+// SourceRejectedError, with the (quoted) reason. Without a reason, the condition itself is the reason.
+//
+// The condition compares the source video's fields with values, using ==, !=, <, <=, >, >=, combined with &&, ||, !
+// and parentheses. The fields are:
+//
+//   - codec, target_codec: the video codec of the source video and the profile's target codec, e.g. "h264"
+//   - width, height: the resolution of the source video, in pixels
+//   - bitrate: the bitrate of the source video, e.g. 2mbps or 800kbps
+//   - bits_per_sample: the bit depth of the source video
+//   - duration: the duration of the source video, e.g. 20m or 1h30m
+//   - field_order: the field order of the source video, if it was detected: "progressive", "tff", "bff" or "telecine"
+//   - interlaced: true if the source video was detected as interlaced or telecined
+//   - frame_rate: the average frame rate of the source video, e.g. 23.976
+//   - vfr: true if the source video has a variable frame rate
+//
+// For example: `reject if codec == "mpeg4" && height < 576` or `skip if bitrate < 2mbps because "low bitrate"`.
+//
+// If the rule is invalid, ParseRule returns a RuleError with the position of the error.
+func ParseRule(rule string) (Rule, error) {
+	p, err := newRuleParser(rule)
+	if err != nil {
+		return nil, err
+	}
+	return p.parseRule()
+}
+
+// RuleError is returned when a rule expression can't be parsed. Pos is the position of the error in the rule,
+// starting at 1.
+type RuleError struct {
+	Msg string
+	Pos int
+}
+
+func (e *RuleError) Error() string {
+	return "position " + strconv.Itoa(e.Pos) + ": " + e.Msg
+}
+
+////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+type valueType string
+
+const (
+	typeNumber valueType = "number"
+	typeString valueType = "string"
+	typeBool   valueType = "boolean"
+)
+
+// ruleInput is what a rule's condition is evaluated against.
+type ruleInput struct {
+	profile Profile
+	stats   ffmpeg.VideoStats
+}
+
+// a node is a compiled (sub)expression. eval returns a float64, string or bool, depending on the node's type.
+type node struct {
+	eval func(ruleInput) any
+	typ  valueType
+}
+
+var ruleFields = map[string]node{
+	"codec":           {typ: typeString, eval: func(in ruleInput) any { return in.stats.VideoCodec }},
+	"target_codec":    {typ: typeString, eval: func(in ruleInput) any { return in.profile.TargetCodec }},
+	"width":           {typ: typeNumber, eval: func(in ruleInput) any { return float64(in.stats.Width) }},
+	"height":          {typ: typeNumber, eval: func(in ruleInput) any { return float64(in.stats.Height) }},
+	"bitrate":         {typ: typeNumber, eval: func(in ruleInput) any { return float64(in.stats.BitRate) }},
+	"bits_per_sample": {typ: typeNumber, eval: func(in ruleInput) any { return float64(in.stats.BitsPerSample) }},
+	"duration":        {typ: typeNumber, eval: func(in ruleInput) any { return in.stats.Duration.Seconds() }},
+	"field_order":     {typ: typeString, eval: func(in ruleInput) any { return in.stats.FieldOrder }},
+	"interlaced":      {typ: typeBool, eval: func(in ruleInput) any { return in.stats.Interlaced() }},
+	"frame_rate":      {typ: typeNumber, eval: func(in ruleInput) any { return in.stats.FrameRate }},
+	"vfr":             {typ: typeBool, eval: func(in ruleInput) any { return in.stats.VariableFrameRate() }},
+}
+
+////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenNumber
+	tokenString
+	tokenOperator
+)
+
+type token struct {
+	text string
+	kind tokenKind
+	pos  int
+}
+
+func (t token) String() string {
+	if t.kind == tokenEOF {
+		return "end of rule"
+	}
+	return strconv.Quote(t.text)
+}
+
+// tokenize splits the rule into tokens. Positions are byte offsets in the rule, starting at 0.
+func tokenize(rule string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(rule); {
+		c := rule[i]
+		switch {
+		case c == ' ' || c == '\t':
+			i++
+		case c == '"':
+			end := i + 1
+			for end < len(rule) && rule[end] != '"' {
+				if rule[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(rule) {
+				return nil, &RuleError{Msg: "unterminated string", Pos: i + 1}
+			}
+			tokens = append(tokens, token{kind: tokenString, text: rule[i : end+1], pos: i})
+			i = end + 1
+		case isDigit(c):
+			end := i
+			for end < len(rule) && (isDigit(rule[end]) || isLetter(rule[end]) || rule[end] == '.') {
+				end++
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: rule[i:end], pos: i})
+			i = end
+		case isLetter(c):
+			end := i
+			for end < len(rule) && (isLetter(rule[end]) || isDigit(rule[end])) {
+				end++
+			}
+			tokens = append(tokens, token{kind: tokenIdent, text: rule[i:end], pos: i})
+			i = end
+		default:
+			op := operator(rule[i:])
+			if op == "" {
+				return nil, &RuleError{Msg: "unexpected character " + strconv.QuoteRune(rune(c)), Pos: i + 1}
+			}
+			tokens = append(tokens, token{kind: tokenOperator, text: op, pos: i})
+			i += len(op)
+		}
+	}
+	return append(tokens, token{kind: tokenEOF, pos: len(rule)}), nil
+}
+
+func operator(s string) string {
+	for _, op := range []string{"&&", "||", "==", "!=", "<=", ">=", "<", ">", "!", "(", ")"} {
+		if strings.HasPrefix(s, op) {
+			return op
+		}
+	}
+	return ""
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isLetter(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// ruleParser is a recursive descent parser for rule expressions.
+type ruleParser struct {
+	rule   string
+	tokens []token
+	next   int
+}
+
+func newRuleParser(rule string) (*ruleParser, error) {
+	tokens, err := tokenize(rule)
+	if err != nil {
+		return nil, err
+	}
+	return &ruleParser{rule: rule, tokens: tokens}, nil
+}
+
+func (p *ruleParser) peek() token {
+	return p.tokens[p.next]
+}
+
+func (p *ruleParser) advance() token {
+	t := p.tokens[p.next]
+	if t.kind != tokenEOF {
+		p.next++
+	}
+	return t
+}
+
+func (p *ruleParser) accept(kind tokenKind, text string) bool {
+	if t := p.peek(); t.kind == kind && t.text == text {
+		p.next++
+		return true
+	}
+	return false
+}
+
+func errorAt(t token, format string, args ...any) error {
+	return &RuleError{Msg: fmt.Sprintf(format, args...), Pos: t.pos + 1}
+}
+
+func (p *ruleParser) parseRule() (Rule, error) {
+	action := p.advance()
+	if action.kind != tokenIdent || (action.text != "skip" && action.text != "reject") {
+		return nil, errorAt(action, "expected skip or reject, got %s", action)
+	}
+	if t := p.peek(); !p.accept(tokenIdent, "if") {
+		return nil, errorAt(t, "expected if, got %s", t)
+	}
+	start := p.peek()
+	condition, err := p.parseCondition()
+	if err != nil {
+		return nil, err
+	}
+	if condition.typ != typeBool {
+		return nil, errorAt(start, "condition must be a boolean, got a %s", condition.typ)
+	}
+	end := p.peek()
+	reason := strings.TrimSpace(p.rule[start.pos:end.pos])
+	if p.accept(tokenIdent, "because") {
+		t := p.advance()
+		if t.kind != tokenString {
+			return nil, errorAt(t, "expected a quoted reason, got %s", t)
+		}
+		if reason, err = strconv.Unquote(t.text); err != nil {
+			return nil, errorAt(t, "invalid reason: %s", t)
+		}
+	}
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, errorAt(t, "unexpected %s", t)
+	}
+
+	skip := action.text == "skip"
+	return func(profile Profile, sourceStats ffmpeg.VideoStats) error {
+		if !condition.eval(ruleInput{profile: profile, stats: sourceStats}).(bool) {
+			return nil
+		}
+		if skip {
+			return &SourceSkippedError{Reason: reason}
+		}
+		return &SourceRejectedError{Reason: reason}
+	}, nil
+}
+
+// parseCondition parses a sequence of && conditions, separated by ||.
+func (p *ruleParser) parseCondition() (node, error) {
+	return p.parseLogical("||", p.parseAnd, func(a, b bool) bool { return a || b })
+}
+
+func (p *ruleParser) parseAnd() (node, error) {
+	return p.parseLogical("&&", p.parseComparison, func(a, b bool) bool { return a && b })
+}
+
+func (p *ruleParser) parseLogical(op string, operand func() (node, error), f func(a, b bool) bool) (node, error) {
+	start := p.peek()
+	left, err := operand()
+	if err != nil {
+		return node{}, err
+	}
+	for {
+		t := p.peek()
+		if !p.accept(tokenOperator, op) {
+			return left, nil
+		}
+		if left.typ != typeBool {
+			return node{}, errorAt(start, "%s requires a boolean, got a %s", t.text, left.typ)
+		}
+		next := p.peek()
+		right, err := operand()
+		if err != nil {
+			return node{}, err
+		}
+		if right.typ != typeBool {
+			return node{}, errorAt(next, "%s requires a boolean, got a %s", t.text, right.typ)
+		}
+		l, r := left, right
+		left = node{typ: typeBool, eval: func(in ruleInput) any { return f(l.eval(in).(bool), r.eval(in).(bool)) }}
+	}
+}
+
+// parseComparison parses an optional comparison between two operands.
+func (p *ruleParser) parseComparison() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return node{}, err
+	}
+	op := p.peek()
+	compare, ok := comparisons[op.text]
+	if op.kind != tokenOperator || !ok {
+		return left, nil
+	}
+	p.advance()
+	right, err := p.parseUnary()
+	if err != nil {
+		return node{}, err
+	}
+	if left.typ != right.typ {
+		return node{}, errorAt(op, "can't compare a %s with a %s", left.typ, right.typ)
+	}
+	if left.typ != typeNumber && op.text != "==" && op.text != "!=" {
+		return node{}, errorAt(op, "%s requires numbers, got a %s", op.text, left.typ)
+	}
+	return node{typ: typeBool, eval: func(in ruleInput) any { return compare(left.eval(in), right.eval(in)) }}, nil
+}
+
+var comparisons = map[string]func(a, b any) bool{
+	"==": func(a, b any) bool { return a == b },
+	"!=": func(a, b any) bool { return a != b },
+	"<":  func(a, b any) bool { return a.(float64) < b.(float64) },
+	"<=": func(a, b any) bool { return a.(float64) <= b.(float64) },
+	">":  func(a, b any) bool { return a.(float64) > b.(float64) },
+	">=": func(a, b any) bool { return a.(float64) >= b.(float64) },
+}
+
+func (p *ruleParser) parseUnary() (node, error) {
+	t := p.peek()
+	if !p.accept(tokenOperator, "!") {
+		return p.parseOperand()
+	}
+	n, err := p.parseUnary()
+	if err != nil {
+		return node{}, err
+	}
+	if n.typ != typeBool {
+		return node{}, errorAt(t, "! requires a boolean, got a %s", n.typ)
+	}
+	return node{typ: typeBool, eval: func(in ruleInput) any { return !n.eval(in).(bool) }}, nil
+}
+
+func (p *ruleParser) parseOperand() (node, error) {
+	t := p.advance()
+	switch t.kind {
+	case tokenIdent:
+		switch t.text {
+		case "true", "false":
+			return constant(typeBool, t.text == "true"), nil
+		}
+		if field, ok := ruleFields[t.text]; ok {
+			return field, nil
+		}
+		return node{}, errorAt(t, "unknown field %s", t)
+	case tokenNumber:
+		value, err := parseNumber(t.text)
+		if err != nil {
+			return node{}, errorAt(t, "invalid number %s", t)
+		}
+		return constant(typeNumber, value), nil
+	case tokenString:
+		value, err := strconv.Unquote(t.text)
+		if err != nil {
+			return node{}, errorAt(t, "invalid string %s", t)
+		}
+		return constant(typeString, value), nil
+	case tokenOperator:
+		if t.text == "(" {
+			n, err := p.parseCondition()
+			if err != nil {
+				return node{}, err
+			}
+			if closing := p.advance(); closing.kind != tokenOperator || closing.text != ")" {
+				return node{}, errorAt(closing, "expected ), got %s", closing)
+			}
+			return n, nil
+		}
+	}
+	return node{}, errorAt(t, "unexpected %s", t)
+}
+
+func constant(typ valueType, value any) node {
+	return node{typ: typ, eval: func(ruleInput) any { return value }}
+}
+
+// parseNumber parses a number, a bitrate (e.g. "2mbps") or a duration (e.g. "1h30m"). Durations are returned in seconds.
+func parseNumber(s string) (float64, error) {
+	if value, err := strconv.ParseFloat(s, 64); err == nil {
+		return value, nil
+	}
+	if strings.HasSuffix(strings.ToLower(s), "bps") {
+		bits, err := ffmpeg.ParseBits(s)
+		return float64(bits), err
+	}
+	d, err := time.ParseDuration(s)
+	return d.Seconds(), err
+}
@@ -0,0 +1,91 @@
+package transcoder
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/clambin/xcoder/ffmpeg"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRule(t *testing.T) {
+	profile := Profile{TargetCodec: "hevc"}
+	mpeg4 := ffmpeg.VideoStats{VideoCodec: "mpeg4", Width: 720, Height: 480, BitRate: 1_500_000, Duration: 10 * time.Minute}
+	h264 := ffmpeg.VideoStats{VideoCodec: "h264", Width: 1920, Height: 1080, BitRate: 8_000_000, BitsPerSample: 10, Duration: 2 * time.Hour}
+
+	tests := []struct {
+		name  string
+		rule  string
+		stats ffmpeg.VideoStats
+		want  error
+	}{
+		{name: "reject", rule: `reject if codec == "mpeg4" && height < 576`, stats: mpeg4, want: &SourceRejectedError{Reason: `codec == "mpeg4" && height < 576`}},
+		{name: "reject: no match", rule: `reject if codec == "mpeg4" && height < 576`, stats: h264},
+		{name: "skip with reason", rule: `skip if bitrate < 2mbps because "low bitrate"`, stats: mpeg4, want: &SourceSkippedError{Reason: "low bitrate"}},
+		{name: "skip: no match", rule: `skip if bitrate < 2mbps because "low bitrate"`, stats: h264},
+		{name: "or", rule: `skip if codec == target_codec || width >= 1920`, stats: h264, want: &SourceSkippedError{Reason: "codec == target_codec || width >= 1920"}},
+		{name: "not", rule: `skip if !(bits_per_sample > 8)`, stats: mpeg4, want: &SourceSkippedError{Reason: "!(bits_per_sample > 8)"}},
+		{name: "duration", rule: `reject if duration <= 15m`, stats: mpeg4, want: &SourceRejectedError{Reason: "duration <= 15m"}},
+		{name: "compound duration", rule: `reject if duration > 1h30m`, stats: h264, want: &SourceRejectedError{Reason: "duration > 1h30m"}},
+		{name: "precedence", rule: `reject if codec != "h264" || height > 720 && bitrate < 1000kbps`, stats: h264},
+		{name: "boolean", rule: `skip if true`, stats: h264, want: &SourceSkippedError{Reason: "true"}},
+		{name: "interlaced", rule: `reject if interlaced because "interlaced"`, stats: ffmpeg.VideoStats{FieldOrder: ffmpeg.FieldOrderTFF}, want: &SourceRejectedError{Reason: "interlaced"}},
+		{name: "frame rate", rule: `reject if frame_rate > 30`, stats: ffmpeg.VideoStats{FrameRate: 60}, want: &SourceRejectedError{Reason: "frame_rate > 30"}},
+		{name: "vfr", rule: `skip if vfr`, stats: ffmpeg.VideoStats{FrameRate: 27.3, RealFrameRate: 30}, want: &SourceSkippedError{Reason: "vfr"}},
+		{name: "field order", rule: `reject if field_order == "bff"`, stats: ffmpeg.VideoStats{FieldOrder: ffmpeg.FieldOrderTFF}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rule, err := ParseRule(tt.rule)
+			require.NoError(t, err)
+			err = rule(profile, tt.stats)
+			if tt.want == nil {
+				assert.NoError(t, err)
+				return
+			}
+			assert.ErrorIs(t, err, tt.want)
+		})
+	}
+}
+
+func TestParseRule_Errors(t *testing.T) {
+	tests := []struct {
+		rule string
+		want string
+	}{
+		{rule: ``, want: `position 1: expected skip or reject, got end of rule`},
+		{rule: `drop if height < 576`, want: `position 1: expected skip or reject, got "drop"`},
+		{rule: `skip when height < 576`, want: `position 6: expected if, got "when"`},
+		{rule: `skip if size < 576`, want: `position 9: unknown field "size"`},
+		{rule: `skip if height < "576"`, want: `position 16: can't compare a number with a string`},
+		{rule: `skip if codec < "h264"`, want: `position 15: < requires numbers, got a string`},
+		{rule: `skip if height`, want: `position 9: condition must be a boolean, got a number`},
+		{rule: `skip if height < 576 && codec`, want: `position 25: && requires a boolean, got a string`},
+		{rule: `skip if (height < 576`, want: `position 22: expected ), got end of rule`},
+		{rule: `skip if height < 5xyz`, want: `position 18: invalid number "5xyz"`},
+		{rule: `skip if height < 576 because low`, want: `position 30: expected a quoted reason, got "low"`},
+		{rule: `skip if height < 576 "low"`, want: `position 22: unexpected "\"low\""`},
+		{rule: `skip if codec == "h264`, want: `position 18: unterminated string`},
+		{rule: `skip if height = 576`, want: `position 16: unexpected character '='`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.rule, func(t *testing.T) {
+			_, err := ParseRule(tt.rule)
+			require.Error(t, err)
+			assert.Equal(t, tt.want, err.Error())
+			_, ok := errors.AsType[*RuleError](err)
+			assert.True(t, ok)
+		})
+	}
+}
+
+func TestParseRules(t *testing.T) {
+	rules, err := ParseRules([]string{`skip if height < 576`, `reject if bitrate < 1mbps`})
+	require.NoError(t, err)
+	assert.Len(t, rules, 2)
+
+	_, err = ParseRules([]string{`skip if height < 576`, `reject if`})
+	assert.EqualError(t, err, `rule "reject if": position 10: unexpected end of rule`)
+}
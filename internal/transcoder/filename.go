@@ -5,6 +5,8 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+
+	"github.com/clambin/xcoder/ffmpeg"
 )
 
 func buildTargetFilename(source File, codec, extension string) string {
@@ -67,3 +69,32 @@ func parseMovie(filename string) (string, bool) {
 func parseGeneric(filename string) string {
 	return strings.TrimSuffix(filename, filepath.Ext(filename))
 }
+
+// TargetFilename returns the name of the file that the Transcoder writes when converting the source file to the codec.
+// stats are the video stats of the target file: the filename includes its height, which is the source's height
+// unless the video is cropped.
+func TargetFilename(source string, stats ffmpeg.VideoStats, codec string) string {
+	return buildTargetFilename(File{Path: source, VideoStats: stats}, codec, "mkv")
+}
+
+// IsTargetFilename returns true if target is the name of a file that the Transcoder may write when converting
+// the source file to the codec. The name includes the height of the target, which depends on the profile
+// (e.g. when cropping), so any height matches.
+func IsTargetFilename(source, target, codec string) bool {
+	if filepath.Dir(source) != filepath.Dir(target) || source == target {
+		return false
+	}
+	height, ok := strings.CutPrefix(filepath.Base(target), getBasename(source)+".")
+	if !ok {
+		return false
+	}
+	if height, ok = strings.CutSuffix(height, codec+".mkv"); !ok {
+		return false
+	}
+	if height == "" {
+		return true
+	}
+	height, ok = strings.CutSuffix(height, ".")
+	_, err := strconv.Atoi(height)
+	return ok && err == nil
+}
@@ -29,3 +29,28 @@ func Test_buildTargetFilename(t *testing.T) {
 		})
 	}
 }
+
+func TestIsTargetFilename(t *testing.T) {
+	tests := []struct {
+		name   string
+		source string
+		target string
+		want   bool
+	}{
+		{"height", "dir/Movie (2020).mkv", "dir/Movie (2020).1080.hevc.mkv", true},
+		{"cropped height", "dir/Movie (2020).mkv", "dir/Movie (2020).800.hevc.mkv", true},
+		{"no height", "dir/Movie (2020).mkv", "dir/Movie (2020).hevc.mkv", true},
+		{"episode", "dir/ep.S01E01.1080p.x264.mkv", "dir/ep.s01e01.720.hevc.mkv", true},
+		{"other codec", "dir/Movie (2020).mkv", "dir/Movie (2020).1080.h264.mkv", false},
+		{"other movie", "dir/Movie (2020).mkv", "dir/Other (2020).1080.hevc.mkv", false},
+		{"not a height", "dir/Movie (2020).mkv", "dir/Movie (2020).foo.hevc.mkv", false},
+		{"other directory", "dir/Movie (2020).mkv", "other/Movie (2020).1080.hevc.mkv", false},
+		{"same file", "dir/Movie (2020).1080.hevc.mkv", "dir/Movie (2020).1080.hevc.mkv", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, IsTargetFilename(tt.source, tt.target, "hevc"))
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package transcoder
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/clambin/xcoder/ffmpeg"
+)
+
+// leaseTTL is the time a remote worker has to renew its lease, before the transcoder considers it lost.
+const leaseTTL = 30 * time.Second
+
+var (
+	// ErrLeaseNotFound is returned when a worker renews or completes a lease that expired or whose session was aborted.
+	ErrLeaseNotFound = errors.New("lease not found")
+	// ErrLeaseExpired is the error of a WorkItem whose remote worker stopped renewing its lease.
+	ErrLeaseExpired = errors.New("worker lease expired")
+)
+
+// A lease gives a remote worker the right to transcode a WorkItem. The worker must renew the lease before it expires.
+type lease struct {
+	expires time.Time
+	result  chan error
+	id      string
+	mu      sync.Mutex
+}
+
+func newLease(ttl time.Duration) *lease {
+	return &lease{id: rand.Text(), expires: time.Now().Add(ttl), result: make(chan error, 1)}
+}
+
+func (l *lease) renew(ttl time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.expires = time.Now().Add(ttl)
+}
+
+func (l *lease) expired() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return time.Now().After(l.expires)
+}
+
+// complete records the result of the session. Only the first result is kept.
+func (l *lease) complete(err error) {
+	select {
+	case l.result <- err:
+	default:
+	}
+}
+
+// lease assigns the next queued WorkItem to a remote worker. If the transcoder is active, it also leases scanned
+// WorkItems, like queueNextItem does for local sessions. It returns false if there is no work available.
+// The caller must send a leaseEvent to the event loop to start the session.
+func (e *engine) lease(worker string) (*Session, bool) {
+	if e.stopping.Load() || e.paused() {
+		return nil, false
+	}
+	workItem, ok := e.workItems.GetFirst(StatusQueued)
+	if !ok && e.Active() {
+		workItem, ok = e.workItems.GetFirst(StatusScanned)
+	}
+	if !ok {
+		return nil, false
+	}
+	// another worker may have leased the same workItem in the meantime
+	if err := e.changeStatus(workItem, StatusTranscoding, StatusQueued, StatusScanned); err != nil {
+		return nil, false
+	}
+	session := e.addRemoteSession(workItem, worker, e.leaseTTL)
+	session.Backend = e.retryPolicy(workItem).Backend(workItem.AttemptCount())
+	session.overwriteTarget = e.overwriteTarget
+	return session, true
+}
+
+// RenewLease records the progress of a remote session and extends its lease. It returns ErrLeaseNotFound
+// if the lease expired or the session was aborted, in which case the worker should stop transcoding.
+func (e *engine) RenewLease(id string, progress ffmpeg.Progress) error {
+	session, ok := e.leasedSession(id)
+	if !ok || session.ctx.Err() != nil {
+		return ErrLeaseNotFound
+	}
+	session.progress.Store(&progress)
+	session.lease.renew(e.leaseTTL)
+	return nil
+}
+
+// CompleteLease reports the result of a remote session.
+func (e *engine) CompleteLease(id string, err error) error {
+	session, ok := e.leasedSession(id)
+	if !ok {
+		return ErrLeaseNotFound
+	}
+	session.lease.complete(err)
+	return nil
+}
+
+// waitForWorker waits for the remote worker to complete the session.
+// It returns ErrLeaseExpired if the worker stops renewing its lease.
+func (e *engine) waitForWorker(session *Session) error {
+	ticker := time.NewTicker(scheduleInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case err := <-session.lease.result:
+			return err
+		case <-session.ctx.Done():
+			return session.ctx.Err()
+		case <-ticker.C:
+			if session.lease.expired() {
+				return ErrLeaseExpired
+			}
+		}
+	}
+}
+
+// Transcode transcodes a WorkItem leased from a coordinator, outside the transcoder's own scheduling.
+// The session's progress is reported to the progress function.
+func (e *engine) Transcode(ctx context.Context, workItem *WorkItem, backend string, overwriteTarget bool, progress func(ffmpeg.Progress)) error {
+	session := &Session{WorkItem: workItem, Backend: backend, overwriteTarget: overwriteTarget, onProgress: progress}
+	session.ctx, session.cancel = context.WithCancelCause(ctx)
+	defer session.cancel(nil)
+
+	// transcodeFunc allows us to stub transcoding during testing.
+	f := e.transcodeFunc
+	if f == nil {
+		f = e.transcode
+	}
+	return f(session)
+}
@@ -0,0 +1,85 @@
+package transcoder
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/clambin/xcoder/ffmpeg"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTranscoder_Lease(t *testing.T) {
+	var q WorkItems
+	workItem := WorkItem{Source: File{Path: "source.mkv"}, Target: File{Path: "source.hevc.mkv"}}
+	workItem.SetStatus(StatusQueued, nil)
+	q.Add(&workItem)
+
+	var cfg Configuration
+	cfg.Profile, _ = GetProfile("hevc-high")
+	cfg.RemoteOnly = true
+	transcoder := New(&q, cfg, slog.New(slog.DiscardHandler))
+	transcoder.controller.(*engine).leaseTTL = 5 * scheduleInterval
+	go func() { _ = transcoder.Run(t.Context()) }()
+
+	// lease the work item
+	session, ok := transcoder.Lease("worker-1")
+	require.True(t, ok)
+	assert.NotEmpty(t, session.LeaseID())
+	assert.Equal(t, "worker-1", session.Worker)
+	status, _ := workItem.Status()
+	assert.Equal(t, StatusTranscoding, status)
+
+	// no more work
+	_, ok = transcoder.Lease("worker-2")
+	assert.False(t, ok)
+
+	// renewing the lease keeps the session alive
+	for range 10 {
+		require.NoError(t, transcoder.RenewLease(session.LeaseID(), ffmpeg.Progress{Converted: time.Minute}))
+		time.Sleep(scheduleInterval)
+	}
+	assert.Equal(t, time.Minute, session.Progress().Converted)
+	status, _ = workItem.Status()
+	assert.Equal(t, StatusTranscoding, status)
+
+	// once the worker stops renewing the lease, the session fails
+	require.Eventually(t, func() bool {
+		status, _ = workItem.Status()
+		return status == StatusRetrying
+	}, time.Second, 10*time.Millisecond)
+	_, err := workItem.Status()
+	assert.ErrorIs(t, err, ErrLeaseExpired)
+	assert.Eventually(t, func() bool { return transcoder.SessionCount() == 0 }, time.Second, 10*time.Millisecond)
+
+	// the worker's lease is no longer valid
+	assert.ErrorIs(t, transcoder.RenewLease(session.LeaseID(), ffmpeg.Progress{}), ErrLeaseNotFound)
+	assert.ErrorIs(t, transcoder.CompleteLease(session.LeaseID(), nil), ErrLeaseNotFound)
+}
+
+func TestTranscoder_Lease_Abort(t *testing.T) {
+	var q WorkItems
+	// the worker shares the target's filesystem and is writing the target
+	target := filepath.Join(t.TempDir(), "source.hevc.mkv")
+	require.NoError(t, os.WriteFile(target, []byte("partial"), 0o644))
+	workItem := WorkItem{Source: File{Path: "source.mkv"}, Target: File{Path: target}}
+	workItem.SetStatus(StatusQueued, nil)
+	q.Add(&workItem)
+
+	var cfg Configuration
+	cfg.Profile, _ = GetProfile("hevc-high")
+	cfg.RemoteOnly = true
+	transcoder := New(&q, cfg, slog.New(slog.DiscardHandler))
+	go func() { _ = transcoder.Run(t.Context()) }()
+
+	session, ok := transcoder.Lease("worker-1")
+	require.True(t, ok)
+	require.NoError(t, transcoder.Shutdown(t.Context(), ShutdownAbort))
+
+	// the lease is revoked, so the worker stops and removes the target itself
+	assert.ErrorIs(t, transcoder.RenewLease(session.LeaseID(), ffmpeg.Progress{}), ErrLeaseNotFound)
+	assert.FileExists(t, target)
+}
@@ -0,0 +1,185 @@
+package transcoder
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// loadCheckInterval is the time between two checks of the system load. The load average lags behind,
+	// so checking more often would start several sessions before the first one shows up in the load.
+	loadCheckInterval = 10 * time.Second
+	// loadProbeTimeout is the maximum time the load probe may run.
+	loadProbeTimeout = 5 * time.Second
+)
+
+// LoadConfiguration holds the system load thresholds over which the Transcoder holds back new sessions,
+// so other workloads on the same system (e.g. a media server streaming to clients) aren't starved.
+type LoadConfiguration struct {
+	// MaxLoad is the maximum 1-minute load average, per CPU, at which a new session may start. Zero disables the check.
+	MaxLoad float64
+	// MinIdle is the minimum percentage of idle CPU time at which a new session may start. Zero disables the check.
+	// This is only supported on Linux.
+	MinIdle float64
+	// Probe is a command that checks if the system can take another session. If the command fails,
+	// the Transcoder holds back new sessions. The command is not run in a shell.
+	Probe []string
+}
+
+func (c LoadConfiguration) enabled() bool {
+	return c.MaxLoad > 0 || c.MinIdle > 0 || len(c.Probe) > 0
+}
+
+// loadMonitor checks if the system load allows a new session to start. Checking the load may take a while
+// (e.g. running the load probe), so run samples the load in the background and allow only reads the last result.
+type loadMonitor struct {
+	logger      *slog.Logger
+	loadAverage func() (float64, error)  // stubbed during testing
+	cpuTimes    func() (cpuTimes, error) // stubbed during testing
+	cpu         cpuTimes
+	reason      string
+	cfg         LoadConfiguration
+	interval    time.Duration
+	mu          sync.Mutex
+	sampled     bool
+}
+
+func newLoadMonitor(cfg LoadConfiguration, logger *slog.Logger) *loadMonitor {
+	return &loadMonitor{cfg: cfg, logger: logger, loadAverage: loadAverage, cpuTimes: readCPUTimes, interval: loadCheckInterval}
+}
+
+// run samples the system load every interval, until the context is canceled.
+func (m *loadMonitor) run(ctx context.Context) {
+	if !m.cfg.enabled() {
+		return
+	}
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for {
+		m.sample()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sample checks the system load and records the result for allow.
+func (m *loadMonitor) sample() {
+	reason := m.check()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if reason != m.reason {
+		if reason != "" {
+			m.logger.Info("holding back new sessions", "reason", reason)
+		} else {
+			m.logger.Info("system load back to normal. resuming new sessions")
+		}
+	}
+	m.reason = reason
+	m.sampled = true
+}
+
+// allow returns true if the last sample of the system load allows a new session to start.
+// Until the load has been sampled, it holds back new sessions.
+func (m *loadMonitor) allow() bool {
+	if !m.cfg.enabled() {
+		return true
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.sampled && m.reason == ""
+}
+
+// check returns why the system can't take another session, or a blank string if it can.
+// If the load can't be determined, we don't hold back.
+func (m *loadMonitor) check() string {
+	if m.cfg.MaxLoad > 0 {
+		if load, err := m.loadAverage(); err != nil {
+			m.logger.Warn("failed to determine load average", "err", err)
+		} else if load /= float64(runtime.NumCPU()); load > m.cfg.MaxLoad {
+			return fmt.Sprintf("load %.2f per cpu exceeds %.2f", load, m.cfg.MaxLoad)
+		}
+	}
+	if m.cfg.MinIdle > 0 {
+		if cpu, err := m.cpuTimes(); err != nil {
+			m.logger.Warn("failed to determine cpu idle time", "err", err)
+		} else {
+			// idle time since the previous check (or since boot, the first time)
+			idle := cpu.idlePercentage(m.cpu)
+			m.cpu = cpu
+			if idle < m.cfg.MinIdle {
+				return fmt.Sprintf("cpu idle %.1f%% below %.1f%%", idle, m.cfg.MinIdle)
+			}
+		}
+	}
+	if len(m.cfg.Probe) > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), loadProbeTimeout)
+		defer cancel()
+		if err := exec.CommandContext(ctx, m.cfg.Probe[0], m.cfg.Probe[1:]...).Run(); err != nil {
+			return fmt.Sprintf("load probe failed: %v", err)
+		}
+	}
+	return ""
+}
+
+// cpuTimes holds the cumulative idle and total CPU time of the system.
+type cpuTimes struct {
+	idle  uint64
+	total uint64
+}
+
+// idlePercentage returns the percentage of CPU time that the system was idle since the previous sample.
+func (c cpuTimes) idlePercentage(previous cpuTimes) float64 {
+	total := c.total - previous.total
+	if total == 0 {
+		return 100
+	}
+	return 100 * float64(c.idle-previous.idle) / float64(total)
+}
+
+// parseCPUTimes parses the aggregate cpu line of Linux's /proc/stat.
+func parseCPUTimes(r io.Reader) (cpuTimes, error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 || fields[0] != "cpu" {
+			continue
+		}
+		var c cpuTimes
+		for i, field := range fields[1:] {
+			value, err := strconv.ParseUint(field, 10, 64)
+			if err != nil {
+				return cpuTimes{}, fmt.Errorf("invalid cpu time %q: %w", field, err)
+			}
+			c.total += value
+			// idle & iowait
+			if i == 3 || i == 4 {
+				c.idle += value
+			}
+		}
+		return c, nil
+	}
+	return cpuTimes{}, errors.New("no cpu line found")
+}
+
+// parseLoadAverage returns the 1-minute load average from Linux's /proc/loadavg
+// or the output of macOS's "sysctl -n vm.loadavg".
+func parseLoadAverage(s string) (float64, error) {
+	fields := strings.Fields(strings.Trim(strings.TrimSpace(s), "{}"))
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("invalid load average %q", s)
+	}
+	return strconv.ParseFloat(fields[0], 64)
+}
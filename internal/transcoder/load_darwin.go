@@ -0,0 +1,18 @@
+package transcoder
+
+import (
+	"errors"
+	"os/exec"
+)
+
+func loadAverage() (float64, error) {
+	output, err := exec.Command("sysctl", "-n", "vm.loadavg").Output()
+	if err != nil {
+		return 0, err
+	}
+	return parseLoadAverage(string(output))
+}
+
+func readCPUTimes() (cpuTimes, error) {
+	return cpuTimes{}, errors.ErrUnsupported
+}
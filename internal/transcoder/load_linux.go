@@ -0,0 +1,20 @@
+package transcoder
+
+import "os"
+
+func loadAverage() (float64, error) {
+	body, err := os.ReadFile("/proc/loadavg")
+	if err != nil {
+		return 0, err
+	}
+	return parseLoadAverage(string(body))
+}
+
+func readCPUTimes() (cpuTimes, error) {
+	f, err := os.Open("/proc/stat")
+	if err != nil {
+		return cpuTimes{}, err
+	}
+	defer func() { _ = f.Close() }()
+	return parseCPUTimes(f)
+}
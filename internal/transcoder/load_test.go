@@ -0,0 +1,124 @@
+package transcoder
+
+import (
+	"context"
+	"log/slog"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadMonitor(t *testing.T) {
+	cpus := float64(runtime.NumCPU())
+	tests := []struct {
+		name  string
+		cfg   LoadConfiguration
+		load  float64
+		cpu   []cpuTimes
+		err   error
+		allow []bool
+	}{
+		{name: "disabled", allow: []bool{true, true}},
+		{name: "load below max", cfg: LoadConfiguration{MaxLoad: 1}, load: cpus, allow: []bool{true, true}},
+		{name: "load above max", cfg: LoadConfiguration{MaxLoad: 1}, load: 2 * cpus, allow: []bool{false, false}},
+		{name: "load unknown", cfg: LoadConfiguration{MaxLoad: 1}, err: assert.AnError, allow: []bool{true, true}},
+		{name: "idle", cfg: LoadConfiguration{MinIdle: 50}, cpu: []cpuTimes{{idle: 60, total: 100}, {idle: 80, total: 200}}, allow: []bool{true, false}},
+		{name: "probe succeeds", cfg: LoadConfiguration{Probe: []string{"true"}}, allow: []bool{true, true}},
+		{name: "probe fails", cfg: LoadConfiguration{Probe: []string{"false"}}, allow: []bool{false, false}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := newLoadMonitor(tt.cfg, slog.New(slog.DiscardHandler))
+			m.loadAverage = func() (float64, error) { return tt.load, tt.err }
+			var sample int
+			m.cpuTimes = func() (cpuTimes, error) {
+				sample++
+				return tt.cpu[sample-1], nil
+			}
+			// until the load is sampled, new sessions are held back
+			assert.Equal(t, !tt.cfg.enabled(), m.allow())
+			for i, want := range tt.allow {
+				m.sample()
+				assert.Equal(t, want, m.allow(), i)
+				// the result is cached until the next sample
+				assert.Equal(t, want, m.allow(), i)
+			}
+		})
+	}
+}
+
+func TestParseLoadAverage(t *testing.T) {
+	for _, input := range []string{"1.50 0.80 0.40 2/345 6789\n", "{ 1.50 0.80 0.40 }\n"} {
+		load, err := parseLoadAverage(input)
+		require.NoError(t, err)
+		assert.Equal(t, 1.5, load)
+	}
+	_, err := parseLoadAverage("")
+	assert.Error(t, err)
+}
+
+func TestParseCPUTimes(t *testing.T) {
+	const stat = `cpu  100 0 50 800 50 0 0 0 0 0
+cpu0 50 0 25 400 25 0 0 0 0 0
+intr 12345
+`
+	cpu, err := parseCPUTimes(strings.NewReader(stat))
+	require.NoError(t, err)
+	assert.Equal(t, cpuTimes{idle: 850, total: 1000}, cpu)
+	assert.Equal(t, 85.0, cpu.idlePercentage(cpuTimes{}))
+
+	_, err = parseCPUTimes(strings.NewReader("intr 12345\n"))
+	assert.Error(t, err)
+}
+
+func TestTranscoder_Load(t *testing.T) {
+	var q WorkItems
+	workItem := WorkItem{Source: File{Path: "source.mkv"}, Target: File{Path: "source.hevc.mkv"}}
+	workItem.SetStatus(StatusQueued, nil)
+	q.Add(&workItem)
+
+	var cfg Configuration
+	cfg.Profile, _ = GetProfile("hevc-high")
+	cfg.Load.MaxLoad = 1
+	transcoder := New(&q, cfg, slog.New(slog.DiscardHandler))
+	e := transcoder.controller.(*engine)
+	var load atomic.Int64
+	load.Store(int64(2 * runtime.NumCPU()))
+	e.load.loadAverage = func() (float64, error) { return float64(load.Load()), nil }
+	e.load.interval = 100 * time.Millisecond
+	e.transcodeFunc = func(_ *Session) error { return nil }
+	ctx, cancel := context.WithCancel(t.Context())
+	t.Cleanup(cancel)
+	go func() { _ = transcoder.Run(ctx) }()
+
+	// the system is too busy: the work item stays queued
+	time.Sleep(3 * scheduleInterval)
+	status, _ := workItem.Status()
+	assert.Equal(t, StatusQueued, status)
+
+	// the load drops: the session starts after the next sample
+	load.Store(0)
+	require.Eventually(t, func() bool {
+		status, _ = workItem.Status()
+		return status == StatusConverted
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestLoadMonitor_Run(t *testing.T) {
+	// a slow probe doesn't block allow
+	m := newLoadMonitor(LoadConfiguration{Probe: []string{"sleep", "1"}}, slog.New(slog.DiscardHandler))
+	ctx, cancel := context.WithCancel(t.Context())
+	t.Cleanup(cancel)
+	go m.run(ctx)
+
+	start := time.Now()
+	assert.False(t, m.allow())
+	assert.Less(t, time.Since(start), 100*time.Millisecond)
+	assert.Eventually(t, m.allow, 5*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,79 @@
+package transcoder
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// An Override records a user decision for a media file. It takes precedence over the transcoder's profile.
+type Override struct {
+	// Profile is the name of the profile used to analyze the file. Empty means the transcoder's profile.
+	Profile string `json:"profile,omitempty"`
+	// Force queues the file for transcoding, even if the profile's rules skip or reject it.
+	Force bool `json:"force,omitempty"`
+}
+
+// Overrides holds the Override for each media file. If a filename is set, overrides are persisted,
+// so they survive a rescan of the media files.
+type Overrides struct {
+	overrides map[string]Override
+	filename  string
+	mu        sync.Mutex
+}
+
+// LoadOverrides loads the overrides from filename. If the file does not exist, it returns an empty set of overrides.
+func LoadOverrides(filename string) (*Overrides, error) {
+	o := Overrides{overrides: make(map[string]Override), filename: filename}
+	body, err := os.ReadFile(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return &o, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read overrides: %w", err)
+	}
+	if err = json.Unmarshal(body, &o.overrides); err != nil {
+		return nil, fmt.Errorf("parse overrides: %w", err)
+	}
+	return &o, nil
+}
+
+// Get returns the Override for the media file at path.
+func (o *Overrides) Get(path string) (Override, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	override, ok := o.overrides[absPath(path)]
+	return override, ok
+}
+
+// Set records the Override for the media file at path and persists the overrides.
+func (o *Overrides) Set(path string, override Override) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.overrides == nil {
+		o.overrides = make(map[string]Override)
+	}
+	o.overrides[absPath(path)] = override
+	if o.filename == "" {
+		return nil
+	}
+	body, err := json.MarshalIndent(o.overrides, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encode overrides: %w", err)
+	}
+	if err = os.WriteFile(o.filename, body, 0o644); err != nil {
+		return fmt.Errorf("write overrides: %w", err)
+	}
+	return nil
+}
+
+// absPath returns the absolute path, so the same file is found regardless of the directory xcoder was started in.
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
@@ -0,0 +1,46 @@
+package transcoder
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOverrides(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "overrides.json")
+
+	o, err := LoadOverrides(filename)
+	require.NoError(t, err)
+	_, ok := o.Get("foo.mkv")
+	assert.False(t, ok)
+
+	require.NoError(t, o.Set("foo.mkv", Override{Profile: "hevc-low", Force: true}))
+
+	// overrides are persisted
+	o, err = LoadOverrides(filename)
+	require.NoError(t, err)
+	override, ok := o.Get("foo.mkv")
+	require.True(t, ok)
+	assert.Equal(t, Override{Profile: "hevc-low", Force: true}, override)
+
+	// paths are stored as absolute paths
+	override, ok = o.Get(filepath.Join(".", "foo.mkv"))
+	assert.True(t, ok)
+	assert.Equal(t, "hevc-low", override.Profile)
+
+	// invalid content
+	require.NoError(t, os.WriteFile(filename, []byte("invalid"), 0o644))
+	_, err = LoadOverrides(filename)
+	assert.Error(t, err)
+}
+
+func TestOverrides_InMemory(t *testing.T) {
+	var o Overrides
+	require.NoError(t, o.Set("foo.mkv", Override{Force: true}))
+	override, ok := o.Get("foo.mkv")
+	require.True(t, ok)
+	assert.True(t, override.Force)
+}
@@ -4,13 +4,22 @@ import (
 	"errors"
 	"fmt"
 	"maps"
+	"math"
 	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/clambin/xcoder/ffmpeg"
 )
 
+// defaultRetryPolicy retries a failed session once, using the software encoder.
+var defaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 2,
+	Backoff:     time.Minute,
+	Backends:    []string{HardwareBackend, SoftwareBackend},
+}
+
 var profiles = map[string]Profile{
 	"hevc-low": {
 		TargetCodec: "hevc",
@@ -19,6 +28,7 @@ var profiles = map[string]Profile{
 			RejectBitrateTooLow(),
 		},
 		CapBitrate: true,
+		Retry:      defaultRetryPolicy,
 	},
 	"hevc-medium": {
 		TargetCodec: "hevc",
@@ -28,6 +38,7 @@ var profiles = map[string]Profile{
 			RejectBitrateTooLow(),
 		},
 		//CapBitrate: true,
+		Retry: defaultRetryPolicy,
 	},
 	"hevc-high": {
 		TargetCodec: "hevc",
@@ -36,6 +47,7 @@ var profiles = map[string]Profile{
 			RejectVideoHeightTooLow(1080),
 			RejectBitrateTooLow(),
 		},
+		Retry: defaultRetryPolicy,
 	},
 }
 
@@ -71,14 +83,59 @@ func (e *SourceSkippedError) Is(e2 error) bool {
 
 // A Profile specifies the requirements of a source media file and the corresponding converted target media file.
 type Profile struct {
+	Name        string
 	TargetCodec string
 	Rules       []Rule
+	Retry       RetryPolicy
 	CapBitrate  bool
+	// Deinterlace determines how source videos that were detected as interlaced are deinterlaced.
+	// If blank, interlaced source videos are encoded as-is. To reject them, add a rule: "reject if interlaced".
+	Deinterlace Deinterlace
+	// Crop removes the black bars that were detected in the source video.
+	Crop bool
+	// InverseTelecine reverses the 3:2 pulldown of source videos that were detected as telecined, restoring their
+	// original frame rate. Otherwise, telecined source videos are deinterlaced.
+	InverseTelecine bool
+	// ConstantFrameRate converts source videos with a variable frame rate to a constant frame rate.
+	ConstantFrameRate bool
+	// MaxFrameRate is the maximum frame rate of the target video. Zero means no maximum.
+	MaxFrameRate float64
+	// NormalizeLoudness re-encodes the audio streams, normalizing their loudness to EBU R128. The loudness
+	// of the source's audio streams is measured before the source is transcoded.
+	NormalizeLoudness bool
+}
+
+// Deinterlace is the method used to deinterlace an interlaced source video.
+type Deinterlace string
+
+const (
+	// DeinterlaceOff encodes interlaced video as-is.
+	DeinterlaceOff Deinterlace = "off"
+	// DeinterlaceBwdif uses ffmpeg's bwdif filter.
+	DeinterlaceBwdif Deinterlace = "bwdif"
+	// DeinterlaceYadif uses ffmpeg's yadif filter, which is faster than bwdif, but of lower quality.
+	DeinterlaceYadif Deinterlace = "yadif"
+	// DeinterlaceHardware uses the backend's hardware deinterlacer. Backends without one use bwdif.
+	DeinterlaceHardware Deinterlace = "hardware"
+	// DeinterlaceInverseTelecine reverses 3:2 pulldown. It's used for telecined source videos if the profile's
+	// InverseTelecine is set and can't be selected as a profile's Deinterlace method.
+	DeinterlaceInverseTelecine Deinterlace = "ivtc"
+)
+
+// ParseDeinterlace returns the Deinterlace method for the name.
+func ParseDeinterlace(name string) (Deinterlace, error) {
+	switch d := Deinterlace(name); d {
+	case DeinterlaceOff, DeinterlaceBwdif, DeinterlaceYadif, DeinterlaceHardware:
+		return d, nil
+	default:
+		return "", fmt.Errorf("invalid deinterlace method %q", name)
+	}
 }
 
 // GetProfile returns the profile associated with name.
 func GetProfile(name string) (Profile, error) {
 	if profile, ok := profiles[name]; ok {
+		profile.Name = name
 		return profile, nil
 	}
 	return Profile{}, fmt.Errorf("invalid profile name: %q. supported profile names: %s", name, strings.Join(SupportedProfiles(), ", ")) //nolint:err113
@@ -91,20 +148,65 @@ func SupportedProfiles() []string {
 	return p
 }
 
+// Backends returns the backends that the profile uses to transcode a media file.
+func (p Profile) Backends() []string {
+	if len(p.Retry.Backends) == 0 {
+		return []string{HardwareBackend}
+	}
+	return slices.Compact(slices.Clone(p.Retry.Backends))
+}
+
 // Analyze performs a profile analysis on the source file and returns the target video stats
 func (p Profile) Analyze(source File) (ffmpeg.VideoStats, error) {
-	// evaluate all rules
-	for _, rule := range p.Rules {
-		if err := rule(p, source.VideoStats); err != nil {
-			return ffmpeg.VideoStats{}, err
+	return p.analyze(source, false)
+}
+
+// analyze performs the profile analysis. If force is true, the profile's rules are not evaluated.
+func (p Profile) analyze(source File, force bool) (ffmpeg.VideoStats, error) {
+	// evaluate all rules, unless the user forced the conversion
+	if !force {
+		for _, rule := range p.Rules {
+			if err := rule(p, source.VideoStats); err != nil {
+				return ffmpeg.VideoStats{}, err
+			}
 		}
 	}
 
 	// determine target videoStats
 	targetVideoStats := source.VideoStats
 	targetVideoStats.VideoCodec = p.TargetCodec
+	switch {
+	case source.VideoStats.FieldOrder == ffmpeg.FieldOrderTelecine && p.InverseTelecine:
+		// 3:2 pulldown turns 4 frames into 5
+		targetVideoStats.Deinterlace = string(DeinterlaceInverseTelecine)
+		targetVideoStats.FieldOrder = ffmpeg.FieldOrderProgressive
+		targetVideoStats.FrameRate = source.VideoStats.FrameRate * 4 / 5
+		targetVideoStats.RealFrameRate = targetVideoStats.FrameRate
+	case source.VideoStats.Interlaced() && p.Deinterlace != "" && p.Deinterlace != DeinterlaceOff:
+		targetVideoStats.Deinterlace = string(p.Deinterlace)
+		targetVideoStats.FieldOrder = ffmpeg.FieldOrderProgressive
+	}
+	if p.ConstantFrameRate && source.VideoStats.VariableFrameRate() {
+		targetVideoStats.FPS = math.Round(targetVideoStats.FrameRate*1000) / 1000
+	}
+	if p.MaxFrameRate > 0 && targetVideoStats.FrameRate > p.MaxFrameRate {
+		targetVideoStats.FPS = p.MaxFrameRate
+	}
+	if targetVideoStats.FPS > 0 {
+		targetVideoStats.FrameRate, targetVideoStats.RealFrameRate = targetVideoStats.FPS, targetVideoStats.FPS
+	}
+	targetVideoStats.Crop = ffmpeg.Crop{}
+	if p.Crop && !source.VideoStats.Crop.IsZero() {
+		targetVideoStats.Crop = source.VideoStats.Crop
+		targetVideoStats.Width, targetVideoStats.Height = source.VideoStats.Crop.Width, source.VideoStats.Crop.Height
+	}
+	// the loudness is measured when transcoding, unless it was measured for an earlier session
+	targetVideoStats.NormalizeLoudness = p.NormalizeLoudness && source.VideoStats.AudioStreams > 0
+	if !targetVideoStats.NormalizeLoudness {
+		targetVideoStats.Loudness = nil
+	}
 	var err error
-	if targetVideoStats.BitRate, err = getTargetBitrate(source.VideoStats, source.VideoStats.VideoCodec, p.TargetCodec, p.CapBitrate); err != nil {
+	if targetVideoStats.BitRate, err = getTargetBitrate(source.VideoStats, targetVideoStats, p.CapBitrate); err != nil {
 		return ffmpeg.VideoStats{}, err
 	}
 	return targetVideoStats, nil
@@ -112,6 +214,41 @@ func (p Profile) Analyze(source File) (ffmpeg.VideoStats, error) {
 
 ////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
 
+// A RetryPolicy determines if, when and how a failed transcoding session is retried.
+type RetryPolicy struct {
+	// Backends is the chain of backends used for each attempt: the first attempt uses the first backend, the second
+	// attempt the second backend, etc. If there are more attempts than backends, the last backend is used.
+	// If no backends are specified, all attempts use the hardware backend.
+	Backends []string
+	// MaxAttempts is the maximum number of times a work item is transcoded. Zero means no retries.
+	MaxAttempts int
+	// Backoff is the delay before the first retry. The delay doubles for each subsequent retry.
+	Backoff time.Duration
+}
+
+// Backend returns the name of the backend to use for the given attempt (starting at zero).
+func (r RetryPolicy) Backend(attempt int) string {
+	if len(r.Backends) == 0 {
+		return HardwareBackend
+	}
+	return r.Backends[min(attempt, len(r.Backends)-1)]
+}
+
+// ShouldRetry returns true if a work item that failed after the given number of attempts should be retried.
+func (r RetryPolicy) ShouldRetry(attempts int) bool {
+	return attempts < r.MaxAttempts
+}
+
+// Delay returns how long to wait before retrying a work item that failed after the given number of attempts.
+func (r RetryPolicy) Delay(attempts int) time.Duration {
+	if attempts <= 1 {
+		return r.Backoff
+	}
+	return r.Backoff << (attempts - 1)
+}
+
+////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
 // A Rule is a function that evaluates a source file and returns an error if the source file does not meet the profile requirements
 type Rule func(profile Profile, sourceStats ffmpeg.VideoStats) error
 
@@ -205,10 +342,33 @@ func getMinimumBitRate(videoStats ffmpeg.VideoStats, from string, to string) (in
 	if !ok {
 		return 0, &SourceRejectedError{Reason: "unsupported target video codec: " + to}
 	}
-	return max(sourceMinimumBitrates.getBitrate(videoStats.Height), targetMinimumBitrates.getBitrate(videoStats.Height)), nil
+	minimum := max(sourceMinimumBitrates.getBitrate(videoStats.Height), targetMinimumBitrates.getBitrate(videoStats.Height))
+	return int(float64(minimum) * frameRateScale(videoStats.FrameRate)), nil
+}
+
+// referenceFrameRate is the frame rate that the minimum bitrates apply to.
+const referenceFrameRate = 30
+
+// frameRateScale returns by how much the minimum bitrates are scaled for the frame rate.
+// Unknown frame rates aren't scaled.
+func frameRateScale(frameRate float64) float64 {
+	return frameRateFactor(referenceFrameRate, frameRate)
+}
+
+// frameRateFactor returns by how much the bitrate is scaled when converting the source's frame rate to the
+// target's frame rate. Consecutive frames differ less at higher frame rates, so halving the frame rate saves about
+// a third of the bitrate, not half. Unchanged or unknown frame rates aren't scaled.
+func frameRateFactor(source, target float64) float64 {
+	if source <= 0 || target <= 0 || source == target {
+		return 1
+	}
+	return math.Pow(target/source, math.Log2(1.5))
 }
 
-func getTargetBitrate(videoStats ffmpeg.VideoStats, from string, to string, capBitrate bool) (int, error) {
+// getTargetBitrate determines the bitrate of the target video. The target's height may be less than the source's
+// height, if the video is cropped, and its frame rate may be lower, if the frame rate is converted.
+func getTargetBitrate(videoStats ffmpeg.VideoStats, target ffmpeg.VideoStats, capBitrate bool) (int, error) {
+	from, to := videoStats.VideoCodec, target.VideoCodec
 	// minimum bitrate for the source codec
 	sourceMinimumBitrates, ok := minimumBitrates[from]
 	if !ok {
@@ -219,14 +379,14 @@ func getTargetBitrate(videoStats ffmpeg.VideoStats, from string, to string, capB
 	if !ok {
 		return 0, &SourceRejectedError{Reason: "unsupported target video codec: " + to}
 	}
-	// minimum bitrate for the video's height
-	bitrate := targetMinimumBitrates.getBitrate(videoStats.Height)
+	// minimum bitrate for the target video's height & the source's frame rate, scaled if the frame rate is converted
+	bitrate := int(float64(targetMinimumBitrates.getBitrate(target.Height)) * frameRateScale(videoStats.FrameRate) * frameRateFactor(videoStats.FrameRate, target.FrameRate))
 	if capBitrate {
 		return bitrate, nil
 	}
 	// if we're not capping the bitRate at the minimum, determine the oversampling factor,
-	// i.e., by how much the source is over the minimum rate for the source code & height
-	oversampling := float64(videoStats.BitRate) / float64(sourceMinimumBitrates.getBitrate(videoStats.Height))
+	// i.e., by how much the source is over the minimum rate for the source code, height & frame rate
+	oversampling := float64(videoStats.BitRate) / (float64(sourceMinimumBitrates.getBitrate(videoStats.Height)) * frameRateScale(videoStats.FrameRate))
 	// apply the oversampling factor to the target codec's minimum bitrate
 	// so, if the source is twice its minimum bitrate, the target will also be twice its minimum bitrate
 	return int(float64(bitrate) * oversampling), nil
@@ -2,6 +2,7 @@ package transcoder
 
 import (
 	"testing"
+	"time"
 
 	"github.com/clambin/xcoder/ffmpeg"
 	"github.com/stretchr/testify/assert"
@@ -24,6 +25,9 @@ func TestProfile_Analyze(t *testing.T) {
 		{"oversampled", "hevc-high", File{VideoStats: ffmpeg.VideoStats{VideoCodec: "h264", Height: 1080, BitRate: 16_000_000}}, ffmpeg.VideoStats{VideoCodec: "hevc", Height: 1080, BitRate: 8_000_000}, nil},
 		{"hevc-medium", "hevc-medium", File{VideoStats: ffmpeg.VideoStats{VideoCodec: "h264", Height: 872, BitRate: 10_080_000}}, ffmpeg.VideoStats{VideoCodec: "hevc", Height: 872, BitRate: 5_040_000}, nil},
 		{"hevc-low", "hevc-low", File{VideoStats: ffmpeg.VideoStats{VideoCodec: "h264", Height: 872, BitRate: 10_080_000}}, ffmpeg.VideoStats{VideoCodec: "hevc", Height: 872, BitRate: 2_133_333}, nil},
+		{"interlaced", "hevc-high", File{VideoStats: ffmpeg.VideoStats{VideoCodec: "h264", Height: 1080, BitRate: 8_000_000, FieldOrder: ffmpeg.FieldOrderTFF}}, ffmpeg.VideoStats{VideoCodec: "hevc", Height: 1080, BitRate: 4_000_000, FieldOrder: ffmpeg.FieldOrderTFF}, nil},
+		{"cropped", "hevc-high", File{VideoStats: ffmpeg.VideoStats{VideoCodec: "h264", Width: 1920, Height: 1080, BitRate: 8_000_000, Crop: ffmpeg.Crop{Width: 1920, Height: 800, Y: 140}}}, ffmpeg.VideoStats{VideoCodec: "hevc", Width: 1920, Height: 1080, BitRate: 4_000_000}, nil},
+		{"progressive", "hevc-high", File{VideoStats: ffmpeg.VideoStats{VideoCodec: "h264", Height: 1080, BitRate: 8_000_000, FieldOrder: ffmpeg.FieldOrderProgressive}}, ffmpeg.VideoStats{VideoCodec: "hevc", Height: 1080, BitRate: 4_000_000, FieldOrder: ffmpeg.FieldOrderProgressive}, nil},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -39,3 +43,197 @@ func TestProfile_Analyze(t *testing.T) {
 		})
 	}
 }
+
+func TestProfile_Analyze_Deinterlace(t *testing.T) {
+	p, err := GetProfile("hevc-high")
+	require.NoError(t, err)
+	p.Deinterlace = DeinterlaceBwdif
+	got, err := p.Analyze(File{VideoStats: ffmpeg.VideoStats{VideoCodec: "h264", Height: 1080, BitRate: 8_000_000, FieldOrder: ffmpeg.FieldOrderTFF}})
+	require.NoError(t, err)
+	assert.Equal(t, ffmpeg.VideoStats{VideoCodec: "hevc", Height: 1080, BitRate: 4_000_000, FieldOrder: ffmpeg.FieldOrderProgressive, Deinterlace: "bwdif"}, got)
+}
+
+func TestProfile_Analyze_DeinterlaceOff(t *testing.T) {
+	p, err := GetProfile("hevc-high")
+	require.NoError(t, err)
+	p.Deinterlace = DeinterlaceOff
+	got, err := p.Analyze(File{VideoStats: ffmpeg.VideoStats{VideoCodec: "h264", Height: 1080, BitRate: 8_000_000, FieldOrder: ffmpeg.FieldOrderBFF}})
+	require.NoError(t, err)
+	assert.Equal(t, ffmpeg.FieldOrderBFF, got.FieldOrder)
+	assert.Empty(t, got.Deinterlace)
+}
+
+func TestProfile_Analyze_Crop(t *testing.T) {
+	p, err := GetProfile("hevc-high")
+	require.NoError(t, err)
+	p.Crop = true
+	got, err := p.Analyze(File{VideoStats: ffmpeg.VideoStats{VideoCodec: "h264", Width: 1920, Height: 1080, BitRate: 8_000_000, Crop: ffmpeg.Crop{Width: 1920, Height: 800, Y: 140}}})
+	require.NoError(t, err)
+	assert.Equal(t, ffmpeg.VideoStats{VideoCodec: "hevc", Width: 1920, Height: 800, BitRate: 2_444_444, Crop: ffmpeg.Crop{Width: 1920, Height: 800, Y: 140}}, got)
+}
+
+func TestProfile_Analyze_CropOff(t *testing.T) {
+	p, err := GetProfile("hevc-high")
+	require.NoError(t, err)
+	p.Crop = false
+	got, err := p.Analyze(File{VideoStats: ffmpeg.VideoStats{VideoCodec: "h264", Width: 1920, Height: 1080, BitRate: 8_000_000, Crop: ffmpeg.Crop{Width: 1920, Height: 800, Y: 140}}})
+	require.NoError(t, err)
+	assert.Equal(t, ffmpeg.VideoStats{VideoCodec: "hevc", Width: 1920, Height: 1080, BitRate: 4_000_000}, got)
+}
+
+func TestProfile_Analyze_NormalizeLoudness(t *testing.T) {
+	p, err := GetProfile("hevc-high")
+	require.NoError(t, err)
+	loudness := []ffmpeg.Loudness{{Integrated: -27.6, TruePeak: -4.5, Range: 18, Threshold: -39.2, Offset: -0.4}}
+	source := ffmpeg.VideoStats{VideoCodec: "h264", Width: 1920, Height: 1080, BitRate: 8_000_000, AudioStreams: 1, Loudness: loudness}
+
+	// off by default: measured loudness isn't used
+	got, err := p.Analyze(File{VideoStats: source})
+	require.NoError(t, err)
+	assert.False(t, got.NormalizeLoudness)
+	assert.Empty(t, got.Loudness)
+
+	// the target reuses the measured loudness
+	p.NormalizeLoudness = true
+	got, err = p.Analyze(File{VideoStats: source})
+	require.NoError(t, err)
+	assert.True(t, got.NormalizeLoudness)
+	assert.Equal(t, loudness, got.Loudness)
+
+	// no audio: nothing to normalize
+	source.AudioStreams, source.Loudness = 0, nil
+	got, err = p.Analyze(File{VideoStats: source})
+	require.NoError(t, err)
+	assert.False(t, got.NormalizeLoudness)
+}
+
+func TestProfile_Analyze_FrameRate(t *testing.T) {
+	tests := []struct {
+		name    string
+		profile func(*Profile)
+		source  ffmpeg.VideoStats
+		want    ffmpeg.VideoStats
+	}{
+		{
+			name:   "60 fps",
+			source: ffmpeg.VideoStats{FrameRate: 60, RealFrameRate: 60},
+			want:   ffmpeg.VideoStats{FrameRate: 60, RealFrameRate: 60},
+		},
+		{
+			name:    "60 fps capped at 30 fps",
+			profile: func(p *Profile) { p.MaxFrameRate = 30 },
+			source:  ffmpeg.VideoStats{FrameRate: 60, RealFrameRate: 60},
+			want:    ffmpeg.VideoStats{FrameRate: 30, RealFrameRate: 30, FPS: 30},
+		},
+		{
+			name:    "24 fps not capped at 30 fps",
+			profile: func(p *Profile) { p.MaxFrameRate = 30 },
+			source:  ffmpeg.VideoStats{FrameRate: 24, RealFrameRate: 24},
+			want:    ffmpeg.VideoStats{FrameRate: 24, RealFrameRate: 24},
+		},
+		{
+			name:   "variable frame rate",
+			source: ffmpeg.VideoStats{FrameRate: 27.3, RealFrameRate: 30},
+			want:   ffmpeg.VideoStats{FrameRate: 27.3, RealFrameRate: 30},
+		},
+		{
+			name:    "variable frame rate to constant",
+			profile: func(p *Profile) { p.ConstantFrameRate = true },
+			source:  ffmpeg.VideoStats{FrameRate: 27.3, RealFrameRate: 30},
+			want:    ffmpeg.VideoStats{FrameRate: 27.3, RealFrameRate: 27.3, FPS: 27.3},
+		},
+		{
+			name:    "telecine",
+			profile: func(p *Profile) { p.InverseTelecine = true },
+			source:  ffmpeg.VideoStats{FrameRate: 30, RealFrameRate: 30, FieldOrder: ffmpeg.FieldOrderTelecine},
+			want:    ffmpeg.VideoStats{FrameRate: 24, RealFrameRate: 24, FieldOrder: ffmpeg.FieldOrderProgressive, Deinterlace: "ivtc"},
+		},
+		{
+			name:    "telecine without inverse telecine",
+			profile: func(p *Profile) { p.Deinterlace = DeinterlaceBwdif },
+			source:  ffmpeg.VideoStats{FrameRate: 30, RealFrameRate: 30, FieldOrder: ffmpeg.FieldOrderTelecine},
+			want:    ffmpeg.VideoStats{FrameRate: 30, RealFrameRate: 30, FieldOrder: ffmpeg.FieldOrderProgressive, Deinterlace: "bwdif"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := GetProfile("hevc-high")
+			require.NoError(t, err)
+			if tt.profile != nil {
+				tt.profile(&p)
+			}
+			// scale the source bitrate with the frame rate, so all sources have the same quality as an 8 mbps 30 fps source.
+			// the target should then have the same quality as a 4 mbps 30 fps target.
+			source := tt.source
+			source.VideoCodec, source.Height, source.BitRate = "h264", 1080, int(8_000_000*frameRateScale(source.FrameRate))
+			got, err := p.Analyze(File{VideoStats: source})
+			require.NoError(t, err)
+			assert.InDelta(t, 4_000_000*frameRateScale(got.FrameRate), got.BitRate, 2)
+			got.VideoCodec, got.Height, got.BitRate = "", 0, 0
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestRejectBitrateTooLow_FrameRate(t *testing.T) {
+	rule := RejectBitrateTooLow()
+	profile := Profile{TargetCodec: "hevc"}
+	source := ffmpeg.VideoStats{VideoCodec: "h264", Height: 1080, BitRate: 5_500_000}
+	assert.Error(t, rule(profile, source))
+	source.FrameRate = 24
+	assert.NoError(t, rule(profile, source))
+	source.FrameRate = 60
+	assert.Error(t, rule(profile, source))
+}
+
+func TestProfile_Analyze_FrameRate_CapBitrate(t *testing.T) {
+	p, err := GetProfile("hevc-low")
+	require.NoError(t, err)
+	// capped targets still scale with the frame rate
+	var bitrates []int
+	for _, frameRate := range []float64{24, 60} {
+		got, err := p.Analyze(File{VideoStats: ffmpeg.VideoStats{VideoCodec: "h264", Height: 1080, BitRate: 20_000_000, FrameRate: frameRate, RealFrameRate: frameRate}})
+		require.NoError(t, err)
+		bitrates = append(bitrates, got.BitRate)
+	}
+	assert.Less(t, bitrates[0], bitrates[1])
+}
+
+func TestParseDeinterlace(t *testing.T) {
+	for _, name := range []string{"off", "bwdif", "yadif", "hardware"} {
+		d, err := ParseDeinterlace(name)
+		require.NoError(t, err)
+		assert.Equal(t, Deinterlace(name), d)
+	}
+	for _, name := range []string{"reject", "ivtc"} {
+		_, err := ParseDeinterlace(name)
+		assert.Error(t, err)
+	}
+}
+
+func TestRetryPolicy(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 3, Backoff: time.Minute, Backends: []string{HardwareBackend, SoftwareBackend}}
+
+	assert.Equal(t, HardwareBackend, p.Backend(0))
+	assert.Equal(t, SoftwareBackend, p.Backend(1))
+	assert.Equal(t, SoftwareBackend, p.Backend(2))
+	assert.Equal(t, HardwareBackend, RetryPolicy{}.Backend(1))
+
+	assert.True(t, p.ShouldRetry(1))
+	assert.True(t, p.ShouldRetry(2))
+	assert.False(t, p.ShouldRetry(3))
+	assert.False(t, RetryPolicy{}.ShouldRetry(1))
+
+	assert.Equal(t, time.Minute, p.Delay(1))
+	assert.Equal(t, 2*time.Minute, p.Delay(2))
+	assert.Equal(t, 4*time.Minute, p.Delay(3))
+}
+
+func Test_frameRateFactor(t *testing.T) {
+	assert.Equal(t, 1.0, frameRateFactor(23.976, 23.976))
+	assert.Equal(t, 1.0, frameRateFactor(0, 30))
+	assert.Equal(t, 1.0, frameRateFactor(60, 0))
+	assert.InDelta(t, 1/1.5, frameRateFactor(60, 30), 0.0001)
+	assert.Equal(t, 1.0, frameRateScale(30))
+	assert.InDelta(t, 1.5, frameRateScale(60), 0.0001)
+}
@@ -0,0 +1,184 @@
+package transcoder
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ScheduleAction determines what the Transcoder does with the active sessions when a processing window closes.
+type ScheduleAction string
+
+const (
+	// ScheduleFinish lets the active sessions complete.
+	ScheduleFinish ScheduleAction = "finish"
+	// SchedulePause stops the active sessions and queues them again. Queued work items wait for the next window.
+	// If segmented encoding is enabled, the sessions resume where they left off.
+	SchedulePause ScheduleAction = "pause"
+	// ScheduleCancel stops the active sessions. Their work items will be queued again during the next window.
+	ScheduleCancel ScheduleAction = "cancel"
+)
+
+// ErrOutsideSchedule is the cause of a session that was stopped because its processing window closed.
+var ErrOutsideSchedule = errors.New("processing window closed")
+
+// ParseScheduleAction returns the ScheduleAction for the action. A blank action defaults to ScheduleFinish.
+func ParseScheduleAction(action string) (ScheduleAction, error) {
+	switch a := ScheduleAction(action); a {
+	case "":
+		return ScheduleFinish, nil
+	case ScheduleFinish, SchedulePause, ScheduleCancel:
+		return a, nil
+	default:
+		return "", fmt.Errorf("invalid schedule action %q", action)
+	}
+}
+
+// A Schedule holds the windows during which batch processing is active. An empty Schedule has no windows:
+// batch processing is then controlled manually, through SetActive.
+type Schedule []Window
+
+// A Window is a daily time range on one or more weekdays. If the range ends before it starts, the window ends
+// on the next day: "fri 22:00-06:00" runs from Friday 22:00 to Saturday 06:00.
+type Window struct {
+	days  [7]bool
+	start time.Duration
+	end   time.Duration
+}
+
+var weekdays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+// ParseSchedule parses the windows of a Schedule. Each window has the form "<days> <start>-<end>", where days is
+// a comma-separated list of weekdays or weekday ranges ("mon-fri,sun"), or "*" for every day, and start and end
+// are times of the day ("22:00-06:00"). "24:00" marks the end of the day.
+func ParseSchedule(windows []string) (Schedule, error) {
+	s := make(Schedule, 0, len(windows))
+	for _, window := range windows {
+		w, err := parseWindow(window)
+		if err != nil {
+			return nil, fmt.Errorf("window %q: %w", window, err)
+		}
+		s = append(s, w)
+	}
+	return s, nil
+}
+
+func parseWindow(window string) (Window, error) {
+	var w Window
+	fields := strings.Fields(window)
+	if len(fields) != 2 {
+		return w, errors.New("expected <days> <start>-<end>")
+	}
+	if err := w.parseDays(fields[0]); err != nil {
+		return w, err
+	}
+	from, to, ok := strings.Cut(fields[1], "-")
+	if !ok {
+		return w, errors.New("expected <start>-<end>")
+	}
+	var err error
+	if w.start, err = parseTimeOfDay(from); err != nil {
+		return w, err
+	}
+	if w.end, err = parseTimeOfDay(to); err != nil {
+		return w, err
+	}
+	if w.start == w.end {
+		return w, errors.New("window is empty")
+	}
+	return w, nil
+}
+
+func (w *Window) parseDays(days string) error {
+	if days == "*" {
+		for i := range w.days {
+			w.days[i] = true
+		}
+		return nil
+	}
+	for _, r := range strings.Split(strings.ToLower(days), ",") {
+		from, to, isRange := strings.Cut(r, "-")
+		first := slices.Index(weekdays, from)
+		last := first
+		if isRange {
+			last = slices.Index(weekdays, to)
+		}
+		if first == -1 || last == -1 {
+			return fmt.Errorf("invalid days %q", r)
+		}
+		// ranges may wrap around the end of the week: "fri-mon"
+		for d := first; ; d = (d + 1) % 7 {
+			w.days[d] = true
+			if d == last {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func parseTimeOfDay(s string) (time.Duration, error) {
+	h, m, ok := strings.Cut(s, ":")
+	hours, err1 := strconv.Atoi(h)
+	minutes, err2 := strconv.Atoi(m)
+	if !ok || err1 != nil || err2 != nil || hours < 0 || minutes < 0 || minutes > 59 || hours*60+minutes > 24*60 {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	return time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute, nil
+}
+
+// on returns the start and end of the window, if it opens on the day that starts at the given midnight.
+func (w Window) on(day time.Time) (time.Time, time.Time, bool) {
+	if !w.days[day.Weekday()] {
+		return time.Time{}, time.Time{}, false
+	}
+	start := day.Add(w.start)
+	end := day.Add(w.end)
+	if w.end < w.start {
+		end = end.AddDate(0, 0, 1)
+	}
+	return start, end, true
+}
+
+// Open returns true if t falls within one of the Schedule's windows.
+func (s Schedule) Open(t time.Time) bool {
+	today := midnight(t)
+	for _, w := range s {
+		// a window that started yesterday may still be open
+		for _, day := range []time.Time{today, today.AddDate(0, 0, -1)} {
+			if start, end, ok := w.on(day); ok && !t.Before(start) && t.Before(end) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Next returns the time after t when the Schedule next opens or closes a window.
+// It returns false if the Schedule never changes (it's always open, or it has no windows).
+func (s Schedule) Next(t time.Time) (time.Time, bool) {
+	var boundaries []time.Time
+	today := midnight(t)
+	for day := -1; day <= 7; day++ {
+		for _, w := range s {
+			if start, end, ok := w.on(today.AddDate(0, 0, day)); ok {
+				boundaries = append(boundaries, start, end)
+			}
+		}
+	}
+	slices.SortFunc(boundaries, time.Time.Compare)
+	open := s.Open(t)
+	for _, b := range boundaries {
+		if b.After(t) && s.Open(b) != open {
+			return b, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func midnight(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
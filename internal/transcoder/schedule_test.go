@@ -0,0 +1,147 @@
+package transcoder
+
+import (
+	"context"
+	"log/slog"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSchedule(t *testing.T) {
+	tests := []struct {
+		name    string
+		windows []string
+		wantErr string
+	}{
+		{name: "valid", windows: []string{"mon-fri 22:00-06:00", "sat,sun 00:00-24:00", "* 12:00-13:30", "fri-mon 01:00-02:00"}},
+		{name: "missing range", windows: []string{"mon-fri"}, wantErr: `window "mon-fri": expected <days> <start>-<end>`},
+		{name: "invalid day", windows: []string{"mon-fry 22:00-06:00"}, wantErr: `window "mon-fry 22:00-06:00": invalid days "mon-fry"`},
+		{name: "invalid time", windows: []string{"mon 22:00-25:00"}, wantErr: `window "mon 22:00-25:00": invalid time "25:00"`},
+		{name: "invalid range", windows: []string{"mon 22:00"}, wantErr: `window "mon 22:00": expected <start>-<end>`},
+		{name: "empty window", windows: []string{"mon 22:00-22:00"}, wantErr: `window "mon 22:00-22:00": window is empty`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := ParseSchedule(tt.windows)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			require.NoError(t, err)
+			assert.Len(t, s, len(tt.windows))
+		})
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	s, err := ParseSchedule([]string{"mon-fri 22:00-06:00", "sat,sun 00:00-24:00"})
+	require.NoError(t, err)
+
+	// 2026-10-16 is a Friday
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2026, time.October, day, hour, minute, 0, 0, time.Local)
+	}
+	tests := []struct {
+		name     string
+		t        time.Time
+		wantOpen bool
+		wantNext time.Time
+	}{
+		{name: "friday afternoon", t: at(16, 14, 0), wantOpen: false, wantNext: at(16, 22, 0)},
+		{name: "friday night", t: at(16, 22, 0), wantOpen: true, wantNext: at(19, 0, 0)},
+		{name: "saturday morning", t: at(17, 5, 59), wantOpen: true, wantNext: at(19, 0, 0)},
+		{name: "monday morning", t: at(19, 0, 0), wantOpen: false, wantNext: at(19, 22, 0)},
+		{name: "tuesday morning", t: at(20, 1, 0), wantOpen: true, wantNext: at(20, 6, 0)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.wantOpen, s.Open(tt.t))
+			next, ok := s.Next(tt.t)
+			require.True(t, ok)
+			assert.Equal(t, tt.wantNext, next)
+		})
+	}
+
+	// an empty schedule never changes
+	_, ok := Schedule{}.Next(at(16, 14, 0))
+	assert.False(t, ok)
+}
+
+func TestTranscoder_Schedule(t *testing.T) {
+	tests := []struct {
+		name       string
+		action     ScheduleAction
+		wantStatus Status
+	}{
+		{name: "finish", action: ScheduleFinish, wantStatus: StatusConverted},
+		{name: "pause", action: SchedulePause, wantStatus: StatusQueued},
+		{name: "cancel", action: ScheduleCancel, wantStatus: StatusScanned},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var q WorkItems
+			workItem := WorkItem{Source: File{Path: "source.mkv"}, Target: File{Path: t.TempDir() + "/source.hevc.mkv"}}
+			workItem.SetStatus(StatusScanned, nil)
+			q.Add(&workItem)
+
+			var cfg Configuration
+			cfg.Profile, _ = GetProfile("hevc-high")
+			cfg.Schedule, _ = ParseSchedule([]string{"* 00:00-12:00"})
+			cfg.ScheduleAction = tt.action
+			transcoder := New(&q, cfg, slog.New(slog.DiscardHandler))
+
+			var now atomic.Pointer[time.Time]
+			setClock := func(hour int) {
+				ts := time.Date(2026, time.October, 16, hour, 0, 0, 0, time.Local)
+				now.Store(&ts)
+			}
+			setClock(10)
+			transcoder.controller.(*engine).nowFunc = func() time.Time { return *now.Load() }
+			release := make(chan struct{})
+			transcoder.controller.(*engine).transcodeFunc = func(session *Session) error {
+				select {
+				case <-release:
+					return nil
+				case <-session.ctx.Done():
+					return session.ctx.Err()
+				}
+			}
+			ctx, cancel := context.WithCancel(t.Context())
+			t.Cleanup(cancel)
+			go func() { _ = transcoder.Run(ctx) }()
+
+			// the window is open: batch processing starts
+			require.Eventually(t, func() bool { return transcoder.SessionCount() == 1 }, time.Second, 10*time.Millisecond)
+			open, next := transcoder.ScheduleWindow()
+			assert.True(t, open)
+			assert.Equal(t, 12, next.Hour())
+
+			// the window closes
+			setClock(13)
+			require.Eventually(t, func() bool { return !transcoder.Active() }, time.Second, 10*time.Millisecond)
+			if tt.action == ScheduleFinish {
+				close(release)
+			}
+			require.Eventually(t, func() bool {
+				status, _ := workItem.Status()
+				return status == tt.wantStatus
+			}, time.Second, 10*time.Millisecond)
+
+			// no new sessions start outside the window
+			time.Sleep(3 * scheduleInterval)
+			assert.Zero(t, transcoder.SessionCount())
+			status, _ := workItem.Status()
+			assert.Equal(t, tt.wantStatus, status)
+			if tt.action != ScheduleFinish {
+				assert.Zero(t, workItem.AttemptCount())
+			}
+		})
+	}
+}
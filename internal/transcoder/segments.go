@@ -0,0 +1,385 @@
+package transcoder
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/clambin/xcoder/ffmpeg"
+)
+
+// SegmentConfiguration configures segmented encoding. Segmented encoding splits the source file at keyframes,
+// encodes each segment separately and concatenates the encoded segments into the target file.
+// Completed segments are kept in a work directory, so an interrupted session resumes where it stopped.
+type SegmentConfiguration struct {
+	// WorkDir holds the segments of each session. Each session gets its own subdirectory.
+	WorkDir string
+	// Duration is the (approximate) duration of each segment. If zero, segmented encoding is disabled.
+	Duration time.Duration
+	// Parallel encodes segments in parallel, using any free session slots.
+	Parallel bool
+}
+
+// enabled returns true if a source file of the given duration should be encoded in segments.
+// Files that are shorter than two segments are encoded in one pass.
+func (c SegmentConfiguration) enabled(duration time.Duration) bool {
+	return c.Duration > 0 && c.WorkDir != "" && duration >= 2*c.Duration
+}
+
+// A segment is a part of the source file, split at a keyframe.
+type segment struct {
+	source   string
+	target   string
+	index    int
+	duration time.Duration
+}
+
+// segmentMaxAge is the age after which a segment directory that was left behind by an interrupted session
+// is removed when the Transcoder starts.
+const segmentMaxAge = 7 * 24 * time.Hour
+
+// transcodeSegments runs the transcoding session in segments. Segments that were encoded by an earlier session
+// for the same source file, encoder arguments and backend are not encoded again.
+func (e *engine) transcodeSegments(session *Session, backend Backend, args []string, cb func(ffmpeg.Progress)) error {
+	// ffmpeg only writes the target when concatenating the segments. check if it exists before encoding them,
+	// so we don't encode all segments to find out we can't write the target.
+	if !session.overwriteTarget {
+		if _, err := os.Stat(session.WorkItem.Target.Path); err == nil {
+			return &ffmpeg.Error{
+				Command: "ffmpeg",
+				Err:     os.ErrExist,
+				Kind:    ffmpeg.ErrorTargetExists,
+				Stderr:  "File '" + session.WorkItem.Target.Path + "' already exists. Exiting.",
+			}
+		}
+	}
+	dir := e.segmentDir(session, args)
+	session.segmentDir = dir
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("create segment directory: %w", err)
+	}
+	logger := e.logger.With(slog.String("source", session.WorkItem.Source.Path))
+
+	segments, err := e.splitSource(session, dir)
+	if err != nil {
+		return fmt.Errorf("split source: %w", err)
+	}
+	if err = e.encodeSegments(session, backend, args, segments, cb); err != nil {
+		return err
+	}
+	if err = e.concatSegments(session, dir, segments); err != nil {
+		return fmt.Errorf("concat segments: %w", err)
+	}
+	// the target is complete: we no longer need the segments
+	if err = os.RemoveAll(dir); err != nil {
+		logger.Warn("failed to remove segment directory", "dir", dir, "err", err)
+	}
+	return nil
+}
+
+// segmentDir returns the work directory for the session. The directory depends on the source file, the backend
+// and the arguments used to encode each segment, so a new session only resumes from segments that it would have
+// encoded itself.
+func (e *engine) segmentDir(session *Session, args []string) string {
+	key := strings.Join(slices.Concat([]string{
+		session.WorkItem.Source.Path,
+		strconv.FormatInt(session.WorkItem.Source.Size, 10),
+		session.Backend,
+		e.segments.Duration.String(),
+	}, args), "|")
+	hash := sha256.Sum256([]byte(key))
+	return filepath.Join(e.segments.WorkDir, hex.EncodeToString(hash[:8]))
+}
+
+// removeSegments removes the segment directory of a session that won't be resumed.
+func (e *engine) removeSegments(session *Session) {
+	if session.segmentDir == "" {
+		return
+	}
+	if err := os.RemoveAll(session.segmentDir); err != nil {
+		e.logger.Warn("failed to remove segment directory", "dir", session.segmentDir, "err", err)
+	}
+}
+
+// sweepSegments removes the segment directories that haven't been modified for segmentMaxAge. These are left behind
+// by sessions that were interrupted and never resumed, e.g. because the source file was removed.
+func (e *engine) sweepSegments(now time.Time) {
+	if e.segments.WorkDir == "" {
+		return
+	}
+	entries, err := os.ReadDir(e.segments.WorkDir)
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			e.logger.Warn("failed to read segment work directory", "dir", e.segments.WorkDir, "err", err)
+		}
+		return
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !entry.IsDir() || now.Sub(info.ModTime()) < segmentMaxAge {
+			continue
+		}
+		dir := filepath.Join(e.segments.WorkDir, entry.Name())
+		if err = os.RemoveAll(dir); err != nil {
+			e.logger.Warn("failed to remove segment directory", "dir", dir, "err", err)
+			continue
+		}
+		e.logger.Debug("removed stale segment directory", "dir", dir)
+	}
+}
+
+// splitSource splits the video stream of the source file into segments, without re-encoding.
+// The segment muxer only cuts at keyframes, so each segment can be encoded independently.
+// If the source file was split by an earlier session, splitSource returns the existing segments.
+func (e *engine) splitSource(session *Session, dir string) ([]segment, error) {
+	list := filepath.Join(dir, "segments.csv")
+	if segments, err := readSegmentList(list); err == nil {
+		e.logger.Debug("resuming from existing segments", "source", session.WorkItem.Source.Path, "dir", dir)
+		return segments, nil
+	}
+
+	// ffmpeg writes the list while it's splitting the source file. Only rename it once the split completed,
+	// so we don't resume from a partial split.
+	partial := filepath.Join(dir, "segments.partial.csv")
+	f := ffmpeg.
+		Decode(session.WorkItem.Source.Path).
+		Encode("-map", "0:v:0", "-c", "copy").
+		Muxer("segment",
+			"-segment_time", strconv.FormatFloat(e.segments.Duration.Seconds(), 'f', -1, 64),
+			"-segment_format", "matroska",
+			"-segment_list", partial,
+			"-reset_timestamps", "1",
+		).
+		NoStats().
+		LogLevel("error").
+		OverWriteTarget().
+		Output(filepath.Join(dir, "source-%05d.mkv"))
+	if err := e.runFFMPEG(session.ctx, f); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(partial, list); err != nil {
+		return nil, fmt.Errorf("segment list: %w", err)
+	}
+	return readSegmentList(list)
+}
+
+// readSegmentList reads the CSV segment list written by ffmpeg's segment muxer.
+// Each line holds the filename of the segment, its start time and its end time.
+func readSegmentList(path string) ([]segment, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+
+	records, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("segment list: %w", err)
+	}
+	if len(records) == 0 {
+		return nil, errors.New("segment list: no segments found")
+	}
+	dir := filepath.Dir(path)
+	segments := make([]segment, len(records))
+	for i, record := range records {
+		if len(record) != 3 {
+			return nil, fmt.Errorf("segment list: invalid line %d", i+1)
+		}
+		start, err1 := strconv.ParseFloat(record[1], 64)
+		end, err2 := strconv.ParseFloat(record[2], 64)
+		if err = errors.Join(err1, err2); err != nil {
+			return nil, fmt.Errorf("segment list: invalid line %d: %w", i+1, err)
+		}
+		segments[i] = segment{
+			index:    i,
+			source:   filepath.Join(dir, record[0]),
+			target:   filepath.Join(dir, fmt.Sprintf("encoded-%05d.mkv", i)),
+			duration: time.Duration((end - start) * float64(time.Second)),
+		}
+	}
+	return segments, nil
+}
+
+// encodeSegments encodes all segments that don't have an encoded target yet. If parallel encoding is enabled,
+// encodeSegments uses any free session slots to encode multiple segments at the same time.
+func (e *engine) encodeSegments(session *Session, backend Backend, args []string, segments []segment, cb func(ffmpeg.Progress)) error {
+	progress := newSegmentProgress(segments, cb)
+	pending := slices.DeleteFunc(slices.Clone(segments), func(s segment) bool {
+		if _, err := os.Stat(s.target); err == nil {
+			progress.done(s)
+			return true
+		}
+		return false
+	})
+	if len(pending) < len(segments) {
+		e.logger.Info("resuming transcoding", "source", session.WorkItem.Source.Path, "segments", len(segments), "remaining", len(pending))
+	}
+
+	workers := 1
+	if e.segments.Parallel {
+		for workers < len(pending) && e.acquireSlot() {
+			workers++
+		}
+		defer func() {
+			for range workers - 1 {
+				e.releaseSlot()
+			}
+		}()
+	}
+
+	ctx, cancel := context.WithCancel(session.ctx)
+	defer cancel()
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+	queue := make(chan segment)
+	for range workers {
+		wg.Go(func() {
+			for s := range queue {
+				if err := e.encodeSegment(ctx, session, backend, args, s, func(p ffmpeg.Progress) { progress.update(s, p) }); err != nil {
+					errOnce.Do(func() { firstErr = fmt.Errorf("segment %d: %w", s.index, err) })
+					cancel()
+					continue
+				}
+				progress.done(s)
+			}
+		})
+	}
+feed:
+	for _, s := range pending {
+		select {
+		case queue <- s:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(queue)
+	wg.Wait()
+	if firstErr == nil {
+		firstErr = ctx.Err()
+	}
+	return firstErr
+}
+
+// encodeSegment encodes one segment. ffmpeg writes to a temporary file, which is only renamed once the segment
+// is complete, so an interrupted session never leaves a partial segment behind.
+func (e *engine) encodeSegment(ctx context.Context, session *Session, backend Backend, args []string, s segment, cb func(ffmpeg.Progress)) error {
+	// ffmpeg removes the progress socket's directory when it's done, so each segment gets its own directory
+	tmpDir, err := os.MkdirTemp("", "xcoder")
+	if err != nil {
+		return fmt.Errorf("create temp directory: %w", err)
+	}
+	defer func() { _ = os.RemoveAll(tmpDir) }()
+
+	partial := s.target + ".partial"
+	f := ffmpeg.
+		Decode(s.source, backend.DecoderArguments(session.WorkItem.Source.VideoStats)...).
+		Encode(args...).
+		Muxer("matroska").
+		NoStats().
+		LogLevel("error").
+		OverWriteTarget().
+		Progress(cb, filepath.Join(tmpDir, "transcoder.sock")).
+		Output(partial)
+	if err = e.runFFMPEG(ctx, f); err != nil {
+		return err
+	}
+	return os.Rename(partial, s.target)
+}
+
+// concatSegments concatenates the encoded segments into the target file, adding the audio & subtitle streams
+// of the source file. If the target normalizes the loudness, the audio streams are re-encoded.
+func (e *engine) concatSegments(session *Session, dir string, segments []segment) error {
+	var list strings.Builder
+	for _, s := range segments {
+		list.WriteString("file '" + filepath.Base(s.target) + "'\n")
+	}
+	listPath := filepath.Join(dir, "concat.txt")
+	if err := os.WriteFile(listPath, []byte(list.String()), 0o644); err != nil {
+		return err
+	}
+
+	f := ffmpeg.
+		Decode(listPath, "-f", "concat", "-safe", "0").
+		Decode(session.WorkItem.Source.Path).
+		Encode("-map", "0:v", "-map", "1:a?", "-map", "1:s?", "-c", "copy")
+	if session.WorkItem.Target.VideoStats.NormalizeLoudness {
+		f = f.Encode(audioArguments(session.WorkItem.Target.VideoStats)...)
+	}
+	f = f.
+		Muxer("matroska").
+		NoStats().
+		LogLevel("error").
+		Output(session.WorkItem.Target.Path)
+	if session.overwriteTarget {
+		f = f.OverWriteTarget()
+	}
+	return e.runFFMPEG(session.ctx, f)
+}
+
+// runFFMPEG runs the ffmpeg command. runFunc allows us to stub ffmpeg during testing.
+func (e *engine) runFFMPEG(ctx context.Context, f *ffmpeg.FFMPEG) error {
+	f = f.Priority(e.priority)
+	if e.runFunc != nil {
+		return e.runFunc(ctx, f)
+	}
+	return f.Run(ctx, e.logger)
+}
+
+// segmentProgress combines the progress of each segment into the progress of the session
+// and reports it to the session's progress callback.
+type segmentProgress struct {
+	cb       func(ffmpeg.Progress)
+	segments []ffmpeg.Progress
+	mu       sync.Mutex
+}
+
+func newSegmentProgress(segments []segment, cb func(ffmpeg.Progress)) *segmentProgress {
+	return &segmentProgress{cb: cb, segments: make([]ffmpeg.Progress, len(segments))}
+}
+
+// update records the progress of one segment.
+func (p *segmentProgress) update(s segment, progress ffmpeg.Progress) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.segments[s.index] = progress
+	p.cb(p.total())
+}
+
+// done marks the segment as complete.
+func (p *segmentProgress) done(s segment) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	// keep the segment's size, so the session's output size doesn't drop when a segment completes
+	p.segments[s.index] = ffmpeg.Progress{Converted: s.duration, TotalSize: p.segments[s.index].TotalSize}
+	p.cb(p.total())
+}
+
+// total returns the combined progress. Speed and FPS add up, as segments may be encoded in parallel.
+// The bitrate is derived from the combined output size.
+func (p *segmentProgress) total() ffmpeg.Progress {
+	var total ffmpeg.Progress
+	for _, s := range p.segments {
+		total.Converted += s.Converted
+		total.Speed += s.Speed
+		total.FPS += s.FPS
+		total.TotalSize += s.TotalSize
+	}
+	if total.Converted > 0 {
+		total.Bitrate = 8 * float64(total.TotalSize) / total.Converted.Seconds()
+	}
+	return total
+}
@@ -0,0 +1,274 @@
+package transcoder
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/clambin/xcoder/ffmpeg"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTranscoder_Segments(t *testing.T) {
+	tmpDir := t.TempDir()
+	workItem := WorkItem{
+		Source: File{Path: filepath.Join(tmpDir, "source.mkv"), VideoStats: ffmpeg.VideoStats{VideoCodec: "h264", Duration: 30 * time.Minute}},
+		Target: File{Path: filepath.Join(tmpDir, "source.hevc.mkv"), VideoStats: ffmpeg.VideoStats{VideoCodec: "hevc", BitRate: 3_000_000}},
+	}
+	cfg := Configuration{Segments: SegmentConfiguration{WorkDir: filepath.Join(tmpDir, "work"), Duration: 10 * time.Minute}}
+	e := New(&WorkItems{}, cfg, slog.New(slog.DiscardHandler)).controller.(*engine)
+	f := fakeFFMPEG{fail: "encoded-00001"}
+	e.runFunc = f.run
+
+	// first session fails halfway
+	session, ok := e.allocateSession(&workItem)
+	require.True(t, ok)
+	session.Backend = SoftwareBackend
+	require.Error(t, e.transcode(session))
+	e.freeSession(session)
+	assert.Equal(t, int32(1), f.splits.Load())
+	assert.Equal(t, int32(2), f.encodes.Load())
+	assert.NoFileExists(t, workItem.Target.Path)
+
+	// next session resumes from the completed segments
+	f.fail = ""
+	session, ok = e.allocateSession(&workItem)
+	require.True(t, ok)
+	session.Backend = SoftwareBackend
+	require.NoError(t, e.transcode(session))
+	e.freeSession(session)
+	assert.Equal(t, int32(1), f.splits.Load())
+	assert.Equal(t, int32(4), f.encodes.Load())
+	assert.FileExists(t, workItem.Target.Path)
+	assert.Equal(t, 30*time.Minute, session.Progress().Converted)
+
+	// segments are removed once the target is complete
+	dir := session.segmentDir
+	require.NotEmpty(t, dir)
+	assert.NoDirExists(t, dir)
+
+	// different encoder arguments or a different backend do not reuse the segments
+	args := []string{"-c:v", "libx265", "-b:v", "3000000"}
+	assert.NotEqual(t, dir, e.segmentDir(session, append(args, "-vf", "bwdif")))
+	session.Backend = HardwareBackend
+	assert.NotEqual(t, dir, e.segmentDir(session, args))
+}
+
+func TestTranscoder_Segments_Failed(t *testing.T) {
+	ctx, cancel := context.WithCancel(t.Context())
+	t.Cleanup(cancel)
+
+	tmpDir := t.TempDir()
+	workItem := WorkItem{
+		Source: File{Path: filepath.Join(tmpDir, "source.mkv"), VideoStats: ffmpeg.VideoStats{VideoCodec: "h264", Height: 1080, BitRate: 8_000_000, Duration: 30 * time.Minute}},
+	}
+	workItem.SetStatus(StatusScanned, nil)
+	var q WorkItems
+	q.Add(&workItem)
+	cfg := Configuration{Segments: SegmentConfiguration{WorkDir: filepath.Join(tmpDir, "work"), Duration: 10 * time.Minute}}
+	cfg.Profile, _ = GetProfile("hevc-high")
+	cfg.Profile.Retry = RetryPolicy{}
+	transcoder := New(&q, cfg, slog.New(slog.DiscardHandler))
+	e := transcoder.controller.(*engine)
+	f := fakeFFMPEG{fail: "encoded-00001"}
+	e.runFunc = f.run
+	workItem.Target = File{Path: filepath.Join(tmpDir, "source.hevc.mkv"), VideoStats: ffmpeg.VideoStats{VideoCodec: "hevc", BitRate: 4_000_000}}
+	transcoder.SetActive(true)
+	go func() { _ = transcoder.Run(ctx) }()
+
+	require.Eventually(t, func() bool {
+		status, _ := workItem.Status()
+		return status == StatusFailed
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// the session won't be resumed, so its segments are removed
+	assert.Equal(t, int32(1), f.splits.Load())
+	require.Eventually(t, func() bool {
+		entries, err := os.ReadDir(cfg.Segments.WorkDir)
+		return err == nil && len(entries) == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestTranscoder_Segments_TargetExists(t *testing.T) {
+	tmpDir := t.TempDir()
+	workItem := WorkItem{
+		Source: File{Path: filepath.Join(tmpDir, "source.mkv"), VideoStats: ffmpeg.VideoStats{VideoCodec: "h264", Duration: 30 * time.Minute}},
+		Target: File{Path: filepath.Join(tmpDir, "source.hevc.mkv"), VideoStats: ffmpeg.VideoStats{VideoCodec: "hevc", BitRate: 3_000_000}},
+	}
+	require.NoError(t, os.WriteFile(workItem.Target.Path, []byte("target"), 0o644))
+	cfg := Configuration{Segments: SegmentConfiguration{WorkDir: filepath.Join(tmpDir, "work"), Duration: 10 * time.Minute}}
+	e := New(&WorkItems{}, cfg, slog.New(slog.DiscardHandler)).controller.(*engine)
+	var f fakeFFMPEG
+	e.runFunc = f.run
+
+	// the session fails before splitting the source
+	session, ok := e.allocateSession(&workItem)
+	require.True(t, ok)
+	session.Backend = SoftwareBackend
+	err := e.transcode(session)
+	e.freeSession(session)
+	ffmpegErr, ok := errors.AsType[*ffmpeg.Error](err)
+	require.True(t, ok)
+	assert.Equal(t, ffmpeg.ErrorTargetExists, ffmpegErr.Kind)
+	assert.Zero(t, f.splits.Load())
+
+	// unless we may overwrite the target
+	session, ok = e.allocateSession(&workItem)
+	require.True(t, ok)
+	session.Backend = SoftwareBackend
+	session.overwriteTarget = true
+	require.NoError(t, e.transcode(session))
+	e.freeSession(session)
+	assert.Equal(t, int32(1), f.splits.Load())
+}
+
+func TestEngine_sweepSegments(t *testing.T) {
+	workDir := t.TempDir()
+	cfg := Configuration{Segments: SegmentConfiguration{WorkDir: workDir, Duration: 10 * time.Minute}}
+	e := New(&WorkItems{}, cfg, slog.New(slog.DiscardHandler)).controller.(*engine)
+	for _, name := range []string{"stale", "recent"} {
+		require.NoError(t, os.Mkdir(filepath.Join(workDir, name), 0o755))
+	}
+	old := time.Now().Add(-segmentMaxAge - time.Hour)
+	require.NoError(t, os.Chtimes(filepath.Join(workDir, "stale"), old, old))
+
+	e.sweepSegments(time.Now())
+	assert.NoDirExists(t, filepath.Join(workDir, "stale"))
+	assert.DirExists(t, filepath.Join(workDir, "recent"))
+
+	// a missing work directory is not an error
+	e.segments.WorkDir = filepath.Join(workDir, "missing")
+	e.sweepSegments(time.Now())
+}
+
+func TestTranscoder_Segments_Parallel(t *testing.T) {
+	tmpDir := t.TempDir()
+	workItem := WorkItem{
+		Source: File{Path: filepath.Join(tmpDir, "source.mkv"), VideoStats: ffmpeg.VideoStats{VideoCodec: "h264", Duration: 30 * time.Minute}},
+		Target: File{Path: filepath.Join(tmpDir, "source.hevc.mkv"), VideoStats: ffmpeg.VideoStats{VideoCodec: "hevc", BitRate: 3_000_000}},
+	}
+	cfg := Configuration{Segments: SegmentConfiguration{WorkDir: filepath.Join(tmpDir, "work"), Duration: 10 * time.Minute, Parallel: true}}
+	e := New(&WorkItems{}, cfg, slog.New(slog.DiscardHandler)).controller.(*engine)
+	e.maxConcurrentSessions = 3
+	f := fakeFFMPEG{delay: 100 * time.Millisecond}
+	e.runFunc = f.run
+
+	session, ok := e.allocateSession(&workItem)
+	require.True(t, ok)
+	session.Backend = SoftwareBackend
+	require.NoError(t, e.transcode(session))
+
+	// the session used the free slots to encode all segments at the same time
+	assert.Equal(t, int32(3), f.maxRunning.Load())
+	// and released them afterward
+	assert.True(t, e.acquireSlot())
+	assert.True(t, e.acquireSlot())
+	assert.False(t, e.acquireSlot())
+}
+
+func TestTranscoder_Segments_NormalizeLoudness(t *testing.T) {
+	tmpDir := t.TempDir()
+	workItem := WorkItem{
+		Source: File{Path: filepath.Join(tmpDir, "source.mkv"), VideoStats: ffmpeg.VideoStats{VideoCodec: "h264", Duration: 30 * time.Minute, AudioStreams: 2}},
+		Target: File{Path: filepath.Join(tmpDir, "source.hevc.mkv"), VideoStats: ffmpeg.VideoStats{VideoCodec: "hevc", BitRate: 3_000_000, AudioStreams: 2, NormalizeLoudness: true}},
+	}
+	cfg := Configuration{Segments: SegmentConfiguration{WorkDir: filepath.Join(tmpDir, "work"), Duration: 10 * time.Minute}}
+	e := New(&WorkItems{}, cfg, slog.New(slog.DiscardHandler)).controller.(*engine)
+	var f fakeFFMPEG
+	e.runFunc = f.run
+	loudness := []ffmpeg.Loudness{{Integrated: -27.61, TruePeak: -4.47, Range: 18.06, Threshold: -39.2, Offset: -0.42}, {}}
+	var measured atomic.Int32
+	e.loudnessFunc = func(_ context.Context, path string, streams int) ([]ffmpeg.Loudness, error) {
+		measured.Add(1)
+		if path != workItem.Source.Path || streams != 2 {
+			return nil, fmt.Errorf("unexpected arguments: %s, %d", path, streams)
+		}
+		return loudness, nil
+	}
+
+	for range 2 {
+		session, ok := e.allocateSession(&workItem)
+		require.True(t, ok)
+		session.Backend = SoftwareBackend
+		session.overwriteTarget = true
+		require.NoError(t, e.transcode(session))
+		e.freeSession(session)
+	}
+
+	// the loudness is only measured once and recorded in the work item
+	assert.Equal(t, int32(1), measured.Load())
+	assert.Equal(t, loudness, workItem.Source.VideoStats.Loudness)
+	assert.Equal(t, loudness, workItem.Target.VideoStats.Loudness)
+
+	// the segments hold no audio. the audio is normalized when concatenating the segments
+	assert.NotContains(t, *f.encodeArgs.Load(), "-filter:a:0")
+	assert.Subset(t, f.concatArgs, []string{"-c:a", "aac", "-filter:a:0", loudness[0].Filter()})
+	assert.NotContains(t, f.concatArgs, "-filter:a:1")
+}
+
+func TestSegmentConfiguration_enabled(t *testing.T) {
+	cfg := SegmentConfiguration{WorkDir: "work", Duration: 10 * time.Minute}
+	assert.True(t, cfg.enabled(time.Hour))
+	assert.False(t, cfg.enabled(15*time.Minute))
+	assert.False(t, SegmentConfiguration{WorkDir: "work"}.enabled(time.Hour))
+	assert.False(t, SegmentConfiguration{Duration: 10 * time.Minute}.enabled(time.Hour))
+}
+
+// fakeFFMPEG stubs ffmpeg: it splits the source in three 10-minute segments and writes the output of each command.
+type fakeFFMPEG struct {
+	fail       string
+	delay      time.Duration
+	splits     atomic.Int32
+	encodes    atomic.Int32
+	running    atomic.Int32
+	maxRunning atomic.Int32
+	encodeArgs atomic.Pointer[[]string]
+	concatArgs []string
+}
+
+func (f *fakeFFMPEG) run(ctx context.Context, ff *ffmpeg.FFMPEG) error {
+	args := ff.Build(ctx).Args
+	output := args[len(args)-1]
+	switch {
+	case slices.Contains(args, "segment"):
+		f.splits.Add(1)
+		list := args[slices.Index(args, "-segment_list")+1]
+		var lines []string
+		for i := range 3 {
+			name := fmt.Sprintf(output, i)
+			if err := os.WriteFile(name, []byte("source"), 0o644); err != nil {
+				return err
+			}
+			lines = append(lines, fmt.Sprintf("%s,%d.000000,%d.000000", filepath.Base(name), 600*i, 600*(i+1)))
+		}
+		return os.WriteFile(list, []byte(strings.Join(lines, "\n")+"\n"), 0o644)
+	case slices.Contains(args, "concat"):
+		f.concatArgs = args
+		return os.WriteFile(output, []byte("target"), 0o644)
+	default:
+		f.encodes.Add(1)
+		f.encodeArgs.Store(&args)
+		running := f.running.Add(1)
+		defer f.running.Add(-1)
+		for {
+			current := f.maxRunning.Load()
+			if running <= current || f.maxRunning.CompareAndSwap(current, running) {
+				break
+			}
+		}
+		time.Sleep(f.delay)
+		if f.fail != "" && strings.Contains(output, f.fail) {
+			return fmt.Errorf("ffmpeg: %w", assert.AnError)
+		}
+		return os.WriteFile(output, []byte("encoded"), 0o644)
+	}
+}
@@ -1,12 +1,15 @@
 package transcoder
 
 import (
+	"cmp"
 	"context"
 	"errors"
 	"fmt"
 	"log/slog"
+	"maps"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
 	"sync"
 	"sync/atomic"
@@ -14,6 +17,7 @@ import (
 
 	"codeberg.org/clambin/go-common/pubsub"
 	"github.com/clambin/xcoder/ffmpeg"
+	"github.com/clambin/xcoder/internal/history"
 	"github.com/clambin/xcoder/internal/transcoder/evl"
 	"golang.org/x/sync/semaphore"
 )
@@ -32,50 +36,122 @@ type controller interface {
 	Subscribe() <-chan SessionEvent
 	Unsubscribe(<-chan SessionEvent)
 	SessionCount() int
+	SubscribeStatus() <-chan StatusEvent
+	UnsubscribeStatus(<-chan StatusEvent)
+	Sessions() []*Session
+	SpaceSaved() int64
+	ScheduleWindow() (bool, time.Time)
+	Shutdown(context.Context, ShutdownMode) error
 	OverwriteTarget() bool
 	RemoveSource() bool
+	Queue(*WorkItem) error
+	Dequeue(*WorkItem) error
+	ForceConvert(*WorkItem) error
+	SetProfile(*WorkItem, string) error
+	RenewLease(string, ffmpeg.Progress) error
+	CompleteLease(string, error) error
+	Transcode(context.Context, *WorkItem, string, bool, func(ffmpeg.Progress)) error
+	lease(string) (*Session, bool)
 }
 
 type Configuration struct {
+	Overrides       *Overrides
+	History         *history.History
 	BaseDir         string
 	Profile         Profile
+	Segments        SegmentConfiguration
 	OverwriteTarget bool
 	RemoveSource    bool
+	// Schedule holds the windows during which batch processing is active. If empty, batch processing
+	// is controlled manually.
+	Schedule Schedule
+	// ScheduleAction determines what happens to the active sessions when a window closes.
+	ScheduleAction ScheduleAction
+	// Load holds the system load thresholds over which no new sessions are started.
+	Load LoadConfiguration
+	// Priority sets the CPU and I/O priority of ffmpeg and ffprobe processes.
+	Priority ffmpeg.Priority
+	// RemoteOnly leaves all transcoding to remote workers.
+	RemoteOnly bool
+	// DetectInterlacing samples each source video during scanning to detect if it's interlaced.
+	DetectInterlacing bool
+	// DetectCrop samples each source video during scanning to detect black bars.
+	DetectCrop bool
+	// GetProfile returns the named profile, configured in the same way as Profile. It's used for the profiles
+	// that the user selects for individual work items. Defaults to the package's GetProfile.
+	GetProfile func(name string) (Profile, error)
 }
 
 // A Transcoder takes files from the WorkItems list and transcodes them.
 type Transcoder struct {
 	eventLoop *evl.EventLoop
+	load      *loadMonitor
 	controller
 }
 
 // New creates a new Transcoder instance
 func New(workItems *WorkItems, cfg Configuration, logger *slog.Logger) *Transcoder {
 	e := engine{
-		probeSema: semaphore.NewWeighted(maxConcurrentScans),
-		workItems: workItems,
-		logger:    logger,
-		profile:   cfg.Profile,
+		probeSema:  semaphore.NewWeighted(maxConcurrentScans),
+		workItems:  workItems,
+		logger:     logger,
+		profile:    cfg.Profile,
+		getProfile: cfg.GetProfile,
 		sessionTracker: sessionTracker{
 			sessions:              make(map[*Session]struct{}),
 			maxConcurrentSessions: maxConcurrentSessions,
 		},
-		overwriteTarget: cfg.OverwriteTarget,
-		removeSource:    cfg.RemoveSource,
+		overrides:         cfg.Overrides,
+		history:           cfg.History,
+		segments:          cfg.Segments,
+		schedule:          cfg.Schedule,
+		scheduleAction:    cmp.Or(cfg.ScheduleAction, ScheduleFinish),
+		load:              newLoadMonitor(cfg.Load, logger),
+		priority:          cfg.Priority,
+		overwriteTarget:   cfg.OverwriteTarget,
+		removeSource:      cfg.RemoveSource,
+		detectInterlacing: cfg.DetectInterlacing,
+		detectCrop:        cfg.DetectCrop,
+		leaseTTL:          leaseTTL,
+	}
+	if e.overrides == nil {
+		e.overrides = new(Overrides)
+	}
+	if e.getProfile == nil {
+		e.getProfile = GetProfile
+	}
+	if e.history == nil {
+		e.history = new(history.History)
+	}
+	if cfg.RemoteOnly {
+		e.maxConcurrentSessions = 0
 	}
 
 	return &Transcoder{
 		controller: &e,
 		eventLoop:  evl.New(&e),
+		load:       e.load,
 	}
 }
 
 // Run starts the transcoder event loop
 func (t *Transcoder) Run(ctx context.Context) error {
 	defer t.eventLoop.Stop()
+	go t.load.run(ctx)
 	return t.eventLoop.Run(ctx)
 }
 
+// Lease assigns the next queued WorkItem to the remote worker and starts a session that waits for the worker
+// to report its result. It returns false if there is no work available.
+// The transcoder needs to be running, or this will block.
+func (t *Transcoder) Lease(worker string) (*Session, bool) {
+	session, ok := t.lease(worker)
+	if ok {
+		t.eventLoop.Send(leaseEvent{session: session})
+	}
+	return session, ok
+}
+
 // AddMediaFile adds a media file to the transcoder for processing.
 // The transcoder needs to be running, or this will block.
 func (t *Transcoder) AddMediaFile(path string) {
@@ -90,21 +166,45 @@ var (
 type engine struct {
 	probeSema     *semaphore.Weighted
 	workItems     *WorkItems
+	overrides     *Overrides
+	history       *history.History
 	logger        *slog.Logger
-	probeFunc     func(path string) (ffmpeg.VideoStats, error) // only used during testing to stub probe
-	transcodeFunc func(session *Session) error                 // only used during testing to stub transcode
+	probeFunc     func(path string) (ffmpeg.VideoStats, error)                                   // only used during testing to stub probe
+	idetFunc      func(path string, start, duration time.Duration) (ffmpeg.Interlacing, error)   // only used during testing to stub interlace detection
+	cropFunc      func(path string, start, duration time.Duration) (ffmpeg.Crop, error)          // only used during testing to stub crop detection
+	loudnessFunc  func(ctx context.Context, path string, streams int) ([]ffmpeg.Loudness, error) // only used during testing to stub loudness measurement
+	transcodeFunc func(session *Session) error                                                   // only used during testing to stub transcode
+	runFunc       func(context.Context, *ffmpeg.FFMPEG) error                                    // only used during testing to stub ffmpeg
+	nowFunc       func() time.Time                                                               // only used during testing to stub the clock
 	sessionTracker
-	profile Profile
+	profile        Profile
+	getProfile     func(name string) (Profile, error)
+	segments       SegmentConfiguration
+	schedule       Schedule
+	scheduleAction ScheduleAction
+	load           *loadMonitor
+	priority       ffmpeg.Priority
 	pubsub.Publisher[SessionEvent]
-	active          atomic.Bool
-	overwriteTarget bool
-	removeSource    bool
+	statusEvents      pubsub.Publisher[StatusEvent]
+	active            atomic.Bool
+	windowOpen        atomic.Bool
+	windowChecked     bool // only accessed when handling tickEvents
+	stopping          atomic.Bool
+	spaceSaved        atomic.Int64
+	leaseTTL          time.Duration
+	overwriteTarget   bool
+	removeSource      bool
+	detectInterlacing bool
+	detectCrop        bool
 }
 
 // Init implements the evl.Handler interface.
-// It sends the first tickEvent to the event loop.
+// It removes stale segment directories and sends the first tickEvent to the event loop.
 func (e *engine) Init() evl.Cmd {
-	return func() evl.Event { return tickEvent{} }
+	return func() evl.Event {
+		e.sweepSegments(e.now())
+		return tickEvent{}
+	}
 }
 
 // Update implements the evl.Handler interface.
@@ -113,6 +213,7 @@ func (e *engine) Update(msg evl.Event) evl.Cmd {
 	//e.logger.Debug("processing event", "event", fmt.Sprintf("%T", msg))
 	switch msg := msg.(type) {
 	case tickEvent:
+		e.checkSchedule(e.now())
 		e.queueNextItem()
 		return evl.Batch(
 			e.startQueuedWorkItemCmd(),
@@ -123,10 +224,20 @@ func (e *engine) Update(msg evl.Event) evl.Cmd {
 		e.logger.Debug("newMediaEvent", "path", string(msg))
 		workItem := &WorkItem{Source: File{Path: string(msg)}}
 		e.workItems.Add(workItem)
+		e.setStatus(workItem, StatusFound, nil)
 		// scan the workItem
 		return e.scanCmd(workItem)
 	case transcodeCompleteEvent:
-		if status, _ := msg.workItem.Status(); status != StatusConverted {
+		// only free the session once we're done, so Shutdown waits for the source file to be removed.
+		defer e.freeSession(msg.session)
+		status, _ := msg.workItem.Status()
+		if status == StatusRetrying {
+			// retry the workItem once the backoff delay expires
+			delay := e.retryPolicy(msg.workItem).Delay(msg.workItem.AttemptCount())
+			e.logger.Debug("scheduling retry", "path", msg.workItem.Source.Path, "delay", delay)
+			return evl.Tick(delay, func() evl.Event { return retryEvent{workItem: msg.workItem} })
+		}
+		if status != StatusConverted {
 			return nil
 		}
 		e.logger.Debug("transcodeCompleteEvent")
@@ -138,6 +249,7 @@ func (e *engine) Update(msg evl.Event) evl.Cmd {
 			case nil:
 				// remove from the workItems
 				e.workItems.Remove(msg.workItem)
+				e.Publish(SessionEvent{Session: msg.session, Type: SourceRemovedEvent})
 			default:
 				e.logger.Warn("failed to remove source file", "path", msg.workItem.Source.Path, "err", err)
 			}
@@ -145,6 +257,15 @@ func (e *engine) Update(msg evl.Event) evl.Cmd {
 		// add the converted file to the work list
 		e.logger.Debug("queueing newMediaEvent", "path", msg.workItem.Target.Path)
 		return func() evl.Event { return newMediaEvent(msg.workItem.Target.Path) }
+	case leaseEvent:
+		// start the remote session
+		return e.transcodeCmd(msg.session)
+	case retryEvent:
+		// requeue the workItem, unless its status was changed while waiting for the retry
+		if err := e.changeStatus(msg.workItem, StatusQueued, StatusRetrying); err == nil {
+			e.logger.Debug("requeued media file", "path", msg.workItem.Source.Path)
+		}
+		return nil
 	default:
 		return nil
 	}
@@ -161,6 +282,145 @@ func (e *engine) SetActive(active bool) {
 	e.active.Store(active)
 }
 
+// ScheduleWindow returns whether the current processing window is open and when the next window opens or closes.
+// If the Transcoder has no schedule, or the schedule never changes, the time is zero.
+func (e *engine) ScheduleWindow() (bool, time.Time) {
+	now := e.now()
+	next, _ := e.schedule.Next(now)
+	return e.schedule.Open(now), next
+}
+
+// checkSchedule activates batch processing when a processing window opens and deactivates it when the window closes.
+// Between changes, the user can still toggle batch processing manually.
+func (e *engine) checkSchedule(now time.Time) {
+	if len(e.schedule) == 0 {
+		return
+	}
+	open := e.schedule.Open(now)
+	if e.windowChecked && open == e.windowOpen.Load() {
+		return
+	}
+	e.windowChecked = true
+	e.windowOpen.Store(open)
+	e.SetActive(open)
+	next, _ := e.schedule.Next(now)
+	if open {
+		e.logger.Info("processing window opened", "closes", next)
+		return
+	}
+	e.logger.Info("processing window closed", "opens", next, "action", string(e.scheduleAction))
+	if e.scheduleAction != ScheduleFinish {
+		e.abortSessions(ErrOutsideSchedule)
+	}
+}
+
+func (e *engine) now() time.Time {
+	if e.nowFunc != nil {
+		return e.nowFunc()
+	}
+	return time.Now()
+}
+
+// paused returns true if queued work items must wait for the next processing window.
+func (e *engine) paused() bool {
+	return len(e.schedule) > 0 && e.scheduleAction == SchedulePause && !e.windowOpen.Load()
+}
+
+// Unsubscribe stops sending SessionEvents to the channel.
+func (e *engine) Unsubscribe(ch <-chan SessionEvent) {
+	unsubscribe(ch, e.Publisher.Unsubscribe)
+}
+
+// SubscribeStatus returns a channel that receives a StatusEvent each time the Transcoder changes the status of a WorkItem.
+// The caller must keep reading from the channel until it calls UnsubscribeStatus.
+func (e *engine) SubscribeStatus() <-chan StatusEvent {
+	return e.statusEvents.Subscribe()
+}
+
+// UnsubscribeStatus stops sending StatusEvents to the channel.
+func (e *engine) UnsubscribeStatus(ch <-chan StatusEvent) {
+	unsubscribe(ch, e.statusEvents.Unsubscribe)
+}
+
+// unsubscribe removes a subscription. As publishing blocks until all subscribers have read the event,
+// it keeps draining the channel until the subscription is removed, so the caller may stop reading at any time.
+func unsubscribe[T any](ch <-chan T, f func(<-chan T)) {
+	done := make(chan struct{})
+	go func() {
+		f(ch)
+		close(done)
+	}()
+	for {
+		select {
+		case <-ch:
+		case <-done:
+			return
+		}
+	}
+}
+
+// setStatus sets the status of the workItem and informs the StatusEvent subscribers.
+func (e *engine) setStatus(workItem *WorkItem, status Status, err error) {
+	workItem.SetStatus(status, err)
+	e.statusEvents.Publish(StatusEvent{WorkItem: workItem, Status: status, Err: err})
+}
+
+// changeStatus sets the status of the workItem, if its current status is one of the given statuses,
+// and informs the StatusEvent subscribers.
+func (e *engine) changeStatus(workItem *WorkItem, status Status, current ...Status) error {
+	if actual, ok := workItem.setStatusIf(status, nil, current...); !ok {
+		return fmt.Errorf("%s: invalid status: %s", workItem.Source.Path, actual)
+	}
+	e.statusEvents.Publish(StatusEvent{WorkItem: workItem, Status: status})
+	return nil
+}
+
+// Queue queues a scanned WorkItem for transcoding. As the user queues it again, the WorkItem's previous attempts
+// are cleared, so it gets all attempts of its retry policy.
+func (e *engine) Queue(workItem *WorkItem) error {
+	if status, _ := workItem.Status(); status == StatusScanned {
+		workItem.resetAttempts()
+	}
+	return e.changeStatus(workItem, StatusQueued, StatusScanned)
+}
+
+// Dequeue removes a queued WorkItem, or a WorkItem waiting to be retried, from the queue.
+func (e *engine) Dequeue(workItem *WorkItem) error {
+	return e.changeStatus(workItem, StatusScanned, StatusQueued, StatusRetrying)
+}
+
+// SpaceSaved returns the space saved by all media files converted since the Transcoder started.
+func (e *engine) SpaceSaved() int64 {
+	return e.spaceSaved.Load()
+}
+
+// Shutdown stops the Transcoder from starting new transcoding sessions and handles the active sessions as per the mode.
+// Unless the mode is ShutdownDetach, it waits for all active sessions to stop, or for the context to be canceled.
+func (e *engine) Shutdown(ctx context.Context, mode ShutdownMode) error {
+	switch mode {
+	case ShutdownDrain, ShutdownDetach, ShutdownAbort:
+	default:
+		return fmt.Errorf("invalid shutdown mode %q", string(mode))
+	}
+	// stop starting new sessions before aborting the active ones, so we don't miss a session that starts in between
+	e.stopping.Store(true)
+	if mode == ShutdownAbort {
+		e.abortSessions(ErrAborted)
+	}
+	e.logger.Info("shutting down", "mode", string(mode), "sessions", e.SessionCount())
+	if mode == ShutdownDetach {
+		return nil
+	}
+	for e.SessionCount() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(scheduleInterval):
+		}
+	}
+	return nil
+}
+
 // OverwriteTarget returns whether the Transcoder will overwrite the target file if it exists.
 func (e *engine) OverwriteTarget() bool {
 	return e.overwriteTarget
@@ -178,7 +438,7 @@ func (e *engine) scanCmd(workItem *WorkItem) evl.Cmd {
 		logger := e.logger.With(slog.String("source", workItem.Source.Path))
 		probe := e.probeFunc
 		if probe == nil {
-			probe = ffmpeg.Probe
+			probe = e.priority.Probe
 		}
 
 		logger.Debug("acquiring probe semaphore")
@@ -191,51 +451,226 @@ func (e *engine) scanCmd(workItem *WorkItem) evl.Cmd {
 		start := time.Now()
 
 		// determine source media video stats
-		workItem.SetStatus(StatusScanning, nil)
+		e.setStatus(workItem, StatusScanning, nil)
 		var err error
 		if workItem.Source.VideoStats, err = probe(workItem.Source.Path); err != nil {
-			workItem.SetStatus(StatusScanFailed, err)
+			e.setStatus(workItem, StatusScanFailed, err)
 			logger.Warn("failed to probe media file", "err", err)
 			return nil
 		}
+		workItem.Source.Size = fileSize(workItem.Source.Path)
 
-		// determine target media filename
-		workItem.Target.Path = buildTargetFilename(workItem.Source, e.profile.TargetCodec, "mkv")
-
-		// determine target media video stats
-		workItem.Target.VideoStats, err = e.profile.Analyze(workItem.Source)
+		// detect interlacing. if this fails, the video is assumed to be progressive
+		if e.detectInterlacing {
+			if workItem.Source.VideoStats.FieldOrder, err = e.detectFieldOrder(workItem.Source); err != nil {
+				logger.Warn("failed to detect interlacing", "err", err)
+			}
+		}
 
-		// set the workItem status
-		var status Status
-		if err == nil {
-			status = StatusScanned
-		} else if _, ok := errors.AsType[*SourceSkippedError](err); ok {
-			status = StatusSkipped
-		} else if _, ok := errors.AsType[*SourceRejectedError](err); ok {
-			status = StatusRejected
-		} else {
-			status = StatusScanFailed
+		// detect black bars. if this fails, the video isn't cropped
+		if e.detectCrop {
+			if workItem.Source.VideoStats.Crop, err = e.detectBlackBars(workItem.Source); err != nil {
+				logger.Warn("failed to detect black bars", "err", err)
+			}
 		}
-		workItem.SetStatus(status, err)
+
+		// determine the target media file
+		status, err := e.analyze(workItem)
 
 		logger.Debug("scanned media file", "status", status.String(), "err", err, "duration", time.Since(start))
 		return nil
 	}
 }
 
+// interlaceSample is the duration of the sample used to detect interlacing.
+const interlaceSample = time.Minute
+
+// detectFieldOrder runs ffmpeg's idet filter on a sample of the source video and returns the field order.
+// The sample starts at 10% of the video, to skip any (progressive) intro.
+func (e *engine) detectFieldOrder(source File) (string, error) {
+	detect := e.idetFunc
+	if detect == nil {
+		detect = func(path string, start, duration time.Duration) (ffmpeg.Interlacing, error) {
+			return e.priority.DetectInterlacing(context.Background(), path, start, duration)
+		}
+	}
+	start := max(0, min(source.VideoStats.Duration/10, source.VideoStats.Duration-interlaceSample))
+	interlacing, err := detect(source.Path, start, interlaceSample)
+	if err != nil {
+		return "", err
+	}
+	return interlacing.FieldOrder(), nil
+}
+
+const (
+	// cropSamples is the number of samples used to detect black bars, spread evenly over the video.
+	cropSamples = 5
+	// cropSample is the duration of each sample.
+	cropSample = 10 * time.Second
+	// minCrop is the minimum part of the frame's width or height that must be black to crop the video.
+	// This avoids cropping a few lines of noise at the edge of the frame.
+	minCrop = 0.02
+)
+
+// detectBlackBars runs ffmpeg's cropdetect filter on several samples of the source video and returns the area of
+// the frame that isn't black in any of the samples. Dark scenes may look like they have black bars, so using all
+// samples ensures we never crop any part of the picture. If there's nothing (worth) cropping, the crop is empty.
+func (e *engine) detectBlackBars(source File) (ffmpeg.Crop, error) {
+	detect := e.cropFunc
+	if detect == nil {
+		detect = func(path string, start, duration time.Duration) (ffmpeg.Crop, error) {
+			return e.priority.DetectCrop(context.Background(), path, start, duration)
+		}
+	}
+	var crop ffmpeg.Crop
+	var err error
+	var detected int
+	for i := range cropSamples {
+		// samples are taken at 10%, 30%, 50%, etc. of the video
+		start := source.VideoStats.Duration * time.Duration(2*i+1) / (2 * cropSamples)
+		c, sampleErr := detect(source.Path, start, cropSample)
+		if sampleErr != nil {
+			err = sampleErr
+			continue
+		}
+		crop = crop.Union(c)
+		detected++
+	}
+	if detected == 0 {
+		return ffmpeg.Crop{}, err
+	}
+	width, height := float64(source.VideoStats.Width), float64(source.VideoStats.Height)
+	if float64(crop.Width) > width*(1-minCrop) && float64(crop.Height) > height*(1-minCrop) {
+		return ffmpeg.Crop{}, nil
+	}
+	return crop, nil
+}
+
+// analyze uses the profile to determine the target media file of a scanned WorkItem and sets its status accordingly.
+// If the user recorded an Override for the source file, it takes precedence over the transcoder's profile.
+func (e *engine) analyze(workItem *WorkItem) (Status, error) {
+	override, _ := e.overrides.Get(workItem.Source.Path)
+	return e.analyzeWithOverride(workItem, override)
+}
+
+// analyzeWithOverride analyzes a WorkItem, using the override rather than the one recorded for the source file.
+func (e *engine) analyzeWithOverride(workItem *WorkItem, override Override) (Status, error) {
+	profile := e.profile
+	if override.Profile != "" {
+		var err error
+		if profile, err = e.getProfile(override.Profile); err != nil {
+			e.logger.Warn("ignoring invalid profile override", "source", workItem.Source.Path, "err", err)
+			profile = e.profile
+		}
+	}
+	workItem.setProfile(profile.Name)
+
+	// determine target media video stats
+	var err error
+	workItem.Target.VideoStats, err = profile.analyze(workItem.Source, override.Force)
+
+	// determine target media filename. this uses the target's height, which is less than the source's if the video is cropped
+	named := workItem.Source
+	if err == nil {
+		named.VideoStats = workItem.Target.VideoStats
+	}
+	workItem.Target.Path = buildTargetFilename(named, profile.TargetCodec, "mkv")
+
+	// set the workItem status
+	var status Status
+	if err == nil {
+		status = StatusScanned
+	} else if _, ok := errors.AsType[*SourceSkippedError](err); ok {
+		status = StatusSkipped
+	} else if _, ok := errors.AsType[*SourceRejectedError](err); ok {
+		status = StatusRejected
+	} else {
+		status = StatusScanFailed
+	}
+	e.setStatus(workItem, status, err)
+	return status, err
+}
+
+// ForceConvert queues a WorkItem for transcoding, even if the profile skipped or rejected it.
+// The override is recorded, so it is applied again when the media file is rescanned.
+func (e *engine) ForceConvert(workItem *WorkItem) error {
+	override, err := e.getOverride(workItem)
+	if err != nil {
+		return err
+	}
+	override.Force = true
+	if status, err := e.analyzeWithOverride(workItem, override); status != StatusScanned {
+		return fmt.Errorf("cannot convert %s: %w", workItem.Source.Path, err)
+	}
+	// only record the override once we know it works
+	e.setOverride(workItem, override)
+	workItem.resetAttempts()
+	e.setStatus(workItem, StatusQueued, nil)
+	e.logger.Info("forced conversion of media file", "path", workItem.Source.Path, "profile", cmp.Or(override.Profile, e.profile.Name))
+	return nil
+}
+
+// SetProfile analyzes a WorkItem again, using the profile with the given name.
+// The override is recorded, so it is applied again when the media file is rescanned.
+func (e *engine) SetProfile(workItem *WorkItem, profileName string) error {
+	if _, err := e.getProfile(profileName); err != nil {
+		return err
+	}
+	override, err := e.getOverride(workItem)
+	if err != nil {
+		return err
+	}
+	override.Profile = profileName
+	e.setOverride(workItem, override)
+	status, err := e.analyze(workItem)
+	e.logger.Info("analyzed media file with new profile", "path", workItem.Source.Path, "profile", profileName, "status", status.String(), "err", err)
+	return nil
+}
+
+// retryPolicy returns the retry policy of the profile that analyzed the WorkItem.
+func (e *engine) retryPolicy(workItem *WorkItem) RetryPolicy {
+	if name := workItem.Profile(); name != "" && name != e.profile.Name {
+		if profile, err := e.getProfile(name); err == nil {
+			return profile.Retry
+		}
+	}
+	return e.profile.Retry
+}
+
+// getOverride returns the Override for the WorkItem's source file. Overrides can only be set for
+// WorkItems that have been scanned and are not queued or being transcoded.
+func (e *engine) getOverride(workItem *WorkItem) (Override, error) {
+	switch status, _ := workItem.Status(); status {
+	case StatusScanned, StatusSkipped, StatusRejected, StatusFailed:
+	default:
+		return Override{}, fmt.Errorf("cannot override %s: status is %s", workItem.Source.Path, status)
+	}
+	override, _ := e.overrides.Get(workItem.Source.Path)
+	return override, nil
+}
+
+// setOverride records the Override for the WorkItem's source file.
+func (e *engine) setOverride(workItem *WorkItem, override Override) {
+	if err := e.overrides.Set(workItem.Source.Path, override); err != nil {
+		e.logger.Warn("failed to save override", "path", workItem.Source.Path, "err", err)
+	}
+}
+
 // queueNextItem queues the next available work item for transcoding
 // if the Transcoder is active and there are available transcoder slots.
 func (e *engine) queueNextItem() {
-	// don't queue items if we are not active (user will queue manually) or if we don't have any transcoder slots left
-	if !e.Active() || e.SessionCount() >= e.maxConcurrentSessions {
+	// don't queue items if we are not active (user will queue manually), if we are shutting down
+	// or if we don't have any transcoder slots left
+	if !e.Active() || e.stopping.Load() || e.localSessionCount() >= e.maxConcurrentSessions {
 		return
 	}
 	if workItem, ok := e.workItems.GetFirst(StatusScanned); ok {
 		if strings.Contains(workItem.Source.Path, ".hevc.") {
 			panic("should never happen")
 		}
-		workItem.SetStatus(StatusQueued, nil)
-		e.logger.Debug("queued media file", "path", workItem.Source.Path)
+		if err := e.changeStatus(workItem, StatusQueued, StatusScanned); err == nil {
+			e.logger.Debug("queued media file", "path", workItem.Source.Path)
+		}
 	}
 }
 
@@ -244,12 +679,23 @@ func (e *engine) queueNextItem() {
 // If no session slot is available (as per sessionTracker.maxConcurrentSessions), the command returns nil
 // and the work item remains queued.
 func (e *engine) startQueuedWorkItemCmd() evl.Cmd {
+	// don't start new sessions if we are shutting down, if remote workers handle all sessions
+	// or if we are waiting for the next processing window
+	if e.stopping.Load() || e.maxConcurrentSessions == 0 || e.paused() {
+		return nil
+	}
+
 	// get the next queued item
 	workItem, ok := e.workItems.GetFirst(StatusQueued)
 	if !ok {
 		return nil
 	}
 
+	// don't start new sessions while the system is too busy
+	if !e.load.allow() {
+		return nil
+	}
+
 	// allocate a transcode session
 	session, ok := e.allocateSession(workItem)
 	if !ok {
@@ -259,10 +705,23 @@ func (e *engine) startQueuedWorkItemCmd() evl.Cmd {
 		e.logger.Debug("skipping queued item due to max concurrent scans", "count", e.SessionCount())
 		return nil
 	}
+	// Shutdown may have aborted the active sessions since we checked, before this one was allocated
+	if e.stopping.Load() {
+		e.freeSession(session)
+		return nil
+	}
 
 	// mark the workItem here; if we wait until we're in transcodeCmd,
 	// startQueuedWorkItemCmd() may pick up the same item twice.
-	workItem.SetStatus(StatusTranscoding, nil)
+	if err := e.changeStatus(workItem, StatusTranscoding, StatusQueued); err != nil {
+		// the workItem was dequeued in the meantime
+		e.freeSession(session)
+		return nil
+	}
+
+	// select the backend for this attempt
+	session.Backend = e.retryPolicy(workItem).Backend(workItem.AttemptCount())
+	session.overwriteTarget = e.overwriteTarget
 
 	// start the session
 	return e.transcodeCmd(session)
@@ -273,7 +732,10 @@ func (e *engine) startQueuedWorkItemCmd() evl.Cmd {
 func (e *engine) transcodeCmd(session *Session) evl.Cmd {
 	return func() evl.Event {
 		start := time.Now()
-		logger := e.logger.With(slog.String("source", session.WorkItem.Source.Path))
+		logger := e.logger.With(slog.String("source", session.WorkItem.Source.Path), slog.String("backend", session.Backend))
+		if session.Worker != "" {
+			logger = logger.With(slog.String("worker", session.Worker))
+		}
 		logger.Info("started transcoding")
 
 		// inform the listeners that a new session is starting.
@@ -285,44 +747,92 @@ func (e *engine) transcodeCmd(session *Session) evl.Cmd {
 		if f == nil {
 			f = e.transcode
 		}
+		// remote sessions wait for the worker to report the result
+		if session.lease != nil {
+			f = e.waitForWorker
+		}
 		err := f(session)
+		aborted := session.ctx.Err() != nil
+		if aborted {
+			err = context.Cause(session.ctx)
+		}
+		// sessions stopped by the schedule will run again and sessions aborted by the user say nothing about
+		// the media file, so they don't count as an attempt
+		interrupted := errors.Is(err, ErrOutsideSchedule)
+		if !interrupted && !aborted {
+			session.WorkItem.addAttempt(Attempt{Start: start, Duration: time.Since(start), Backend: session.Backend, Err: err})
+		}
+		if err == nil {
+			session.WorkItem.Target.Size = fileSize(session.WorkItem.Target.Path)
+		}
+		// aborted sessions say nothing about the media file, so we don't record them
+		if !aborted {
+			if err2 := e.history.Add(newRecord(session, start, err)); err2 != nil {
+				logger.Warn("failed to record session history", "err", err2)
+			}
+		}
 
 		// mark the workItem status
-		switch err {
-		case nil:
-			session.WorkItem.SetStatus(StatusConverted, nil)
-			logger.Info("finished transcoding", "duration", time.Since(start))
+		// remote sessions are aborted by revoking their lease: the worker stops transcoding and removes the partial
+		// target file itself, so we don't remove a file that the worker may still be writing.
+		if aborted && session.lease == nil {
+			// remove the partial target file
+			if err2 := os.Remove(session.WorkItem.Target.Path); err2 != nil && !errors.Is(err2, os.ErrNotExist) {
+				logger.Warn("failed to remove partial target file", "err", err2)
+			}
+		}
+		retry := e.retryPolicy(session.WorkItem)
+		switch {
+		case interrupted:
+			status := StatusScanned
+			if e.scheduleAction == SchedulePause {
+				status = StatusQueued
+			}
+			e.setStatus(session.WorkItem, status, nil)
+			logger.Info("transcoding stopped outside processing window", "duration", time.Since(start), "status", status)
+		case aborted:
+			e.setStatus(session.WorkItem, StatusFailed, err)
+			logger.Warn("transcoding aborted", "duration", time.Since(start))
+		case err == nil:
+			e.setStatus(session.WorkItem, StatusConverted, nil)
+			e.spaceSaved.Add(session.WorkItem.Saved())
+			logger.Info("finished transcoding", "duration", time.Since(start), "saved", ffmpeg.Bytes(session.WorkItem.Saved()).Format(1))
+		case retryable(err, session.Backend, retry.Backend(session.WorkItem.AttemptCount())) && retry.ShouldRetry(session.WorkItem.AttemptCount()):
+			e.setStatus(session.WorkItem, StatusRetrying, err)
+			logger.Warn("finished transcoding with errors. will retry", "err", err, "attempts", session.WorkItem.AttemptCount(), "duration", time.Since(start))
 		default:
-			session.WorkItem.SetStatus(StatusFailed, err)
+			e.setStatus(session.WorkItem, StatusFailed, err)
 			logger.Warn("finished transcoding with errors", "err", err, "duration", time.Since(start))
 		}
+		// only keep the segments if the next session resumes from them
+		status, _ := session.WorkItem.Status()
+		if resume := interrupted || (status == StatusRetrying && retry.Backend(session.WorkItem.AttemptCount()) == session.Backend); !resume {
+			e.removeSegments(session)
+		}
 
 		// inform listeners that the session has stopped
 		e.Publish(SessionEvent{Session: session, Type: SessionStoppedEvent})
-
-		// delete the session
-		e.freeSession(session)
-		e.logger.Debug("removed work item from session map")
-		return transcodeCompleteEvent{workItem: session.WorkItem}
+		return transcodeCompleteEvent{workItem: session.WorkItem, session: session}
 	}
 }
 
 // transcode runs the transcoding session for the given session.
 func (e *engine) transcode(session *Session) error {
-	// add progress monitor to the transcoder
-	tmpDir, err := os.MkdirTemp("", "xcoder")
+	// encoding arguments
+	backend, err := GetBackend(session.Backend)
 	if err != nil {
-		return fmt.Errorf("create temp directory: %w", err)
+		return err
 	}
-
-	defer func() {
-		if err := os.RemoveAll(tmpDir); err != nil {
-			e.logger.Warn("failed to remove temp directory", "err", err)
-		}
-	}()
-
-	// encoding arguments
-	args, err := encoderArguments(session.WorkItem.Target.VideoStats)
+	if err = e.measureLoudness(session); err != nil {
+		return fmt.Errorf("measure loudness: %w", err)
+	}
+	target := session.WorkItem.Target.VideoStats
+	segmented := e.segments.enabled(session.WorkItem.Source.VideoStats.Duration)
+	if segmented {
+		// segments only hold the video stream. the audio streams are added when concatenating the segments
+		target.NormalizeLoudness = false
+	}
+	args, err := backend.EncoderArguments(target)
 	if err != nil {
 		return err
 	}
@@ -332,6 +842,9 @@ func (e *engine) transcode(session *Session) error {
 	lastLogTimestamp := time.Now()
 	cb := func(p ffmpeg.Progress) {
 		session.progress.Store(&p)
+		if session.onProgress != nil {
+			session.onProgress(p)
+		}
 		if time.Since(lastLogTimestamp) > logProgressInterval {
 			speed, eta := processSessionProgress(session, p)
 			etaString := "N/A"
@@ -343,20 +856,102 @@ func (e *engine) transcode(session *Session) error {
 		}
 	}
 
-	t := ffmpeg.
-		Decode(session.WorkItem.Source.Path, DecoderArguments(session.WorkItem.Source.VideoStats)...).
+	// large files are encoded in segments, so an interrupted session can resume
+	if segmented {
+		return e.transcodeSegments(session, backend, args, cb)
+	}
+
+	// add progress monitor to the transcoder
+	tmpDir, err := os.MkdirTemp("", "xcoder")
+	if err != nil {
+		return fmt.Errorf("create temp directory: %w", err)
+	}
+
+	defer func() {
+		if err := os.RemoveAll(tmpDir); err != nil {
+			e.logger.Warn("failed to remove temp directory", "err", err)
+		}
+	}()
+
+	t := ffmpeg.Decode(session.WorkItem.Source.Path, backend.DecoderArguments(session.WorkItem.Source.VideoStats)...)
+	if target.NormalizeLoudness {
+		// map all audio streams, so each stream's filter applies to the stream it was measured for
+		t = t.Encode("-map", "0:v:0", "-map", "0:a", "-map", "0:s?")
+	}
+	t = t.
 		Encode(args...).
 		Muxer("matroska"). // mkv only
 		NoStats().
 		LogLevel("error").
+		Priority(e.priority).
 		Progress(cb, filepath.Join(tmpDir, "transcoder.sock")).
 		Output(session.WorkItem.Target.Path)
-	if e.overwriteTarget {
+	if session.overwriteTarget {
 		t = t.OverWriteTarget()
 	}
 
-	err = t.Run(context.Background(), e.logger.With(slog.String("source", session.WorkItem.Source.Path)))
-	return err
+	return t.Run(session.ctx, e.logger.With(slog.String("source", session.WorkItem.Source.Path)))
+}
+
+// measureLoudness runs the first loudnorm pass on the source's audio streams, if the target normalizes their loudness.
+// The loudness is recorded in the WorkItem, so later sessions (e.g. retries) don't need to measure it again.
+func (e *engine) measureLoudness(session *Session) error {
+	workItem := session.WorkItem
+	if !workItem.Target.VideoStats.NormalizeLoudness || len(workItem.Target.VideoStats.Loudness) > 0 {
+		return nil
+	}
+	measure := e.loudnessFunc
+	if measure == nil {
+		measure = e.priority.MeasureLoudness
+	}
+	e.logger.Info("measuring loudness", "source", workItem.Source.Path, "streams", workItem.Source.VideoStats.AudioStreams)
+	loudness, err := measure(session.ctx, workItem.Source.Path, workItem.Source.VideoStats.AudioStreams)
+	if err != nil {
+		return err
+	}
+	workItem.Source.VideoStats.Loudness = loudness
+	workItem.Target.VideoStats.Loudness = loudness
+	return nil
+}
+
+// newRecord returns the history.Record for a completed session.
+func newRecord(session *Session, start time.Time, err error) history.Record {
+	end := time.Now()
+	r := history.Record{
+		Start:   start,
+		End:     end,
+		Source:  history.File{Path: session.WorkItem.Source.Path, Stats: session.WorkItem.Source.VideoStats, Size: session.WorkItem.Source.Size},
+		Target:  history.File{Path: session.WorkItem.Target.Path, Stats: session.WorkItem.Target.VideoStats, Size: session.WorkItem.Target.Size},
+		Profile: session.WorkItem.Profile(),
+		Backend: session.Backend,
+	}
+	if elapsed := end.Sub(start); elapsed > 0 {
+		r.Speed = session.Progress().Converted.Seconds() / elapsed.Seconds()
+	}
+	if err != nil {
+		r.Error = err.Error()
+	}
+	return r
+}
+
+// retryable returns false if ffmpeg failed in a way that retrying won't fix (e.g. the source file is corrupt).
+// Hardware decoders also report corrupt input for streams that they can't decode, so corrupt input is retried
+// if the session used the hardware backend and the next attempt uses another backend.
+func retryable(err error, backend, next string) bool {
+	if ffmpegErr, ok := errors.AsType[*ffmpeg.Error](err); ok {
+		if ffmpegErr.Kind == ffmpeg.ErrorCorruptInput && backend == HardwareBackend && next != HardwareBackend {
+			return true
+		}
+		return ffmpegErr.Kind.Retryable()
+	}
+	return true
+}
+
+func fileSize(path string) int64 {
+	if fi, err := os.Stat(path); err == nil {
+		return fi.Size()
+	}
+	return 0
 }
 
 func processSessionProgress(session *Session, progress ffmpeg.Progress) (float64, time.Duration) {
@@ -371,7 +966,9 @@ func processSessionProgress(session *Session, progress ffmpeg.Progress) (float64
 type sessionTracker struct {
 	sessions              map[*Session]struct{}
 	maxConcurrentSessions int
-	mu                    sync.Mutex
+	// borrowed counts the slots that sessions use to encode segments in parallel
+	borrowed int
+	mu       sync.Mutex
 }
 
 // allocateSession returns a new session for the workItem.
@@ -381,20 +978,83 @@ func (t *sessionTracker) allocateSession(workItem *WorkItem) (*Session, bool) {
 	defer t.mu.Unlock()
 
 	// don't exceed maximum number of concurrent transcode sessions
-	if len(t.sessions) >= t.maxConcurrentSessions {
+	if t.localSessions()+t.borrowed >= t.maxConcurrentSessions {
 		return nil, false
 	}
 
 	// add a new session and inform listeners
 	session := &Session{WorkItem: workItem}
+	session.ctx, session.cancel = context.WithCancelCause(context.Background())
 	t.sessions[session] = struct{}{}
 	return session, true
 }
 
+// acquireSlot reserves a free session slot, so an active session can use it to encode segments in parallel.
+// It returns false if no slot is available.
+func (t *sessionTracker) acquireSlot() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.localSessions()+t.borrowed >= t.maxConcurrentSessions {
+		return false
+	}
+	t.borrowed++
+	return true
+}
+
+// addRemoteSession adds a session for a remote worker. Remote sessions don't count against maxConcurrentSessions.
+func (t *sessionTracker) addRemoteSession(workItem *WorkItem, worker string, ttl time.Duration) *Session {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	session := &Session{WorkItem: workItem, Worker: worker, lease: newLease(ttl)}
+	session.ctx, session.cancel = context.WithCancelCause(context.Background())
+	t.sessions[session] = struct{}{}
+	return session
+}
+
+// leasedSession returns the remote session with the given lease ID.
+func (t *sessionTracker) leasedSession(id string) (*Session, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for session := range t.sessions {
+		if session.lease != nil && session.lease.id == id {
+			return session, true
+		}
+	}
+	return nil, false
+}
+
+// releaseSlot releases a slot reserved by acquireSlot.
+func (t *sessionTracker) releaseSlot() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.borrowed--
+}
+
+// abortSessions stops all active sessions. The cause determines how the sessions' work items are handled.
+func (t *sessionTracker) abortSessions(cause error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for session := range t.sessions {
+		session.cancel(cause)
+	}
+}
+
+// Sessions returns the active sessions, sorted by source path.
+func (t *sessionTracker) Sessions() []*Session {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	sessions := slices.Collect(maps.Keys(t.sessions))
+	slices.SortFunc(sessions, func(a, b *Session) int {
+		return strings.Compare(a.WorkItem.Source.Path, b.WorkItem.Source.Path)
+	})
+	return sessions
+}
+
 // freeSession removes the session from the tracker.
 func (t *sessionTracker) freeSession(session *Session) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
+	session.cancel(nil)
 	delete(t.sessions, session)
 }
 
@@ -405,6 +1065,24 @@ func (t *sessionTracker) SessionCount() int {
 	return len(t.sessions)
 }
 
+// localSessionCount returns the number of active sessions, excluding remote sessions.
+func (t *sessionTracker) localSessionCount() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.localSessions()
+}
+
+// localSessions returns the number of local sessions. The caller must hold the lock.
+func (t *sessionTracker) localSessions() int {
+	var count int
+	for session := range t.sessions {
+		if session.lease == nil {
+			count++
+		}
+	}
+	return count
+}
+
 ////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
 // events
 
@@ -414,13 +1092,45 @@ type newMediaEvent string
 
 type transcodeCompleteEvent struct {
 	workItem *WorkItem
+	session  *Session
+}
+
+type retryEvent struct {
+	workItem *WorkItem
+}
+
+type leaseEvent struct {
+	session *Session
 }
 
 ////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
 
 type Session struct {
-	WorkItem *WorkItem
-	progress atomic.Pointer[ffmpeg.Progress]
+	WorkItem   *WorkItem
+	ctx        context.Context
+	cancel     context.CancelCauseFunc
+	lease      *lease
+	onProgress func(ffmpeg.Progress)
+	progress   atomic.Pointer[ffmpeg.Progress]
+	Backend    string
+	// Worker is the name of the remote worker running the session. It is blank for local sessions.
+	Worker string
+	// segmentDir is the work directory of a session that is encoded in segments.
+	segmentDir      string
+	overwriteTarget bool
+}
+
+// OverwriteTarget returns true if the session overwrites an existing target file.
+func (s *Session) OverwriteTarget() bool {
+	return s.overwriteTarget
+}
+
+// LeaseID returns the ID of the remote worker's lease. It is blank for local sessions.
+func (s *Session) LeaseID() string {
+	if s.lease == nil {
+		return ""
+	}
+	return s.lease.id
 }
 
 func (s *Session) Progress() ffmpeg.Progress {
@@ -440,6 +1150,8 @@ type SessionEvent struct {
 const (
 	SessionStartedEvent EventType = iota
 	SessionStoppedEvent
+	// SourceRemovedEvent is published when the Transcoder removed the source file of a converted WorkItem.
+	SourceRemovedEvent
 )
 
 type EventType int
@@ -450,7 +1162,35 @@ func (t EventType) String() string {
 		return "SessionStartedEvent"
 	case SessionStoppedEvent:
 		return "SessionStoppedEvent"
+	case SourceRemovedEvent:
+		return "SourceRemovedEvent"
 	default:
 		return "UnknownEventType"
 	}
 }
+
+////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// A StatusEvent is published when the Transcoder changes the status of a WorkItem.
+type StatusEvent struct {
+	WorkItem *WorkItem
+	Err      error
+	Status   Status
+}
+
+////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
+// ShutdownMode determines how Shutdown handles the active transcoding sessions.
+type ShutdownMode string
+
+const (
+	// ShutdownDrain waits for the active sessions to complete.
+	ShutdownDrain ShutdownMode = "drain"
+	// ShutdownAbort stops the active sessions and removes their partial target files.
+	ShutdownAbort ShutdownMode = "abort"
+	// ShutdownDetach leaves the active sessions running.
+	ShutdownDetach ShutdownMode = "detach"
+)
+
+// ErrAborted is the error of a WorkItem whose transcoding session was stopped by Shutdown.
+var ErrAborted = errors.New("transcoding aborted")
@@ -2,11 +2,16 @@ package transcoder
 
 import (
 	"fmt"
+	"slices"
 	"strconv"
 
 	"github.com/clambin/xcoder/ffmpeg"
 )
 
+// hardwareDeinterlaceFilter deinterlaces the video. VideoToolbox's deinterlacer (yadif_videotoolbox) only accepts
+// frames in GPU memory, which the decoder doesn't produce, so this uses bwdif.
+const hardwareDeinterlaceFilter = "bwdif"
+
 func DecoderArguments(videoStats ffmpeg.VideoStats) []string {
 	switch videoStats.VideoCodec {
 	case "h264", "hevc":
@@ -23,13 +28,16 @@ func encoderArguments(videoStats ffmpeg.VideoStats) ([]string, error) {
 		if videoStats.BitsPerSample == 10 {
 			profileName = "main10"
 		}
-		return []string{
-			"-c:v", "hevc_videotoolbox",
-			"-b:v", strconv.Itoa(videoStats.BitRate),
-			"-profile:v", profileName,
-			"-c:a", "copy",
-			"-c:s", "copy",
-		}, nil
+		return slices.Concat(
+			filterArguments(videoStats, hardwareDeinterlaceFilter),
+			[]string{
+				"-c:v", "hevc_videotoolbox",
+				"-b:v", strconv.Itoa(videoStats.BitRate),
+				"-profile:v", profileName,
+			},
+			audioArguments(videoStats),
+			[]string{"-c:s", "copy"},
+		), nil
 	default:
 		return nil, fmt.Errorf("unsupported codec: %s", videoStats.VideoCodec)
 	}
@@ -4,11 +4,14 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"os"
+	"path/filepath"
 	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/clambin/xcoder/ffmpeg"
+	"github.com/clambin/xcoder/internal/history"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -136,6 +139,464 @@ func TestTranscoder_Transcode(t *testing.T) {
 	assert.Len(t, q.ItemsWithStatus(StatusSkipped), fileCount)
 }
 
+func TestTranscoder_Retry(t *testing.T) {
+	ctx, cancel := context.WithCancel(t.Context())
+	t.Cleanup(cancel)
+
+	var q WorkItems
+	item := WorkItem{
+		Source: File{Path: "test.mkv"},
+		Target: File{Path: "test.hevc.mkv"},
+	}
+	item.SetStatus(StatusScanned, nil)
+	q.Add(&item)
+
+	var cfg Configuration
+	cfg.Profile, _ = GetProfile("hevc-high")
+	cfg.Profile.Retry = RetryPolicy{MaxAttempts: 2, Backoff: scheduleInterval, Backends: []string{HardwareBackend, SoftwareBackend}}
+	cfg.History = new(history.History)
+	l := slog.New(slog.DiscardHandler)
+	transcoder := New(&q, cfg, l)
+	transcoder.controller.(*engine).probeFunc = func(path string) (ffmpeg.VideoStats, error) {
+		return ffmpeg.VideoStats{Height: 1080, BitRate: 3_000_000, VideoCodec: "hevc"}, nil
+	}
+	transcoder.controller.(*engine).transcodeFunc = func(session *Session) error {
+		if session.Backend == HardwareBackend {
+			return assert.AnError
+		}
+		return nil
+	}
+	transcoder.SetActive(true)
+	go func() { _ = transcoder.Run(ctx) }()
+
+	require.Eventually(t, func() bool {
+		status, _ := item.Status()
+		return status == StatusConverted
+	}, 5*time.Second, 10*time.Millisecond)
+
+	attempts := item.Attempts()
+	require.Len(t, attempts, 2)
+	assert.Equal(t, HardwareBackend, attempts[0].Backend)
+	assert.ErrorIs(t, attempts[0].Err, assert.AnError)
+	assert.Equal(t, SoftwareBackend, attempts[1].Backend)
+	assert.NoError(t, attempts[1].Err)
+
+	records := cfg.History.Records()
+	require.Len(t, records, 2)
+	assert.Equal(t, HardwareBackend, records[0].Backend)
+	assert.Equal(t, assert.AnError.Error(), records[0].Error)
+	assert.Equal(t, SoftwareBackend, records[1].Backend)
+	assert.True(t, records[1].Succeeded())
+	assert.Equal(t, "test.hevc.mkv", records[1].Target.Path)
+}
+
+func TestTranscoder_Retry_Exhausted(t *testing.T) {
+	ctx, cancel := context.WithCancel(t.Context())
+	t.Cleanup(cancel)
+
+	var q WorkItems
+	item := WorkItem{Source: File{Path: "test.mkv"}}
+	item.SetStatus(StatusScanned, nil)
+	q.Add(&item)
+
+	var cfg Configuration
+	cfg.Profile, _ = GetProfile("hevc-high")
+	cfg.Profile.Retry = RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond}
+	transcoder := New(&q, cfg, slog.New(slog.DiscardHandler))
+	transcoder.controller.(*engine).transcodeFunc = func(session *Session) error {
+		return assert.AnError
+	}
+	transcoder.SetActive(true)
+	go func() { _ = transcoder.Run(ctx) }()
+
+	require.Eventually(t, func() bool {
+		status, _ := item.Status()
+		return status == StatusFailed
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 3, item.AttemptCount())
+}
+
+func TestTranscoder_Overrides(t *testing.T) {
+	ctx, cancel := context.WithCancel(t.Context())
+	t.Cleanup(cancel)
+
+	var q WorkItems
+	var cfg Configuration
+	cfg.Profile, _ = GetProfile("hevc-high")
+	cfg.Overrides = new(Overrides)
+	transcoder := New(&q, cfg, slog.New(slog.DiscardHandler))
+	transcoder.controller.(*engine).probeFunc = func(path string) (ffmpeg.VideoStats, error) {
+		return ffmpeg.VideoStats{Height: 720, BitRate: 6_000_000, VideoCodec: "h264"}, nil
+	}
+	go func() { _ = transcoder.Run(ctx) }()
+
+	// hevc-high rejects 720p files
+	transcoder.AddMediaFile("file.mkv")
+	var item *WorkItem
+	require.Eventually(t, func() bool {
+		items := q.ItemsWithStatus(StatusRejected)
+		if len(items) == 1 {
+			item = items[0]
+		}
+		return item != nil
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, "hevc-high", item.Profile())
+
+	// hevc-medium accepts 720p files
+	require.NoError(t, transcoder.SetProfile(item, "hevc-medium"))
+	status, err := item.Status()
+	require.NoError(t, err)
+	assert.Equal(t, StatusScanned, status)
+	assert.Equal(t, "hevc-medium", item.Profile())
+	assert.Error(t, transcoder.SetProfile(item, "invalid"))
+
+	// back to hevc-high: rejected again, but we can force the conversion
+	require.NoError(t, transcoder.SetProfile(item, "hevc-high"))
+	status, _ = item.Status()
+	assert.Equal(t, StatusRejected, status)
+	require.NoError(t, transcoder.ForceConvert(item))
+	status, _ = item.Status()
+	assert.Equal(t, StatusQueued, status)
+	assert.Equal(t, 3_000_000, item.Target.VideoStats.BitRate)
+
+	// queued items can't be overridden
+	assert.Error(t, transcoder.ForceConvert(item))
+
+	// a rescan keeps the override
+	transcoder.AddMediaFile("file.mkv")
+	require.Eventually(t, func() bool {
+		return len(q.ItemsWithStatus(StatusScanned)) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestTranscoder_Overrides_Configuration(t *testing.T) {
+	ctx, cancel := context.WithCancel(t.Context())
+	t.Cleanup(cancel)
+
+	var q WorkItems
+	var cfg Configuration
+	cfg.Profile, _ = GetProfile("hevc-high")
+	cfg.Overrides = new(Overrides)
+	// the configured rules apply to the profiles selected by the user as well
+	cfg.GetProfile = func(name string) (Profile, error) {
+		profile, err := GetProfile(name)
+		rule, _ := ParseRule(`reject if height < 1080 because "configured rule"`)
+		profile.Rules = append([]Rule{rule}, profile.Rules...)
+		return profile, err
+	}
+	transcoder := New(&q, cfg, slog.New(slog.DiscardHandler))
+	transcoder.controller.(*engine).probeFunc = func(path string) (ffmpeg.VideoStats, error) {
+		codec := "h264"
+		if path == "unsupported.mkv" {
+			codec = "unsupported"
+		}
+		return ffmpeg.VideoStats{Height: 720, BitRate: 6_000_000, VideoCodec: codec}, nil
+	}
+	go func() { _ = transcoder.Run(ctx) }()
+
+	transcoder.AddMediaFile("file.mkv")
+	transcoder.AddMediaFile("unsupported.mkv")
+	require.Eventually(t, func() bool { return len(q.ItemsWithStatus(StatusRejected)) == 2 }, 5*time.Second, 10*time.Millisecond)
+
+	item, ok := q.Get("file.mkv")
+	require.True(t, ok)
+	require.NoError(t, transcoder.SetProfile(item, "hevc-medium"))
+	status, err := item.Status()
+	assert.Equal(t, StatusRejected, status)
+	assert.EqualError(t, err, `configured rule`)
+
+	// if the conversion can't be forced, the override isn't recorded
+	item, ok = q.Get("unsupported.mkv")
+	require.True(t, ok)
+	assert.Error(t, transcoder.ForceConvert(item))
+	override, _ := cfg.Overrides.Get("unsupported.mkv")
+	assert.False(t, override.Force)
+}
+
+func TestTranscoder_DetectInterlacing(t *testing.T) {
+	ctx, cancel := context.WithCancel(t.Context())
+	t.Cleanup(cancel)
+
+	var q WorkItems
+	var cfg Configuration
+	cfg.Profile, _ = GetProfile("hevc-high")
+	cfg.Profile.Deinterlace = DeinterlaceBwdif
+	cfg.DetectInterlacing = true
+	transcoder := New(&q, cfg, slog.New(slog.DiscardHandler))
+	transcoder.controller.(*engine).probeFunc = func(path string) (ffmpeg.VideoStats, error) {
+		return ffmpeg.VideoStats{Height: 1080, BitRate: 8_000_000, VideoCodec: "h264", Duration: time.Hour}, nil
+	}
+	var sampleStart atomic.Int64
+	transcoder.controller.(*engine).idetFunc = func(path string, start, duration time.Duration) (ffmpeg.Interlacing, error) {
+		sampleStart.Store(int64(start))
+		switch path {
+		case "interlaced.mkv":
+			return ffmpeg.Interlacing{TFF: 1000, Progressive: 10}, nil
+		case "progressive.mkv":
+			return ffmpeg.Interlacing{TFF: 10, Progressive: 1000}, nil
+		default:
+			return ffmpeg.Interlacing{}, assert.AnError
+		}
+	}
+	go func() { _ = transcoder.Run(ctx) }()
+
+	for _, file := range []string{"interlaced.mkv", "progressive.mkv", "failed.mkv"} {
+		transcoder.AddMediaFile(file)
+	}
+	require.Eventually(t, func() bool {
+		return len(q.ItemsWithStatus(StatusScanned)) == 3
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 6*time.Minute, time.Duration(sampleStart.Load()))
+
+	want := map[string][2]ffmpeg.VideoStats{
+		"interlaced.mkv": {
+			{Height: 1080, BitRate: 8_000_000, VideoCodec: "h264", Duration: time.Hour, FieldOrder: ffmpeg.FieldOrderTFF},
+			{Height: 1080, BitRate: 4_000_000, VideoCodec: "hevc", Duration: time.Hour, FieldOrder: ffmpeg.FieldOrderProgressive, Deinterlace: "bwdif"},
+		},
+		"progressive.mkv": {
+			{Height: 1080, BitRate: 8_000_000, VideoCodec: "h264", Duration: time.Hour, FieldOrder: ffmpeg.FieldOrderProgressive},
+			{Height: 1080, BitRate: 4_000_000, VideoCodec: "hevc", Duration: time.Hour, FieldOrder: ffmpeg.FieldOrderProgressive},
+		},
+		"failed.mkv": {
+			{Height: 1080, BitRate: 8_000_000, VideoCodec: "h264", Duration: time.Hour},
+			{Height: 1080, BitRate: 4_000_000, VideoCodec: "hevc", Duration: time.Hour},
+		},
+	}
+	for _, item := range q.Items() {
+		assert.Equal(t, want[item.Source.Path][0], item.Source.VideoStats, item.Source.Path)
+		assert.Equal(t, want[item.Source.Path][1], item.Target.VideoStats, item.Source.Path)
+	}
+}
+
+func TestTranscoder_DetectCrop(t *testing.T) {
+	ctx, cancel := context.WithCancel(t.Context())
+	t.Cleanup(cancel)
+
+	var q WorkItems
+	var cfg Configuration
+	cfg.Profile, _ = GetProfile("hevc-high")
+	cfg.Profile.Crop = true
+	cfg.DetectCrop = true
+	transcoder := New(&q, cfg, slog.New(slog.DiscardHandler))
+	transcoder.controller.(*engine).probeFunc = func(path string) (ffmpeg.VideoStats, error) {
+		return ffmpeg.VideoStats{Width: 1920, Height: 1080, BitRate: 8_000_000, VideoCodec: "h264", Duration: time.Hour}, nil
+	}
+	transcoder.controller.(*engine).cropFunc = func(path string, start, duration time.Duration) (ffmpeg.Crop, error) {
+		switch path {
+		case "letterboxed (2020).mkv":
+			// a dark scene at 30% looks like it has larger black bars
+			if start == 18*time.Minute {
+				return ffmpeg.Crop{Width: 1800, Height: 600, X: 60, Y: 240}, nil
+			}
+			return ffmpeg.Crop{Width: 1920, Height: 800, Y: 140}, nil
+		case "noise (2020).mkv":
+			return ffmpeg.Crop{Width: 1920, Height: 1072, Y: 4}, nil
+		case "black (2020).mkv":
+			return ffmpeg.Crop{}, nil
+		default:
+			return ffmpeg.Crop{}, assert.AnError
+		}
+	}
+	go func() { _ = transcoder.Run(ctx) }()
+
+	want := map[string]struct {
+		target string
+		crop   ffmpeg.Crop
+		height int
+	}{
+		"letterboxed (2020).mkv": {target: "letterboxed (2020).800.hevc.mkv", crop: ffmpeg.Crop{Width: 1920, Height: 800, Y: 140}, height: 800},
+		"noise (2020).mkv":       {target: "noise (2020).1080.hevc.mkv", height: 1080},
+		"black (2020).mkv":       {target: "black (2020).1080.hevc.mkv", height: 1080},
+		"failed (2020).mkv":      {target: "failed (2020).1080.hevc.mkv", height: 1080},
+	}
+	for file := range want {
+		transcoder.AddMediaFile(file)
+	}
+	require.Eventually(t, func() bool {
+		return len(q.ItemsWithStatus(StatusScanned)) == len(want)
+	}, 5*time.Second, 10*time.Millisecond)
+
+	for _, item := range q.Items() {
+		w := want[item.Source.Path]
+		assert.Equal(t, w.crop, item.Source.VideoStats.Crop, item.Source.Path)
+		assert.Equal(t, w.crop, item.Target.VideoStats.Crop, item.Source.Path)
+		assert.Equal(t, w.height, item.Target.VideoStats.Height, item.Source.Path)
+		assert.Equal(t, w.target, item.Target.Path, item.Source.Path)
+	}
+}
+
+func TestTranscoder_SubscribeStatus(t *testing.T) {
+	ctx, cancel := context.WithCancel(t.Context())
+	t.Cleanup(cancel)
+
+	var q WorkItems
+	var cfg Configuration
+	cfg.Profile, _ = GetProfile("hevc-high")
+	transcoder := New(&q, cfg, slog.New(slog.DiscardHandler))
+	transcoder.controller.(*engine).probeFunc = func(path string) (ffmpeg.VideoStats, error) {
+		return ffmpeg.VideoStats{Height: 1080, BitRate: 8_000_000, VideoCodec: "h264"}, nil
+	}
+	ch := transcoder.SubscribeStatus()
+	go func() { _ = transcoder.Run(ctx) }()
+
+	transcoder.AddMediaFile("file.mkv")
+	for _, want := range []Status{StatusFound, StatusScanning, StatusScanned} {
+		ev := <-ch
+		assert.Equal(t, want, ev.Status)
+		assert.Equal(t, "file.mkv", ev.WorkItem.Source.Path)
+	}
+	transcoder.UnsubscribeStatus(ch)
+}
+
+func TestTranscoder_Shutdown(t *testing.T) {
+	tests := []struct {
+		name        string
+		mode        ShutdownMode
+		wantStatus  Status
+		wantTarget  bool
+		wantRecords int
+	}{
+		{name: "drain", mode: ShutdownDrain, wantStatus: StatusConverted, wantTarget: true, wantRecords: 1},
+		// aborted sessions don't count as an attempt
+		{name: "abort", mode: ShutdownAbort, wantStatus: StatusFailed, wantTarget: false, wantRecords: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(t.Context())
+			t.Cleanup(cancel)
+
+			tmpDir := t.TempDir()
+			var q WorkItems
+			items := make([]*WorkItem, 2)
+			for i := range items {
+				items[i] = &WorkItem{
+					Source: File{Path: filepath.Join(tmpDir, fmt.Sprintf("test_%d.mkv", i))},
+					Target: File{Path: filepath.Join(tmpDir, fmt.Sprintf("test_%d.hevc.mkv", i))},
+				}
+				items[i].SetStatus(StatusScanned, nil)
+				q.Add(items[i])
+			}
+
+			var cfg Configuration
+			cfg.Profile, _ = GetProfile("hevc-high")
+			cfg.History = new(history.History)
+			transcoder := New(&q, cfg, slog.New(slog.DiscardHandler))
+			transcoder.controller.(*engine).sessionTracker.maxConcurrentSessions = 1
+			transcoder.controller.(*engine).probeFunc = func(path string) (ffmpeg.VideoStats, error) {
+				return ffmpeg.VideoStats{Height: 1080, BitRate: 3_000_000, VideoCodec: "hevc"}, nil
+			}
+			release := make(chan struct{})
+			transcoder.controller.(*engine).transcodeFunc = func(session *Session) error {
+				// write a partial target file and wait until we're released or aborted
+				_ = os.WriteFile(session.WorkItem.Target.Path, []byte("partial"), 0o644)
+				select {
+				case <-release:
+					return nil
+				case <-session.ctx.Done():
+					return session.ctx.Err()
+				}
+			}
+			transcoder.SetActive(true)
+			go func() { _ = transcoder.Run(ctx) }()
+
+			require.Eventually(t, func() bool { return transcoder.SessionCount() == 1 }, 5*time.Second, 10*time.Millisecond)
+
+			errCh := make(chan error)
+			go func() { errCh <- transcoder.Shutdown(t.Context(), tt.mode) }()
+			if tt.mode == ShutdownDrain {
+				// drain waits for the active session to complete
+				assert.Never(t, func() bool { return len(errCh) > 0 }, 3*scheduleInterval, scheduleInterval)
+				close(release)
+			}
+			require.NoError(t, <-errCh)
+
+			status, _ := items[0].Status()
+			assert.Equal(t, tt.wantStatus, status)
+			_, err := os.Stat(items[0].Target.Path)
+			assert.Equal(t, tt.wantTarget, err == nil)
+			assert.Len(t, cfg.History.Records(), tt.wantRecords)
+			assert.Equal(t, tt.wantRecords, items[0].AttemptCount())
+
+			// no new sessions are started
+			time.Sleep(3 * scheduleInterval)
+			status, _ = items[1].Status()
+			assert.Equal(t, StatusScanned, status)
+			assert.Zero(t, transcoder.SessionCount())
+		})
+	}
+
+	var cfg Configuration
+	assert.Error(t, New(&WorkItems{}, cfg, slog.New(slog.DiscardHandler)).Shutdown(t.Context(), "invalid"))
+}
+
+func TestTranscoder_Queue(t *testing.T) {
+	var q WorkItems
+	var cfg Configuration
+	cfg.Profile, _ = GetProfile("hevc-high")
+	transcoder := New(&q, cfg, slog.New(slog.DiscardHandler))
+	ch := transcoder.SubscribeStatus()
+	t.Cleanup(func() { transcoder.UnsubscribeStatus(ch) })
+
+	item := WorkItem{Source: File{Path: "file.mkv"}}
+	assert.Error(t, transcoder.Queue(&item))
+	assert.Error(t, transcoder.Dequeue(&item))
+
+	item.SetStatus(StatusScanned, nil)
+	for _, tt := range []struct {
+		f    func(*WorkItem) error
+		want Status
+	}{
+		{f: transcoder.Queue, want: StatusQueued},
+		{f: transcoder.Dequeue, want: StatusScanned},
+	} {
+		go func() { assert.NoError(t, tt.f(&item)) }()
+		ev := <-ch
+		assert.Equal(t, tt.want, ev.Status)
+		status, _ := item.Status()
+		assert.Equal(t, tt.want, status)
+	}
+
+	// queueing a work item again clears its attempts
+	item.addAttempt(Attempt{Backend: HardwareBackend, Err: assert.AnError})
+	go func() { assert.NoError(t, transcoder.Queue(&item)) }()
+	<-ch
+	assert.Zero(t, item.AttemptCount())
+}
+
+func TestTranscoder_Retry_Profile(t *testing.T) {
+	ctx, cancel := context.WithCancel(t.Context())
+	t.Cleanup(cancel)
+
+	var q WorkItems
+	item := WorkItem{Source: File{Path: "test.mkv"}}
+	item.SetStatus(StatusScanned, nil)
+	item.setProfile("hevc-medium")
+	q.Add(&item)
+
+	// the work item was analyzed by hevc-medium, which doesn't retry
+	var cfg Configuration
+	cfg.Profile, _ = GetProfile("hevc-high")
+	cfg.Profile.Retry = RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond}
+	cfg.GetProfile = func(name string) (Profile, error) {
+		profile, err := GetProfile(name)
+		profile.Retry = RetryPolicy{MaxAttempts: 1}
+		return profile, err
+	}
+	transcoder := New(&q, cfg, slog.New(slog.DiscardHandler))
+	transcoder.controller.(*engine).transcodeFunc = func(session *Session) error {
+		return assert.AnError
+	}
+	transcoder.SetActive(true)
+	go func() { _ = transcoder.Run(ctx) }()
+
+	require.Eventually(t, func() bool {
+		status, _ := item.Status()
+		return status == StatusFailed
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 1, item.AttemptCount())
+}
+
 func Test_processSessionProgress(t *testing.T) {
 	tests := []struct {
 		name          string
@@ -183,3 +644,62 @@ func Test_processSessionProgress(t *testing.T) {
 		})
 	}
 }
+
+func TestTranscoder_Retry_NotRetryable(t *testing.T) {
+	ctx, cancel := context.WithCancel(t.Context())
+	t.Cleanup(cancel)
+
+	var q WorkItems
+	item := WorkItem{Source: File{Path: "test.mkv"}}
+	item.SetStatus(StatusScanned, nil)
+	q.Add(&item)
+
+	var cfg Configuration
+	cfg.Profile, _ = GetProfile("hevc-high")
+	cfg.Profile.Retry = RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond}
+	transcoder := New(&q, cfg, slog.New(slog.DiscardHandler))
+	transcoder.controller.(*engine).transcodeFunc = func(session *Session) error {
+		// retrying won't fix corrupt input
+		return &ffmpeg.Error{Command: "ffmpeg", Err: assert.AnError, Kind: ffmpeg.ErrorCorruptInput}
+	}
+	transcoder.SetActive(true)
+	go func() { _ = transcoder.Run(ctx) }()
+
+	require.Eventually(t, func() bool {
+		status, _ := item.Status()
+		return status == StatusFailed
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 1, item.AttemptCount())
+}
+
+func TestTranscoder_Retry_CorruptInputOnHardware(t *testing.T) {
+	ctx, cancel := context.WithCancel(t.Context())
+	t.Cleanup(cancel)
+
+	var q WorkItems
+	item := WorkItem{Source: File{Path: "test.mkv"}}
+	item.SetStatus(StatusScanned, nil)
+	q.Add(&item)
+
+	var cfg Configuration
+	cfg.Profile, _ = GetProfile("hevc-high")
+	cfg.Profile.Retry = RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond, Backends: []string{HardwareBackend, SoftwareBackend}}
+	transcoder := New(&q, cfg, slog.New(slog.DiscardHandler))
+	var backends []string
+	transcoder.controller.(*engine).transcodeFunc = func(session *Session) error {
+		backends = append(backends, session.Backend)
+		// the hardware decoder can't decode the source, but the software decoder can
+		if session.Backend == HardwareBackend {
+			return &ffmpeg.Error{Command: "ffmpeg", Err: assert.AnError, Kind: ffmpeg.ErrorCorruptInput}
+		}
+		return nil
+	}
+	transcoder.SetActive(true)
+	go func() { _ = transcoder.Run(ctx) }()
+
+	require.Eventually(t, func() bool {
+		status, _ := item.Status()
+		return status == StatusConverted
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, []string{HardwareBackend, SoftwareBackend}, backends)
+}
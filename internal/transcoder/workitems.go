@@ -4,6 +4,7 @@ import (
 	"slices"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/clambin/xcoder/ffmpeg"
 )
@@ -21,6 +22,7 @@ const (
 	StatusTranscoding
 	StatusConverted
 	StatusFailed
+	StatusRetrying
 )
 
 var statusStrings = map[Status]string{
@@ -34,6 +36,7 @@ var statusStrings = map[Status]string{
 	StatusTranscoding: "transcoding",
 	StatusFailed:      "failed",
 	StatusConverted:   "converted",
+	StatusRetrying:    "retrying",
 }
 
 func (s Status) String() string {
@@ -46,14 +49,26 @@ func (s Status) String() string {
 type File struct {
 	Path       string
 	VideoStats ffmpeg.VideoStats
+	// Size is the size of the file in bytes. It is recorded when the source is scanned and when the target is converted.
+	Size int64
+}
+
+// An Attempt records one transcoding session of a WorkItem.
+type Attempt struct {
+	Start    time.Time
+	Err      error
+	Backend  string
+	Duration time.Duration
 }
 
 type WorkItem struct {
-	err    error
-	Source File
-	Target File
-	status Status
-	mu     sync.Mutex
+	err      error
+	Source   File
+	Target   File
+	profile  string
+	attempts []Attempt
+	status   Status
+	mu       sync.Mutex
 }
 
 func (w *WorkItem) Status() (status Status, err error) {
@@ -69,6 +84,76 @@ func (w *WorkItem) SetStatus(status Status, err error) {
 	w.err = err
 }
 
+// setStatusIf sets the status if the current status is one of the given statuses.
+// It returns the current status and whether the status was changed.
+func (w *WorkItem) setStatusIf(status Status, err error, current ...Status) (Status, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !slices.Contains(current, w.status) {
+		return w.status, false
+	}
+	w.status = status
+	w.err = err
+	return status, true
+}
+
+// Profile returns the name of the profile used to analyze the WorkItem.
+func (w *WorkItem) Profile() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.profile
+}
+
+func (w *WorkItem) setProfile(profile string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.profile = profile
+}
+
+// EstimatedSize returns the expected size of the target file, based on the target bitrate.
+// Once the WorkItem is converted, it returns the actual size of the target file.
+func (w *WorkItem) EstimatedSize() int64 {
+	if w.Target.Size > 0 {
+		return w.Target.Size
+	}
+	return int64(float64(w.Target.VideoStats.BitRate) * w.Source.VideoStats.Duration.Seconds() / 8)
+}
+
+// Saved returns the difference in size between the source and target file of a converted WorkItem.
+// For any other WorkItem, it returns zero.
+func (w *WorkItem) Saved() int64 {
+	if status, _ := w.Status(); status != StatusConverted || w.Target.Size == 0 {
+		return 0
+	}
+	return w.Source.Size - w.Target.Size
+}
+
+// Attempts returns all transcoding attempts of the WorkItem, oldest first.
+func (w *WorkItem) Attempts() []Attempt {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return slices.Clone(w.attempts)
+}
+
+// AttemptCount returns the number of transcoding attempts of the WorkItem.
+func (w *WorkItem) AttemptCount() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.attempts)
+}
+
+func (w *WorkItem) addAttempt(attempt Attempt) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.attempts = append(w.attempts, attempt)
+}
+
+func (w *WorkItem) resetAttempts() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.attempts = nil
+}
+
 type WorkItems struct {
 	items []*WorkItem
 	mu    sync.Mutex
@@ -109,6 +194,19 @@ func (q *WorkItems) Items() []*WorkItem {
 	return slices.Clone(q.items)
 }
 
+// Get returns the WorkItem for the source file at path.
+func (q *WorkItems) Get(path string) (*WorkItem, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	idx := slices.IndexFunc(q.items, func(item *WorkItem) bool {
+		return item.Source.Path == path
+	})
+	if idx == -1 {
+		return nil, false
+	}
+	return q.items[idx], true
+}
+
 func (q *WorkItems) ItemsWithStatus(status Status) []*WorkItem {
 	workItems := q.Items()
 	return slices.DeleteFunc(workItems, func(item *WorkItem) bool {
@@ -4,7 +4,9 @@ import (
 	"fmt"
 	"slices"
 	"testing"
+	"time"
 
+	"github.com/clambin/xcoder/ffmpeg"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -16,6 +18,21 @@ func TestStatus_String(t *testing.T) {
 	assert.Equal(t, "unknown", Status(-1).String())
 }
 
+func TestWorkItem_Sizes(t *testing.T) {
+	item := WorkItem{
+		Source: File{Path: "foo.mkv", Size: 4_000_000_000, VideoStats: ffmpeg.VideoStats{BitRate: 8_000_000, Duration: time.Hour}},
+		Target: File{Path: "foo.hevc.mkv", VideoStats: ffmpeg.VideoStats{BitRate: 4_000_000}},
+		status: StatusScanned,
+	}
+	assert.Equal(t, int64(1_800_000_000), item.EstimatedSize())
+	assert.Zero(t, item.Saved())
+
+	item.Target.Size = 1_500_000_000
+	item.status = StatusConverted
+	assert.Equal(t, int64(1_500_000_000), item.EstimatedSize())
+	assert.Equal(t, int64(2_500_000_000), item.Saved())
+}
+
 func TestWorkItems(t *testing.T) {
 	items := []*WorkItem{
 		{Source: File{Path: "file1.mp4"}, status: StatusScanned},
@@ -8,6 +8,7 @@ import (
 
 type KeyMap struct {
 	RootKeyMap
+	QuitPromptKeyMap
 	LogViewerKeyMap
 	MediaViewerKeyMap
 }
@@ -28,6 +29,12 @@ func DefaultKeyMap() KeyMap {
 				key.WithHelp("?/f1", "toggle help"),
 			),
 		},
+		QuitPromptKeyMap: QuitPromptKeyMap{
+			Drain:  key.NewBinding(key.WithKeys("d"), key.WithHelp("d", "drain")),
+			Abort:  key.NewBinding(key.WithKeys("a"), key.WithHelp("a", "abort")),
+			Detach: key.NewBinding(key.WithKeys("x"), key.WithHelp("x", "detach")),
+			Cancel: key.NewBinding(key.WithKeys("esc"), key.WithHelp("esc", "cancel")),
+		},
 		LogViewerKeyMap: LogViewerKeyMap{
 			WordWrap:   key.NewBinding(key.WithKeys("w"), key.WithHelp("w", "wrap words")),
 			AutoScroll: key.NewBinding(key.WithKeys("s"), key.WithHelp("s", "auto scroll")),
@@ -35,10 +42,13 @@ func DefaultKeyMap() KeyMap {
 		},
 		MediaViewerKeyMap: MediaViewerKeyMap{
 			ShowFullPath:       key.NewBinding(key.WithKeys("f"), key.WithHelp("f", "toggle full file path")),
+			ShowError:          key.NewBinding(key.WithKeys("e"), key.WithHelp("e", "show error details")),
 			HideSkippedFiles:   key.NewBinding(key.WithKeys("s"), key.WithHelp("s", "toggle skipped files")),
 			HideRejectedFiles:  key.NewBinding(key.WithKeys("r"), key.WithHelp("r", "toggle rejected files")),
 			HideConvertedFiles: key.NewBinding(key.WithKeys("c"), key.WithHelp("c", "toggle converted files")),
 			ConvertSelected:    key.NewBinding(key.WithKeys("enter"), key.WithHelp("enter", "convert selected file")),
+			ForceConvert:       key.NewBinding(key.WithKeys("F"), key.WithHelp("F", "force convert file")),
+			NextProfile:        key.NewBinding(key.WithKeys("p"), key.WithHelp("p", "change file profile")),
 			AutoProcess:        key.NewBinding(key.WithKeys("a"), key.WithHelp("a", "toggle batch processing")),
 			FilterTableKeyMap:  table.DefaultFilterTableKeyMap(),
 		},
@@ -47,6 +57,7 @@ func DefaultKeyMap() KeyMap {
 
 var (
 	_ help.KeyMap = RootKeyMap{}
+	_ help.KeyMap = QuitPromptKeyMap{}
 	_ help.KeyMap = LogViewerKeyMap{}
 	_ help.KeyMap = MediaViewerKeyMap{}
 )
@@ -65,6 +76,22 @@ func (r RootKeyMap) FullHelp() [][]key.Binding {
 	return [][]key.Binding{{r.Quit, r.Help, r.Logs}}
 }
 
+// QuitPromptKeyMap holds the choices when quitting the application while transcoding sessions are active.
+type QuitPromptKeyMap struct {
+	Drain  key.Binding
+	Abort  key.Binding
+	Detach key.Binding
+	Cancel key.Binding
+}
+
+func (q QuitPromptKeyMap) ShortHelp() []key.Binding {
+	return []key.Binding{q.Drain, q.Abort, q.Detach, q.Cancel}
+}
+
+func (q QuitPromptKeyMap) FullHelp() [][]key.Binding {
+	return [][]key.Binding{q.ShortHelp()}
+}
+
 type LogViewerKeyMap struct {
 	WordWrap   key.Binding
 	AutoScroll key.Binding
@@ -81,10 +108,13 @@ func (l LogViewerKeyMap) FullHelp() [][]key.Binding {
 
 type MediaViewerKeyMap struct {
 	ShowFullPath       key.Binding
+	ShowError          key.Binding
 	HideSkippedFiles   key.Binding
 	HideRejectedFiles  key.Binding
 	HideConvertedFiles key.Binding
 	ConvertSelected    key.Binding
+	ForceConvert       key.Binding
+	NextProfile        key.Binding
 	AutoProcess        key.Binding
 	table.FilterTableKeyMap
 }
@@ -100,10 +130,13 @@ func (l MediaViewerKeyMap) ShortHelp() []key.Binding {
 func (l MediaViewerKeyMap) FullHelp() [][]key.Binding {
 	return append([][]key.Binding{{
 		l.ShowFullPath,
+		l.ShowError,
 		l.HideSkippedFiles,
 		l.HideRejectedFiles,
 		l.HideConvertedFiles,
 		l.ConvertSelected,
+		l.ForceConvert,
+		l.NextProfile,
 		l.AutoProcess,
 	}}, l.FilterKeyMap.FullHelp()...)
 }
@@ -1,6 +1,7 @@
 package ui
 
 import (
+	"errors"
 	"fmt"
 	"path/filepath"
 	"slices"
@@ -16,6 +17,7 @@ import (
 	"codeberg.org/clambin/bubbles/helper"
 	"codeberg.org/clambin/bubbles/table"
 	"codeberg.org/clambin/bubbles/ticker"
+	"github.com/clambin/xcoder/ffmpeg"
 	"github.com/clambin/xcoder/internal/transcoder"
 )
 
@@ -95,11 +97,17 @@ func (v mediaViewer) helpSections() []helper.Section {
 
 var statusTransformer = table.CellStyle{Style: lipgloss.NewStyle().Transform(table.StringStyler(statusStyles))}
 
+var sizeStyle = table.CellStyle{Style: lipgloss.NewStyle().Align(lipgloss.Right)}
+
 var workItemsColumns = []table.Column{
 	{Name: "Source"},
 	{Name: "Source Stats", Width: 20},
 	{Name: "Target Stats", Width: 20},
+	{Name: "Size", Width: 8, CellStyle: sizeStyle},
+	{Name: "Estimate", Width: 8, CellStyle: sizeStyle},
+	{Name: "Saved", Width: 8, CellStyle: sizeStyle},
 	{Name: "Status", Width: 12, CellStyle: statusTransformer},
+	{Name: "Attempts", Width: 18},
 	{Name: "Error"},
 }
 
@@ -117,6 +125,7 @@ type workItemsViewer struct {
 	totalRowCount        int
 	mediaFilterState     mediaFilterState
 	showFullPath         bool
+	showError            bool
 	mediaTableFilterIsOn bool
 }
 
@@ -141,6 +150,10 @@ func (v workItemsViewer) Update(msg tea.Msg) (workItemsViewer, tea.Cmd) {
 	case setRowsMsg:
 		v.totalRowCount = msg.totalRowCount
 		v.FilterTable = v.Rows(msg.rows)
+		if _, ok := v.selectedWorkItem(); !ok {
+			// the work item is no longer shown: close the error window
+			v.showError = false
+		}
 		return v, nil
 	case table.FilterStateChangeMsg:
 		v.mediaTableFilterIsOn = msg.State
@@ -159,7 +172,18 @@ func (v workItemsViewer) Update(msg tea.Msg) (workItemsViewer, tea.Cmd) {
 		if v.mediaTableFilterIsOn {
 			break
 		}
+		if v.showError {
+			// the error window is open: any key closes it
+			v.showError = false
+			return v, nil
+		}
 		switch {
+		case key.Matches(msg, v.keyMap.ShowError):
+			if item, ok := v.selectedWorkItem(); ok {
+				_, err := item.Status()
+				v.showError = err != nil
+			}
+			return v, nil
 		case key.Matches(msg, v.keyMap.ShowFullPath):
 			v.showFullPath = !v.showFullPath
 			return v, refreshTableCmd(v.workItems.Items(), v.mediaFilterState, v.showFullPath)
@@ -176,19 +200,23 @@ func (v workItemsViewer) Update(msg tea.Msg) (workItemsViewer, tea.Cmd) {
 			v.transcoder.SetActive(!v.transcoder.Active())
 			return v, nil
 		case key.Matches(msg, v.keyMap.ConvertSelected):
-			if row := v.SelectedRow(); row != nil {
-				userData := row[len(row)-1].(table.UserData)
-				item, ok := userData.Data.(*transcoder.WorkItem)
-				if !ok {
-					panic("selected row is not a work item")
-				}
-				status, _ := item.Status()
-				if status != transcoder.StatusScanned {
-					return v, nil
-				}
-				item.SetStatus(transcoder.StatusQueued, nil)
+			if item, ok := v.selectedWorkItem(); ok {
+				// only scanned items can be queued
+				_ = v.transcoder.Queue(item)
 			}
 			return v, nil
+		case key.Matches(msg, v.keyMap.ForceConvert):
+			var err error
+			if item, ok := v.selectedWorkItem(); ok {
+				err = v.transcoder.ForceConvert(item)
+			}
+			return v, tea.Batch(errorMessageCmd(err), refreshTableCmd(v.workItems.Items(), v.mediaFilterState, v.showFullPath))
+		case key.Matches(msg, v.keyMap.NextProfile):
+			var err error
+			if item, ok := v.selectedWorkItem(); ok {
+				err = v.transcoder.SetProfile(item, nextProfile(item.Profile()))
+			}
+			return v, tea.Batch(errorMessageCmd(err), refreshTableCmd(v.workItems.Items(), v.mediaFilterState, v.showFullPath))
 		}
 	}
 	var cmd tea.Cmd
@@ -197,6 +225,11 @@ func (v workItemsViewer) Update(msg tea.Msg) (workItemsViewer, tea.Cmd) {
 }
 
 func (v workItemsViewer) View() string {
+	if v.showError {
+		if item, ok := v.selectedWorkItem(); ok {
+			return v.errorView(item)
+		}
+	}
 	content := v.FilterTable.View()
 	borderWidth, borderHeight := v.styles.FrameStyle.BorderSize()
 	if borderWidth > 0 || borderHeight > 0 {
@@ -218,6 +251,38 @@ func (v workItemsViewer) SetSize(width, height int) workItemsViewer {
 	return v
 }
 
+// errorView shows the full error of the selected work item, including the output that ffmpeg wrote to stderr.
+func (v workItemsViewer) errorView(item *transcoder.WorkItem) string {
+	_, err := item.Status()
+	borderWidth, borderHeight := v.styles.FrameStyle.BorderSize()
+	content := lipgloss.NewStyle().
+		Width(max(0, v.width-borderWidth)).
+		Height(max(0, v.height-borderHeight)).
+		MaxHeight(max(0, v.height-borderHeight)).
+		Render(errorDetails(err))
+	return frame.Render("error: "+filepath.Base(item.Source.Path), lipgloss.Center, v.styles.FrameStyle, content)
+}
+
+// selectedWorkItem returns the WorkItem of the selected row.
+func (v workItemsViewer) selectedWorkItem() (*transcoder.WorkItem, bool) {
+	row := v.SelectedRow()
+	if row == nil {
+		return nil, false
+	}
+	userData := row[len(row)-1].(table.UserData)
+	item, ok := userData.Data.(*transcoder.WorkItem)
+	if !ok {
+		panic("selected row is not a work item")
+	}
+	return item, true
+}
+
+// nextProfile returns the name of the profile that follows the given profile in the list of supported profiles.
+func nextProfile(profile string) string {
+	profiles := transcoder.SupportedProfiles()
+	return profiles[(slices.Index(profiles, profile)+1)%len(profiles)]
+}
+
 // buildTitle builds the title for the workItemsViewer.
 func (v workItemsViewer) buildTitle() string {
 	title := "media files"
@@ -256,19 +321,56 @@ func itemToRow(item *transcoder.WorkItem, showFullPath bool) table.Row {
 	status, err := item.Status()
 	var errString string
 	if err != nil {
-		errString = err.Error()
+		errString = errorReason(err)
 	}
 
 	return table.Row{
 		source,
 		item.Source.VideoStats.String(),
 		item.Target.VideoStats.String(),
+		sizeToString(item.Source.Size),
+		sizeToString(item.EstimatedSize()),
+		sizeToString(item.Saved()),
 		status.String(),
+		attemptsToString(item.Attempts()),
 		errString,
 		table.UserData{Data: item},
 	}
 }
 
+// errorReason returns a short description of the error. For ffmpeg errors, this omits the output that ffmpeg wrote to stderr.
+func errorReason(err error) string {
+	if ffmpegErr, ok := errors.AsType[*ffmpeg.Error](err); ok {
+		return ffmpegErr.Reason()
+	}
+	return err.Error()
+}
+
+// errorDetails returns the full description of the error, with the output that ffmpeg wrote to stderr on separate lines.
+func errorDetails(err error) string {
+	if ffmpegErr, ok := errors.AsType[*ffmpeg.Error](err); ok {
+		return ffmpegErr.Reason() + "\n\n" + ffmpegErr.Command + ": " + ffmpegErr.Err.Error() + "\n" + ffmpegErr.Stderr
+	}
+	return err.Error()
+}
+
+// sizeToString formats a file size. Unknown sizes are left blank.
+func sizeToString(size int64) string {
+	if size == 0 {
+		return ""
+	}
+	return ffmpeg.Bytes(size).Format(1)
+}
+
+// attemptsToString returns the backends used by each transcoding attempt
+func attemptsToString(attempts []transcoder.Attempt) string {
+	backends := make([]string, len(attempts))
+	for i, attempt := range attempts {
+		backends[i] = attempt.Backend
+	}
+	return strings.Join(backends, "→")
+}
+
 // mediaFilterState holds the current state of the mediaFilter. It determines which media files should be shown/hidden.
 type mediaFilterState struct {
 	hideSkipped   bool
@@ -410,6 +512,10 @@ func (s *transcodeSession) view() string {
 	etaLabel := fmt.Sprintf(" ETA: %-8s", (time.Duration(eta) * time.Second).String())
 	remainingWidth -= lipgloss.Width(etaLabel)
 
+	// output
+	output := outputStats(p, s.session.WorkItem.Source.VideoStats.Duration, s.session.WorkItem.Source.Size)
+	remainingWidth -= lipgloss.Width(output)
+
 	// now that we have all the fixed-size labels, we can trim the filename if necessary
 	filename := filepath.Base(s.session.WorkItem.Source.Path)
 	if len(filename) > remainingWidth {
@@ -421,9 +527,25 @@ func (s *transcodeSession) view() string {
 		speed,
 		prog,
 		etaLabel,
+		output,
 	)
 }
 
+// outputStats shows the output bitrate, the bytes written so far and the projected size of the target file,
+// extrapolated from the part of the source that has been converted. The savings compare the projected size
+// to the size of the source file.
+func outputStats(p ffmpeg.Progress, duration time.Duration, sourceSize int64) string {
+	var bitrate, savings string
+	if p.Bitrate > 0 {
+		bitrate = ffmpeg.Bits(p.Bitrate).Format(1)
+	}
+	projected := p.ProjectedSize(duration)
+	if projected > 0 && sourceSize > 0 {
+		savings = fmt.Sprintf("%+.0f%%", 100*float64(projected-sourceSize)/float64(sourceSize))
+	}
+	return fmt.Sprintf(" Bitrate: %-10s Size: %8s → %-8s %5s", bitrate, sizeToString(p.TotalSize), sizeToString(projected), savings)
+}
+
 func ltrim(s string, n int, trim rune) string {
 	if n == 0 {
 		return ""
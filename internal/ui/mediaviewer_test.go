@@ -1,14 +1,19 @@
 package ui
 
 import (
+	"errors"
+	"fmt"
 	"testing"
+	"time"
 
 	tea "charm.land/bubbletea/v2"
 	"charm.land/lipgloss/v2"
 	"codeberg.org/clambin/bubbles/table"
 	"github.com/charmbracelet/x/exp/golden"
+	"github.com/clambin/xcoder/ffmpeg"
 	"github.com/clambin/xcoder/internal/transcoder"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestMediaViewer_View(t *testing.T) {
@@ -37,7 +42,7 @@ func TestWorkItemsViewer_View(t *testing.T) {
 			v := workItemsViewer{
 				FilterTable: table.NewFilterTable().Columns(workItemsColumns),
 				styles:      DefaultStyles().MediaViewerItemStyles,
-			}.SetSize(100, 10)
+			}.SetSize(120, 10)
 
 			v, _ = v.Update(refreshTableCmd(q.Items(), tt.mediaFilterState, true)())
 			golden.RequireEqual(t, v.View())
@@ -45,6 +50,67 @@ func TestWorkItemsViewer_View(t *testing.T) {
 	}
 }
 
+func TestWorkItemsViewer_Error(t *testing.T) {
+	var q transcoder.WorkItems
+	item := transcoder.WorkItem{Source: transcoder.File{Path: "/media/foo.mkv"}}
+	item.SetStatus(transcoder.StatusFailed, fmt.Errorf("segment 1: %w", &ffmpeg.Error{
+		Command: "ffmpeg",
+		Err:     errors.New("exit status 1"),
+		Kind:    ffmpeg.ErrorCorruptInput,
+		Stderr:  "[matroska,webm @ 0x1234] EBML header parsing failed\n/media/foo.mkv: Invalid data found when processing input",
+	}))
+	q.Add(&item)
+
+	// the table only shows the reason
+	row := itemToRow(&item, false)
+	assert.Equal(t, "corrupt input", row[8])
+
+	v := workItemsViewer{
+		FilterTable: table.NewFilterTable().Columns(workItemsColumns),
+		workItems:   &q,
+		styles:      DefaultStyles().MediaViewerItemStyles,
+		keyMap:      DefaultKeyMap().MediaViewerKeyMap,
+	}.SetSize(120, 10)
+	v, _ = v.Update(refreshTableCmd(q.Items(), mediaFilterState{}, false)())
+
+	// show the full error
+	v, _ = v.Update(tea.KeyPressMsg{Code: 'e', Text: "e"})
+	require.True(t, v.showError)
+	golden.RequireEqual(t, v.View())
+
+	// any key closes the error window
+	v, _ = v.Update(tea.KeyPressMsg{Code: tea.KeyEscape})
+	assert.False(t, v.showError)
+
+	// the error window closes if the work item is no longer shown
+	v, _ = v.Update(tea.KeyPressMsg{Code: 'e', Text: "e"})
+	require.True(t, v.showError)
+	q.Remove(&item)
+	v, _ = v.Update(refreshTableCmd(q.Items(), mediaFilterState{}, false)())
+	assert.False(t, v.showError)
+	assert.NotPanics(t, func() { _ = v.View() })
+}
+
+func TestWorkItemsViewer_Override(t *testing.T) {
+	q := generateWorkItems()
+	tr := fakeTranscoder{overrideErr: errors.New("cannot override: status is queued")}
+	v := workItemsViewer{
+		FilterTable: table.NewFilterTable().Columns(workItemsColumns),
+		workItems:   q,
+		transcoder:  &tr,
+		keyMap:      DefaultKeyMap().MediaViewerKeyMap,
+	}.SetSize(120, 10)
+	v, _ = v.Update(refreshTableCmd(q.Items(), mediaFilterState{}, false)())
+
+	// if the transcoder can't apply the override, the status line shows why
+	for _, msg := range []tea.KeyPressMsg{{Code: 'F', Text: "F"}, {Code: 'p', Text: "p"}} {
+		var cmd tea.Cmd
+		v, cmd = v.Update(msg)
+		require.NotNil(t, cmd)
+		assert.Contains(t, flattenBatchCmd(cmd()), statusMessageMsg{text: "cannot override: status is queued"}, msg.Text)
+	}
+}
+
 func TestTranscodeSessionsViewer(t *testing.T) {
 	v := transcodeSessionsViewer{}.Width(100)
 
@@ -74,6 +140,29 @@ func TestTranscodeSessionsViewer(t *testing.T) {
 	assert.Empty(t, v.View())
 }
 
+func Test_outputStats(t *testing.T) {
+	tests := []struct {
+		name     string
+		progress ffmpeg.Progress
+		want     string
+	}{
+		{
+			name: "no output yet",
+			want: " Bitrate:            Size:          →               ",
+		},
+		{
+			name:     "output",
+			progress: ffmpeg.Progress{Converted: 15 * time.Minute, Bitrate: 2_500_000, TotalSize: 250_000_000},
+			want:     " Bitrate: 2.5 mbps   Size: 250.0 MB → 1.0 GB    -50%",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, outputStats(tt.progress, time.Hour, 2_000_000_000))
+		})
+	}
+}
+
 func TestMediaFilterState_String(t *testing.T) {
 	tests := []struct {
 		name string
@@ -8,21 +8,31 @@ import (
 	"charm.land/bubbles/v2/spinner"
 	tea "charm.land/bubbletea/v2"
 	"charm.land/lipgloss/v2"
+	"github.com/clambin/xcoder/ffmpeg"
+	"github.com/clambin/xcoder/internal/transcoder"
 )
 
-const blinkStatusInterval = 500 * time.Millisecond
+const (
+	blinkStatusInterval = 500 * time.Millisecond
+	messageDuration     = 5 * time.Second
+)
 
 type statusLine struct {
 	styles     StatusStyles
+	workItems  WorkItems
 	transcoder Transcoder
 	profile    string
 	spinner    spinner.Model
 	width      int
+	projected  int64
 	showState  bool
+	message    string
+	expires    time.Time
 }
 
-func newStatusLine(transcoder Transcoder, profile string, styles StatusStyles, opts ...spinner.Option) statusLine {
+func newStatusLine(workItems WorkItems, transcoder Transcoder, profile string, styles StatusStyles, opts ...spinner.Option) statusLine {
 	return statusLine{
+		workItems:  workItems,
 		transcoder: transcoder,
 		profile:    profile,
 		spinner:    spinner.New(opts...),
@@ -47,8 +57,15 @@ func (s statusLine) Update(msg tea.Msg) (statusLine, tea.Cmd) {
 			s.spinner, cmd = s.spinner.Update(msg)
 		}
 		return s, cmd
+	case statusMessageMsg:
+		s.message, s.expires = msg.text, time.Now().Add(messageDuration)
+		return s, nil
 	case blinkStatusMsg:
 		s.showState = !s.showState
+		s.projected = projectedSavings(s.workItems.Items())
+		if s.message != "" && time.Now().After(s.expires) {
+			s.message = ""
+		}
 		return s, tea.Tick(blinkStatusInterval, func(_ time.Time) tea.Msg {
 			return blinkStatusMsg{}
 		})
@@ -106,12 +123,62 @@ func (s statusLine) state() string {
 }
 
 func (s statusLine) status() string {
-	var status string
+	// a message hides the status until it expires
+	if s.message != "" {
+		return s.message
+	}
+	parts := make([]string, 0, 3)
 	if converting := s.transcoder.SessionCount(); converting > 0 {
-		status = fmt.Sprintf("Converting %d file(s) ... %s", converting, s.spinner.View())
+		parts = append(parts, fmt.Sprintf("Converting %d file(s) ... %s", converting, s.spinner.View()))
+	}
+	if saved := s.transcoder.SpaceSaved(); saved != 0 {
+		parts = append(parts, "Saved: "+ffmpeg.Bytes(saved).Format(1))
+	}
+	if s.projected != 0 {
+		parts = append(parts, "Queued: "+ffmpeg.Bytes(s.projected).Format(1))
+	}
+	if open, next := s.transcoder.ScheduleWindow(); !next.IsZero() {
+		parts = append(parts, windowChange(open, next, time.Now()))
+	}
+	return strings.Join(parts, "  ")
+}
+
+// windowChange describes the next change of the processing window. Changes more than a day away include the weekday.
+func windowChange(open bool, next time.Time, now time.Time) string {
+	change := "opens"
+	if open {
+		change = "closes"
 	}
-	return status
+	layout := "15:04"
+	if next.Sub(now) >= 24*time.Hour {
+		layout = "Mon 15:04"
+	}
+	return "Window " + change + " " + next.Format(layout)
+}
+
+// projectedSavings returns the expected savings of converting all queued media files.
+func projectedSavings(items []*transcoder.WorkItem) int64 {
+	var projected int64
+	for _, item := range items {
+		if status, _ := item.Status(); status == transcoder.StatusQueued && item.Source.Size > 0 {
+			projected += item.Source.Size - item.EstimatedSize()
+		}
+	}
+	return projected
 }
 
 // blinkStatusMsg is a message that blinks the state if it's "on"
 type blinkStatusMsg struct{}
+
+// statusMessageMsg shows a message in the status line for a few seconds, e.g. why the user's last action failed.
+type statusMessageMsg struct {
+	text string
+}
+
+// errorMessageCmd returns a command that shows the error in the status line. If err is nil, it returns nil.
+func errorMessageCmd(err error) tea.Cmd {
+	if err == nil {
+		return nil
+	}
+	return func() tea.Msg { return statusMessageMsg{text: err.Error()} }
+}
@@ -1,13 +1,16 @@
 package ui
 
 import (
+	"errors"
 	"strings"
 	"testing"
+	"time"
 	"unicode/utf8"
 
 	"charm.land/bubbles/v2/spinner"
 	tea "charm.land/bubbletea/v2"
 	"github.com/charmbracelet/x/ansi"
+	"github.com/clambin/xcoder/internal/transcoder"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -15,7 +18,7 @@ import (
 func TestStatusLine_BatchStatus(t *testing.T) {
 	const expectedWidth = 92
 	var x fakeTranscoder
-	s := newStatusLine(&x, "test", StatusStyles{}).setWidth(expectedWidth)
+	s := newStatusLine(generateWorkItems(), &x, "test", StatusStyles{}).setWidth(expectedWidth)
 	for _, msg := range flattenBatchCmd(s.Init()()) {
 		s, _ = s.Update(msg)
 	}
@@ -46,7 +49,7 @@ func TestStatusLine_Converting(t *testing.T) {
 	transcoder := fakeTranscoder{count: 2}
 	transcoder.SetActive(true)
 
-	s := newStatusLine(&transcoder, "test", StatusStyles{}, spinner.WithSpinner(spinner.Dot)).setWidth(expectedWidth)
+	s := newStatusLine(generateWorkItems(), &transcoder, "test", StatusStyles{}, spinner.WithSpinner(spinner.Dot)).setWidth(expectedWidth)
 
 	v := s.View()
 	assert.Equal(t, expectedWidth, utf8.RuneCountInString(ansi.Strip(v)))
@@ -58,6 +61,41 @@ func TestStatusLine_Converting(t *testing.T) {
 	assert.Equal(t, "  Converting 2 file(s) ... ⣽    Profile: test Overwrite target: ON Remove source: ON Batch processing: ON   ", v)
 }
 
+func TestStatusLine_Savings(t *testing.T) {
+	q := generateWorkItems()
+	queued := q.Items()[0]
+	queued.SetStatus(transcoder.StatusQueued, nil)
+
+	s := newStatusLine(q, &fakeTranscoder{saved: 2_000_000_000}, "test", StatusStyles{})
+	assert.Equal(t, "Saved: 2.0 GB", s.status())
+	s, _ = s.Update(blinkStatusMsg{})
+	assert.Equal(t, "Saved: 2.0 GB  Queued: 1.8 GB", s.status())
+}
+
+func TestStatusLine_Window(t *testing.T) {
+	now := time.Date(2026, time.October, 16, 14, 0, 0, 0, time.Local)
+	assert.Equal(t, "Window opens 22:00", windowChange(false, now.Add(8*time.Hour), now))
+	assert.Equal(t, "Window closes Mon 06:00", windowChange(true, now.Add(64*time.Hour), now))
+
+	s := newStatusLine(generateWorkItems(), &fakeTranscoder{open: true, next: time.Now().Add(time.Hour)}, "test", StatusStyles{})
+	assert.Contains(t, s.status(), "Window closes ")
+}
+
+func TestStatusLine_Message(t *testing.T) {
+	s := newStatusLine(generateWorkItems(), &fakeTranscoder{saved: 2_000_000_000}, "test", StatusStyles{})
+	s, _ = s.Update(errorMessageCmd(errors.New("cannot override foo.mkv: status is queued"))())
+	assert.Equal(t, "cannot override foo.mkv: status is queued", s.status())
+
+	// the message is cleared once it expires
+	s, _ = s.Update(blinkStatusMsg{})
+	assert.Equal(t, "cannot override foo.mkv: status is queued", s.status())
+	s.expires = time.Now().Add(-time.Second)
+	s, _ = s.Update(blinkStatusMsg{})
+	assert.Equal(t, "Saved: 2.0 GB", s.status())
+
+	assert.Nil(t, errorMessageCmd(nil))
+}
+
 func flattenBatchCmd(msg tea.Msg) []tea.Msg {
 	if cmd, ok := msg.(tea.BatchMsg); ok {
 		msgs := make([]tea.Msg, len(cmd))
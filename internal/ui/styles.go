@@ -20,6 +20,7 @@ var (
 		transcoder.StatusSkipped.String():     lipgloss.NewStyle().Foreground(colors.Yellow4Alt),
 		transcoder.StatusTranscoding.String(): lipgloss.NewStyle().Foreground(colors.Orange1),
 		transcoder.StatusFailed.String():      lipgloss.NewStyle().Foreground(colors.Red),
+		transcoder.StatusRetrying.String():    lipgloss.NewStyle().Foreground(colors.DarkOrange),
 		transcoder.StatusConverted.String():   lipgloss.NewStyle().Foreground(colors.Green4),
 	}
 
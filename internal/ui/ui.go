@@ -1,7 +1,9 @@
 package ui
 
 import (
+	"context"
 	"io"
+	"time"
 
 	"charm.land/bubbles/v2/key"
 	"charm.land/bubbles/v2/spinner"
@@ -21,10 +23,16 @@ var _ WorkItems = (*transcoder.WorkItems)(nil)
 type Transcoder interface {
 	Active() bool
 	SessionCount() int
+	SpaceSaved() int64
+	ScheduleWindow() (bool, time.Time)
 	SetActive(active bool)
+	Shutdown(context.Context, transcoder.ShutdownMode) error
 	Subscribe() <-chan transcoder.SessionEvent
 	OverwriteTarget() bool
 	RemoveSource() bool
+	Queue(*transcoder.WorkItem) error
+	ForceConvert(*transcoder.WorkItem) error
+	SetProfile(*transcoder.WorkItem, string) error
 }
 
 var _ Transcoder = (*transcoder.Transcoder)(nil)
@@ -32,24 +40,30 @@ var _ Transcoder = (*transcoder.Transcoder)(nil)
 var _ tea.Model = Application{}
 
 type Application struct {
-	keyMap      RootKeyMap
-	helpWindow  helper.Helper
-	logViewer   logViewer
-	statusLine  statusLine
-	mediaViewer mediaViewer
-	height      int
+	transcoder       Transcoder
+	keyMap           RootKeyMap
+	quitPromptKeyMap QuitPromptKeyMap
+	helpWindow       helper.Helper
+	logViewer        logViewer
+	statusLine       statusLine
+	mediaViewer      mediaViewer
+	shutdownMode     transcoder.ShutdownMode
+	height           int
 	windows
 	mediaTableFilterIsOn bool
+	showQuitPrompt       bool
 }
 
 func New(workItems WorkItems, transcoder Transcoder, profileName string, r io.Reader, keyMap KeyMap, styles Styles) Application {
 	ch := transcoder.Subscribe()
 
 	a := Application{
-		statusLine:  newStatusLine(transcoder, profileName, styles.StatusStyles, spinner.WithSpinner(spinner.Meter)),
-		logViewer:   newLogViewer(r, keyMap.LogViewerKeyMap, styles.LogViewerStyles),
-		mediaViewer: newMediaViewer(workItems, transcoder, ch, keyMap.MediaViewerKeyMap, styles.MediaViewerStyles),
-		keyMap:      keyMap.RootKeyMap,
+		statusLine:       newStatusLine(workItems, transcoder, profileName, styles.StatusStyles, spinner.WithSpinner(spinner.Meter)),
+		logViewer:        newLogViewer(r, keyMap.LogViewerKeyMap, styles.LogViewerStyles),
+		mediaViewer:      newMediaViewer(workItems, transcoder, ch, keyMap.MediaViewerKeyMap, styles.MediaViewerStyles),
+		keyMap:           keyMap.RootKeyMap,
+		quitPromptKeyMap: keyMap.QuitPromptKeyMap,
+		transcoder:       transcoder,
 	}
 	var sections []helper.Section
 	sections = append(sections, a.helpSections()...)
@@ -76,10 +90,22 @@ func (a Application) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		a.mediaTableFilterIsOn = msg.State
 		a.mediaViewer, _ = a.mediaViewer.Update(msg)
 		return a, nil
+	case ShutdownMsg:
+		return a.shutdown(msg.Mode)
 	case tea.KeyPressMsg:
 		switch {
+		case a.showQuitPrompt:
+			return a.handleQuitPrompt(msg)
+		case a.shutdownMode != "":
+			// we're shutting down: only the keys that speed up the shutdown are active
+			return a.handleShutdownKeys(msg)
 		// enable / disable these depending on the mediaWindow filterStatus
 		case key.Matches(msg.Key(), a.keyMap.Quit) && !a.mediaTableFilterIsOn:
+			if a.transcoder.SessionCount() > 0 {
+				// ask the user what to do with the active sessions
+				a.showQuitPrompt = true
+				return a, nil
+			}
 			return a, tea.Quit
 		case key.Matches(msg.Key(), a.keyMap.Logs) && !a.mediaTableFilterIsOn:
 			a.showLogs = !a.showLogs
@@ -127,13 +153,64 @@ func (a Application) View() tea.View {
 		lipgloss.JoinVertical(lipgloss.Top,
 			w,
 			a.statusLine.View(),
-			a.helpWindow.ShortHelpView(a.shortHelp()),
+			a.bottomLine(),
 		),
 	)
 	v.AltScreen = true
 	return v
 }
 
+// bottomLine returns the short help, or the state of the shutdown if the application is quitting.
+func (a Application) bottomLine() string {
+	switch {
+	case a.showQuitPrompt:
+		return "Transcoding in progress: " + a.helpWindow.ShortHelpView(a.quitPromptKeyMap.ShortHelp())
+	case a.shutdownMode == transcoder.ShutdownDrain:
+		return "Waiting for active transcoding sessions to complete ... " + a.helpWindow.ShortHelpView([]key.Binding{a.quitPromptKeyMap.Abort, a.quitPromptKeyMap.Detach})
+	case a.shutdownMode == transcoder.ShutdownAbort:
+		return "Aborting active transcoding sessions ... " + a.helpWindow.ShortHelpView([]key.Binding{a.quitPromptKeyMap.Detach})
+	default:
+		return a.helpWindow.ShortHelpView(a.shortHelp())
+	}
+}
+
+// handleQuitPrompt handles the user's choice when quitting while transcoding sessions are active.
+func (a Application) handleQuitPrompt(msg tea.KeyPressMsg) (tea.Model, tea.Cmd) {
+	switch {
+	case key.Matches(msg, a.quitPromptKeyMap.Drain):
+		return a.shutdown(transcoder.ShutdownDrain)
+	case key.Matches(msg, a.quitPromptKeyMap.Abort):
+		return a.shutdown(transcoder.ShutdownAbort)
+	case key.Matches(msg, a.quitPromptKeyMap.Detach):
+		return a.shutdown(transcoder.ShutdownDetach)
+	case key.Matches(msg, a.quitPromptKeyMap.Cancel):
+		a.showQuitPrompt = false
+	}
+	return a, nil
+}
+
+// handleShutdownKeys handles the keys that remain active while shutting down: a drain can still be turned into
+// an abort and both can be turned into a detach, e.g. if a session doesn't stop.
+func (a Application) handleShutdownKeys(msg tea.KeyPressMsg) (tea.Model, tea.Cmd) {
+	switch {
+	case a.shutdownMode == transcoder.ShutdownDrain && key.Matches(msg, a.quitPromptKeyMap.Abort):
+		return a.shutdown(transcoder.ShutdownAbort)
+	case key.Matches(msg, a.quitPromptKeyMap.Detach):
+		return a.shutdown(transcoder.ShutdownDetach)
+	}
+	return a, nil
+}
+
+// shutdown shuts down the transcoder and quits the application once the transcoder has stopped.
+func (a Application) shutdown(mode transcoder.ShutdownMode) (tea.Model, tea.Cmd) {
+	a.showQuitPrompt = false
+	a.shutdownMode = mode
+	return a, func() tea.Msg {
+		_ = a.transcoder.Shutdown(context.Background(), mode)
+		return tea.Quit()
+	}
+}
+
 func (a Application) resize(width, height int) Application {
 	a.height = height
 	a.statusLine = a.statusLine.setWidth(width)
@@ -165,6 +242,13 @@ func (a Application) helpSections() []helper.Section {
 
 ////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
 
+// ShutdownMsg shuts down the transcoder, as per Mode, and quits the application.
+type ShutdownMsg struct {
+	Mode transcoder.ShutdownMode
+}
+
+////////////////////////////////////////////////////////////////////////////////////////////////////////////////////////
+
 // activeWindow represents the currently active window in the UI
 type activeWindow int
 
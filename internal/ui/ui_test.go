@@ -2,6 +2,7 @@ package ui
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"sync/atomic"
 	"testing"
@@ -13,6 +14,7 @@ import (
 	"github.com/clambin/xcoder/ffmpeg"
 	"github.com/clambin/xcoder/internal/transcoder"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestApplication(t *testing.T) {
@@ -58,6 +60,95 @@ func TestApplication(t *testing.T) {
 	}
 }
 
+func TestApplication_Quit(t *testing.T) {
+	tests := []struct {
+		name     string
+		sessions int
+		keys     []string
+		wantQuit bool
+		wantMode transcoder.ShutdownMode
+	}{
+		{name: "no sessions", keys: []string{"q"}, wantQuit: true},
+		{name: "cancel", sessions: 1, keys: []string{"q", "esc"}, wantQuit: false},
+		{name: "drain", sessions: 1, keys: []string{"q", "d"}, wantQuit: true, wantMode: transcoder.ShutdownDrain},
+		{name: "abort", sessions: 1, keys: []string{"q", "a"}, wantQuit: true, wantMode: transcoder.ShutdownAbort},
+		{name: "detach", sessions: 1, keys: []string{"q", "x"}, wantQuit: true, wantMode: transcoder.ShutdownDetach},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tr := fakeTranscoder{count: tt.sessions}
+			var a tea.Model = New(generateWorkItems(), &tr, "test", &bytes.Buffer{}, DefaultKeyMap(), DefaultStyles())
+			a, _ = a.Update(tea.WindowSizeMsg{Width: 120, Height: 10})
+
+			var cmd tea.Cmd
+			for _, k := range tt.keys {
+				key := tea.Key{Text: k}
+				if k == "esc" {
+					key = tea.Key{Code: tea.KeyEscape}
+				}
+				a, cmd = a.Update(tea.KeyPressMsg(key))
+				if tt.sessions > 0 && k == "q" {
+					assert.Contains(t, a.View().Content, "Transcoding in progress: ")
+				}
+			}
+
+			var msg tea.Msg
+			if cmd != nil {
+				msg = cmd()
+			}
+			_, quit := msg.(tea.QuitMsg)
+			assert.Equal(t, tt.wantQuit, quit)
+			assert.Equal(t, tt.wantMode, tr.shutdownMode)
+		})
+	}
+}
+
+func TestApplication_Shutdown(t *testing.T) {
+	var tr fakeTranscoder
+	var a tea.Model = New(generateWorkItems(), &tr, "test", &bytes.Buffer{}, DefaultKeyMap(), DefaultStyles())
+	a, _ = a.Update(tea.WindowSizeMsg{Width: 120, Height: 10})
+	a, cmd := a.Update(ShutdownMsg{Mode: transcoder.ShutdownDrain})
+	assert.Contains(t, a.View().Content, "Waiting for active transcoding sessions to complete ...")
+	assert.Equal(t, tea.QuitMsg{}, cmd())
+	assert.Equal(t, transcoder.ShutdownDrain, tr.shutdownMode)
+}
+
+func TestApplication_Shutdown_Keys(t *testing.T) {
+	tests := []struct {
+		name     string
+		mode     transcoder.ShutdownMode
+		key      string
+		wantMode transcoder.ShutdownMode
+	}{
+		{name: "drain, then abort", mode: transcoder.ShutdownDrain, key: "a", wantMode: transcoder.ShutdownAbort},
+		{name: "drain, then detach", mode: transcoder.ShutdownDrain, key: "x", wantMode: transcoder.ShutdownDetach},
+		{name: "abort, then detach", mode: transcoder.ShutdownAbort, key: "x", wantMode: transcoder.ShutdownDetach},
+		{name: "abort, then drain", mode: transcoder.ShutdownAbort, key: "d"},
+		{name: "other keys are ignored", mode: transcoder.ShutdownDrain, key: "q"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var tr fakeTranscoder
+			var a tea.Model = New(generateWorkItems(), &tr, "test", &bytes.Buffer{}, DefaultKeyMap(), DefaultStyles())
+			a, _ = a.Update(tea.WindowSizeMsg{Width: 120, Height: 10})
+			// the transcoder is still shutting down: don't run the shutdown command
+			a, _ = a.Update(ShutdownMsg{Mode: tt.mode})
+
+			a, cmd := a.Update(tea.KeyPressMsg(tea.Key{Text: tt.key}))
+			if tt.wantMode == "" {
+				assert.Nil(t, cmd)
+				return
+			}
+			require.NotNil(t, cmd)
+			assert.Equal(t, tea.QuitMsg{}, cmd())
+			assert.Equal(t, tt.wantMode, tr.shutdownMode)
+			assert.Equal(t, tt.wantMode, a.(Application).shutdownMode)
+		})
+	}
+}
+
 func generateWorkItems() *transcoder.WorkItems {
 	var skippedWorkItem, rejectedWorkItem, convertedWorkItem, failedWorkItem, transcodingWorkItem transcoder.WorkItem
 	skippedWorkItem.SetStatus(transcoder.StatusSkipped, assert.AnError)
@@ -69,9 +160,11 @@ func generateWorkItems() *transcoder.WorkItems {
 	workItems := []*transcoder.WorkItem{&skippedWorkItem, &rejectedWorkItem, &convertedWorkItem, &failedWorkItem, &transcodingWorkItem}
 	for i, workItem := range workItems {
 		workItem.Source.Path = fmt.Sprintf("file_%d", i)
-		workItem.Source.VideoStats = ffmpeg.VideoStats{VideoCodec: "h264", Height: 1080, BitRate: 8_000_000}
+		workItem.Source.VideoStats = ffmpeg.VideoStats{VideoCodec: "h264", Height: 1080, BitRate: 8_000_000, Duration: time.Hour}
+		workItem.Source.Size = 3_600_000_000
 		workItem.Target.VideoStats = ffmpeg.VideoStats{VideoCodec: "hevc", Height: 1080, BitRate: 4_000_000}
 	}
+	convertedWorkItem.Target.Size = 1_600_000_000
 	var q transcoder.WorkItems
 	q.Add(workItems...)
 	return &q
@@ -80,14 +173,32 @@ func generateWorkItems() *transcoder.WorkItems {
 var _ Transcoder = (*fakeTranscoder)(nil)
 
 type fakeTranscoder struct {
-	active atomic.Bool
-	count  int
+	shutdownMode transcoder.ShutdownMode
+	active       atomic.Bool
+	next         time.Time
+	count        int
+	overrideErr  error
+	saved        int64
+	open         bool
 }
 
 func (f *fakeTranscoder) SessionCount() int {
 	return f.count
 }
 
+func (f *fakeTranscoder) SpaceSaved() int64 {
+	return f.saved
+}
+
+func (f *fakeTranscoder) ScheduleWindow() (bool, time.Time) {
+	return f.open, f.next
+}
+
+func (f *fakeTranscoder) Shutdown(_ context.Context, mode transcoder.ShutdownMode) error {
+	f.shutdownMode = mode
+	return nil
+}
+
 func (f *fakeTranscoder) Active() bool {
 	return f.active.Load()
 }
@@ -107,3 +218,20 @@ func (f *fakeTranscoder) OverwriteTarget() bool {
 func (f *fakeTranscoder) RemoveSource() bool {
 	return true
 }
+
+func (f *fakeTranscoder) Queue(item *transcoder.WorkItem) error {
+	item.SetStatus(transcoder.StatusQueued, nil)
+	return nil
+}
+
+func (f *fakeTranscoder) ForceConvert(item *transcoder.WorkItem) error {
+	if f.overrideErr != nil {
+		return f.overrideErr
+	}
+	item.SetStatus(transcoder.StatusQueued, nil)
+	return nil
+}
+
+func (f *fakeTranscoder) SetProfile(_ *transcoder.WorkItem, _ string) error {
+	return f.overrideErr
+}
@@ -0,0 +1,131 @@
+package verify
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/clambin/xcoder/ffmpeg"
+	"github.com/clambin/xcoder/internal/transcoder"
+)
+
+const (
+	// durationTolerance is the maximum difference in duration between the source and the target.
+	durationTolerance = time.Second
+	// frameTolerance is the maximum difference in frame count, relative to the source. Encoders may drop
+	// or duplicate a few frames at the edges.
+	frameTolerance = 0.001
+	// frameRateTolerance is the maximum difference in frame count, relative to the expected frame count, if the
+	// frame rate changed. Converting the frame rate or removing pulldown doesn't map source frames exactly to target frames.
+	frameRateTolerance = 0.01
+	// syncTolerance is the maximum change in the offset between the first audio and the first video packet.
+	syncTolerance = 100 * time.Millisecond
+)
+
+// Expected holds the changes that a conversion makes to the layout of the source, beyond re-encoding the video.
+type Expected struct {
+	// FrameRatio is the number of target frames per source frame. Zero means the frame rate doesn't change.
+	FrameRatio float64
+	// AudioReencoded is true if the audio streams are re-encoded, e.g. to normalize their loudness.
+	AudioReencoded bool
+}
+
+// Expect returns the changes that the profile makes to the layout of a source with the given video stats.
+func Expect(source ffmpeg.VideoStats, profile transcoder.Profile) Expected {
+	// the source was converted, so its profile's rules no longer apply
+	profile.Rules = nil
+	target, err := profile.Analyze(transcoder.File{VideoStats: source})
+	if err != nil {
+		return Expected{}
+	}
+	expected := Expected{AudioReencoded: target.NormalizeLoudness}
+	if source.FrameRate > 0 && target.FrameRate != source.FrameRate {
+		expected.FrameRatio = target.FrameRate / source.FrameRate
+	}
+	return expected
+}
+
+// Compare compares the layout of a target file with the layout of the source it was converted from
+// and returns each discrepancy. Video streams are expected to be re-encoded, so only their number and
+// frame count are compared. All other streams must be the same, except for the codec of audio streams
+// that are expected to be re-encoded.
+func Compare(source, target ffmpeg.MediaInfo, expected Expected) []string {
+	var discrepancies []string
+	add := func(format string, args ...any) {
+		discrepancies = append(discrepancies, fmt.Sprintf(format, args...))
+	}
+
+	if diff := target.Duration - source.Duration; diff.Abs() > durationTolerance {
+		add("duration: source %s, target %s", source.Duration.Round(time.Second), target.Duration.Round(time.Second))
+	}
+
+	for _, streamType := range []string{"video", "audio", "subtitle"} {
+		s, t := source.StreamsOfType(streamType), target.StreamsOfType(streamType)
+		if len(s) != len(t) {
+			add("%s streams: source %d, target %d", streamType, len(s), len(t))
+			continue
+		}
+		if streamType == "video" {
+			continue
+		}
+		for i := range s {
+			if streamType == "audio" && expected.AudioReencoded {
+				s[i].Codec, t[i].Codec = "", ""
+			}
+			if describe(s[i]) != describe(t[i]) {
+				add("%s stream %d: source %s, target %s", streamType, i, describe(s[i]), describe(t[i]))
+			}
+		}
+	}
+
+	sourceVideo, targetVideo := source.StreamsOfType("video"), target.StreamsOfType("video")
+	if len(sourceVideo) > 0 && len(targetVideo) > 0 {
+		if s, t := sourceVideo[0].Packets, targetVideo[0].Packets; s > 0 && t > 0 {
+			if expected.FrameRatio == 0 {
+				if float64(abs(s-t)) > max(2, frameTolerance*float64(s)) {
+					add("frames: source %d, target %d", s, t)
+				}
+			} else if want := int64(math.Round(float64(s) * expected.FrameRatio)); float64(abs(want-t)) > max(2, frameRateTolerance*float64(want)) {
+				add("frames: source %d, expected %d, target %d", s, want, t)
+			}
+		}
+		sourceAudio, targetAudio := source.StreamsOfType("audio"), target.StreamsOfType("audio")
+		if len(sourceAudio) > 0 && len(targetAudio) > 0 {
+			sourceOffset := sourceAudio[0].StartTime - sourceVideo[0].StartTime
+			targetOffset := targetAudio[0].StartTime - targetVideo[0].StartTime
+			if drift := targetOffset - sourceOffset; drift.Abs() > syncTolerance {
+				add("audio/video sync: target drifts %s from source", drift)
+			}
+		}
+	}
+
+	if source.Chapters != target.Chapters {
+		add("chapters: source %d, target %d", source.Chapters, target.Chapters)
+	}
+	return discrepancies
+}
+
+// describe returns the properties of a stream that should survive transcoding: "aac/eng/2ch".
+// If the codec is blank, it's left out: "eng/2ch".
+func describe(s ffmpeg.Stream) string {
+	elements := make([]string, 0, 3)
+	if s.Codec != "" {
+		elements = append(elements, s.Codec)
+	}
+	if s.Language != "" {
+		elements = append(elements, s.Language)
+	}
+	if s.Channels > 0 {
+		elements = append(elements, strconv.Itoa(s.Channels)+"ch")
+	}
+	return strings.Join(elements, "/")
+}
+
+func abs(n int64) int64 {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
@@ -0,0 +1,141 @@
+package verify
+
+import (
+	"testing"
+	"time"
+
+	"github.com/clambin/xcoder/ffmpeg"
+	"github.com/clambin/xcoder/internal/transcoder"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompare(t *testing.T) {
+	source := ffmpeg.MediaInfo{
+		Duration: time.Hour,
+		Chapters: 8,
+		Streams: []ffmpeg.Stream{
+			{Type: "video", Codec: "h264", Packets: 90_000},
+			{Type: "audio", Codec: "ac3", Language: "eng", Channels: 6},
+			{Type: "audio", Codec: "aac", Language: "fre", Channels: 2},
+			{Type: "subtitle", Codec: "subrip", Language: "eng"},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		target   func(ffmpeg.MediaInfo) ffmpeg.MediaInfo
+		expected Expected
+		want     []string
+	}{
+		{
+			name:   "match",
+			target: func(m ffmpeg.MediaInfo) ffmpeg.MediaInfo { return m },
+		},
+		{
+			name: "re-encoded within tolerance",
+			target: func(m ffmpeg.MediaInfo) ffmpeg.MediaInfo {
+				m.Duration += 500 * time.Millisecond
+				m.Streams[0].Codec = "hevc"
+				m.Streams[0].Packets -= 50
+				m.Streams[1].StartTime = 50 * time.Millisecond
+				return m
+			},
+		},
+		{
+			name: "shorter",
+			target: func(m ffmpeg.MediaInfo) ffmpeg.MediaInfo {
+				m.Duration -= 2 * time.Minute
+				m.Streams[0].Packets -= 2880
+				return m
+			},
+			want: []string{"duration: source 1h0m0s, target 58m0s", "frames: source 90000, target 87120"},
+		},
+		{
+			name: "missing audio",
+			target: func(m ffmpeg.MediaInfo) ffmpeg.MediaInfo {
+				m.Streams = append(m.Streams[:2:2], m.Streams[3])
+				return m
+			},
+			want: []string{"audio streams: source 2, target 1"},
+		},
+		{
+			name: "downmixed audio",
+			target: func(m ffmpeg.MediaInfo) ffmpeg.MediaInfo {
+				m.Streams[1].Codec, m.Streams[1].Channels = "aac", 2
+				return m
+			},
+			want: []string{"audio stream 0: source ac3/eng/6ch, target aac/eng/2ch"},
+		},
+		{
+			name: "normalized audio",
+			target: func(m ffmpeg.MediaInfo) ffmpeg.MediaInfo {
+				m.Streams[1].Codec = "aac"
+				return m
+			},
+			expected: Expected{AudioReencoded: true},
+		},
+		{
+			name: "normalized audio - downmixed",
+			target: func(m ffmpeg.MediaInfo) ffmpeg.MediaInfo {
+				m.Streams[1].Codec, m.Streams[1].Channels = "aac", 2
+				return m
+			},
+			expected: Expected{AudioReencoded: true},
+			want:     []string{"audio stream 0: source eng/6ch, target eng/2ch"},
+		},
+		{
+			name: "frame rate changed",
+			target: func(m ffmpeg.MediaInfo) ffmpeg.MediaInfo {
+				m.Streams[0].Packets = 72_100
+				return m
+			},
+			expected: Expected{FrameRatio: 0.8},
+		},
+		{
+			name: "frame rate changed - frames missing",
+			target: func(m ffmpeg.MediaInfo) ffmpeg.MediaInfo {
+				m.Streams[0].Packets = 70_000
+				return m
+			},
+			expected: Expected{FrameRatio: 0.8},
+			want:     []string{"frames: source 90000, expected 72000, target 70000"},
+		},
+		{
+			name: "sync drift",
+			target: func(m ffmpeg.MediaInfo) ffmpeg.MediaInfo {
+				m.Streams[1].StartTime = 500 * time.Millisecond
+				return m
+			},
+			want: []string{"audio/video sync: target drifts 500ms from source"},
+		},
+		{
+			name: "chapters",
+			target: func(m ffmpeg.MediaInfo) ffmpeg.MediaInfo {
+				m.Chapters = 0
+				return m
+			},
+			want: []string{"chapters: source 8, target 0"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target := source
+			target.Streams = append([]ffmpeg.Stream(nil), source.Streams...)
+			assert.Equal(t, tt.want, Compare(source, tt.target(target), tt.expected))
+		})
+	}
+}
+
+func TestExpect(t *testing.T) {
+	profile, err := transcoder.GetProfile("hevc-high")
+	require.NoError(t, err)
+	source := ffmpeg.VideoStats{VideoCodec: "h264", Height: 1080, BitRate: 8_000_000, FrameRate: 59.94, RealFrameRate: 59.94, AudioStreams: 1}
+
+	// the profile's rules don't apply: the source is already converted
+	assert.Equal(t, Expected{}, Expect(source, profile))
+
+	profile.MaxFrameRate = 29.97
+	profile.NormalizeLoudness = true
+	assert.Equal(t, Expected{FrameRatio: 0.5, AudioReencoded: true}, Expect(source, profile))
+}
@@ -0,0 +1,204 @@
+package verify
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/clambin/xcoder/ffmpeg"
+)
+
+// Result is the outcome of verifying one file.
+type Result struct {
+	Err    error
+	Path   string
+	Source string
+	// Discrepancies lists the differences between the file and its source.
+	Discrepancies []string
+	Duration      time.Duration
+}
+
+// Failed returns true if the file couldn't be decoded or doesn't match its source.
+func (r Result) Failed() bool {
+	return r.Err != nil || len(r.Discrepancies) > 0
+}
+
+// String returns a one-line description of the result.
+func (r Result) String() string {
+	path := r.Path
+	if r.Source != "" {
+		path += " (source: " + r.Source + ")"
+	}
+	if r.Failed() {
+		return "FAIL " + path + ": " + r.reason()
+	}
+	return "PASS " + path
+}
+
+// reason returns a short description of why the file failed verification.
+func (r Result) reason() string {
+	if r.Err != nil {
+		return reason(r.Err)
+	}
+	return strings.Join(r.Discrepancies, "; ")
+}
+
+// details returns the full description of why the file failed verification.
+func (r Result) details() string {
+	if r.Err != nil {
+		return r.Err.Error()
+	}
+	return strings.Join(r.Discrepancies, "\n")
+}
+
+// reason returns a short description of the error. For ffmpeg errors, this omits the output that ffmpeg wrote to stderr.
+func reason(err error) string {
+	if ffmpegErr, ok := errors.AsType[*ffmpeg.Error](err); ok {
+		return ffmpegErr.Reason()
+	}
+	return err.Error()
+}
+
+// Report holds the results of all verified files.
+type Report struct {
+	Results  []Result
+	Duration time.Duration
+}
+
+// Failed returns the number of files that failed verification.
+func (r Report) Failed() int {
+	var failed int
+	for _, result := range r.Results {
+		if result.Failed() {
+			failed++
+		}
+	}
+	return failed
+}
+
+// Summary returns a one-line summary of the report.
+func (r Report) Summary() string {
+	return fmt.Sprintf("%d files verified, %d failed (%s)", len(r.Results), r.Failed(), r.Duration.Round(time.Second))
+}
+
+// Write writes the report in the requested format: text, json or junit.
+func (r Report) Write(w io.Writer, format string) error {
+	switch format {
+	case "text":
+		return r.writeText(w)
+	case "json":
+		return r.writeJSON(w)
+	case "junit":
+		return r.writeJUnit(w)
+	default:
+		return fmt.Errorf("invalid format %q", format)
+	}
+}
+
+func (r Report) writeText(w io.Writer) error {
+	for _, result := range r.Results {
+		if _, err := fmt.Fprintln(w, result); err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintln(w, r.Summary())
+	return err
+}
+
+//nolint:tagliatelle
+type jsonResult struct {
+	Path          string   `json:"path"`
+	Source        string   `json:"source,omitempty"`
+	Passed        bool     `json:"passed"`
+	Seconds       float64  `json:"seconds"`
+	Reason        string   `json:"reason,omitempty"`
+	Error         string   `json:"error,omitempty"`
+	Discrepancies []string `json:"discrepancies,omitempty"`
+}
+
+func (r Report) writeJSON(w io.Writer) error {
+	results := make([]jsonResult, len(r.Results))
+	for i, result := range r.Results {
+		results[i] = jsonResult{
+			Path:          result.Path,
+			Source:        result.Source,
+			Passed:        !result.Failed(),
+			Seconds:       result.Duration.Seconds(),
+			Discrepancies: result.Discrepancies,
+		}
+		if result.Failed() {
+			results[i].Reason = result.reason()
+		}
+		if result.Err != nil {
+			results[i].Error = result.Err.Error()
+		}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(struct {
+		Results []jsonResult `json:"results"`
+		Files   int          `json:"files"`
+		Failed  int          `json:"failed"`
+		Seconds float64      `json:"seconds"`
+	}{Results: results, Files: len(r.Results), Failed: r.Failed(), Seconds: r.Duration.Seconds()})
+}
+
+// junitTestSuites is the JUnit XML format understood by most CI systems.
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Time      string          `xml:"time,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+func (r Report) writeJUnit(w io.Writer) error {
+	suite := junitTestSuite{
+		Name:      "xcoder verify",
+		Tests:     len(r.Results),
+		Failures:  r.Failed(),
+		Time:      junitTime(r.Duration),
+		TestCases: make([]junitTestCase, len(r.Results)),
+	}
+	for i, result := range r.Results {
+		suite.TestCases[i] = junitTestCase{Name: result.Path, ClassName: "verify", Time: junitTime(result.Duration)}
+		if result.Failed() {
+			suite.TestCases[i].Failure = &junitFailure{Message: result.reason(), Text: result.details()}
+		}
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(junitTestSuites{Suites: []junitTestSuite{suite}}); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+func junitTime(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
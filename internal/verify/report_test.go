@@ -0,0 +1,85 @@
+package verify
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/clambin/xcoder/ffmpeg"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReport_Write(t *testing.T) {
+	r := Report{
+		Results: []Result{
+			{Path: "good.mkv", Duration: 1500 * time.Millisecond},
+			{Path: "bad.mkv", Duration: 250 * time.Millisecond, Err: &ffmpeg.Error{
+				Command: "ffmpeg",
+				Err:     errors.New("exit status 1"),
+				Kind:    ffmpeg.ErrorCorruptInput,
+				Stderr:  "bad.mkv: Invalid data found when processing input",
+			}},
+		},
+		Duration: 2 * time.Second,
+	}
+
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{
+			format: "text",
+			want: `PASS good.mkv
+FAIL bad.mkv: corrupt input
+2 files verified, 1 failed (2s)
+`,
+		},
+		{
+			format: "json",
+			want: `{
+  "results": [
+    {
+      "path": "good.mkv",
+      "passed": true,
+      "seconds": 1.5
+    },
+    {
+      "path": "bad.mkv",
+      "passed": false,
+      "seconds": 0.25,
+      "reason": "corrupt input",
+      "error": "ffmpeg: exit status 1 (bad.mkv: Invalid data found when processing input)"
+    }
+  ],
+  "files": 2,
+  "failed": 1,
+  "seconds": 2
+}
+`,
+		},
+		{
+			format: "junit",
+			want: `<?xml version="1.0" encoding="UTF-8"?>
+<testsuites>
+  <testsuite name="xcoder verify" tests="2" failures="1" time="2.000">
+    <testcase name="good.mkv" classname="verify" time="1.500"></testcase>
+    <testcase name="bad.mkv" classname="verify" time="0.250">
+      <failure message="corrupt input">ffmpeg: exit status 1 (bad.mkv: Invalid data found when processing input)</failure>
+    </testcase>
+  </testsuite>
+</testsuites>
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var buf bytes.Buffer
+			require.NoError(t, r.Write(&buf, tt.format))
+			assert.Equal(t, tt.want, buf.String())
+		})
+	}
+
+	assert.Error(t, r.Write(&bytes.Buffer{}, "invalid"))
+}
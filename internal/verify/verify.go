@@ -0,0 +1,198 @@
+package verify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/clambin/xcoder/ffmpeg"
+	"github.com/clambin/xcoder/internal/mediafiles"
+	"github.com/clambin/xcoder/internal/transcoder"
+)
+
+// Files returns the files to verify. Directories are searched recursively for media files.
+func Files(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !fi.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		if err = mediafiles.FindMediaFiles(path, func(file string) { files = append(files, file) }); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return files, nil
+}
+
+// An Item is a file to verify. If Source is set, the file is compared to the source file it was converted from.
+type Item struct {
+	Path   string
+	Source string
+	// Expected holds the changes that the conversion made to the source.
+	Expected Expected
+}
+
+// Items returns the files as items without a source.
+func Items(files []string) []Item {
+	items := make([]Item, len(files))
+	for i, file := range files {
+		items[i] = Item{Path: file}
+	}
+	return items
+}
+
+// Against returns the file as an item to be compared with the source that the profile converted it from.
+func Against(file, source string, profile transcoder.Profile, priority ffmpeg.Priority) Item {
+	item := Item{Path: file, Source: source}
+	// if the source can't be probed, comparing fails anyway
+	if stats, err := priority.Probe(source); err == nil {
+		item.Expected = Expect(stats, profile)
+	}
+	return item
+}
+
+// Pair pairs the files with their targets, using the Transcoder's naming of target files for the profile's codec.
+// A file with a target on disk is replaced by its target, to be compared with the file. Targets that are
+// in the list of files are only verified as part of their pair. All other files are verified on their own.
+func Pair(files []string, profile transcoder.Profile, priority ffmpeg.Priority) []Item {
+	items := make([]Item, 0, len(files))
+	targets := make(map[string]struct{})
+	dirs := make(map[string][]os.DirEntry)
+	for _, file := range files {
+		item := Item{Path: file}
+		if target, ok := findTarget(file, profile.TargetCodec, dirs); ok {
+			item = Against(target, file, profile, priority)
+			targets[target] = struct{}{}
+		}
+		items = append(items, item)
+	}
+	return slices.DeleteFunc(items, func(item Item) bool {
+		_, isTarget := targets[item.Path]
+		return isTarget && item.Source == ""
+	})
+}
+
+// findTarget returns the target of the file, if it exists. dirs caches the entries of each directory.
+func findTarget(file, codec string, dirs map[string][]os.DirEntry) (string, bool) {
+	dir := filepath.Dir(file)
+	entries, ok := dirs[dir]
+	if !ok {
+		entries, _ = os.ReadDir(dir)
+		dirs[dir] = entries
+	}
+	for _, entry := range entries {
+		if target := filepath.Join(dir, entry.Name()); !entry.IsDir() && transcoder.IsTargetFilename(file, target, codec) {
+			return target, true
+		}
+	}
+	return "", false
+}
+
+// A Verifier checks that media files can be decoded and, if they have a source, that they match their source.
+type Verifier struct {
+	Logger   *slog.Logger
+	Priority ffmpeg.Priority
+	// Workers is the number of files verified in parallel. Zero uses the number of CPUs.
+	Workers int
+}
+
+// Verify verifies the items and returns the results in the order of the items. If onResult is not nil,
+// it is called with the result of each item as soon as that item is verified.
+//
+// If the context is cancelled, items that haven't been verified yet are reported as failed.
+func (v Verifier) Verify(ctx context.Context, items []Item, onResult func(Result)) Report {
+	start := time.Now()
+	results := make([]Result, len(items))
+	workers := v.Workers
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex
+	)
+	queue := make(chan int)
+	for range min(workers, len(items)) {
+		wg.Go(func() {
+			for i := range queue {
+				results[i] = v.verify(ctx, items[i])
+				if onResult != nil {
+					mu.Lock()
+					onResult(results[i])
+					mu.Unlock()
+				}
+			}
+		})
+	}
+	for i := range items {
+		queue <- i
+	}
+	close(queue)
+	wg.Wait()
+	return Report{Results: results, Duration: time.Since(start)}
+}
+
+// verify decodes the file, discarding the output, and compares it to its source.
+func (v Verifier) verify(ctx context.Context, item Item) (r Result) {
+	start := time.Now()
+	r = Result{Path: item.Path, Source: item.Source}
+	defer func() { r.Duration = time.Since(start) }()
+	if r.Err = ctx.Err(); r.Err != nil {
+		return r
+	}
+	stats, err := v.Priority.Probe(item.Path)
+	if err != nil {
+		r.Err = err
+		return r
+	}
+	r.Err = ffmpeg.
+		Decode(item.Path, transcoder.DecoderArguments(stats)...).
+		Muxer("null").
+		LogLevel("error").
+		NoStats().
+		Priority(v.Priority).
+		Run(ctx, v.logger())
+	if r.Err != nil {
+		if ctx.Err() != nil {
+			r.Err = errors.Join(ctx.Err(), r.Err)
+		}
+		return r
+	}
+	if item.Source != "" {
+		r.Discrepancies, r.Err = v.compare(item.Source, item.Path, item.Expected)
+	}
+	return r
+}
+
+// compare compares the target with its source.
+func (v Verifier) compare(source, target string, expected Expected) ([]string, error) {
+	sourceInfo, err := v.Priority.ProbeMediaInfo(source)
+	if err != nil {
+		return nil, fmt.Errorf("source: %w", err)
+	}
+	targetInfo, err := v.Priority.ProbeMediaInfo(target)
+	if err != nil {
+		return nil, err
+	}
+	return Compare(sourceInfo, targetInfo, expected), nil
+}
+
+func (v Verifier) logger() *slog.Logger {
+	if v.Logger == nil {
+		return slog.New(slog.DiscardHandler)
+	}
+	return v.Logger
+}
@@ -0,0 +1,104 @@
+package verify
+
+import (
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/clambin/xcoder/ffmpeg"
+	"github.com/clambin/xcoder/internal/transcoder"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, file := range []string{"a.mkv", "notes.txt", "sub/b.mp4", "sub/c.MKV"} {
+		touch(t, filepath.Join(dir, file))
+	}
+
+	// files are taken as-is, directories are searched for media files
+	files, err := Files([]string{filepath.Join(dir, "notes.txt"), dir})
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		filepath.Join(dir, "notes.txt"),
+		filepath.Join(dir, "a.mkv"),
+		filepath.Join(dir, "sub/b.mp4"),
+		filepath.Join(dir, "sub/c.MKV"),
+	}, files)
+
+	_, err = Files([]string{filepath.Join(dir, "missing.mkv")})
+	assert.Error(t, err)
+}
+
+func TestPair(t *testing.T) {
+	fakeFFMPEG(t)
+	dir := t.TempDir()
+	source := filepath.Join(dir, "Movie (2020).mkv")
+	// the target of a cropped source is named after its cropped height
+	target := filepath.Join(dir, "Movie (2020).800.hevc.mkv")
+	other := filepath.Join(dir, "Other (2021).mkv")
+	for _, file := range []string{source, target, other} {
+		touch(t, file)
+	}
+	profile, err := transcoder.GetProfile("hevc-high")
+	require.NoError(t, err)
+	profile.NormalizeLoudness = true
+
+	// the target is only verified as part of its pair
+	items := Pair([]string{target, source, other}, profile, ffmpeg.Priority{})
+	assert.Equal(t, []Item{{Path: target, Source: source, Expected: Expected{AudioReencoded: true}}, {Path: other}}, items)
+}
+
+func TestVerifier_Verify(t *testing.T) {
+	fakeFFMPEG(t)
+
+	items := []Item{
+		{Path: "good1.mkv"},
+		{Path: "bad.mkv"},
+		{Path: "good2.hevc.mkv", Source: "good2.mkv"},
+		{Path: "short.hevc.mkv", Source: "good3.mkv"},
+	}
+	var calls atomic.Int32
+	r := Verifier{Workers: 2}.Verify(t.Context(), items, func(Result) { calls.Add(1) })
+
+	assert.Equal(t, int32(len(items)), calls.Load())
+	require.Len(t, r.Results, len(items))
+	for i, result := range r.Results {
+		assert.Equal(t, items[i].Path, result.Path)
+	}
+	assert.Equal(t, 2, r.Failed())
+	assert.Equal(t, "PASS good1.mkv", r.Results[0].String())
+	assert.Equal(t, "FAIL bad.mkv: corrupt input", r.Results[1].String())
+	assert.Equal(t, "PASS good2.hevc.mkv (source: good2.mkv)", r.Results[2].String())
+	assert.Equal(t, "FAIL short.hevc.mkv (source: good3.mkv): duration: source 1m0s, target 50s; frames: source 1500, target 1250", r.Results[3].String())
+}
+
+// fakeFFMPEG stubs ffmpeg and ffprobe: files with "bad" in their name are corrupt and files with "short"
+// in their name are 10 seconds shorter than the others.
+func fakeFFMPEG(t *testing.T) {
+	t.Helper()
+	bin := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(bin, "ffprobe"), []byte(`#!/bin/sh
+case "$*" in
+*-count_packets*short*) echo '{"format":{"duration":"50.0"},"streams":[{"codec_type":"video","nb_read_packets":"1250"}]}';;
+*-count_packets*) echo '{"format":{"duration":"60.0"},"streams":[{"codec_type":"video","nb_read_packets":"1500"}]}';;
+*) echo '{"format":{"duration":"60.0","bit_rate":"4000000"},"streams":[{"codec_name":"h264","codec_type":"video","height":1080},{"codec_name":"ac3","codec_type":"audio"}]}';;
+esac
+`), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(bin, "ffmpeg"), []byte(`#!/bin/sh
+case "$*" in
+*bad*) echo "bad.mkv: Invalid data found when processing input" >&2; exit 1;;
+esac
+`), 0o755))
+	path, probePath := ffmpeg.Path, ffmpeg.ProbePath
+	ffmpeg.Path, ffmpeg.ProbePath = filepath.Join(bin, "ffmpeg"), filepath.Join(bin, "ffprobe")
+	t.Cleanup(func() { ffmpeg.Path, ffmpeg.ProbePath = path, probePath })
+}
+
+func touch(t *testing.T, path string) {
+	t.Helper()
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+	require.NoError(t, os.WriteFile(path, nil, 0o644))
+}
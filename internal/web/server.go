@@ -0,0 +1,358 @@
+package web
+
+import (
+	"context"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/clambin/xcoder/ffmpeg"
+	"github.com/clambin/xcoder/internal/transcoder"
+	"github.com/clambin/xcoder/internal/ui"
+)
+
+type WorkItems interface {
+	Items() []*transcoder.WorkItem
+	Get(path string) (*transcoder.WorkItem, bool)
+}
+
+var _ WorkItems = (*transcoder.WorkItems)(nil)
+
+// Transcoder is the control surface of the transcoder. It extends the TUI's ui.Transcoder, so the dashboard offers
+// the same actions as the TUI. Unsubscribe and UnsubscribeStatus drain the channel until the subscription is stopped,
+// so the caller may stop reading from it at any time.
+type Transcoder interface {
+	ui.Transcoder
+	Sessions() []*transcoder.Session
+	Unsubscribe(<-chan transcoder.SessionEvent)
+	SubscribeStatus() <-chan transcoder.StatusEvent
+	UnsubscribeStatus(<-chan transcoder.StatusEvent)
+	Dequeue(*transcoder.WorkItem) error
+}
+
+var _ Transcoder = (*transcoder.Transcoder)(nil)
+
+//go:embed static
+var static embed.FS
+
+var _ http.Handler = (*Server)(nil)
+
+// Server exposes the transcoder as an HTTP/JSON API and serves a small web dashboard on top of it.
+type Server struct {
+	workItems  WorkItems
+	transcoder Transcoder
+	logger     *slog.Logger
+	mux        *http.ServeMux
+}
+
+// New returns a new Server.
+func New(workItems WorkItems, tr Transcoder, logger *slog.Logger) *Server {
+	s := Server{
+		workItems:  workItems,
+		transcoder: tr,
+		logger:     logger,
+		mux:        http.NewServeMux(),
+	}
+	staticFS, _ := fs.Sub(static, "static")
+	s.mux.Handle("GET /", http.FileServerFS(staticFS))
+	s.mux.HandleFunc("GET /api/workitems", s.listWorkItems)
+	s.mux.HandleFunc("POST /api/workitems/queue", s.queueWorkItem)
+	s.mux.HandleFunc("POST /api/workitems/dequeue", s.dequeueWorkItem)
+	s.mux.HandleFunc("POST /api/workitems/force", s.forceConvertWorkItem)
+	s.mux.HandleFunc("POST /api/workitems/profile", s.setWorkItemProfile)
+	s.mux.HandleFunc("GET /api/profiles", s.listProfiles)
+	s.mux.HandleFunc("GET /api/state", s.getState)
+	s.mux.HandleFunc("PUT /api/state/active", s.setActive)
+	s.mux.HandleFunc("GET /api/sessions", s.listSessions)
+	s.mux.HandleFunc("GET /api/events", s.events)
+	return &s
+}
+
+// ServeHTTP implements the http.Handler interface.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+//nolint:tagliatelle
+type workItem struct {
+	Source      string            `json:"source"`
+	Target      string            `json:"target,omitempty"`
+	SourceStats ffmpeg.VideoStats `json:"source_stats"`
+	TargetStats ffmpeg.VideoStats `json:"target_stats"`
+	SourceSize  int64             `json:"source_size"`
+	TargetSize  int64             `json:"target_size"`
+	Saved       int64             `json:"saved"`
+	Status      string            `json:"status"`
+	Error       string            `json:"error,omitempty"`
+	Profile     string            `json:"profile,omitempty"`
+	Attempts    []attempt         `json:"attempts,omitempty"`
+}
+
+type attempt struct {
+	Start    time.Time     `json:"start"`
+	Backend  string        `json:"backend"`
+	Error    string        `json:"error,omitempty"`
+	Duration time.Duration `json:"duration"`
+}
+
+func newWorkItem(item *transcoder.WorkItem) workItem {
+	status, err := item.Status()
+	attempts := item.Attempts()
+	w := workItem{
+		Source:      item.Source.Path,
+		Target:      item.Target.Path,
+		SourceStats: item.Source.VideoStats,
+		TargetStats: item.Target.VideoStats,
+		SourceSize:  item.Source.Size,
+		TargetSize:  item.EstimatedSize(),
+		Saved:       item.Saved(),
+		Status:      status.String(),
+		Error:       errorString(err),
+		Profile:     item.Profile(),
+		Attempts:    make([]attempt, len(attempts)),
+	}
+	for i, a := range attempts {
+		w.Attempts[i] = attempt{Start: a.Start, Backend: a.Backend, Error: errorString(a.Err), Duration: a.Duration}
+	}
+	return w
+}
+
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+func (s *Server) listWorkItems(w http.ResponseWriter, _ *http.Request) {
+	items := s.workItems.Items()
+	response := make([]workItem, len(items))
+	for i, item := range items {
+		response[i] = newWorkItem(item)
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+type workItemRequest struct {
+	Path    string `json:"path"`
+	Profile string `json:"profile,omitempty"`
+}
+
+func (s *Server) queueWorkItem(w http.ResponseWriter, r *http.Request) {
+	s.updateWorkItem(w, r, func(item *transcoder.WorkItem, _ workItemRequest) error {
+		return s.transcoder.Queue(item)
+	})
+}
+
+func (s *Server) dequeueWorkItem(w http.ResponseWriter, r *http.Request) {
+	s.updateWorkItem(w, r, func(item *transcoder.WorkItem, _ workItemRequest) error {
+		return s.transcoder.Dequeue(item)
+	})
+}
+
+func (s *Server) forceConvertWorkItem(w http.ResponseWriter, r *http.Request) {
+	s.updateWorkItem(w, r, func(item *transcoder.WorkItem, _ workItemRequest) error {
+		return s.transcoder.ForceConvert(item)
+	})
+}
+
+func (s *Server) setWorkItemProfile(w http.ResponseWriter, r *http.Request) {
+	s.updateWorkItem(w, r, func(item *transcoder.WorkItem, request workItemRequest) error {
+		return s.transcoder.SetProfile(item, request.Profile)
+	})
+}
+
+// updateWorkItem calls f for the WorkItem specified in the request and returns the updated WorkItem.
+func (s *Server) updateWorkItem(w http.ResponseWriter, r *http.Request, f func(*transcoder.WorkItem, workItemRequest) error) {
+	var request workItemRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+		return
+	}
+	item, ok := s.workItems.Get(request.Path)
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("%s: not found", request.Path))
+		return
+	}
+	if err := f(item, request); err != nil {
+		writeError(w, http.StatusConflict, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, newWorkItem(item))
+}
+
+func (s *Server) listProfiles(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, transcoder.SupportedProfiles())
+}
+
+//nolint:tagliatelle
+type state struct {
+	Active          bool  `json:"active"`
+	Sessions        int   `json:"sessions"`
+	SpaceSaved      int64 `json:"space_saved"`
+	OverwriteTarget bool  `json:"overwrite_target"`
+	RemoveSource    bool  `json:"remove_source"`
+}
+
+func (s *Server) getState(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, state{
+		Active:          s.transcoder.Active(),
+		Sessions:        s.transcoder.SessionCount(),
+		SpaceSaved:      s.transcoder.SpaceSaved(),
+		OverwriteTarget: s.transcoder.OverwriteTarget(),
+		RemoveSource:    s.transcoder.RemoveSource(),
+	})
+}
+
+func (s *Server) setActive(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Active bool `json:"active"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid request: %w", err))
+		return
+	}
+	s.transcoder.SetActive(request.Active)
+	s.logger.Info("batch processing changed", "active", request.Active, "remote", r.RemoteAddr)
+	s.getState(w, r)
+}
+
+//nolint:tagliatelle
+type session struct {
+	Source     string  `json:"source"`
+	Backend    string  `json:"backend"`
+	Worker     string  `json:"worker,omitempty"`
+	Completed  float64 `json:"completed"`
+	Speed      float64 `json:"speed"`
+	FPS        float64 `json:"fps"`
+	ETASeconds float64 `json:"eta_seconds"`
+}
+
+func newSession(s *transcoder.Session) session {
+	p := s.Progress()
+	duration := s.WorkItem.Source.VideoStats.Duration
+	var completed, eta float64
+	if duration > 0 {
+		completed = p.Converted.Seconds() / duration.Seconds()
+	}
+	if p.Speed > 0 {
+		eta = (duration - p.Converted).Seconds() / p.Speed
+	}
+	return session{
+		Source:     s.WorkItem.Source.Path,
+		Backend:    s.Backend,
+		Worker:     s.Worker,
+		Completed:  completed,
+		Speed:      p.Speed,
+		FPS:        p.FPS,
+		ETASeconds: max(0, eta),
+	}
+}
+
+func (s *Server) listSessions(w http.ResponseWriter, _ *http.Request) {
+	sessions := s.transcoder.Sessions()
+	response := make([]session, len(sessions))
+	for i, s := range sessions {
+		response[i] = newSession(s)
+	}
+	writeJSON(w, http.StatusOK, response)
+}
+
+// eventQueueSize is the number of events that are queued for each client of the event stream.
+// Clients that fall further behind are disconnected.
+const eventQueueSize = 64
+
+type event struct {
+	data  any
+	event string
+}
+
+// events streams the transcoder's session and status events as server-sent events.
+func (s *Server) events(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, fmt.Errorf("streaming not supported"))
+		return
+	}
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	queue := s.subscribe(ctx, cancel)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case ev := <-queue:
+			if err := writeEvent(w, ev.event, ev.data); err != nil {
+				s.logger.Debug("failed to send event", "err", err)
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// subscribe queues the transcoder's events for one client until the context is canceled. The transcoder blocks until
+// all subscribers receive an event, so a slow client must never hold up the subscription: if the client's queue is full,
+// subscribe calls disconnect and stops.
+func (s *Server) subscribe(ctx context.Context, disconnect context.CancelFunc) <-chan event {
+	sessionEvents := s.transcoder.Subscribe()
+	statusEvents := s.transcoder.SubscribeStatus()
+	queue := make(chan event, eventQueueSize)
+	go func() {
+		defer s.transcoder.UnsubscribeStatus(statusEvents)
+		defer s.transcoder.Unsubscribe(sessionEvents)
+		for {
+			var ev event
+			select {
+			case <-ctx.Done():
+				return
+			case e := <-sessionEvents:
+				ev = event{event: "session", data: struct {
+					Type string `json:"type"`
+					session
+				}{Type: e.Type.String(), session: newSession(e.Session)}}
+			case e := <-statusEvents:
+				ev = event{event: "status", data: newWorkItem(e.WorkItem)}
+			}
+			select {
+			case queue <- ev:
+			default:
+				s.logger.Warn("disconnecting slow event stream client")
+				disconnect()
+				return
+			}
+		}
+	}()
+	return queue
+}
+
+func writeEvent(w http.ResponseWriter, event string, data any) error {
+	body, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, body)
+	return err
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, statusCode int, err error) {
+	writeJSON(w, statusCode, struct {
+		Error string `json:"error"`
+	}{Error: err.Error()})
+}
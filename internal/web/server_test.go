@@ -0,0 +1,362 @@
+package web
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"codeberg.org/clambin/go-common/pubsub"
+	"github.com/clambin/xcoder/ffmpeg"
+	"github.com/clambin/xcoder/internal/transcoder"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_API(t *testing.T) {
+	var q transcoder.WorkItems
+	scanned := &transcoder.WorkItem{Source: transcoder.File{Path: "scanned.mkv"}, Target: transcoder.File{Path: "scanned.hevc.mkv"}}
+	scanned.SetStatus(transcoder.StatusScanned, nil)
+	failed := &transcoder.WorkItem{Source: transcoder.File{Path: "failed.mkv"}}
+	failed.SetStatus(transcoder.StatusScanFailed, errors.New("no video stream found"))
+	q.Add(scanned, failed)
+
+	tr := fakeTranscoder{sessions: []*transcoder.Session{{
+		WorkItem: &transcoder.WorkItem{Source: transcoder.File{Path: "converting.mkv", VideoStats: ffmpeg.VideoStats{Duration: time.Hour}}},
+		Backend:  transcoder.HardwareBackend,
+	}}}
+	s := New(&q, &tr, slog.New(slog.DiscardHandler))
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		body           string
+		wantStatusCode int
+		wantBody       string
+	}{
+		{
+			name:           "work items",
+			method:         http.MethodGet,
+			path:           "/api/workitems",
+			wantStatusCode: http.StatusOK,
+			wantBody: `[{"source":"failed.mkv","source_stats":{"video_codec":"","duration":0,"bit_rate":0,"bits_per_sample":0,"height":0,"width":0},"target_stats":{"video_codec":"","duration":0,"bit_rate":0,"bits_per_sample":0,"height":0,"width":0},"source_size":0,"target_size":0,"saved":0,"status":"scan failed","error":"no video stream found"},{"source":"scanned.mkv","target":"scanned.hevc.mkv","source_stats":{"video_codec":"","duration":0,"bit_rate":0,"bits_per_sample":0,"height":0,"width":0},"target_stats":{"video_codec":"","duration":0,"bit_rate":0,"bits_per_sample":0,"height":0,"width":0},"source_size":0,"target_size":0,"saved":0,"status":"scanned"}]
+`,
+		},
+		{
+			name:           "queue",
+			method:         http.MethodPost,
+			path:           "/api/workitems/queue",
+			body:           `{"path":"scanned.mkv"}`,
+			wantStatusCode: http.StatusOK,
+			wantBody: `{"source":"scanned.mkv","target":"scanned.hevc.mkv","source_stats":{"video_codec":"","duration":0,"bit_rate":0,"bits_per_sample":0,"height":0,"width":0},"target_stats":{"video_codec":"","duration":0,"bit_rate":0,"bits_per_sample":0,"height":0,"width":0},"source_size":0,"target_size":0,"saved":0,"status":"queued"}
+`,
+		},
+		{
+			name:           "queue a queued item",
+			method:         http.MethodPost,
+			path:           "/api/workitems/queue",
+			body:           `{"path":"scanned.mkv"}`,
+			wantStatusCode: http.StatusConflict,
+			wantBody: `{"error":"scanned.mkv: invalid status: queued"}
+`,
+		},
+		{
+			name:           "dequeue",
+			method:         http.MethodPost,
+			path:           "/api/workitems/dequeue",
+			body:           `{"path":"scanned.mkv"}`,
+			wantStatusCode: http.StatusOK,
+			wantBody: `{"source":"scanned.mkv","target":"scanned.hevc.mkv","source_stats":{"video_codec":"","duration":0,"bit_rate":0,"bits_per_sample":0,"height":0,"width":0},"target_stats":{"video_codec":"","duration":0,"bit_rate":0,"bits_per_sample":0,"height":0,"width":0},"source_size":0,"target_size":0,"saved":0,"status":"scanned"}
+`,
+		},
+		{
+			name:           "set profile",
+			method:         http.MethodPost,
+			path:           "/api/workitems/profile",
+			body:           `{"path":"scanned.mkv","profile":"hevc-low"}`,
+			wantStatusCode: http.StatusOK,
+			wantBody: `{"source":"scanned.mkv","target":"scanned.hevc.mkv","source_stats":{"video_codec":"","duration":0,"bit_rate":0,"bits_per_sample":0,"height":0,"width":0},"target_stats":{"video_codec":"","duration":0,"bit_rate":0,"bits_per_sample":0,"height":0,"width":0},"source_size":0,"target_size":0,"saved":0,"status":"scanned"}
+`,
+		},
+		{
+			name:           "set invalid profile",
+			method:         http.MethodPost,
+			path:           "/api/workitems/profile",
+			body:           `{"path":"scanned.mkv","profile":"foo"}`,
+			wantStatusCode: http.StatusConflict,
+			wantBody: `{"error":"invalid profile name: \"foo\". supported profile names: hevc-high, hevc-low, hevc-medium"}
+`,
+		},
+		{
+			name:           "force convert",
+			method:         http.MethodPost,
+			path:           "/api/workitems/force",
+			body:           `{"path":"scanned.mkv"}`,
+			wantStatusCode: http.StatusOK,
+			wantBody: `{"source":"scanned.mkv","target":"scanned.hevc.mkv","source_stats":{"video_codec":"","duration":0,"bit_rate":0,"bits_per_sample":0,"height":0,"width":0},"target_stats":{"video_codec":"","duration":0,"bit_rate":0,"bits_per_sample":0,"height":0,"width":0},"source_size":0,"target_size":0,"saved":0,"status":"queued"}
+`,
+		},
+		{
+			name:           "force convert a queued item",
+			method:         http.MethodPost,
+			path:           "/api/workitems/force",
+			body:           `{"path":"scanned.mkv"}`,
+			wantStatusCode: http.StatusConflict,
+			wantBody: `{"error":"scanned.mkv: invalid status: queued"}
+`,
+		},
+		{
+			name:           "profiles",
+			method:         http.MethodGet,
+			path:           "/api/profiles",
+			wantStatusCode: http.StatusOK,
+			wantBody: `["hevc-high","hevc-low","hevc-medium"]
+`,
+		},
+		{
+			name:           "queue an unknown item",
+			method:         http.MethodPost,
+			path:           "/api/workitems/queue",
+			body:           `{"path":"unknown.mkv"}`,
+			wantStatusCode: http.StatusNotFound,
+			wantBody: `{"error":"unknown.mkv: not found"}
+`,
+		},
+		{
+			name:           "invalid request",
+			method:         http.MethodPost,
+			path:           "/api/workitems/queue",
+			body:           `{`,
+			wantStatusCode: http.StatusBadRequest,
+			wantBody: `{"error":"invalid request: unexpected EOF"}
+`,
+		},
+		{
+			name:           "state",
+			method:         http.MethodGet,
+			path:           "/api/state",
+			wantStatusCode: http.StatusOK,
+			wantBody: `{"active":false,"sessions":1,"space_saved":0,"overwrite_target":false,"remove_source":false}
+`,
+		},
+		{
+			name:           "set active",
+			method:         http.MethodPut,
+			path:           "/api/state/active",
+			body:           `{"active":true}`,
+			wantStatusCode: http.StatusOK,
+			wantBody: `{"active":true,"sessions":1,"space_saved":0,"overwrite_target":false,"remove_source":false}
+`,
+		},
+		{
+			name:           "sessions",
+			method:         http.MethodGet,
+			path:           "/api/sessions",
+			wantStatusCode: http.StatusOK,
+			wantBody: `[{"source":"converting.mkv","backend":"hardware","completed":0,"speed":0,"fps":0,"eta_seconds":0}]
+`,
+		},
+		{
+			name:           "invalid method",
+			method:         http.MethodDelete,
+			path:           "/api/sessions",
+			wantStatusCode: http.StatusMethodNotAllowed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body)))
+			assert.Equal(t, tt.wantStatusCode, w.Code)
+			if tt.wantBody != "" {
+				assert.Equal(t, tt.wantBody, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestServer_Dashboard(t *testing.T) {
+	s := New(&transcoder.WorkItems{}, &fakeTranscoder{}, slog.New(slog.DiscardHandler))
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "<title>xcoder</title>")
+}
+
+func TestServer_Events(t *testing.T) {
+	var tr fakeTranscoder
+	ts := httptest.NewServer(New(&transcoder.WorkItems{}, &tr, slog.New(slog.DiscardHandler)))
+	t.Cleanup(ts.Close)
+
+	req, _ := http.NewRequestWithContext(t.Context(), http.MethodGet, ts.URL+"/api/events", nil)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = resp.Body.Close() })
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+	require.Eventually(t, func() bool {
+		return tr.sessionEvents.Subscribers() == 1 && tr.statusEvents.Subscribers() == 1
+	}, time.Second, 10*time.Millisecond)
+
+	workItem := transcoder.WorkItem{Source: transcoder.File{Path: "foo.mkv"}}
+	workItem.SetStatus(transcoder.StatusQueued, nil)
+	tr.statusEvents.Publish(transcoder.StatusEvent{WorkItem: &workItem, Status: transcoder.StatusQueued})
+	tr.sessionEvents.Publish(transcoder.SessionEvent{Session: &transcoder.Session{WorkItem: &workItem, Backend: "software"}, Type: transcoder.SessionStartedEvent})
+
+	r := bufio.NewReader(resp.Body)
+	for _, want := range []string{
+		"event: status\n",
+		`data: {"source":"foo.mkv","source_stats":{"video_codec":"","duration":0,"bit_rate":0,"bits_per_sample":0,"height":0,"width":0},"target_stats":{"video_codec":"","duration":0,"bit_rate":0,"bits_per_sample":0,"height":0,"width":0},"source_size":0,"target_size":0,"saved":0,"status":"queued"}` + "\n",
+		"\n",
+		"event: session\n",
+		`data: {"type":"SessionStartedEvent","source":"foo.mkv","backend":"software","completed":0,"speed":0,"fps":0,"eta_seconds":0}` + "\n",
+		"\n",
+	} {
+		line, err := r.ReadString('\n')
+		require.NoError(t, err)
+		assert.Equal(t, want, line)
+	}
+}
+
+func TestServer_Events_SlowClient(t *testing.T) {
+	var tr fakeTranscoder
+	ts := httptest.NewServer(New(&transcoder.WorkItems{}, &tr, slog.New(slog.DiscardHandler)))
+	t.Cleanup(ts.Close)
+
+	// a client that never reads the event stream
+	req, _ := http.NewRequestWithContext(t.Context(), http.MethodGet, ts.URL+"/api/events", nil)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	t.Cleanup(func() { _ = resp.Body.Close() })
+	require.Eventually(t, func() bool {
+		return tr.sessionEvents.Subscribers() == 1 && tr.statusEvents.Subscribers() == 1
+	}, time.Second, 10*time.Millisecond)
+
+	// large events fill up the network buffers, so the server can't write them
+	workItem := transcoder.WorkItem{Source: transcoder.File{Path: strings.Repeat("x", 64*1024)}}
+	published := make(chan struct{})
+	go func() {
+		for range 1000 {
+			tr.statusEvents.Publish(transcoder.StatusEvent{WorkItem: &workItem, Status: transcoder.StatusQueued})
+		}
+		close(published)
+	}()
+	select {
+	case <-published:
+	case <-time.After(10 * time.Second):
+		t.Fatal("publishing blocked on a slow client")
+	}
+
+	// the slow client is disconnected
+	assert.Eventually(t, func() bool {
+		return tr.sessionEvents.Subscribers() == 0 && tr.statusEvents.Subscribers() == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+var _ Transcoder = (*fakeTranscoder)(nil)
+
+type fakeTranscoder struct {
+	sessionEvents pubsub.Publisher[transcoder.SessionEvent]
+	statusEvents  pubsub.Publisher[transcoder.StatusEvent]
+	sessions      []*transcoder.Session
+	active        bool
+}
+
+func (f *fakeTranscoder) Active() bool {
+	return f.active
+}
+
+func (f *fakeTranscoder) SetActive(active bool) {
+	f.active = active
+}
+
+func (f *fakeTranscoder) SessionCount() int {
+	return len(f.sessions)
+}
+
+func (f *fakeTranscoder) Sessions() []*transcoder.Session {
+	return f.sessions
+}
+
+func (f *fakeTranscoder) SpaceSaved() int64 {
+	return 0
+}
+
+func (f *fakeTranscoder) Subscribe() <-chan transcoder.SessionEvent {
+	return f.sessionEvents.Subscribe()
+}
+
+func (f *fakeTranscoder) Unsubscribe(ch <-chan transcoder.SessionEvent) {
+	drain(ch, f.sessionEvents.Unsubscribe)
+}
+
+func (f *fakeTranscoder) SubscribeStatus() <-chan transcoder.StatusEvent {
+	return f.statusEvents.Subscribe()
+}
+
+func (f *fakeTranscoder) UnsubscribeStatus(ch <-chan transcoder.StatusEvent) {
+	drain(ch, f.statusEvents.Unsubscribe)
+}
+
+// drain calls unsubscribe, reading from the channel until the subscription is stopped, like the Transcoder does.
+func drain[T any](ch <-chan T, unsubscribe func(<-chan T)) {
+	done := make(chan struct{})
+	go func() {
+		unsubscribe(ch)
+		close(done)
+	}()
+	for {
+		select {
+		case <-ch:
+		case <-done:
+			return
+		}
+	}
+}
+
+func (f *fakeTranscoder) Queue(item *transcoder.WorkItem) error {
+	return changeStatus(item, transcoder.StatusQueued, transcoder.StatusScanned)
+}
+
+func (f *fakeTranscoder) Dequeue(item *transcoder.WorkItem) error {
+	return changeStatus(item, transcoder.StatusScanned, transcoder.StatusQueued)
+}
+
+func (f *fakeTranscoder) ForceConvert(item *transcoder.WorkItem) error {
+	return changeStatus(item, transcoder.StatusQueued, transcoder.StatusScanned)
+}
+
+func (f *fakeTranscoder) SetProfile(_ *transcoder.WorkItem, profile string) error {
+	_, err := transcoder.GetProfile(profile)
+	return err
+}
+
+func (f *fakeTranscoder) ScheduleWindow() (bool, time.Time) {
+	return true, time.Time{}
+}
+
+func (f *fakeTranscoder) Shutdown(context.Context, transcoder.ShutdownMode) error {
+	return nil
+}
+
+func changeStatus(item *transcoder.WorkItem, status transcoder.Status, current transcoder.Status) error {
+	if actual, _ := item.Status(); actual != current {
+		return errors.New(item.Source.Path + ": invalid status: " + actual.String())
+	}
+	item.SetStatus(status, nil)
+	return nil
+}
+
+func (f *fakeTranscoder) OverwriteTarget() bool {
+	return false
+}
+
+func (f *fakeTranscoder) RemoveSource() bool {
+	return false
+}